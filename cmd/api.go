@@ -1,75 +1,293 @@
 package main
 
 import (
-	"log"
+	"context"
+	"log/slog"
 	"net/http"
+	"strconv"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
-	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/mellomaths/ecommerce-ms/internal/adapters/postgresql"
 	repo "github.com/mellomaths/ecommerce-ms/internal/adapters/postgresql/sqlc"
+	"github.com/mellomaths/ecommerce-ms/internal/auth"
+	"github.com/mellomaths/ecommerce-ms/internal/carts"
+	"github.com/mellomaths/ecommerce-ms/internal/config"
+	"github.com/mellomaths/ecommerce-ms/internal/customers"
+	"github.com/mellomaths/ecommerce-ms/internal/health"
+	"github.com/mellomaths/ecommerce-ms/internal/idempotency"
+	"github.com/mellomaths/ecommerce-ms/internal/locations"
+	"github.com/mellomaths/ecommerce-ms/internal/logging"
+	"github.com/mellomaths/ecommerce-ms/internal/metrics"
 	"github.com/mellomaths/ecommerce-ms/internal/orders"
+	"github.com/mellomaths/ecommerce-ms/internal/outbox"
+	"github.com/mellomaths/ecommerce-ms/internal/payments"
 	"github.com/mellomaths/ecommerce-ms/internal/products"
+	"github.com/mellomaths/ecommerce-ms/internal/ratelimit"
+	"github.com/mellomaths/ecommerce-ms/internal/responses"
+	"github.com/mellomaths/ecommerce-ms/internal/returns"
+	"github.com/mellomaths/ecommerce-ms/internal/tracing"
+	"github.com/mellomaths/ecommerce-ms/internal/webhooks"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 type application struct {
-	config config
-	db     *pgx.Conn
+	config           config.Config
+	db               *pgxpool.Pool
+	gateway          payments.Gateway
+	publisher        outbox.Publisher
+	allocator        products.Allocator
+	verifier         *auth.JWTVerifier
+	rateLimits       ratelimit.Store
+	globalLimit      ratelimit.Policy
+	placeOrderLimit  ratelimit.Policy
+	authFailureLimit ratelimit.Policy
+	draining         atomic.Bool
+	heartbeats       *health.Heartbeats
 }
 
 func (app *application) mount() http.Handler {
 	r := chi.NewRouter()
 
 	// Middlewares
-	r.Use(middleware.RequestID) // Rate Limiting
-	r.Use(middleware.RealIP)    // Rate Limiting, analytics and tracing
-	r.Use(middleware.Logger)
+	r.Use(middleware.RequestID)
+	r.Use(middleware.RealIP) // Rate limiting of anonymous clients, analytics and tracing
+	r.Use(tracing.Middleware)
+	r.Use(logging.Middleware)
+	if app.config.Metrics.Enabled {
+		r.Use(metrics.Middleware)
+	}
 	r.Use(middleware.Recoverer) // Recover from crashes
 
 	// Set a timeout value on the request context (ctx), that will signal
 	// through ctx.Done() that the request has timed out and further
 	// processing should be stopped.
-	r.Use(middleware.Timeout(60 * time.Second))
+	r.Use(middleware.Timeout(app.config.Server.RequestTimeout))
 
-	// Health Check
-	r.Get("/health", func(w http.ResponseWriter, r *http.Request) {
-		w.Write([]byte("hi"))
-	})
+	// Every client gets the global rate limit, the routes placing orders are
+	// limited further. The addresses failing to authenticate are limited
+	// before their credentials are checked.
+	limiter := ratelimit.NewLimiter(app.rateLimits)
+	limit := limiter.Limit(app.globalLimit)
+	limitPlaceOrder := limiter.Limit(app.placeOrderLimit)
+
+	// Requests are authenticated with a bearer token or an API key, the
+	// catalog, the health checks and the metrics are the only public routes.
+	authenticator := auth.NewAuthenticator(app.verifier, repo.New(app.db))
+	authenticate := func(next http.Handler) http.Handler {
+		return limiter.LimitFailedAuthentication(app.authFailureLimit)(authenticator.Middleware(next))
+	}
+
+	// Health Checks: the liveness only tells the process is serving, the
+	// readiness checks the dependencies and fails while the server drains so
+	// it is taken out of the load balancer. They are served before the
+	// credentials are checked, so a probe sending stale ones still gets its
+	// status, only the verbose readiness reserved to admins is authenticated.
+	r.Get("/livez", health.NewChecker(app.config.Health.Timeout).ServeHTTP)
+	r.With(whenVerbose(authenticate)).Get("/readyz", app.readiness().ServeHTTP)
+
+	r.Group(func(r chi.Router) {
+		r.Use(authenticate)
+
+		// Metrics, scraped by Prometheus. Public so the scraper needs no
+		// credentials, it must only be reachable from the monitoring network.
+		if app.config.Metrics.Enabled {
+			r.Get("/metrics", promhttp.Handler().ServeHTTP)
+		}
+
+		// Catalog
+		productsService := products.NewService(repo.New(app.db), app.db)
+		productsHandler := products.NewHandler(productsService)
+		r.With(limit).Get("/products", productsHandler.ListProducts)
+		r.With(limit).Get("/products/{id}", productsHandler.FindProductById)
+
+		// Every other route requires a principal with the permission of the
+		// route. Customers only get to their own customer, orders and carts.
+		r.Group(func(r chi.Router) {
+			r.Use(auth.Required)
+			r.Use(limit)
+
+			// Admin
+			r.With(auth.Require(auth.PermissionReadAdmin)).Get("/admin/db/stats", func(w http.ResponseWriter, r *http.Request) {
+				responses.NewJsonResponse(w, http.StatusOK, postgresql.NewPoolStats(app.db.Stat()))
+			})
+
+			// Retried POST requests with the same Idempotency-Key get the original
+			// response back instead of creating duplicates.
+			idempotent := idempotency.NewMiddleware(repo.New(app.db), app.config.Idempotency.LockTimeout)
+
+			// Product Handlers
+			r.With(auth.Require(auth.PermissionWriteProducts), idempotent).Post("/products", productsHandler.CreateProduct)
+			r.With(auth.Require(auth.PermissionWriteProducts)).Put("/products/{id}", productsHandler.UpdateProduct)
+			r.With(auth.Require(auth.PermissionWriteProducts)).Patch("/products/{id}", productsHandler.PatchProduct)
+			r.With(auth.Require(auth.PermissionWriteProducts)).Delete("/products/{id}", productsHandler.DeleteProduct)
+			r.With(auth.Require(auth.PermissionReadStock)).Get("/products/{id}/stock", productsHandler.ListProductStock)
+			r.With(auth.Require(auth.PermissionWriteStock)).Put("/products/{id}/stock/{locationId}", productsHandler.SetLocationStock)
+			r.With(auth.Require(auth.PermissionReadStock)).Get("/products/{id}/inventory/movements", productsHandler.ListInventoryMovements)
+
+			// Stock Location Handlers
+			locationsHandler := locations.NewHandler(locations.NewService(repo.New(app.db)))
+			r.With(auth.Require(auth.PermissionReadStock)).Get("/locations", locationsHandler.ListLocations)
+			r.With(auth.Require(auth.PermissionWriteStock)).Post("/locations", locationsHandler.CreateLocation)
+			r.With(auth.Require(auth.PermissionReadStock)).Get("/locations/{id}", locationsHandler.FindLocationById)
+			r.With(auth.Require(auth.PermissionWriteStock)).Put("/locations/{id}", locationsHandler.UpdateLocation)
+
+			// Customer Handlers
+			customersService := customers.NewService(repo.New(app.db))
+			customersHandler := customers.NewHandler(customersService)
+			r.With(auth.Require(auth.PermissionCreateCustomers)).Post("/customers", customersHandler.CreateCustomer)
+			r.With(auth.Require(auth.PermissionReadCustomers)).Get("/customers/{id}", customersHandler.FindCustomerById)
+			r.With(auth.Require(auth.PermissionWriteCustomers)).Put("/customers/{id}", customersHandler.UpdateCustomer)
+			r.With(auth.Require(auth.PermissionReadCustomers)).Get("/customers/{id}/addresses", customersHandler.ListAddresses)
+			r.With(auth.Require(auth.PermissionWriteCustomers)).Post("/customers/{id}/addresses", customersHandler.AddAddress)
+			r.With(auth.Require(auth.PermissionWriteCustomers)).Delete("/customers/{id}/addresses/{addressId}", customersHandler.DeleteAddress)
+
+			// Order Handlers
+			ordersService := orders.NewService(repo.New(app.db), app.db, productsService, app.allocator)
+			ordersHandler := orders.NewHandler(ordersService)
+			r.With(auth.Require(auth.PermissionReadOrders)).Get("/orders", ordersHandler.ListOrders)
+			r.With(auth.Require(auth.PermissionPlaceOrders), limitPlaceOrder, idempotent).Post("/orders", ordersHandler.PlaceOrder)
+			r.With(auth.Require(auth.PermissionReadOrders)).Get("/orders/{id}", ordersHandler.FindOrderById)
+			r.With(auth.Require(auth.PermissionReadOrders)).Get("/orders/{id}/transitions", ordersHandler.ListOrderTransitions)
+			r.With(auth.Require(auth.PermissionManageOrders)).Post("/orders/{id}/transitions", ordersHandler.TransitionOrder)
+			r.With(auth.Require(auth.PermissionReadOrders)).Get("/customers/{id}/orders", ordersHandler.ListCustomerOrders)
+
+			// Payment Handlers
+			paymentsService := payments.NewService(repo.New(app.db), app.gateway, ordersService)
+			paymentsHandler := payments.NewHandler(paymentsService)
+			r.With(auth.Require(auth.PermissionReadOrders)).Get("/orders/{id}/payments", paymentsHandler.ListOrderPayments)
+			r.With(auth.Require(auth.PermissionPlaceOrders), idempotent).Post("/orders/{id}/payments", paymentsHandler.PayOrder)
+			// Paid orders are refunded when they are cancelled
+			r.With(auth.Require(auth.PermissionPlaceOrders)).Post("/orders/{id}/cancel", paymentsHandler.CancelOrder)
+
+			// Cart Handlers
+			cartsService := carts.NewService(repo.New(app.db), ordersService, app.config.Carts.TTL)
+			cartsHandler := carts.NewHandler(cartsService)
+			r.With(auth.Require(auth.PermissionPlaceOrders)).Post("/carts", cartsHandler.CreateCart)
+			r.With(auth.Require(auth.PermissionPlaceOrders)).Get("/carts/{id}", cartsHandler.FindCartById)
+			r.With(auth.Require(auth.PermissionPlaceOrders)).Post("/carts/{id}/items", cartsHandler.AddItem)
+			r.With(auth.Require(auth.PermissionPlaceOrders)).Put("/carts/{id}/items/{productId}", cartsHandler.UpdateItem)
+			r.With(auth.Require(auth.PermissionPlaceOrders)).Delete("/carts/{id}/items/{productId}", cartsHandler.RemoveItem)
+			r.With(auth.Require(auth.PermissionPlaceOrders), limitPlaceOrder, idempotent).Post("/carts/{id}/checkout", cartsHandler.Checkout)
 
-	// Product Handlers
-	productsService := products.NewService(repo.New(app.db))
-	productsHandler := products.NewHandler(productsService)
-	r.Get("/products", productsHandler.ListProducts)
-	r.Get("/products/{id}", productsHandler.FindProductById)
-	r.Post("/products", productsHandler.CreateProduct)
+			// Return Handlers
+			returnsService := returns.NewService(repo.New(app.db), app.db, paymentsService, ordersService)
+			returnsHandler := returns.NewHandler(returnsService)
+			r.With(auth.Require(auth.PermissionReadOrders)).Get("/orders/{id}/returns", returnsHandler.ListOrderReturns)
+			r.With(auth.Require(auth.PermissionPlaceOrders), idempotent).Post("/orders/{id}/returns", returnsHandler.RequestReturn)
+			r.With(auth.Require(auth.PermissionManageReturns)).Get("/returns/{id}", returnsHandler.FindReturnById)
+			r.With(auth.Require(auth.PermissionManageReturns)).Post("/returns/{id}/approve", returnsHandler.ApproveReturn)
+			r.With(auth.Require(auth.PermissionManageReturns)).Post("/returns/{id}/reject", returnsHandler.RejectReturn)
+			r.With(auth.Require(auth.PermissionManageReturns)).Post("/returns/{id}/receive", returnsHandler.ReceiveReturn)
+			r.With(auth.Require(auth.PermissionManageReturns)).Post("/returns/{id}/refund", returnsHandler.RefundReturn)
 
-	// Order Handlers
-	ordersService := orders.NewService(repo.New(app.db), app.db, productsService)
-	ordersHandler := orders.NewHandler(ordersService)
-	r.Post("/orders", ordersHandler.PlaceOrder)
-	r.Get("/orders/{id}", ordersHandler.FindOrderById)
+			// Webhook Handlers
+			webhooksService := webhooks.NewService(repo.New(app.db))
+			webhooksHandler := webhooks.NewHandler(webhooksService)
+			r.With(auth.Require(auth.PermissionManageWebhooks)).Get("/webhooks", webhooksHandler.ListSubscriptions)
+			r.With(auth.Require(auth.PermissionManageWebhooks)).Post("/webhooks", webhooksHandler.CreateSubscription)
+			r.With(auth.Require(auth.PermissionManageWebhooks)).Get("/webhooks/{id}", webhooksHandler.FindSubscriptionById)
+			r.With(auth.Require(auth.PermissionManageWebhooks)).Put("/webhooks/{id}", webhooksHandler.UpdateSubscription)
+			r.With(auth.Require(auth.PermissionManageWebhooks)).Delete("/webhooks/{id}", webhooksHandler.DeleteSubscription)
+			r.With(auth.Require(auth.PermissionManageWebhooks)).Get("/webhooks/{id}/deliveries", webhooksHandler.ListDeliveries)
+			r.With(auth.Require(auth.PermissionManageWebhooks)).Get("/webhooks/{id}/deliveries/{deliveryId}", webhooksHandler.FindDeliveryById)
+			r.With(auth.Require(auth.PermissionManageWebhooks)).Post("/webhooks/{id}/deliveries/{deliveryId}/redrive", webhooksHandler.RedriveDelivery)
+		})
+	})
 
 	return r
 }
 
-func (app *application) run(h http.Handler) error {
-	srv := &http.Server{
-		Addr:         app.config.addr,
-		Handler:      h,
-		WriteTimeout: time.Second * 30,
-		ReadTimeout:  time.Second * 10,
-		IdleTimeout:  time.Minute,
+// whenVerbose applies mw to the requests asking for a verbose report only.
+func whenVerbose(mw func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		verbose := mw(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if v, _ := strconv.ParseBool(r.URL.Query().Get("verbose")); v {
+				verbose.ServeHTTP(w, r)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
 	}
-	log.Printf("server has started at addr %s", app.config.addr)
-	return srv.ListenAndServe()
 }
 
-type config struct {
-	addr string
-	db   dbConfig
+// releasePaymentReservations cancels the orders left unpaid after a failed
+// payment until ctx is done.
+func (app *application) releasePaymentReservations(ctx context.Context) {
+	productsService := products.NewService(repo.New(app.db), app.db)
+	ordersService := orders.NewService(repo.New(app.db), app.db, productsService, app.allocator)
+	paymentsService := payments.NewService(repo.New(app.db), app.gateway, ordersService)
+	payments.NewReleaser(paymentsService, app.config.Payments.ReservationWindow, app.config.Payments.ReleaseInterval).Run(ctx)
+}
+
+// resumeRefunds completes the refunds of the returns left refunding until ctx
+// is done.
+func (app *application) resumeRefunds(ctx context.Context) {
+	productsService := products.NewService(repo.New(app.db), app.db)
+	ordersService := orders.NewService(repo.New(app.db), app.db, productsService, app.allocator)
+	paymentsService := payments.NewService(repo.New(app.db), app.gateway, ordersService)
+	returnsService := returns.NewService(repo.New(app.db), app.db, paymentsService, ordersService)
+	returns.NewResumer(returnsService, app.config.Returns.RefundTimeout, app.config.Returns.ResumeInterval).Run(ctx)
+}
+
+// relayOutboxEvents publishes the domain events written to the outbox until
+// ctx is done.
+func (app *application) relayOutboxEvents(ctx context.Context) {
+	cfg := app.config.Outbox
+	outbox.NewRelay(repo.New(app.db), app.db, app.publisher, cfg.RelayInterval, cfg.BatchSize, cfg.MaxBackoff).Run(ctx)
+}
+
+// dispatchWebhooks posts the queued webhook deliveries until ctx is done.
+func (app *application) dispatchWebhooks(ctx context.Context) {
+	cfg := app.config.Webhooks
+	client := &http.Client{Timeout: cfg.Timeout, Transport: tracing.Transport(http.DefaultTransport)}
+	webhooks.NewDispatcher(repo.New(app.db), app.db, client, cfg.DispatchInterval, cfg.BatchSize, cfg.MaxAttempts, cfg.MaxBackoff).Run(ctx)
 }
 
-type dbConfig struct {
-	dsn string
+// sweepRateLimitBuckets deletes the idle rate limit buckets kept in the
+// database until ctx is done.
+func (app *application) sweepRateLimitBuckets(ctx context.Context) {
+	idle := max(app.globalLimit.Period, app.placeOrderLimit.Period, app.authFailureLimit.Period)
+	ratelimit.NewSweeper(repo.New(app.db), app.config.RateLimit.SweepInterval, idle).Run(ctx)
+}
+
+// run serves the requests until ctx is done. The server is then reported as
+// not ready for the readiness delay, so the load balancer stops sending it
+// requests, before it stops accepting connections and waits up to the drain
+// timeout for the requests in flight. The requests still running after that
+// are cut off.
+func (app *application) run(ctx context.Context, h http.Handler) error {
+	srv := &http.Server{
+		Addr:         app.config.Server.Addr,
+		Handler:      h,
+		WriteTimeout: app.config.Server.WriteTimeout,
+		ReadTimeout:  app.config.Server.ReadTimeout,
+		IdleTimeout:  app.config.Server.IdleTimeout,
+	}
+	errs := make(chan error, 1)
+	go func() {
+		slog.Info("server has started", "addr", app.config.Server.Addr)
+		errs <- srv.ListenAndServe()
+	}()
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	cfg := app.config.Shutdown
+	app.draining.Store(true)
+	slog.Info("server is shutting down, draining the requests", "timeout", cfg.DrainTimeout)
+	time.Sleep(cfg.ReadinessDelay)
+	drainCtx, cancel := context.WithTimeout(context.Background(), cfg.DrainTimeout)
+	defer cancel()
+	if err := srv.Shutdown(drainCtx); err != nil {
+		slog.Warn("server has not drained in time", "error", err)
+		return srv.Close()
+	}
+	return nil
 }
@@ -3,19 +3,64 @@ package main
 import (
 	"bytes"
 	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/mellomaths/ecommerce-ms/internal/adapters/postgresql"
 	repo "github.com/mellomaths/ecommerce-ms/internal/adapters/postgresql/sqlc"
+	"github.com/mellomaths/ecommerce-ms/internal/auth"
+	"github.com/mellomaths/ecommerce-ms/internal/carts"
+	"github.com/mellomaths/ecommerce-ms/internal/config"
+	"github.com/mellomaths/ecommerce-ms/internal/customers"
+	"github.com/mellomaths/ecommerce-ms/internal/health"
+	"github.com/mellomaths/ecommerce-ms/internal/idempotency"
+	"github.com/mellomaths/ecommerce-ms/internal/locations"
+	"github.com/mellomaths/ecommerce-ms/internal/logging"
+	"github.com/mellomaths/ecommerce-ms/internal/metrics"
 	"github.com/mellomaths/ecommerce-ms/internal/orders"
+	"github.com/mellomaths/ecommerce-ms/internal/outbox"
+	"github.com/mellomaths/ecommerce-ms/internal/pagination"
+	"github.com/mellomaths/ecommerce-ms/internal/payments"
 	"github.com/mellomaths/ecommerce-ms/internal/products"
+	"github.com/mellomaths/ecommerce-ms/internal/ratelimit"
+	"github.com/mellomaths/ecommerce-ms/internal/responses"
+	"github.com/mellomaths/ecommerce-ms/internal/returns"
+	"github.com/mellomaths/ecommerce-ms/internal/tracing"
+	"github.com/mellomaths/ecommerce-ms/internal/webhooks"
 	"github.com/pashagolub/pgxmock/v4"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 func TestCreateAndGetProduct(t *testing.T) {
@@ -30,13 +75,23 @@ func TestCreateAndGetProduct(t *testing.T) {
 	}
 	defer conn.Close(context.Background())
 
-	expectedRow := pgxmock.NewRows([]string{"id", "name", "price_in_cents", "quantity", "created_at"}).
-		AddRow(int64(1), productData.Name, productData.PriceInCents, productData.Quantity, time.Date(2025, 12, 24, 14, 2, 58, 452793000, time.FixedZone("", -3*3600)))
+	expectedRow := pgxmock.NewRows([]string{"id", "name", "price_in_cents", "quantity", "created_at", "deleted_at"}).
+		AddRow(int64(1), productData.Name, productData.PriceInCents, int32(0), time.Date(2025, 12, 24, 14, 2, 58, 452793000, time.FixedZone("", -3*3600)), nil)
+	conn.ExpectBegin()
 	conn.ExpectQuery("INSERT INTO products").
-		WithArgs(productData.Name, productData.PriceInCents, productData.Quantity).
+		WithArgs(productData.Name, productData.PriceInCents).
 		WillReturnRows(expectedRow)
+	// The initial stock is received at the default location
+	expectDefaultLocation(conn)
+	conn.ExpectQuery("INSERT INTO product_stock").
+		WithArgs(int64(1), int64(1), productData.Quantity).
+		WillReturnRows(pgxmock.NewRows([]string{"product_id", "location_id", "quantity", "updated_at"}).
+			AddRow(int64(1), int64(1), productData.Quantity, time.Date(2025, 12, 24, 14, 2, 58, 452793000, time.FixedZone("", -3*3600))))
+	expectInventoryMovement(conn, 1, 1, productData.Quantity, productData.Quantity, products.ReasonRestock, 0)
+	expectOutboxEvent(conn, outbox.AggregateProduct, 1, outbox.EventProductCreated)
+	conn.ExpectCommit()
 
-	productsService := products.NewService(repo.New(conn))
+	productsService := products.NewService(repo.New(conn), conn)
 	productsHandler := products.NewHandler(productsService)
 	r2 := chi.NewRouter()
 	r2.Get("/products/{id}", productsHandler.FindProductById)
@@ -57,10 +112,10 @@ func TestCreateAndGetProduct(t *testing.T) {
 	resp.Body.Close()
 
 	// Create a new row set for the FindProductById query (expectedRow was consumed)
-	findProductRow := pgxmock.NewRows([]string{"id", "name", "price_in_cents", "quantity", "created_at"}).
-		AddRow(int64(1), productData.Name, productData.PriceInCents, productData.Quantity, time.Date(2025, 12, 24, 14, 2, 58, 452793000, time.FixedZone("", -3*3600)))
+	findProductRow := pgxmock.NewRows([]string{"id", "name", "price_in_cents", "quantity", "created_at", "deleted_at"}).
+		AddRow(int64(1), productData.Name, productData.PriceInCents, productData.Quantity, time.Date(2025, 12, 24, 14, 2, 58, 452793000, time.FixedZone("", -3*3600)), nil)
 
-	// Match the actual query pattern - the query is "SELECT id, name, price_in_cents, quantity, created_at FROM products WHERE id = $1"
+	// Match the actual query pattern - the query is "SELECT id, name, price_in_cents, quantity, created_at, deleted_at FROM products WHERE id = $1"
 	conn.ExpectQuery("FROM products").
 		WithArgs(int64(1)).
 		WillReturnRows(findProductRow)
@@ -82,11 +137,13 @@ func TestListProducts(t *testing.T) {
 	}
 	defer conn.Close(context.Background())
 
-	conn.ExpectQuery("SELECT id, name, price_in_cents, quantity, created_at FROM products").
-		WillReturnRows(pgxmock.NewRows([]string{"id", "name", "price_in_cents", "quantity", "created_at"}).
-			AddRow(int64(1), "Product 1", 10000, 10, time.Date(2025, 12, 24, 14, 2, 58, 452793000, time.FixedZone("", -3*3600))).
-			AddRow(int64(2), "Product 2", 20000, 20, time.Date(2025, 12, 24, 14, 2, 58, 452793000, time.FixedZone("", -3*3600))))
-	productsService := products.NewService(repo.New(conn))
+	conn.ExpectQuery("SELECT id, name, price_in_cents, quantity, created_at, deleted_at FROM products").
+		WithArgs(pgtype.Int4{}, pgtype.Int4{}, false, pgtype.Text{}, pgtype.Int8{}, "created_at", false,
+			pgtype.Text{}, pgtype.Int4{}, pgtype.Timestamptz{}, int32(pagination.DefaultLimit+1)).
+		WillReturnRows(pgxmock.NewRows([]string{"id", "name", "price_in_cents", "quantity", "created_at", "deleted_at"}).
+			AddRow(int64(1), "Product 1", 10000, 10, time.Date(2025, 12, 24, 14, 2, 58, 452793000, time.FixedZone("", -3*3600)), nil).
+			AddRow(int64(2), "Product 2", 20000, 20, time.Date(2025, 12, 24, 14, 2, 58, 452793000, time.FixedZone("", -3*3600)), nil))
+	productsService := products.NewService(repo.New(conn), conn)
 	productsHandler := products.NewHandler(productsService)
 	r2 := chi.NewRouter()
 	r2.Get("/products", productsHandler.ListProducts)
@@ -97,12 +154,72 @@ func TestListProducts(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, http.StatusOK, resp.StatusCode)
 
-	var products []repo.Product
-	json.NewDecoder(resp.Body).Decode(&products)
-	assert.Equal(t, 2, len(products))
-	assert.Equal(t, "Product 1", products[0].Name)
-	assert.Equal(t, "Product 2", products[1].Name)
+	var page pagination.Page[repo.Product]
+	json.NewDecoder(resp.Body).Decode(&page)
+	assert.Equal(t, 2, len(page.Data))
+	assert.Equal(t, "Product 1", page.Data[0].Name)
+	assert.Equal(t, "Product 2", page.Data[1].Name)
+	assert.Empty(t, page.NextCursor)
+	resp.Body.Close()
+}
+
+func TestListProductsPagination(t *testing.T) {
+	conn, err := pgxmock.NewConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close(context.Background())
+
+	createdAt := time.Date(2025, 12, 24, 14, 2, 58, 452793000, time.FixedZone("", -3*3600))
+	// The first page fetches limit+1 rows to know whether there is a next page
+	conn.ExpectQuery("FROM products").
+		WithArgs(pgtype.Int4{Int32: 5000, Valid: true}, pgtype.Int4{}, true, pgtype.Text{String: `App\_`, Valid: true},
+			pgtype.Int8{}, "price_in_cents", true, pgtype.Text{}, pgtype.Int4{}, pgtype.Timestamptz{}, int32(2)).
+		WillReturnRows(pgxmock.NewRows([]string{"id", "name", "price_in_cents", "quantity", "created_at", "deleted_at"}).
+			AddRow(int64(2), "App_le Watch", int32(20000), int32(20), createdAt, nil).
+			AddRow(int64(1), "App_le TV", int32(10000), int32(10), createdAt, nil))
+	productsService := products.NewService(repo.New(conn), conn)
+	productsHandler := products.NewHandler(productsService)
+	r2 := chi.NewRouter()
+	r2.Get("/products", productsHandler.ListProducts)
+	server := httptest.NewServer(r2)
+	defer server.Close()
+
+	query := "/products?limit=1&sort=-price_in_cents&min_price=5000&in_stock=true&name_prefix=App_"
+	resp, err := http.Get(server.URL + query)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var page pagination.Page[repo.Product]
+	json.NewDecoder(resp.Body).Decode(&page)
+	resp.Body.Close()
+	assert.Equal(t, 1, len(page.Data))
+	assert.Equal(t, "App_le Watch", page.Data[0].Name)
+	assert.NotEmpty(t, page.NextCursor)
+	cursor := page.NextCursor
+
+	// The next page continues after the last product of the previous one
+	conn.ExpectQuery("FROM products").
+		WithArgs(pgtype.Int4{Int32: 5000, Valid: true}, pgtype.Int4{}, true, pgtype.Text{String: `App\_`, Valid: true},
+			pgtype.Int8{Int64: 2, Valid: true}, "price_in_cents", true, pgtype.Text{String: "App_le Watch", Valid: true},
+			pgtype.Int4{Int32: 20000, Valid: true}, pgxmock.AnyArg(), int32(2)).
+		WillReturnRows(pgxmock.NewRows([]string{"id", "name", "price_in_cents", "quantity", "created_at", "deleted_at"}).
+			AddRow(int64(1), "App_le TV", int32(10000), int32(10), createdAt, nil))
+	resp, err = http.Get(server.URL + query + "&cursor=" + cursor)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	page = pagination.Page[repo.Product]{}
+	json.NewDecoder(resp.Body).Decode(&page)
+	resp.Body.Close()
+	assert.Equal(t, 1, len(page.Data))
+	assert.Equal(t, "App_le TV", page.Data[0].Name)
+	assert.Empty(t, page.NextCursor)
+
+	// A cursor cannot be reused with another sort
+	resp, err = http.Get(server.URL + "/products?sort=name&cursor=" + cursor)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
 	resp.Body.Close()
+	assert.NoError(t, conn.ExpectationsWereMet())
 }
 
 func TestCreateAndGetOrder(t *testing.T) {
@@ -118,35 +235,37 @@ func TestCreateAndGetOrder(t *testing.T) {
 	}()
 
 	conn.ExpectBegin()
+	// Transaction queries: FindCustomerById and FindCustomerAddress
+	expectShippingAddress(conn, 1, 1)
 	// Transaction query: CreateOrder
 	conn.ExpectQuery("INSERT INTO orders").
 		WithArgs(int64(1)).
-		WillReturnRows(pgxmock.NewRows([]string{"id", "customer_id", "created_at"}).
-			AddRow(int64(1), int64(1), time.Date(2025, 12, 24, 14, 2, 58, 452793000, time.FixedZone("", -3*3600))))
-	// Original connection query: FindProductById (for order item validation)
-	conn.ExpectQuery("FROM products").
-		WithArgs(int64(1)).
-		WillReturnRows(pgxmock.NewRows([]string{"id", "name", "price_in_cents", "quantity", "created_at"}).
-			AddRow(int64(1), "Product 1", int32(10000), int32(10), time.Date(2025, 12, 24, 14, 2, 58, 452793000, time.FixedZone("", -3*3600))))
-	// Transaction query: CreateOrderItem
+		WillReturnRows(pgxmock.NewRows([]string{"id", "customer_id", "created_at", "status"}).
+			AddRow(int64(1), int64(1), time.Date(2025, 12, 24, 14, 2, 58, 452793000, time.FixedZone("", -3*3600)), "pending"))
+	// Transaction query: CreateOrderAddress (snapshot of the shipping address)
+	expectOrderAddress(conn, 1)
+	// Transaction queries: lock the product and allocate the line to the
+	// first location holding enough stock
+	expectProductLock(conn, 1, 10)
+	expectProductStock(conn, 1,
+		products.LocationStock{LocationId: 1, Priority: 0, Quantity: 0},
+		products.LocationStock{LocationId: 2, Priority: 1, Quantity: 10})
+	expectStockChange(conn, 1, 2, -1, 9, products.ReasonSale, 1)
+	// Transaction query: CreateOrderItem and its allocation
 	conn.ExpectQuery("INSERT INTO order_items").
 		WithArgs(int64(1), int64(1), int32(1), int32(10000)).
 		WillReturnRows(pgxmock.NewRows([]string{"id", "order_id", "product_id", "quantity", "price_cents"}).
 			AddRow(int64(1), int64(1), int64(1), int32(1), int32(10000)))
-	// Original connection query: FindProductById (called by RemoveProductStock)
-	conn.ExpectQuery("FROM products").
-		WithArgs(int64(1)).
-		WillReturnRows(pgxmock.NewRows([]string{"id", "name", "price_in_cents", "quantity", "created_at"}).
-			AddRow(int64(1), "Product 1", int32(10000), int32(10), time.Date(2025, 12, 24, 14, 2, 58, 452793000, time.FixedZone("", -3*3600))))
-	// Original connection query: UpdateProduct (called by RemoveProductStock) - uses RETURNING so it's QueryRow
-	conn.ExpectQuery("UPDATE products").
-		WithArgs(int64(1), "Product 1", int32(10000), int32(9)).
-		WillReturnRows(pgxmock.NewRows([]string{"id", "name", "price_in_cents", "quantity", "created_at"}).
-			AddRow(int64(1), "Product 1", int32(10000), int32(9), time.Date(2025, 12, 24, 14, 2, 58, 452793000, time.FixedZone("", -3*3600))))
+	conn.ExpectQuery("INSERT INTO order_item_allocations").
+		WithArgs(int64(1), int64(2), int32(1)).
+		WillReturnRows(pgxmock.NewRows([]string{"id", "order_item_id", "location_id", "quantity"}).
+			AddRow(int64(1), int64(1), int64(2), int32(1)))
+	// Transaction query: CreateOutboxEvent (order.placed)
+	expectOutboxEvent(conn, outbox.AggregateOrder, 1, outbox.EventOrderPlaced)
 	conn.ExpectCommit()
-	productsService := products.NewService(repo.New(conn))
+	productsService := products.NewService(repo.New(conn), conn)
 	// Use NewServiceWithDB to pass the mock connection directly (it implements the dbConn interface)
-	ordersService := orders.NewServiceWithDB(repo.New(conn), conn, productsService)
+	ordersService := orders.NewServiceWithDB(repo.New(conn), conn, productsService, products.SingleLocationPreferred{})
 	ordersHandler := orders.NewHandler(ordersService)
 	r2 := chi.NewRouter()
 	r2.Post("/orders", ordersHandler.PlaceOrder)
@@ -154,15 +273,22 @@ func TestCreateAndGetOrder(t *testing.T) {
 	server := httptest.NewServer(r2)
 	defer server.Close()
 	orderParams := orders.CreateOrderParams{
-		CustomerId: 1,
+		CustomerId:        1,
+		ShippingAddressId: 1,
 		Items: []orders.OrderItemsParams{
 			{ProductId: 1, Quantity: 1},
 		},
 	}
+	ordersPlaced := testutil.ToFloat64(metrics.OrdersPlaced)
+	itemsSold := testutil.ToFloat64(metrics.ItemsSold)
+	revenue := testutil.ToFloat64(metrics.RevenueCents)
 	jsonOrder, _ := json.Marshal(orderParams)
 	resp, err := http.Post(server.URL+"/orders", "application/json", bytes.NewBuffer(jsonOrder))
 	assert.NoError(t, err)
 	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Equal(t, ordersPlaced+1, testutil.ToFloat64(metrics.OrdersPlaced))
+	assert.Equal(t, itemsSold+1, testutil.ToFloat64(metrics.ItemsSold))
+	assert.Equal(t, revenue+10000, testutil.ToFloat64(metrics.RevenueCents))
 
 	var createdOrder repo.Order
 	json.NewDecoder(resp.Body).Decode(&createdOrder)
@@ -180,8 +306,16 @@ func TestCreateAndGetOrder(t *testing.T) {
 	// Use the simplest unique pattern - "WHERE o.id" should be sufficient
 	conn.ExpectQuery("WHERE o.id").
 		WithArgs(int64(1)).
-		WillReturnRows(pgxmock.NewRows([]string{"order_id", "customer_id", "created_at", "order_item_id", "product_id", "quantity", "price_cents"}).
-			AddRow(int64(1), int64(1), time.Date(2025, 12, 24, 14, 2, 58, 452793000, time.FixedZone("", -3*3600)), orderItemID, productID, quantity, priceCents))
+		WillReturnRows(pgxmock.NewRows([]string{"order_id", "customer_id", "created_at", "status", "order_item_id", "product_id", "quantity", "price_cents"}).
+			AddRow(int64(1), int64(1), time.Date(2025, 12, 24, 14, 2, 58, 452793000, time.FixedZone("", -3*3600)), "pending", orderItemID, productID, quantity, priceCents))
+	conn.ExpectQuery("FROM\\s+order_item_allocations").
+		WithArgs(int64(1)).
+		WillReturnRows(pgxmock.NewRows([]string{"id", "order_item_id", "product_id", "location_id", "quantity"}).
+			AddRow(int64(1), int64(1), int64(1), int64(2), int32(1)))
+	conn.ExpectQuery("FROM order_addresses").
+		WithArgs(int64(1)).
+		WillReturnRows(pgxmock.NewRows(orderAddressColumns).
+			AddRow(int64(1), "shipping", "1 Infinite Loop", "", "Cupertino", "CA", "95014", "US"))
 
 	resp, err = http.Get(server.URL + "/orders/1")
 	assert.NoError(t, err)
@@ -194,5 +328,2511 @@ func TestCreateAndGetOrder(t *testing.T) {
 	assert.Equal(t, int64(1), retrievedOrder.Items[0].ProductID)
 	assert.Equal(t, int32(1), retrievedOrder.Items[0].Quantity)
 	assert.Equal(t, int32(10000), retrievedOrder.Items[0].PriceCents)
+	assert.Equal(t, []products.Allocation{{LocationId: 2, Quantity: 1}}, retrievedOrder.Items[0].Allocations)
+	assert.Equal(t, "1 Infinite Loop", retrievedOrder.ShippingAddress.Line1)
+	resp.Body.Close()
+}
+
+func TestPlaceOrderWithoutStock(t *testing.T) {
+	conn, err := pgxmock.NewConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close(context.Background())
+
+	conn.ExpectBegin()
+	expectShippingAddress(conn, 1, 1)
+	conn.ExpectQuery("INSERT INTO orders").
+		WithArgs(int64(1)).
+		WillReturnRows(pgxmock.NewRows([]string{"id", "customer_id", "created_at", "status"}).
+			AddRow(int64(1), int64(1), time.Date(2025, 12, 24, 14, 2, 58, 452793000, time.FixedZone("", -3*3600)), "pending"))
+	expectOrderAddress(conn, 1)
+	// The locked product has less stock than ordered
+	expectProductLock(conn, 1, 1)
+	conn.ExpectRollback()
+
+	productsService := products.NewService(repo.New(conn), conn)
+	ordersService := orders.NewServiceWithDB(repo.New(conn), conn, productsService, products.SingleLocationPreferred{})
+	ordersHandler := orders.NewHandler(ordersService)
+	r2 := chi.NewRouter()
+	r2.Post("/orders", ordersHandler.PlaceOrder)
+	server := httptest.NewServer(r2)
+	defer server.Close()
+
+	jsonOrder, _ := json.Marshal(orders.CreateOrderParams{
+		CustomerId:        1,
+		ShippingAddressId: 1,
+		Items:             []orders.OrderItemsParams{{ProductId: 1, Quantity: 2}},
+	})
+	rejections := testutil.ToFloat64(metrics.OutOfStockRejections)
+	ordersPlaced := testutil.ToFloat64(metrics.OrdersPlaced)
+	resp, err := http.Post(server.URL+"/orders", "application/json", bytes.NewBuffer(jsonOrder))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusExpectationFailed, resp.StatusCode)
+	assert.Equal(t, rejections+1, testutil.ToFloat64(metrics.OutOfStockRejections))
+	assert.Equal(t, ordersPlaced, testutil.ToFloat64(metrics.OrdersPlaced))
+	resp.Body.Close()
+	assert.NoError(t, conn.ExpectationsWereMet())
+}
+
+func TestPlaceOrderLocksProductsInOrder(t *testing.T) {
+	conn, err := pgxmock.NewConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close(context.Background())
+
+	conn.ExpectBegin()
+	expectShippingAddress(conn, 1, 1)
+	conn.ExpectQuery("INSERT INTO orders").
+		WithArgs(int64(1)).
+		WillReturnRows(pgxmock.NewRows([]string{"id", "customer_id", "created_at", "status"}).
+			AddRow(int64(1), int64(1), time.Date(2025, 12, 24, 14, 2, 58, 452793000, time.FixedZone("", -3*3600)), "pending"))
+	expectOrderAddress(conn, 1)
+	// The products are locked by id whatever the order of the items, so two
+	// orders for the same products cannot deadlock
+	expectProductLock(conn, 1, 10)
+	expectProductStock(conn, 1, products.LocationStock{LocationId: 1, Priority: 0, Quantity: 10})
+	expectStockChange(conn, 1, 1, -2, 8, products.ReasonSale, 1)
+	expectProductLock(conn, 3, 10)
+	expectProductStock(conn, 3, products.LocationStock{LocationId: 1, Priority: 0, Quantity: 10})
+	expectStockChange(conn, 3, 1, -1, 9, products.ReasonSale, 1)
+	// The items keep the order of the request
+	for i, item := range []orders.OrderItemsParams{{ProductId: 3, Quantity: 1}, {ProductId: 1, Quantity: 2}} {
+		itemId := int64(i + 1)
+		conn.ExpectQuery("INSERT INTO order_items").
+			WithArgs(int64(1), item.ProductId, item.Quantity, int32(10000)).
+			WillReturnRows(pgxmock.NewRows([]string{"id", "order_id", "product_id", "quantity", "price_cents"}).
+				AddRow(itemId, int64(1), item.ProductId, item.Quantity, int32(10000)))
+		conn.ExpectQuery("INSERT INTO order_item_allocations").
+			WithArgs(itemId, int64(1), item.Quantity).
+			WillReturnRows(pgxmock.NewRows([]string{"id", "order_item_id", "location_id", "quantity"}).
+				AddRow(itemId, itemId, int64(1), item.Quantity))
+	}
+	expectOutboxEvent(conn, outbox.AggregateOrder, 1, outbox.EventOrderPlaced)
+	conn.ExpectCommit()
+
+	productsService := products.NewService(repo.New(conn), conn)
+	ordersService := orders.NewServiceWithDB(repo.New(conn), conn, productsService, products.SingleLocationPreferred{})
+	_, err = ordersService.PlaceOrder(context.Background(), orders.CreateOrderParams{
+		CustomerId:        1,
+		ShippingAddressId: 1,
+		Items:             []orders.OrderItemsParams{{ProductId: 3, Quantity: 1}, {ProductId: 1, Quantity: 2}},
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, conn.ExpectationsWereMet())
+}
+
+// TestConcurrentOrdersForLastUnit runs against a real database, set
+// TEST_DATABASE_URL to a migrated database, as just tests-db does, to enable
+// it.
+func TestConcurrentOrdersForLastUnit(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	ctx := context.Background()
+	pool, err := postgresql.NewPool(ctx, postgresql.PoolConfig{DSN: dsn, MaxConns: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+	productsService := products.NewService(repo.New(pool), pool)
+	product, err := productsService.CreateProduct(ctx, products.CreateProductParams{
+		Name:         "Last Unit",
+		PriceInCents: 1000,
+		Quantity:     1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	customersService := customers.NewService(repo.New(pool))
+	customer, err := customersService.CreateCustomer(ctx, customers.CustomerParams{
+		Name:  "Buyer",
+		Email: fmt.Sprintf("buyer-%d@example.com", product.ID),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	address, err := customersService.AddAddress(ctx, customer.ID, customers.AddressParams{
+		Kind:       customers.AddressShipping,
+		Line1:      "1 Infinite Loop",
+		City:       "Cupertino",
+		PostalCode: "95014",
+		Country:    "US",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const buyers = 10
+	ordersService := orders.NewService(repo.New(pool), pool, productsService, products.SingleLocationPreferred{})
+	var wg sync.WaitGroup
+	results := make(chan error, buyers)
+	for range buyers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := ordersService.PlaceOrder(ctx, orders.CreateOrderParams{
+				CustomerId:        customer.ID,
+				ShippingAddressId: address.ID,
+				Items:             []orders.OrderItemsParams{{ProductId: product.ID, Quantity: 1}},
+			})
+			results <- err
+		}()
+	}
+	wg.Wait()
+	close(results)
+
+	placed := 0
+	for err := range results {
+		if err == nil {
+			placed++
+			continue
+		}
+		assert.ErrorIs(t, err, orders.ErrProductNoStock)
+	}
+	assert.Equal(t, 1, placed)
+	p, err := productsService.FindProductById(ctx, product.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(0), p.Quantity)
+}
+
+func TestOrderTransitions(t *testing.T) {
+	conn, err := pgxmock.NewConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close(context.Background())
+
+	createdAt := time.Date(2025, 12, 24, 14, 2, 58, 452793000, time.FixedZone("", -3*3600))
+	// pending → paid is allowed
+	conn.ExpectBegin()
+	conn.ExpectQuery("FOR UPDATE").
+		WithArgs(int64(1)).
+		WillReturnRows(pgxmock.NewRows([]string{"id", "customer_id", "created_at", "status"}).
+			AddRow(int64(1), int64(1), createdAt, "pending"))
+	conn.ExpectQuery("UPDATE orders").
+		WithArgs(int64(1), "paid").
+		WillReturnRows(pgxmock.NewRows([]string{"id", "customer_id", "created_at", "status"}).
+			AddRow(int64(1), int64(1), createdAt, "paid"))
+	conn.ExpectQuery("INSERT INTO order_status_changes").
+		WithArgs(int64(1), "pending", "paid", "backoffice", "").
+		WillReturnRows(pgxmock.NewRows([]string{"id", "order_id", "from_status", "to_status", "changed_by", "reason", "created_at"}).
+			AddRow(int64(1), int64(1), "pending", "paid", "backoffice", "", createdAt))
+	conn.ExpectCommit()
+	// paid → delivered skips fulfilment and shipping
+	conn.ExpectBegin()
+	conn.ExpectQuery("FOR UPDATE").
+		WithArgs(int64(1)).
+		WillReturnRows(pgxmock.NewRows([]string{"id", "customer_id", "created_at", "status"}).
+			AddRow(int64(1), int64(1), createdAt, "paid"))
+	conn.ExpectRollback()
+
+	productsService := products.NewService(repo.New(conn), conn)
+	ordersService := orders.NewServiceWithDB(repo.New(conn), conn, productsService, products.SingleLocationPreferred{})
+	ordersHandler := orders.NewHandler(ordersService)
+	r2 := chi.NewRouter()
+	r2.Use(authenticatedAs(auth.Principal{Subject: "backoffice", Method: auth.MethodJWT, Roles: []string{auth.RoleStaff}}))
+	r2.Post("/orders/{id}/transitions", ordersHandler.TransitionOrder)
+	server := httptest.NewServer(r2)
+	defer server.Close()
+
+	// The change is recorded as made by the principal, whatever the body says
+	body := []byte(`{"status":"paid"}`)
+	resp, err := http.Post(server.URL+"/orders/1/transitions", "application/json", bytes.NewBuffer(body))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var order repo.Order
+	json.NewDecoder(resp.Body).Decode(&order)
+	assert.Equal(t, "paid", order.Status)
+	resp.Body.Close()
+
+	body, _ = json.Marshal(orders.TransitionParams{Status: orders.StatusDelivered})
+	resp, err = http.Post(server.URL+"/orders/1/transitions", "application/json", bytes.NewBuffer(body))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+	var errResp map[string]string
+	json.NewDecoder(resp.Body).Decode(&errResp)
+	assert.Equal(t, "invalid_transition", errResp["error_code"])
+	resp.Body.Close()
+	assert.NoError(t, conn.ExpectationsWereMet())
+}
+
+func TestCancelOrderRestocks(t *testing.T) {
+	conn, err := pgxmock.NewConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close(context.Background())
+
+	createdAt := time.Date(2025, 12, 24, 14, 2, 58, 452793000, time.FixedZone("", -3*3600))
+	conn.ExpectBegin()
+	conn.ExpectQuery("FOR UPDATE").
+		WithArgs(int64(1)).
+		WillReturnRows(pgxmock.NewRows([]string{"id", "customer_id", "created_at", "status"}).
+			AddRow(int64(1), int64(1), createdAt, "pending"))
+	conn.ExpectQuery("UPDATE orders").
+		WithArgs(int64(1), "cancelled").
+		WillReturnRows(pgxmock.NewRows([]string{"id", "customer_id", "created_at", "status"}).
+			AddRow(int64(1), int64(1), createdAt, "cancelled"))
+	// The item was split over two locations, each gets its units back
+	conn.ExpectQuery("FROM\\s+order_item_allocations").
+		WithArgs(int64(1)).
+		WillReturnRows(pgxmock.NewRows([]string{"id", "order_item_id", "product_id", "location_id", "quantity"}).
+			AddRow(int64(1), int64(1), int64(1), int64(1), int32(2)).
+			AddRow(int64(2), int64(1), int64(1), int64(2), int32(1)))
+	expectProductLock(conn, 1, 7)
+	expectStockChange(conn, 1, 1, 2, 2, products.ReasonCancellation, 1)
+	expectProductLock(conn, 1, 9)
+	expectStockChange(conn, 1, 2, 1, 8, products.ReasonCancellation, 1)
+	expectOutboxEvent(conn, outbox.AggregateOrder, 1, outbox.EventOrderCancelled)
+	conn.ExpectQuery("INSERT INTO order_status_changes").
+		WithArgs(int64(1), "pending", "cancelled", "customer", "changed my mind").
+		WillReturnRows(pgxmock.NewRows([]string{"id", "order_id", "from_status", "to_status", "changed_by", "reason", "created_at"}).
+			AddRow(int64(1), int64(1), "pending", "cancelled", "customer", "changed my mind", createdAt))
+	conn.ExpectCommit()
+	// Cancelling again does not restock twice
+	conn.ExpectBegin()
+	conn.ExpectQuery("FOR UPDATE").
+		WithArgs(int64(1)).
+		WillReturnRows(pgxmock.NewRows([]string{"id", "customer_id", "created_at", "status"}).
+			AddRow(int64(1), int64(1), createdAt, "cancelled"))
+	conn.ExpectRollback()
+	// Shipped orders cannot be cancelled
+	conn.ExpectBegin()
+	conn.ExpectQuery("FOR UPDATE").
+		WithArgs(int64(2)).
+		WillReturnRows(pgxmock.NewRows([]string{"id", "customer_id", "created_at", "status"}).
+			AddRow(int64(2), int64(1), createdAt, "shipped"))
+	conn.ExpectRollback()
+	// Customers only cancel their own orders
+	conn.ExpectBegin()
+	conn.ExpectQuery("FOR UPDATE").
+		WithArgs(int64(3)).
+		WillReturnRows(pgxmock.NewRows([]string{"id", "customer_id", "created_at", "status"}).
+			AddRow(int64(3), int64(1), createdAt, "pending"))
+	conn.ExpectRollback()
+	// A paid order is refunded what is left of its payment
+	paymentColumns := []string{"id", "order_id", "amount_in_cents", "status", "gateway_reference", "failure_reason", "created_at", "updated_at", "refunded_in_cents"}
+	expectPaidOrderCancel := func(orderId int64, reference string) {
+		conn.ExpectBegin()
+		conn.ExpectQuery("FOR UPDATE").
+			WithArgs(orderId).
+			WillReturnRows(pgxmock.NewRows([]string{"id", "customer_id", "created_at", "status"}).
+				AddRow(orderId, int64(1), createdAt, "paid"))
+		conn.ExpectQuery("UPDATE orders").
+			WithArgs(orderId, "cancelled").
+			WillReturnRows(pgxmock.NewRows([]string{"id", "customer_id", "created_at", "status"}).
+				AddRow(orderId, int64(1), createdAt, "cancelled"))
+		conn.ExpectQuery("FROM\\s+order_item_allocations").
+			WithArgs(orderId).
+			WillReturnRows(pgxmock.NewRows([]string{"id", "order_item_id", "product_id", "location_id", "quantity"}))
+		expectOutboxEvent(conn, outbox.AggregateOrder, orderId, outbox.EventOrderCancelled)
+		conn.ExpectQuery("INSERT INTO order_status_changes").
+			WithArgs(orderId, "paid", "cancelled", "customer", "changed my mind").
+			WillReturnRows(pgxmock.NewRows([]string{"id", "order_id", "from_status", "to_status", "changed_by", "reason", "created_at"}).
+				AddRow(int64(2), orderId, "paid", "cancelled", "customer", "changed my mind", createdAt))
+		conn.ExpectQuery("status = 'captured'").
+			WithArgs(orderId).
+			WillReturnRows(pgxmock.NewRows(paymentColumns).AddRow(orderId, orderId, int64(20000), "captured", reference, "", createdAt, createdAt, int64(5000)))
+		conn.ExpectQuery("UPDATE payments").
+			WithArgs(int64(15000), orderId).
+			WillReturnRows(pgxmock.NewRows(paymentColumns).AddRow(orderId, orderId, int64(20000), "refunded", reference, "", createdAt, createdAt, int64(20000)))
+	}
+	expectPaidOrderCancel(4, "fake_4_4")
+	conn.ExpectCommit()
+	// The order stays paid when the gateway fails to refund it
+	expectPaidOrderCancel(5, "fake_unknown")
+	conn.ExpectRollback()
+
+	gateway := payments.NewFakeGateway()
+	reference, err := gateway.Authorize(context.Background(), payments.AuthorizeRequest{OrderId: 4, PaymentId: 4, AmountInCents: 20000, PaymentToken: "tok_ok"})
+	assert.NoError(t, err)
+	assert.NoError(t, gateway.Capture(context.Background(), reference, 20000))
+	assert.NoError(t, gateway.Refund(context.Background(), payments.RefundRequest{Reference: reference, AmountInCents: 5000, IdempotencyKey: "return-1"}))
+
+	productsService := products.NewService(repo.New(conn), conn)
+	ordersService := orders.NewServiceWithDB(repo.New(conn), conn, productsService, products.SingleLocationPreferred{})
+	paymentsHandler := payments.NewHandler(payments.NewService(repo.New(conn), gateway, ordersService))
+	r2 := chi.NewRouter()
+	r2.Use(authenticatedAs(auth.Principal{Subject: "customer", Method: auth.MethodJWT, Roles: []string{auth.RoleStaff}}))
+	r2.Post("/orders/{id}/cancel", paymentsHandler.CancelOrder)
+	server := httptest.NewServer(r2)
+	defer server.Close()
+
+	body, _ := json.Marshal(orders.CancelParams{Reason: "changed my mind"})
+	for range 2 {
+		resp, err := http.Post(server.URL+"/orders/1/cancel", "application/json", bytes.NewBuffer(body))
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		var order repo.Order
+		json.NewDecoder(resp.Body).Decode(&order)
+		assert.Equal(t, "cancelled", order.Status)
+		resp.Body.Close()
+	}
+
+	resp, err := http.Post(server.URL+"/orders/2/cancel", "application/json", bytes.NewBuffer(body))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+	resp.Body.Close()
+
+	r3 := chi.NewRouter()
+	r3.Use(authenticatedAs(auth.Principal{Subject: "user-2", Method: auth.MethodJWT, Roles: []string{auth.RoleCustomer}, CustomerId: 2}))
+	r3.Post("/orders/{id}/cancel", paymentsHandler.CancelOrder)
+	customerServer := httptest.NewServer(r3)
+	defer customerServer.Close()
+	resp, err = http.Post(customerServer.URL+"/orders/3/cancel", "application/json", bytes.NewBuffer(body))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	resp.Body.Close()
+
+	resp, err = http.Post(server.URL+"/orders/4/cancel", "application/json", bytes.NewBuffer(body))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp.Body.Close()
+	// Nothing is left to refund on the gateway
+	assert.Error(t, gateway.Refund(context.Background(), payments.RefundRequest{Reference: reference, AmountInCents: 1, IdempotencyKey: "other"}))
+
+	resp, err = http.Post(server.URL+"/orders/5/cancel", "application/json", bytes.NewBuffer(body))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+	resp.Body.Close()
+	assert.NoError(t, conn.ExpectationsWereMet())
+}
+
+func TestPatchAndDeleteProduct(t *testing.T) {
+	conn, err := pgxmock.NewConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close(context.Background())
+
+	createdAt := time.Date(2025, 12, 24, 14, 2, 58, 452793000, time.FixedZone("", -3*3600))
+	productColumns := []string{"id", "name", "price_in_cents", "quantity", "created_at", "deleted_at"}
+	// Only the price is patched, the other fields keep the value of the
+	// locked row
+	conn.ExpectBegin()
+	conn.ExpectQuery("FOR UPDATE").
+		WithArgs(int64(1)).
+		WillReturnRows(pgxmock.NewRows(productColumns).
+			AddRow(int64(1), "Apple Watch", int32(104900), int32(10), createdAt, nil))
+	conn.ExpectQuery("UPDATE products").
+		WithArgs(int64(1), "Apple Watch", int32(99900)).
+		WillReturnRows(pgxmock.NewRows(productColumns).
+			AddRow(int64(1), "Apple Watch", int32(99900), int32(10), createdAt, nil))
+	// The quantity did not change, so no stock event is recorded
+	conn.ExpectCommit()
+	// Removing a required field is rejected
+	conn.ExpectBegin()
+	conn.ExpectQuery("FOR UPDATE").
+		WithArgs(int64(1)).
+		WillReturnRows(pgxmock.NewRows(productColumns).
+			AddRow(int64(1), "Apple Watch", int32(99900), int32(10), createdAt, nil))
+	conn.ExpectRollback()
+	// Soft delete
+	conn.ExpectQuery("SET deleted_at").
+		WithArgs(int64(1)).
+		WillReturnRows(pgxmock.NewRows(productColumns).
+			AddRow(int64(1), "Apple Watch", int32(99900), int32(10), createdAt, createdAt))
+
+	productsService := products.NewService(repo.New(conn), conn)
+	productsHandler := products.NewHandler(productsService)
+	r2 := chi.NewRouter()
+	r2.Patch("/products/{id}", productsHandler.PatchProduct)
+	r2.Delete("/products/{id}", productsHandler.DeleteProduct)
+	server := httptest.NewServer(r2)
+	defer server.Close()
+
+	patch := func(body string) *http.Response {
+		req, _ := http.NewRequest(http.MethodPatch, server.URL+"/products/1", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/merge-patch+json")
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		return resp
+	}
+	resp := patch(`{"price_in_cents": 99900}`)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var product repo.Product
+	json.NewDecoder(resp.Body).Decode(&product)
+	assert.Equal(t, int32(99900), product.PriceInCents)
+	assert.Equal(t, "Apple Watch", product.Name)
+	resp.Body.Close()
+
+	resp = patch(`{"name": null}`)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp.Body.Close()
+
+	req, _ := http.NewRequest(http.MethodDelete, server.URL+"/products/1", nil)
+	resp, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	resp.Body.Close()
+	assert.NoError(t, conn.ExpectationsWereMet())
+}
+
+func TestInventoryMovements(t *testing.T) {
+	conn, err := pgxmock.NewConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close(context.Background())
+
+	createdAt := time.Date(2025, 12, 24, 14, 2, 58, 452793000, time.FixedZone("", -3*3600))
+	productColumns := []string{"id", "name", "price_in_cents", "quantity", "created_at", "deleted_at"}
+	movementColumns := []string{"id", "product_id", "delta", "quantity", "reason", "order_id", "created_at", "location_id"}
+	// Overwriting the quantity is recorded as an adjustment at the default
+	// location
+	conn.ExpectBegin()
+	conn.ExpectQuery("FOR UPDATE").
+		WithArgs(int64(1)).
+		WillReturnRows(pgxmock.NewRows(productColumns).
+			AddRow(int64(1), "Apple Watch", int32(104900), int32(10), createdAt, nil))
+	expectDefaultLocation(conn)
+	expectStockChange(conn, 1, 1, -3, 5, products.ReasonAdjustment, 0)
+	conn.ExpectQuery("UPDATE products").
+		WithArgs(int64(1), "Apple Watch", int32(104900)).
+		WillReturnRows(pgxmock.NewRows(productColumns).
+			AddRow(int64(1), "Apple Watch", int32(104900), int32(7), createdAt, nil))
+	conn.ExpectCommit()
+	// The history, newest first
+	conn.ExpectQuery("FROM products").
+		WithArgs(int64(1)).
+		WillReturnRows(pgxmock.NewRows(productColumns).
+			AddRow(int64(1), "Apple Watch", int32(104900), int32(7), createdAt, nil))
+	conn.ExpectQuery("FROM\\s+inventory_movements").
+		WithArgs(int64(1), pgtype.Int8{}, int32(2)).
+		WillReturnRows(pgxmock.NewRows(movementColumns).
+			AddRow(int64(3), int64(1), int32(-3), int32(5), products.ReasonAdjustment, pgtype.Int8{}, createdAt, int64(1)).
+			AddRow(int64(2), int64(1), int32(-1), int32(8), products.ReasonSale, pgtype.Int8{Int64: 1, Valid: true}, createdAt, int64(1)))
+	// Unknown product
+	conn.ExpectQuery("FROM products").
+		WithArgs(int64(2)).
+		WillReturnError(pgx.ErrNoRows)
+	// Reconciliation reports the stock that was changed outside of the
+	// ledger
+	conn.ExpectQuery("FULL JOIN").
+		WillReturnRows(pgxmock.NewRows([]string{"product_id", "location_id", "quantity", "ledger_quantity", "drift"}).
+			AddRow(int64(2), int64(1), int32(5), int32(3), int32(2)))
+
+	productsService := products.NewService(repo.New(conn), conn)
+	productsHandler := products.NewHandler(productsService)
+	r := chi.NewRouter()
+	r.Put("/products/{id}", productsHandler.UpdateProduct)
+	r.Get("/products/{id}/inventory/movements", productsHandler.ListInventoryMovements)
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	req, _ := http.NewRequest(http.MethodPut, server.URL+"/products/1", bytes.NewBufferString(`{"name":"Apple Watch","price_in_cents":104900,"quantity":7}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp.Body.Close()
+
+	resp, err = http.Get(server.URL + "/products/1/inventory/movements?limit=1")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var page pagination.Page[repo.InventoryMovement]
+	json.NewDecoder(resp.Body).Decode(&page)
+	resp.Body.Close()
+	assert.Len(t, page.Data, 1)
+	assert.Equal(t, products.ReasonAdjustment, page.Data[0].Reason)
+	assert.Equal(t, int32(-3), page.Data[0].Delta)
+	assert.NotEmpty(t, page.NextCursor)
+
+	resp, err = http.Get(server.URL + "/products/2/inventory/movements")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	resp.Body.Close()
+
+	var report bytes.Buffer
+	drifted, err := reconcileInventory(context.Background(), productsService, &report)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, drifted)
+	assert.Equal(t, "PRODUCT  LOCATION  QUANTITY  LEDGER  DRIFT\n2        1         5         3       +2\n", report.String())
+	assert.NoError(t, conn.ExpectationsWereMet())
+}
+
+func TestStockAllocation(t *testing.T) {
+	// In priority order: the first location is the most expensive one
+	stock := []products.LocationStock{
+		{LocationId: 1, Priority: 0, ShippingCostInCents: 500, Quantity: 2},
+		{LocationId: 2, Priority: 1, ShippingCostInCents: 100, Quantity: 3},
+		{LocationId: 3, Priority: 2, ShippingCostInCents: 300, Quantity: 5},
+	}
+	tests := []struct {
+		allocator products.Allocator
+		quantity  int32
+		want      []products.Allocation
+	}{
+		{products.SingleLocationPreferred{}, 2, []products.Allocation{{LocationId: 1, Quantity: 2}}},
+		{products.SingleLocationPreferred{}, 4, []products.Allocation{{LocationId: 3, Quantity: 4}}},
+		{products.SingleLocationPreferred{}, 7, []products.Allocation{{LocationId: 1, Quantity: 2}, {LocationId: 2, Quantity: 3}, {LocationId: 3, Quantity: 2}}},
+		{products.LowestCost{}, 4, []products.Allocation{{LocationId: 2, Quantity: 3}, {LocationId: 3, Quantity: 1}}},
+		{products.Split{}, 4, []products.Allocation{{LocationId: 1, Quantity: 2}, {LocationId: 2, Quantity: 2}}},
+	}
+	for _, tt := range tests {
+		got, ok := tt.allocator.Allocate(stock, tt.quantity)
+		assert.True(t, ok)
+		assert.Equal(t, tt.want, got, "%T allocating %d", tt.allocator, tt.quantity)
+	}
+	for _, allocator := range []products.Allocator{products.SingleLocationPreferred{}, products.LowestCost{}, products.Split{}} {
+		_, ok := allocator.Allocate(stock, 11)
+		assert.False(t, ok)
+	}
+}
+
+func TestStockLocations(t *testing.T) {
+	conn, err := pgxmock.NewConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close(context.Background())
+
+	createdAt := time.Date(2025, 12, 24, 14, 2, 58, 452793000, time.FixedZone("", -3*3600))
+	locationColumns := []string{"id", "name", "priority", "shipping_cost_in_cents", "is_default", "active", "created_at", "updated_at"}
+	conn.ExpectQuery("INSERT INTO stock_locations").
+		WithArgs("Lisbon", int32(1), int32(250)).
+		WillReturnRows(pgxmock.NewRows(locationColumns).
+			AddRow(int64(2), "Lisbon", int32(1), int32(250), false, true, createdAt, createdAt))
+	// The stock received at the new location is a restock
+	conn.ExpectBegin()
+	expectProductLock(conn, 1, 4)
+	conn.ExpectQuery("FROM\\s+stock_locations").
+		WithArgs(int64(2)).
+		WillReturnRows(pgxmock.NewRows(locationColumns).
+			AddRow(int64(2), "Lisbon", int32(1), int32(250), false, true, createdAt, createdAt))
+	expectProductStock(conn, 1, products.LocationStock{LocationId: 1, Quantity: 4})
+	expectStockChange(conn, 1, 2, 6, 6, products.ReasonRestock, 0)
+	expectProductStock(conn, 1,
+		products.LocationStock{LocationId: 1, Quantity: 4},
+		products.LocationStock{LocationId: 2, Priority: 1, ShippingCostInCents: 250, Quantity: 6})
+	conn.ExpectCommit()
+	// Unknown location
+	conn.ExpectBegin()
+	expectProductLock(conn, 1, 10)
+	conn.ExpectQuery("FROM\\s+stock_locations").
+		WithArgs(int64(3)).
+		WillReturnError(pgx.ErrNoRows)
+	conn.ExpectRollback()
+
+	productsHandler := products.NewHandler(products.NewService(repo.New(conn), conn))
+	locationsHandler := locations.NewHandler(locations.NewService(repo.New(conn)))
+	r := chi.NewRouter()
+	r.Post("/locations", locationsHandler.CreateLocation)
+	r.Put("/products/{id}/stock/{locationId}", productsHandler.SetLocationStock)
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	resp, err := http.Post(server.URL+"/locations", "application/json", bytes.NewBufferString(`{"name":"Lisbon","priority":1,"shipping_cost_in_cents":250}`))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
 	resp.Body.Close()
+
+	setStock := func(location string, body string) *http.Response {
+		req, _ := http.NewRequest(http.MethodPut, server.URL+"/products/1/stock/"+location, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		return resp
+	}
+	resp = setStock("2", `{"quantity":6,"reason":"restock"}`)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var stock []repo.ListProductStockRow
+	json.NewDecoder(resp.Body).Decode(&stock)
+	resp.Body.Close()
+	assert.Len(t, stock, 2)
+	assert.Equal(t, int32(6), stock[1].Quantity)
+
+	resp = setStock("2", `{"quantity":6,"reason":"sale"}`)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp.Body.Close()
+
+	resp = setStock("3", `{"quantity":1}`)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	resp.Body.Close()
+	assert.NoError(t, conn.ExpectationsWereMet())
+}
+
+// authenticatedAs puts the principal into the context of every request, as
+// the authenticator does.
+func authenticatedAs(p auth.Principal) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(auth.WithPrincipal(r.Context(), p)))
+		})
+	}
+}
+
+func TestIdempotentCreateProduct(t *testing.T) {
+	conn, err := pgxmock.NewConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close(context.Background())
+
+	createdAt := time.Date(2025, 12, 24, 14, 2, 58, 452793000, time.FixedZone("", -3*3600))
+	body := `{"name":"Apple Watch","price_in_cents":104900,"quantity":10}`
+	sum := sha256.Sum256([]byte("user-1 POST /products\n" + body))
+	fingerprint := hex.EncodeToString(sum[:])
+	keyColumns := []string{"key", "scope", "fingerprint", "status_code", "content_type", "response_body", "created_at", "completed_at"}
+	storedResponse := `{"id":1,"name":"Apple Watch","price_in_cents":104900,"quantity":10,"created_at":"2025-12-24T14:02:58.452793-03:00","deleted_at":null}` + "\n"
+
+	// First request: the key is stored with the response
+	conn.ExpectQuery("INSERT INTO idempotency_keys").
+		WithArgs("key-1", "user-1 POST /products", fingerprint, pgxmock.AnyArg()).
+		WillReturnRows(pgxmock.NewRows(keyColumns).
+			AddRow("key-1", "user-1 POST /products", fingerprint, nil, nil, nil, createdAt, nil))
+	conn.ExpectBegin()
+	conn.ExpectQuery("INSERT INTO products").
+		WithArgs("Apple Watch", int32(104900)).
+		WillReturnRows(pgxmock.NewRows([]string{"id", "name", "price_in_cents", "quantity", "created_at", "deleted_at"}).
+			AddRow(int64(1), "Apple Watch", int32(104900), int32(0), createdAt, nil))
+	expectDefaultLocation(conn)
+	conn.ExpectQuery("INSERT INTO product_stock").
+		WithArgs(int64(1), int64(1), int32(10)).
+		WillReturnRows(pgxmock.NewRows([]string{"product_id", "location_id", "quantity", "updated_at"}).
+			AddRow(int64(1), int64(1), int32(10), createdAt))
+	expectInventoryMovement(conn, 1, 1, 10, 10, products.ReasonRestock, 0)
+	expectOutboxEvent(conn, outbox.AggregateProduct, 1, outbox.EventProductCreated)
+	conn.ExpectCommit()
+	conn.ExpectExec("UPDATE idempotency_keys").
+		WithArgs("key-1", "user-1 POST /products", pgtype.Int4{Int32: 201, Valid: true}, pgtype.Text{String: "application/json", Valid: true}, []byte(storedResponse)).
+		WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+	// Retry: the stored response is replayed without creating the product
+	conn.ExpectQuery("INSERT INTO idempotency_keys").
+		WithArgs("key-1", "user-1 POST /products", fingerprint, pgxmock.AnyArg()).
+		WillReturnRows(pgxmock.NewRows(keyColumns))
+	conn.ExpectQuery("FROM idempotency_keys").
+		WithArgs("key-1", "user-1 POST /products").
+		WillReturnRows(pgxmock.NewRows(keyColumns).
+			AddRow("key-1", "user-1 POST /products", fingerprint, pgtype.Int4{Int32: 201, Valid: true}, pgtype.Text{String: "application/json", Valid: true}, []byte(storedResponse), createdAt, createdAt))
+	// Same key with another body is rejected
+	conn.ExpectQuery("INSERT INTO idempotency_keys").
+		WithArgs("key-1", "user-1 POST /products", pgxmock.AnyArg(), pgxmock.AnyArg()).
+		WillReturnRows(pgxmock.NewRows(keyColumns))
+	conn.ExpectQuery("FROM idempotency_keys").
+		WithArgs("key-1", "user-1 POST /products").
+		WillReturnRows(pgxmock.NewRows(keyColumns).
+			AddRow("key-1", "user-1 POST /products", fingerprint, pgtype.Int4{Int32: 201, Valid: true}, pgtype.Text{String: "application/json", Valid: true}, []byte(storedResponse), createdAt, createdAt))
+
+	productsService := products.NewService(repo.New(conn), conn)
+	productsHandler := products.NewHandler(productsService)
+	r2 := chi.NewRouter()
+	// The keys are scoped to the principal
+	r2.Use(authenticatedAs(auth.Principal{Subject: "user-1", Method: auth.MethodJWT, Roles: []string{auth.RoleAdmin}}))
+	r2.With(idempotency.NewMiddleware(repo.New(conn), time.Minute)).Post("/products", productsHandler.CreateProduct)
+	server := httptest.NewServer(r2)
+	defer server.Close()
+
+	post := func(body string) (*http.Response, string) {
+		req, _ := http.NewRequest(http.MethodPost, server.URL+"/products", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(idempotency.HeaderKey, "key-1")
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		return resp, string(b)
+	}
+	resp, first := post(body)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Equal(t, storedResponse, first)
+
+	resp, replayed := post(body)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Equal(t, "true", resp.Header.Get(idempotency.HeaderReplayed))
+	assert.Equal(t, first, replayed)
+
+	resp, _ = post(`{"name":"Apple Watch","price_in_cents":104900,"quantity":20}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+	assert.NoError(t, conn.ExpectationsWereMet())
+}
+
+func TestIdempotencyKeyReleased(t *testing.T) {
+	conn, err := pgxmock.NewConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close(context.Background())
+
+	createdAt := time.Date(2025, 12, 24, 14, 2, 58, 452793000, time.FixedZone("", -3*3600))
+	keyColumns := []string{"key", "scope", "fingerprint", "status_code", "content_type", "response_body", "created_at", "completed_at"}
+	for _, key := range []string{"key-1", "key-2"} {
+		conn.ExpectQuery("INSERT INTO idempotency_keys").
+			WithArgs(key, "POST /orders", pgxmock.AnyArg(), pgxmock.AnyArg()).
+			WillReturnRows(pgxmock.NewRows(keyColumns).
+				AddRow(key, "POST /orders", "", nil, nil, nil, createdAt, nil))
+		// Delayed, so the release fails if it runs on the cancelled request
+		conn.ExpectExec("DELETE FROM idempotency_keys").
+			WithArgs(key, "POST /orders").
+			WillReturnResult(pgxmock.NewResult("DELETE", 1)).
+			WillDelayFor(50 * time.Millisecond)
+	}
+
+	r := chi.NewRouter()
+	r.Use(middleware.Recoverer)
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithCancel(r.Context())
+			defer cancel()
+			next.ServeHTTP(w, r.WithContext(context.WithValue(ctx, cancelKey{}, cancel)))
+		})
+	})
+	r.With(idempotency.NewMiddleware(repo.New(conn), time.Minute)).Post("/orders", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(idempotency.HeaderKey) == "key-2" {
+			panic("boom")
+		}
+		// The request times out before the handler fails
+		r.Context().Value(cancelKey{}).(context.CancelFunc)()
+		responses.NewJsonErrorResponse(w, http.StatusGatewayTimeout, "timeout", "request timed out")
+	})
+	server := httptest.NewServer(r)
+	defer server.Close()
+	var logs bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+
+	for _, key := range []string{"key-1", "key-2"} {
+		req, _ := http.NewRequest(http.MethodPost, server.URL+"/orders", bytes.NewBufferString(`{}`))
+		req.Header.Set(idempotency.HeaderKey, key)
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		resp.Body.Close()
+	}
+	assert.NotContains(t, logs.String(), "failed to release the idempotency key")
+	assert.NoError(t, conn.ExpectationsWereMet())
+}
+
+type cancelKey struct{}
+
+var orderAddressColumns = []string{"order_id", "kind", "line1", "line2", "city", "state", "postal_code", "country"}
+
+// expectShippingAddress mocks the customer and shipping address lookups done
+// when an order is placed.
+func expectShippingAddress(conn pgxmock.PgxConnIface, customerId int64, addressId int64) {
+	createdAt := time.Date(2025, 12, 24, 14, 2, 58, 452793000, time.FixedZone("", -3*3600))
+	conn.ExpectQuery("FROM customers").
+		WithArgs(customerId).
+		WillReturnRows(pgxmock.NewRows([]string{"id", "name", "email", "created_at"}).
+			AddRow(customerId, "Customer", "customer@example.com", createdAt))
+	conn.ExpectQuery("FROM customer_addresses").
+		WithArgs(addressId, customerId).
+		WillReturnRows(pgxmock.NewRows([]string{"id", "customer_id", "kind", "line1", "line2", "city", "state", "postal_code", "country", "created_at"}).
+			AddRow(addressId, customerId, "shipping", "1 Infinite Loop", "", "Cupertino", "CA", "95014", "US", createdAt))
+}
+
+// expectOrderAddress mocks the snapshot of the shipping address on the order.
+func expectOrderAddress(conn pgxmock.PgxConnIface, orderId int64) {
+	conn.ExpectQuery("INSERT INTO order_addresses").
+		WithArgs(orderId, "shipping", "1 Infinite Loop", "", "Cupertino", "CA", "95014", "US").
+		WillReturnRows(pgxmock.NewRows(orderAddressColumns).
+			AddRow(orderId, "shipping", "1 Infinite Loop", "", "Cupertino", "CA", "95014", "US"))
+}
+
+// expectOutboxEvent mocks an event written to the outbox.
+func expectOutboxEvent(conn pgxmock.PgxConnIface, aggregateType string, aggregateId int64, eventType string) {
+	createdAt := time.Date(2025, 12, 24, 14, 2, 58, 452793000, time.FixedZone("", -3*3600))
+	conn.ExpectQuery("INSERT INTO outbox").
+		WithArgs(aggregateType, aggregateId, eventType, pgxmock.AnyArg()).
+		WillReturnRows(pgxmock.NewRows([]string{"id", "aggregate_type", "aggregate_id", "event_type", "payload", "attempts", "last_error", "next_attempt_at", "created_at", "published_at"}).
+			AddRow(int64(1), aggregateType, aggregateId, eventType, []byte("{}"), int32(0), "", createdAt, createdAt, nil))
+}
+
+func expectInventoryMovement(conn pgxmock.PgxConnIface, productId int64, locationId int64, delta int32, quantity int32, reason string, orderId int64) {
+	createdAt := time.Date(2025, 12, 24, 14, 2, 58, 452793000, time.FixedZone("", -3*3600))
+	order := pgtype.Int8{Int64: orderId, Valid: orderId != 0}
+	conn.ExpectQuery("INSERT INTO inventory_movements").
+		WithArgs(productId, locationId, delta, quantity, reason, order).
+		WillReturnRows(pgxmock.NewRows([]string{"id", "product_id", "delta", "quantity", "reason", "order_id", "created_at", "location_id"}).
+			AddRow(int64(1), productId, delta, quantity, reason, order, createdAt, locationId))
+}
+
+// expectProductLock mocks the lock of the product row every stock change
+// starts with.
+func expectProductLock(conn pgxmock.PgxConnIface, productId int64, quantity int32) {
+	createdAt := time.Date(2025, 12, 24, 14, 2, 58, 452793000, time.FixedZone("", -3*3600))
+	conn.ExpectQuery("FOR UPDATE").
+		WithArgs(productId).
+		WillReturnRows(pgxmock.NewRows([]string{"id", "name", "price_in_cents", "quantity", "created_at", "deleted_at"}).
+			AddRow(productId, fmt.Sprintf("Product %d", productId), int32(10000), quantity, createdAt, nil))
+}
+
+func expectDefaultLocation(conn pgxmock.PgxConnIface) {
+	createdAt := time.Date(2025, 12, 24, 14, 2, 58, 452793000, time.FixedZone("", -3*3600))
+	conn.ExpectQuery("WHERE\\s+is_default").
+		WillReturnRows(pgxmock.NewRows([]string{"id", "name", "priority", "shipping_cost_in_cents", "is_default", "active", "created_at", "updated_at"}).
+			AddRow(int64(1), "default", int32(0), int32(0), true, true, createdAt, createdAt))
+}
+
+// expectProductStock mocks the stock of the product at the given locations,
+// which are active.
+func expectProductStock(conn pgxmock.PgxConnIface, productId int64, stock ...products.LocationStock) {
+	rows := pgxmock.NewRows([]string{"location_id", "name", "priority", "shipping_cost_in_cents", "active", "quantity"})
+	for _, st := range stock {
+		rows.AddRow(st.LocationId, fmt.Sprintf("Location %d", st.LocationId), st.Priority, st.ShippingCostInCents, true, st.Quantity)
+	}
+	conn.ExpectQuery("FROM\\s+product_stock").
+		WithArgs(productId).
+		WillReturnRows(rows)
+}
+
+// expectStockChange mocks a stock change at a location along with its
+// inventory movement and product.stock_changed event. quantity is what the
+// location holds after the change.
+func expectStockChange(conn pgxmock.PgxConnIface, productId int64, locationId int64, delta int32, quantity int32, reason string, orderId int64) {
+	createdAt := time.Date(2025, 12, 24, 14, 2, 58, 452793000, time.FixedZone("", -3*3600))
+	stock := pgxmock.NewRows([]string{"product_id", "location_id", "quantity", "updated_at"}).
+		AddRow(productId, locationId, quantity, createdAt)
+	if delta > 0 {
+		conn.ExpectQuery("INSERT INTO product_stock").
+			WithArgs(productId, locationId, delta).
+			WillReturnRows(stock)
+	} else {
+		conn.ExpectQuery("UPDATE product_stock").
+			WithArgs(-delta, productId, locationId).
+			WillReturnRows(stock)
+	}
+	expectInventoryMovement(conn, productId, locationId, delta, quantity, reason, orderId)
+	expectOutboxEvent(conn, outbox.AggregateProduct, productId, outbox.EventProductStockChanged)
+}
+
+func TestCreateCustomerWithAddresses(t *testing.T) {
+	conn, err := pgxmock.NewConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close(context.Background())
+
+	createdAt := time.Date(2025, 12, 24, 14, 2, 58, 452793000, time.FixedZone("", -3*3600))
+	customerColumns := []string{"id", "name", "email", "created_at"}
+	addressColumns := []string{"id", "customer_id", "kind", "line1", "line2", "city", "state", "postal_code", "country", "created_at"}
+	conn.ExpectQuery("INSERT INTO customers").
+		WithArgs("Jane Doe", "jane@example.com").
+		WillReturnRows(pgxmock.NewRows(customerColumns).AddRow(int64(1), "Jane Doe", "jane@example.com", createdAt))
+	conn.ExpectQuery("INSERT INTO customers").
+		WithArgs("Jane Doe", "jane@example.com").
+		WillReturnError(&pgconn.PgError{Code: "23505"})
+	conn.ExpectQuery("FROM customers").
+		WithArgs(int64(1)).
+		WillReturnRows(pgxmock.NewRows(customerColumns).AddRow(int64(1), "Jane Doe", "jane@example.com", createdAt))
+	conn.ExpectQuery("INSERT INTO customer_addresses").
+		WithArgs(int64(1), "billing", "1 Infinite Loop", "", "Cupertino", "CA", "95014", "US").
+		WillReturnRows(pgxmock.NewRows(addressColumns).
+			AddRow(int64(1), int64(1), "billing", "1 Infinite Loop", "", "Cupertino", "CA", "95014", "US", createdAt))
+	conn.ExpectQuery("FROM customers").
+		WithArgs(int64(1)).
+		WillReturnRows(pgxmock.NewRows(customerColumns).AddRow(int64(1), "Jane Doe", "jane@example.com", createdAt))
+	conn.ExpectQuery("FROM customer_addresses").
+		WithArgs(int64(1)).
+		WillReturnRows(pgxmock.NewRows(addressColumns).
+			AddRow(int64(1), int64(1), "billing", "1 Infinite Loop", "", "Cupertino", "CA", "95014", "US", createdAt))
+
+	customersHandler := customers.NewHandler(customers.NewService(repo.New(conn)))
+	r2 := chi.NewRouter()
+	r2.Post("/customers", customersHandler.CreateCustomer)
+	r2.Get("/customers/{id}", customersHandler.FindCustomerById)
+	r2.Post("/customers/{id}/addresses", customersHandler.AddAddress)
+	server := httptest.NewServer(r2)
+	defer server.Close()
+
+	customer, _ := json.Marshal(customers.CustomerParams{Name: "Jane Doe", Email: "jane@example.com"})
+	resp, err := http.Post(server.URL+"/customers", "application/json", bytes.NewBuffer(customer))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	resp.Body.Close()
+
+	resp, err = http.Post(server.URL+"/customers", "application/json", bytes.NewBuffer(customer))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+	resp.Body.Close()
+
+	address, _ := json.Marshal(customers.AddressParams{
+		Kind:       customers.AddressBilling,
+		Line1:      "1 Infinite Loop",
+		City:       "Cupertino",
+		State:      "CA",
+		PostalCode: "95014",
+		Country:    "US",
+	})
+	resp, err = http.Post(server.URL+"/customers/1/addresses", "application/json", bytes.NewBuffer(address))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	resp.Body.Close()
+
+	resp, err = http.Get(server.URL + "/customers/1")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var found customers.CustomerWithAddresses
+	json.NewDecoder(resp.Body).Decode(&found)
+	resp.Body.Close()
+	assert.Equal(t, "jane@example.com", found.Email)
+	assert.Equal(t, 1, len(found.Addresses))
+	assert.Equal(t, "billing", found.Addresses[0].Kind)
+	assert.NoError(t, conn.ExpectationsWereMet())
+}
+
+func TestListCustomerOrders(t *testing.T) {
+	conn, err := pgxmock.NewConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close(context.Background())
+
+	createdAt := time.Date(2025, 12, 24, 14, 2, 58, 452793000, time.FixedZone("", -3*3600))
+	from := time.Date(2025, 12, 1, 0, 0, 0, 0, time.UTC)
+	conn.ExpectQuery("FROM customers").
+		WithArgs(int64(1)).
+		WillReturnRows(pgxmock.NewRows([]string{"id", "name", "email", "created_at"}).
+			AddRow(int64(1), "Customer", "customer@example.com", createdAt))
+	conn.ExpectQuery("SUM").
+		WithArgs(pgtype.Int8{Int64: 1, Valid: true}, pgtype.Text{String: "pending", Valid: true},
+			pgtype.Timestamptz{Time: from, Valid: true}, pgtype.Timestamptz{}, pgtype.Int8{},
+			pgtype.Int8{Int64: 5000, Valid: true}, int32(2)).
+		WillReturnRows(pgxmock.NewRows([]string{"id", "customer_id", "created_at", "status", "total_price_in_cents"}).
+			AddRow(int64(7), int64(1), createdAt, "pending", int64(30000)).
+			AddRow(int64(3), int64(1), createdAt, "pending", int64(10000)))
+
+	ordersService := orders.NewServiceWithDB(repo.New(conn), conn, products.NewService(repo.New(conn), conn), products.SingleLocationPreferred{})
+	ordersHandler := orders.NewHandler(ordersService)
+	r2 := chi.NewRouter()
+	r2.Get("/customers/{id}/orders", ordersHandler.ListCustomerOrders)
+	server := httptest.NewServer(r2)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/customers/1/orders?status=pending&min_total=5000&limit=1&from=2025-12-01T00:00:00Z")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var page pagination.Page[repo.ListOrdersRow]
+	json.NewDecoder(resp.Body).Decode(&page)
+	resp.Body.Close()
+	assert.Equal(t, 1, len(page.Data))
+	assert.Equal(t, int64(7), page.Data[0].ID)
+	assert.Equal(t, int64(30000), page.Data[0].TotalPriceInCents)
+	assert.NotEmpty(t, page.NextCursor)
+
+	resp, err = http.Get(server.URL + "/customers/1/orders?status=unknown")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp.Body.Close()
+}
+
+func TestCartCheckout(t *testing.T) {
+	conn, err := pgxmock.NewConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close(context.Background())
+
+	createdAt := time.Date(2025, 12, 24, 14, 2, 58, 452793000, time.FixedZone("", -3*3600))
+	expiresAt := time.Now().Add(time.Hour)
+	cartColumns := []string{"id", "customer_id", "status", "order_id", "expires_at", "created_at", "updated_at"}
+	cartItemColumns := []string{"id", "product_id", "quantity", "name", "price_in_cents", "available_quantity", "deleted_at"}
+
+	// Reading the cart validates the lines against the current stock
+	conn.ExpectQuery("FROM carts").
+		WithArgs(int64(1)).
+		WillReturnRows(pgxmock.NewRows(cartColumns).AddRow(int64(1), int64(1), "open", nil, expiresAt, createdAt, createdAt))
+	conn.ExpectQuery("FROM cart_items").
+		WithArgs(int64(1)).
+		WillReturnRows(pgxmock.NewRows(cartItemColumns).AddRow(int64(1), int64(1), int32(2), "Product 1", int32(10000), int32(1), nil))
+	// Checkout places the order, then checks the cart out and links it to the
+	// order in the same transaction
+	conn.ExpectQuery("FROM carts").
+		WithArgs(int64(1)).
+		WillReturnRows(pgxmock.NewRows(cartColumns).AddRow(int64(1), int64(1), "open", nil, expiresAt, createdAt, createdAt))
+	conn.ExpectQuery("FROM cart_items").
+		WithArgs(int64(1)).
+		WillReturnRows(pgxmock.NewRows(cartItemColumns).AddRow(int64(1), int64(1), int32(1), "Product 1", int32(10000), int32(1), nil))
+	conn.ExpectBegin()
+	expectShippingAddress(conn, 1, 1)
+	conn.ExpectQuery("INSERT INTO orders").
+		WithArgs(int64(1)).
+		WillReturnRows(pgxmock.NewRows([]string{"id", "customer_id", "created_at", "status"}).
+			AddRow(int64(1), int64(1), createdAt, "pending"))
+	expectOrderAddress(conn, 1)
+	expectProductLock(conn, 1, 1)
+	expectProductStock(conn, 1, products.LocationStock{LocationId: 1, Quantity: 1})
+	expectStockChange(conn, 1, 1, -1, 0, products.ReasonSale, 1)
+	conn.ExpectQuery("INSERT INTO order_items").
+		WithArgs(int64(1), int64(1), int32(1), int32(10000)).
+		WillReturnRows(pgxmock.NewRows([]string{"id", "order_id", "product_id", "quantity", "price_cents"}).
+			AddRow(int64(1), int64(1), int64(1), int32(1), int32(10000)))
+	conn.ExpectQuery("INSERT INTO order_item_allocations").
+		WithArgs(int64(1), int64(1), int32(1)).
+		WillReturnRows(pgxmock.NewRows([]string{"id", "order_item_id", "location_id", "quantity"}).
+			AddRow(int64(1), int64(1), int64(1), int32(1)))
+	expectOutboxEvent(conn, outbox.AggregateOrder, 1, outbox.EventOrderPlaced)
+	conn.ExpectQuery("SET status = 'checked_out'").
+		WithArgs(int64(1)).
+		WillReturnRows(pgxmock.NewRows(cartColumns).AddRow(int64(1), int64(1), "checked_out", nil, expiresAt, createdAt, createdAt))
+	conn.ExpectQuery("SET order_id").
+		WithArgs(int64(1), pgtype.Int8{Int64: 1, Valid: true}).
+		WillReturnRows(pgxmock.NewRows(cartColumns).AddRow(int64(1), int64(1), "checked_out", int64(1), expiresAt, createdAt, createdAt))
+	conn.ExpectCommit()
+	// A second checkout is refused
+	conn.ExpectQuery("FROM carts").
+		WithArgs(int64(1)).
+		WillReturnRows(pgxmock.NewRows(cartColumns).AddRow(int64(1), int64(1), "checked_out", int64(1), expiresAt, createdAt, createdAt))
+	// The order of a checkout losing the race with another one is rolled back
+	conn.ExpectQuery("FROM carts").
+		WithArgs(int64(2)).
+		WillReturnRows(pgxmock.NewRows(cartColumns).AddRow(int64(2), int64(1), "open", nil, expiresAt, createdAt, createdAt))
+	conn.ExpectQuery("FROM cart_items").
+		WithArgs(int64(2)).
+		WillReturnRows(pgxmock.NewRows(cartItemColumns).AddRow(int64(2), int64(1), int32(1), "Product 1", int32(10000), int32(1), nil))
+	conn.ExpectBegin()
+	expectShippingAddress(conn, 1, 1)
+	conn.ExpectQuery("INSERT INTO orders").
+		WithArgs(int64(1)).
+		WillReturnRows(pgxmock.NewRows([]string{"id", "customer_id", "created_at", "status"}).
+			AddRow(int64(2), int64(1), createdAt, "pending"))
+	expectOrderAddress(conn, 2)
+	expectProductLock(conn, 1, 1)
+	expectProductStock(conn, 1, products.LocationStock{LocationId: 1, Quantity: 1})
+	expectStockChange(conn, 1, 1, -1, 0, products.ReasonSale, 2)
+	conn.ExpectQuery("INSERT INTO order_items").
+		WithArgs(int64(2), int64(1), int32(1), int32(10000)).
+		WillReturnRows(pgxmock.NewRows([]string{"id", "order_id", "product_id", "quantity", "price_cents"}).
+			AddRow(int64(2), int64(2), int64(1), int32(1), int32(10000)))
+	conn.ExpectQuery("INSERT INTO order_item_allocations").
+		WithArgs(int64(2), int64(1), int32(1)).
+		WillReturnRows(pgxmock.NewRows([]string{"id", "order_item_id", "location_id", "quantity"}).
+			AddRow(int64(2), int64(2), int64(1), int32(1)))
+	expectOutboxEvent(conn, outbox.AggregateOrder, 2, outbox.EventOrderPlaced)
+	conn.ExpectQuery("SET status = 'checked_out'").
+		WithArgs(int64(2)).
+		WillReturnRows(pgxmock.NewRows(cartColumns))
+	conn.ExpectQuery("FROM carts").
+		WithArgs(int64(2)).
+		WillReturnRows(pgxmock.NewRows(cartColumns).AddRow(int64(2), int64(1), "checked_out", int64(3), expiresAt, createdAt, createdAt))
+	conn.ExpectRollback()
+
+	productsService := products.NewService(repo.New(conn), conn)
+	ordersService := orders.NewServiceWithDB(repo.New(conn), conn, productsService, products.SingleLocationPreferred{})
+	cartsHandler := carts.NewHandler(carts.NewService(repo.New(conn), ordersService, time.Hour))
+	r2 := chi.NewRouter()
+	r2.Get("/carts/{id}", cartsHandler.FindCartById)
+	r2.Post("/carts/{id}/checkout", cartsHandler.Checkout)
+	server := httptest.NewServer(r2)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/carts/1")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var cart carts.CartView
+	json.NewDecoder(resp.Body).Decode(&cart)
+	resp.Body.Close()
+	assert.False(t, cart.Valid)
+	assert.Equal(t, carts.IssueInsufficientStock, cart.Lines[0].Issue)
+	assert.Equal(t, int64(20000), cart.TotalPriceInCents)
+
+	body, _ := json.Marshal(carts.CheckoutParams{ShippingAddressId: 1})
+	resp, err = http.Post(server.URL+"/carts/1/checkout", "application/json", bytes.NewBuffer(body))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	var order repo.Order
+	json.NewDecoder(resp.Body).Decode(&order)
+	resp.Body.Close()
+	assert.Equal(t, int64(1), order.ID)
+
+	resp, err = http.Post(server.URL+"/carts/1/checkout", "application/json", bytes.NewBuffer(body))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+	resp.Body.Close()
+
+	resp, err = http.Post(server.URL+"/carts/2/checkout", "application/json", bytes.NewBuffer(body))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+	resp.Body.Close()
+	assert.NoError(t, conn.ExpectationsWereMet())
+}
+
+func TestPayOrder(t *testing.T) {
+	conn, err := pgxmock.NewConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close(context.Background())
+
+	createdAt := time.Date(2025, 12, 24, 14, 2, 58, 452793000, time.FixedZone("", -3*3600))
+	paymentColumns := []string{"id", "order_id", "amount_in_cents", "status", "gateway_reference", "failure_reason", "created_at", "updated_at", "refunded_in_cents"}
+	expectPendingOrder := func(orderId int64) {
+		conn.ExpectQuery("WHERE o.id").
+			WithArgs(orderId).
+			WillReturnRows(pgxmock.NewRows([]string{"order_id", "customer_id", "created_at", "status", "order_item_id", "product_id", "quantity", "price_cents"}).
+				AddRow(orderId, int64(1), createdAt, "pending", pgtype.Int8{Int64: 1, Valid: true}, pgtype.Int8{Int64: 1, Valid: true},
+					pgtype.Int4{Int32: 2, Valid: true}, pgtype.Int4{Int32: 10000, Valid: true}))
+		conn.ExpectQuery("FROM\\s+order_item_allocations").
+			WithArgs(orderId).
+			WillReturnRows(pgxmock.NewRows([]string{"id", "order_item_id", "product_id", "location_id", "quantity"}))
+		conn.ExpectQuery("FROM order_addresses").
+			WithArgs(orderId).
+			WillReturnRows(pgxmock.NewRows(orderAddressColumns))
+	}
+
+	// The payment is authorized, captured and the order becomes paid
+	expectPendingOrder(1)
+	conn.ExpectQuery("INSERT INTO payments").
+		WithArgs(int64(1), int64(20000)).
+		WillReturnRows(pgxmock.NewRows(paymentColumns).AddRow(int64(1), int64(1), int64(20000), "pending", "", "", createdAt, createdAt, int64(0)))
+	conn.ExpectQuery("UPDATE payments").
+		WithArgs(int64(1), "authorized", "fake_1_1", "").
+		WillReturnRows(pgxmock.NewRows(paymentColumns).AddRow(int64(1), int64(1), int64(20000), "authorized", "fake_1_1", "", createdAt, createdAt, int64(0)))
+	conn.ExpectQuery("UPDATE payments").
+		WithArgs(int64(1), "captured", "fake_1_1", "").
+		WillReturnRows(pgxmock.NewRows(paymentColumns).AddRow(int64(1), int64(1), int64(20000), "captured", "fake_1_1", "", createdAt, createdAt, int64(0)))
+	conn.ExpectBegin()
+	conn.ExpectQuery("FOR UPDATE").
+		WithArgs(int64(1)).
+		WillReturnRows(pgxmock.NewRows([]string{"id", "customer_id", "created_at", "status"}).AddRow(int64(1), int64(1), createdAt, "pending"))
+	conn.ExpectQuery("UPDATE orders").
+		WithArgs(int64(1), "paid").
+		WillReturnRows(pgxmock.NewRows([]string{"id", "customer_id", "created_at", "status"}).AddRow(int64(1), int64(1), createdAt, "paid"))
+	conn.ExpectQuery("INSERT INTO order_status_changes").
+		WithArgs(int64(1), "pending", "paid", "payments", "payment 1 captured").
+		WillReturnRows(pgxmock.NewRows([]string{"id", "order_id", "from_status", "to_status", "changed_by", "reason", "created_at"}).
+			AddRow(int64(1), int64(1), "pending", "paid", "payments", "payment 1 captured", createdAt))
+	conn.ExpectCommit()
+	// A declined payment is recorded and the order stays pending
+	expectPendingOrder(2)
+	conn.ExpectQuery("INSERT INTO payments").
+		WithArgs(int64(2), int64(20000)).
+		WillReturnRows(pgxmock.NewRows(paymentColumns).AddRow(int64(2), int64(2), int64(20000), "pending", "", "", createdAt, createdAt, int64(0)))
+	conn.ExpectQuery("UPDATE payments").
+		WithArgs(int64(2), "failed", "", payments.ErrPaymentDeclined.Error()).
+		WillReturnRows(pgxmock.NewRows(paymentColumns).AddRow(int64(2), int64(2), int64(20000), "failed", "", payments.ErrPaymentDeclined.Error(), createdAt, createdAt, int64(0)))
+
+	ordersService := orders.NewServiceWithDB(repo.New(conn), conn, products.NewService(repo.New(conn), conn), products.SingleLocationPreferred{})
+	paymentsHandler := payments.NewHandler(payments.NewService(repo.New(conn), payments.NewFakeGateway(), ordersService))
+	r2 := chi.NewRouter()
+	r2.Post("/orders/{id}/payments", paymentsHandler.PayOrder)
+	server := httptest.NewServer(r2)
+	defer server.Close()
+
+	body, _ := json.Marshal(payments.PaymentParams{PaymentToken: "tok_visa"})
+	resp, err := http.Post(server.URL+"/orders/1/payments", "application/json", bytes.NewBuffer(body))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	var payment repo.Payment
+	json.NewDecoder(resp.Body).Decode(&payment)
+	resp.Body.Close()
+	assert.Equal(t, "captured", payment.Status)
+
+	body, _ = json.Marshal(payments.PaymentParams{PaymentToken: payments.FakeTokenDeclined})
+	resp, err = http.Post(server.URL+"/orders/2/payments", "application/json", bytes.NewBuffer(body))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusPaymentRequired, resp.StatusCode)
+	resp.Body.Close()
+	assert.NoError(t, conn.ExpectationsWereMet())
+}
+
+func TestReleaseFailedReservations(t *testing.T) {
+	conn, err := pgxmock.NewConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close(context.Background())
+
+	createdAt := time.Date(2025, 12, 24, 14, 2, 58, 452793000, time.FixedZone("", -3*3600))
+	// The payments left pending are failed, so their order can be paid again
+	conn.ExpectExec("status = 'pending'").
+		WithArgs(pgxmock.AnyArg()).
+		WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+	// The payments left authorized are voided and failed, unless the gateway
+	// fails to void them
+	paymentColumns := []string{"id", "order_id", "amount_in_cents", "status", "gateway_reference", "failure_reason", "created_at", "updated_at", "refunded_in_cents"}
+	conn.ExpectQuery("status = 'authorized'").
+		WithArgs(pgxmock.AnyArg(), int32(100)).
+		WillReturnRows(pgxmock.NewRows(paymentColumns).
+			AddRow(int64(2), int64(2), int64(20000), "authorized", "fake_2_2", "", createdAt, createdAt, int64(0)).
+			AddRow(int64(3), int64(3), int64(20000), "authorized", "fake_unknown", "", createdAt, createdAt, int64(0)))
+	conn.ExpectQuery("UPDATE payments").
+		WithArgs(int64(2), "failed", "fake_2_2", "payment expired").
+		WillReturnRows(pgxmock.NewRows(paymentColumns).AddRow(int64(2), int64(2), int64(20000), "failed", "fake_2_2", "payment expired", createdAt, createdAt, int64(0)))
+	// The orders whose last payment failed are cancelled and restocked
+	conn.ExpectQuery("p.status = 'failed'").
+		WithArgs(pgxmock.AnyArg(), int32(100)).
+		WillReturnRows(pgxmock.NewRows([]string{"id"}).AddRow(int64(1)))
+	conn.ExpectBegin()
+	conn.ExpectQuery("FOR UPDATE").
+		WithArgs(int64(1)).
+		WillReturnRows(pgxmock.NewRows([]string{"id", "customer_id", "created_at", "status"}).
+			AddRow(int64(1), int64(1), createdAt, "pending"))
+	conn.ExpectQuery("UPDATE orders").
+		WithArgs(int64(1), "cancelled").
+		WillReturnRows(pgxmock.NewRows([]string{"id", "customer_id", "created_at", "status"}).
+			AddRow(int64(1), int64(1), createdAt, "cancelled"))
+	conn.ExpectQuery("FROM\\s+order_item_allocations").
+		WithArgs(int64(1)).
+		WillReturnRows(pgxmock.NewRows([]string{"id", "order_item_id", "product_id", "location_id", "quantity"}).
+			AddRow(int64(1), int64(1), int64(1), int64(1), int32(2)))
+	expectProductLock(conn, 1, 0)
+	expectStockChange(conn, 1, 1, 2, 2, products.ReasonCancellation, 1)
+	expectOutboxEvent(conn, outbox.AggregateOrder, 1, outbox.EventOrderCancelled)
+	conn.ExpectQuery("INSERT INTO order_status_changes").
+		WithArgs(int64(1), "pending", "cancelled", "payments", "payment window expired").
+		WillReturnRows(pgxmock.NewRows([]string{"id", "order_id", "from_status", "to_status", "changed_by", "reason", "created_at"}).
+			AddRow(int64(1), int64(1), "pending", "cancelled", "payments", "payment window expired", createdAt))
+	conn.ExpectCommit()
+
+	productsService := products.NewService(repo.New(conn), conn)
+	ordersService := orders.NewServiceWithDB(repo.New(conn), conn, productsService, products.SingleLocationPreferred{})
+	gateway := payments.NewFakeGateway()
+	reference, err := gateway.Authorize(context.Background(), payments.AuthorizeRequest{OrderId: 2, PaymentId: 2, AmountInCents: 20000, PaymentToken: "tok_ok"})
+	assert.NoError(t, err)
+	paymentsService := payments.NewService(repo.New(conn), gateway, ordersService)
+	released, err := paymentsService.ReleaseFailedReservations(context.Background(), 30*time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, released)
+	// The voided authorization cannot be captured anymore
+	assert.Error(t, gateway.Capture(context.Background(), reference, 20000))
+	assert.NoError(t, conn.ExpectationsWereMet())
+}
+
+func TestReturnWorkflow(t *testing.T) {
+	conn, err := pgxmock.NewConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close(context.Background())
+
+	createdAt := time.Date(2025, 12, 24, 14, 2, 58, 452793000, time.FixedZone("", -3*3600))
+	returnColumns := []string{"id", "order_id", "status", "reason", "refund_amount_in_cents", "decided_by", "decision_reason", "created_at", "updated_at"}
+	returnItemColumns := []string{"id", "return_id", "order_item_id", "product_id", "quantity", "price_cents"}
+	paymentColumns := []string{"id", "order_id", "amount_in_cents", "status", "gateway_reference", "failure_reason", "created_at", "updated_at", "refunded_in_cents"}
+	expectDeliveredOrder := func(returned *pgxmock.Rows) {
+		conn.ExpectBegin()
+		conn.ExpectQuery("FOR UPDATE").
+			WithArgs(int64(1)).
+			WillReturnRows(pgxmock.NewRows([]string{"id", "customer_id", "created_at", "status"}).AddRow(int64(1), int64(1), createdAt, "delivered"))
+		conn.ExpectQuery("FROM\\s+order_items").
+			WithArgs(int64(1)).
+			WillReturnRows(pgxmock.NewRows([]string{"id", "order_id", "product_id", "quantity", "price_cents"}).
+				AddRow(int64(1), int64(1), int64(1), int32(2), int32(10000)))
+		conn.ExpectQuery("FROM\\s+return_items as ri").
+			WithArgs(int64(1)).
+			WillReturnRows(returned)
+	}
+
+	// One of the two units is returned, refunded at the price of the order
+	expectDeliveredOrder(pgxmock.NewRows([]string{"order_item_id", "quantity"}))
+	conn.ExpectQuery("INSERT INTO returns").
+		WithArgs(int64(1), "damaged", int64(10000)).
+		WillReturnRows(pgxmock.NewRows(returnColumns).AddRow(int64(1), int64(1), "requested", "damaged", int64(10000), "", "", createdAt, createdAt))
+	conn.ExpectQuery("INSERT INTO return_items").
+		WithArgs(int64(1), int64(1), int64(1), int32(1), int32(10000)).
+		WillReturnRows(pgxmock.NewRows(returnItemColumns).AddRow(int64(1), int64(1), int64(1), int64(1), int32(1), int32(10000)))
+	conn.ExpectCommit()
+	// Two more units exceed what is left to return
+	expectDeliveredOrder(pgxmock.NewRows([]string{"order_item_id", "quantity"}).AddRow(int64(1), int32(1)))
+	conn.ExpectRollback()
+	// The return is approved
+	conn.ExpectQuery("UPDATE returns").
+		WithArgs(int64(1), "approved", "admin", "").
+		WillReturnRows(pgxmock.NewRows(returnColumns).AddRow(int64(1), int64(1), "approved", "damaged", int64(10000), "admin", "", createdAt, createdAt))
+	// The items arrive and are restocked in one transaction, then the customer
+	// is refunded
+	conn.ExpectBegin()
+	conn.ExpectQuery("UPDATE returns").
+		WithArgs("received", int64(1), "approved").
+		WillReturnRows(pgxmock.NewRows(returnColumns).AddRow(int64(1), int64(1), "received", "damaged", int64(10000), "admin", "", createdAt, createdAt))
+	conn.ExpectQuery("FROM\\s+return_items").
+		WithArgs(int64(1)).
+		WillReturnRows(pgxmock.NewRows(returnItemColumns).AddRow(int64(1), int64(1), int64(1), int64(1), int32(1), int32(10000)))
+	// They go back to the location the order item was shipped from
+	conn.ExpectQuery("FROM\\s+order_item_allocations").
+		WithArgs(int64(1)).
+		WillReturnRows(pgxmock.NewRows([]string{"id", "order_item_id", "product_id", "location_id", "quantity"}).
+			AddRow(int64(1), int64(1), int64(1), int64(2), int32(2)))
+	expectProductLock(conn, 1, 0)
+	expectStockChange(conn, 1, 2, 1, 1, products.ReasonReturn, 1)
+	conn.ExpectCommit()
+	// The refund is claimed, paid, then recorded along with the return
+	conn.ExpectQuery("UPDATE returns").
+		WithArgs("refunding", int64(1), "received").
+		WillReturnRows(pgxmock.NewRows(returnColumns).AddRow(int64(1), int64(1), "refunding", "damaged", int64(10000), "admin", "", createdAt, createdAt))
+	conn.ExpectQuery("status = 'captured'").
+		WithArgs(int64(1)).
+		WillReturnRows(pgxmock.NewRows(paymentColumns).AddRow(int64(1), int64(1), int64(20000), "captured", "fake_1_1", "", createdAt, createdAt, int64(0)))
+	conn.ExpectBegin()
+	conn.ExpectQuery("UPDATE returns").
+		WithArgs("refunded", int64(1), "refunding").
+		WillReturnRows(pgxmock.NewRows(returnColumns).AddRow(int64(1), int64(1), "refunded", "damaged", int64(10000), "admin", "", createdAt, createdAt))
+	conn.ExpectQuery("refunded_in_cents = refunded_in_cents").
+		WithArgs(int64(10000), int64(1)).
+		WillReturnRows(pgxmock.NewRows(paymentColumns).AddRow(int64(1), int64(1), int64(20000), "captured", "fake_1_1", "", createdAt, createdAt, int64(10000)))
+	conn.ExpectCommit()
+	conn.ExpectQuery("FROM\\s+returns").
+		WithArgs(int64(1)).
+		WillReturnRows(pgxmock.NewRows(returnColumns).AddRow(int64(1), int64(1), "refunded", "damaged", int64(10000), "admin", "", createdAt, createdAt))
+	conn.ExpectQuery("FROM\\s+return_items").
+		WithArgs(int64(1)).
+		WillReturnRows(pgxmock.NewRows(returnItemColumns).AddRow(int64(1), int64(1), int64(1), int64(1), int32(1), int32(10000)))
+	// A received return cannot be received again
+	conn.ExpectBegin()
+	conn.ExpectQuery("UPDATE returns").
+		WithArgs("received", int64(1), "approved").
+		WillReturnError(pgx.ErrNoRows)
+	conn.ExpectQuery("FROM\\s+returns").
+		WithArgs(int64(1)).
+		WillReturnRows(pgxmock.NewRows(returnColumns).AddRow(int64(1), int64(1), "refunded", "damaged", int64(10000), "admin", "", createdAt, createdAt))
+	conn.ExpectRollback()
+	// A return whose refund fails is left received and told apart
+	conn.ExpectBegin()
+	conn.ExpectQuery("UPDATE returns").
+		WithArgs("received", int64(2), "approved").
+		WillReturnRows(pgxmock.NewRows(returnColumns).AddRow(int64(2), int64(2), "received", "damaged", int64(10000), "admin", "", createdAt, createdAt))
+	conn.ExpectQuery("FROM\\s+return_items").
+		WithArgs(int64(2)).
+		WillReturnRows(pgxmock.NewRows(returnItemColumns))
+	conn.ExpectQuery("FROM\\s+order_item_allocations").
+		WithArgs(int64(2)).
+		WillReturnRows(pgxmock.NewRows([]string{"id", "order_item_id", "product_id", "location_id", "quantity"}))
+	conn.ExpectCommit()
+	conn.ExpectQuery("UPDATE returns").
+		WithArgs("refunding", int64(2), "received").
+		WillReturnRows(pgxmock.NewRows(returnColumns).AddRow(int64(2), int64(2), "refunding", "damaged", int64(10000), "admin", "", createdAt, createdAt))
+	conn.ExpectQuery("status = 'captured'").
+		WithArgs(int64(2)).
+		WillReturnError(pgx.ErrNoRows)
+	conn.ExpectQuery("UPDATE returns").
+		WithArgs("received", int64(2), "refunding").
+		WillReturnRows(pgxmock.NewRows(returnColumns).AddRow(int64(2), int64(2), "received", "damaged", int64(10000), "admin", "", createdAt, createdAt))
+	conn.ExpectQuery("FROM\\s+returns").
+		WithArgs(int64(2)).
+		WillReturnRows(pgxmock.NewRows(returnColumns).AddRow(int64(2), int64(2), "received", "damaged", int64(10000), "admin", "", createdAt, createdAt))
+	conn.ExpectQuery("FROM\\s+return_items").
+		WithArgs(int64(2)).
+		WillReturnRows(pgxmock.NewRows(returnItemColumns))
+	// A refund left refunding by a crash after the gateway paid it is
+	// completed without paying the customer twice
+	conn.ExpectQuery("status = 'refunding'").
+		WithArgs(pgxmock.AnyArg(), int32(100)).
+		WillReturnRows(pgxmock.NewRows(returnColumns).AddRow(int64(3), int64(1), "refunding", "damaged", int64(5000), "admin", "", createdAt, createdAt))
+	conn.ExpectQuery("status = 'captured'").
+		WithArgs(int64(1)).
+		WillReturnRows(pgxmock.NewRows(paymentColumns).AddRow(int64(1), int64(1), int64(20000), "captured", "fake_1_1", "", createdAt, createdAt, int64(10000)))
+	conn.ExpectBegin()
+	conn.ExpectQuery("UPDATE returns").
+		WithArgs("refunded", int64(3), "refunding").
+		WillReturnRows(pgxmock.NewRows(returnColumns).AddRow(int64(3), int64(1), "refunded", "damaged", int64(5000), "admin", "", createdAt, createdAt))
+	conn.ExpectQuery("refunded_in_cents = refunded_in_cents").
+		WithArgs(int64(5000), int64(1)).
+		WillReturnRows(pgxmock.NewRows(paymentColumns).AddRow(int64(1), int64(1), int64(20000), "captured", "fake_1_1", "", createdAt, createdAt, int64(15000)))
+	conn.ExpectCommit()
+
+	productsService := products.NewService(repo.New(conn), conn)
+	ordersService := orders.NewServiceWithDB(repo.New(conn), conn, productsService, products.SingleLocationPreferred{})
+	// The order was paid earlier through the same gateway
+	gateway := payments.NewFakeGateway()
+	reference, err := gateway.Authorize(context.Background(), payments.AuthorizeRequest{OrderId: 1, PaymentId: 1, AmountInCents: 20000, PaymentToken: "tok_visa"})
+	assert.NoError(t, err)
+	assert.NoError(t, gateway.Capture(context.Background(), reference, 20000))
+	paymentsService := payments.NewService(repo.New(conn), gateway, ordersService)
+	returnsService := returns.NewService(repo.New(conn), conn, paymentsService, ordersService)
+	returnsHandler := returns.NewHandler(returnsService)
+	r2 := chi.NewRouter()
+	r2.Use(authenticatedAs(auth.Principal{Subject: "admin", Method: auth.MethodJWT, Roles: []string{auth.RoleAdmin}}))
+	r2.Post("/orders/{id}/returns", returnsHandler.RequestReturn)
+	r2.Post("/returns/{id}/approve", returnsHandler.ApproveReturn)
+	r2.Post("/returns/{id}/receive", returnsHandler.ReceiveReturn)
+	server := httptest.NewServer(r2)
+	defer server.Close()
+
+	body, _ := json.Marshal(returns.CreateReturnParams{
+		Reason: "damaged",
+		Items:  []returns.ReturnItemsParams{{OrderItemId: 1, Quantity: 1}},
+	})
+	resp, err := http.Post(server.URL+"/orders/1/returns", "application/json", bytes.NewBuffer(body))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	var requested returns.ReturnCompleted
+	json.NewDecoder(resp.Body).Decode(&requested)
+	resp.Body.Close()
+	assert.Equal(t, int64(10000), requested.Return.RefundAmountInCents)
+	assert.Len(t, requested.Items, 1)
+
+	body, _ = json.Marshal(returns.CreateReturnParams{
+		Items: []returns.ReturnItemsParams{{OrderItemId: 1, Quantity: 2}},
+	})
+	resp, err = http.Post(server.URL+"/orders/1/returns", "application/json", bytes.NewBuffer(body))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp.Body.Close()
+
+	body, _ = json.Marshal(returns.DecisionParams{})
+	resp, err = http.Post(server.URL+"/returns/1/approve", "application/json", bytes.NewBuffer(body))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp.Body.Close()
+
+	resp, err = http.Post(server.URL+"/returns/1/receive", "application/json", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var refunded returns.ReturnCompleted
+	json.NewDecoder(resp.Body).Decode(&refunded)
+	resp.Body.Close()
+	assert.Equal(t, returns.StatusRefunded, refunded.Return.Status)
+
+	resp, err = http.Post(server.URL+"/returns/1/receive", "application/json", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+	resp.Body.Close()
+
+	resp, err = http.Post(server.URL+"/returns/2/receive", "application/json", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+	var received returns.ReturnCompleted
+	json.NewDecoder(resp.Body).Decode(&received)
+	resp.Body.Close()
+	assert.Equal(t, returns.StatusReceived, received.Return.Status)
+	assert.Equal(t, payments.ErrNoCapturedPayment.Error(), received.RefundError)
+
+	assert.NoError(t, gateway.Refund(context.Background(), payments.RefundRequest{Reference: reference, AmountInCents: 5000, IdempotencyKey: "return-3"}))
+	resumed, err := returnsService.ResumeRefunds(context.Background(), 5*time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, resumed)
+	// Only 5000 are left to refund on the gateway
+	assert.Error(t, gateway.Refund(context.Background(), payments.RefundRequest{Reference: reference, AmountInCents: 5001, IdempotencyKey: "other"}))
+	assert.NoError(t, conn.ExpectationsWereMet())
+}
+
+// failingPublisher records the events it receives and fails the ones of the
+// given type.
+type failingPublisher struct {
+	failType  string
+	published []int64
+}
+
+func (p *failingPublisher) Publish(ctx context.Context, event repo.Outbox) error {
+	p.published = append(p.published, event.ID)
+	if event.EventType == p.failType {
+		return fmt.Errorf("broker unavailable")
+	}
+	return nil
+}
+
+func TestOutboxRelay(t *testing.T) {
+	conn, err := pgxmock.NewConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close(context.Background())
+
+	createdAt := time.Date(2025, 12, 24, 14, 2, 58, 452793000, time.FixedZone("", -3*3600))
+	outboxColumns := []string{"id", "aggregate_type", "aggregate_id", "event_type", "payload", "attempts", "last_error", "next_attempt_at", "created_at", "published_at"}
+	conn.ExpectBegin()
+	conn.ExpectQuery("FOR UPDATE SKIP LOCKED").
+		WithArgs(int32(10)).
+		WillReturnRows(pgxmock.NewRows(outboxColumns).
+			AddRow(int64(1), outbox.AggregateProduct, int64(1), outbox.EventProductCreated, []byte(`{"product_id":1}`), int32(0), "", createdAt, createdAt, nil).
+			AddRow(int64(2), outbox.AggregateOrder, int64(1), outbox.EventOrderPlaced, []byte(`{"order_id":1}`), int32(2), "", createdAt, createdAt, nil))
+	conn.ExpectExec("SET\\s+attempts = attempts \\+ 1,\\s+published_at").
+		WithArgs(int64(1)).
+		WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+	// The failed event is rescheduled instead of being published
+	conn.ExpectExec("SET\\s+attempts = attempts \\+ 1,\\s+last_error").
+		WithArgs(int64(2), "broker unavailable", pgxmock.AnyArg()).
+		WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+	conn.ExpectCommit()
+
+	publisher := &failingPublisher{failType: outbox.EventOrderPlaced}
+	relay := outbox.NewRelay(repo.New(conn), conn, publisher, time.Second, 10, time.Minute)
+	relayed, err := relay.RelayBatch(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 2, relayed)
+	assert.Equal(t, []int64{1, 2}, publisher.published)
+	assert.NoError(t, conn.ExpectationsWereMet())
+}
+
+func TestWebhooks(t *testing.T) {
+	conn, err := pgxmock.NewConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close(context.Background())
+
+	// The receiver checks the signature, fails the first attempt of delivery
+	// 1 and every attempt of delivery 2
+	const secret = "whsec_0123456789abcdef"
+	var received []string
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		assert.NoError(t, webhooks.Verify(secret, r.Header.Get(webhooks.HeaderSignature), body, time.Now(), 5*time.Minute))
+		assert.Equal(t, outbox.EventOrderPlaced, r.Header.Get(webhooks.HeaderEvent))
+		delivery := r.Header.Get(webhooks.HeaderDelivery)
+		received = append(received, delivery)
+		if delivery == "2" || len(received) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer receiver.Close()
+
+	createdAt := time.Date(2025, 12, 24, 14, 2, 58, 452793000, time.FixedZone("", -3*3600))
+	subscriptionColumns := []string{"id", "url", "secret", "event_types", "active", "created_at", "updated_at"}
+	deliveryColumns := []string{"id", "subscription_id", "event_id", "event_type", "payload", "status", "attempts", "next_attempt_at", "last_status_code", "last_error", "created_at", "updated_at", "delivered_at"}
+	payload := []byte(`{"id":1,"type":"order.placed","data":{"order_id":1}}`)
+	expectSubscription := func() {
+		conn.ExpectQuery("FROM\\s+webhook_subscriptions").
+			WithArgs(int64(1)).
+			WillReturnRows(pgxmock.NewRows(subscriptionColumns).
+				AddRow(int64(1), receiver.URL, secret, []string{outbox.EventOrderPlaced}, true, createdAt, createdAt))
+	}
+	expectAttempt := func(deliveryId int64, status string, statusCode int32, lastError string) {
+		conn.ExpectBegin()
+		conn.ExpectQuery("INSERT INTO webhook_delivery_attempts").
+			WithArgs(deliveryId, pgtype.Int4{Int32: statusCode, Valid: true}, lastError, pgxmock.AnyArg()).
+			WillReturnRows(pgxmock.NewRows([]string{"id", "delivery_id", "status_code", "error", "duration_ms", "created_at"}).
+				AddRow(int64(1), deliveryId, pgtype.Int4{Int32: statusCode, Valid: true}, lastError, int32(1), createdAt))
+		conn.ExpectQuery("attempts = attempts \\+ 1").
+			WithArgs(deliveryId, status, pgxmock.AnyArg(), pgtype.Int4{Int32: statusCode, Valid: true}, lastError).
+			WillReturnRows(pgxmock.NewRows(deliveryColumns).
+				AddRow(deliveryId, int64(1), deliveryId, outbox.EventOrderPlaced, payload, status, int32(1), createdAt, pgtype.Int4{Int32: statusCode, Valid: true}, lastError, createdAt, createdAt, nil))
+		conn.ExpectCommit()
+	}
+
+	// Subscriptions only accept known event types
+	conn.ExpectQuery("INSERT INTO webhook_subscriptions").
+		WithArgs(receiver.URL, pgxmock.AnyArg(), []string{outbox.EventOrderPlaced}).
+		WillReturnRows(pgxmock.NewRows(subscriptionColumns).
+			AddRow(int64(1), receiver.URL, secret, []string{outbox.EventOrderPlaced}, true, createdAt, createdAt))
+	// First batch: delivery 1 fails and is rescheduled
+	conn.ExpectQuery("SKIP LOCKED").
+		WithArgs(pgxmock.AnyArg(), int32(10)).
+		WillReturnRows(pgxmock.NewRows(deliveryColumns).
+			AddRow(int64(1), int64(1), int64(1), outbox.EventOrderPlaced, payload, "pending", int32(0), createdAt, pgtype.Int4{}, "", createdAt, createdAt, nil))
+	expectSubscription()
+	expectAttempt(1, webhooks.StatusPending, http.StatusServiceUnavailable, "unexpected response status 503")
+	// Second batch: delivery 1 succeeds and delivery 2 runs out of attempts
+	conn.ExpectQuery("SKIP LOCKED").
+		WithArgs(pgxmock.AnyArg(), int32(10)).
+		WillReturnRows(pgxmock.NewRows(deliveryColumns).
+			AddRow(int64(1), int64(1), int64(1), outbox.EventOrderPlaced, payload, "pending", int32(1), createdAt, pgtype.Int4{Int32: 503, Valid: true}, "", createdAt, createdAt, nil).
+			AddRow(int64(2), int64(1), int64(2), outbox.EventOrderPlaced, payload, "pending", int32(2), createdAt, pgtype.Int4{Int32: 503, Valid: true}, "", createdAt, createdAt, nil))
+	expectSubscription()
+	expectAttempt(1, webhooks.StatusDelivered, http.StatusNoContent, "")
+	expectAttempt(2, webhooks.StatusDead, http.StatusServiceUnavailable, "unexpected response status 503")
+
+	webhooksHandler := webhooks.NewHandler(webhooks.NewService(repo.New(conn)))
+	r2 := chi.NewRouter()
+	r2.Post("/webhooks", webhooksHandler.CreateSubscription)
+	server := httptest.NewServer(r2)
+	defer server.Close()
+
+	body, _ := json.Marshal(webhooks.CreateSubscriptionParams{URL: receiver.URL, EventTypes: []string{"order.shipped"}})
+	resp, err := http.Post(server.URL+"/webhooks", "application/json", bytes.NewBuffer(body))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp.Body.Close()
+
+	body, _ = json.Marshal(webhooks.CreateSubscriptionParams{URL: receiver.URL, EventTypes: []string{outbox.EventOrderPlaced}})
+	resp, err = http.Post(server.URL+"/webhooks", "application/json", bytes.NewBuffer(body))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	var created webhooks.CreatedSubscription
+	json.NewDecoder(resp.Body).Decode(&created)
+	resp.Body.Close()
+	assert.Equal(t, secret, created.Secret)
+
+	dispatcher := webhooks.NewDispatcher(repo.New(conn), conn, receiver.Client(), time.Second, 10, 3, time.Minute)
+	dispatched, err := dispatcher.DispatchBatch(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, dispatched)
+	dispatched, err = dispatcher.DispatchBatch(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 2, dispatched)
+	assert.Equal(t, []string{"1", "1", "2"}, received)
+	assert.NoError(t, conn.ExpectationsWereMet())
+}
+
+// signToken builds a JWT signed with an HMAC secret ([]byte) or an RSA key.
+func signToken(t *testing.T, kid string, key any, claims map[string]any) string {
+	t.Helper()
+	h := map[string]string{"typ": "JWT", "alg": auth.AlgHS256}
+	if _, ok := key.(*rsa.PrivateKey); ok {
+		h["alg"] = auth.AlgRS256
+	}
+	if kid != "" {
+		h["kid"] = kid
+	}
+	hb, _ := json.Marshal(h)
+	cb, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(hb) + "." + base64.RawURLEncoding.EncodeToString(cb)
+	var signature []byte
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		digest := sha256.Sum256([]byte(signed))
+		s, err := rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature = s
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// unsignedToken builds a JWT with the none algorithm.
+func unsignedToken(claims map[string]any) string {
+	cb, _ := json.Marshal(claims)
+	return base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`)) + "." + base64.RawURLEncoding.EncodeToString(cb) + "."
+}
+
+func TestAuthentication(t *testing.T) {
+	conn, err := pgxmock.NewConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close(context.Background())
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwksFile := filepath.Join(t.TempDir(), "jwks.json")
+	jwks := fmt.Sprintf(`{"keys":[{"kty":"RSA","kid":"key-1","use":"sig","alg":"RS256","n":%q,"e":%q}]}`,
+		base64.RawURLEncoding.EncodeToString(rsaKey.N.Bytes()),
+		base64.RawURLEncoding.EncodeToString(big.NewInt(int64(rsaKey.E)).Bytes()))
+	if err := os.WriteFile(jwksFile, []byte(jwks), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	secret := []byte("a-very-long-hs256-test-secret")
+	verifier, err := newJWTVerifier(config.Auth{
+		JWTIssuer:   "https://auth.example.com",
+		JWTAudience: "ecommerce",
+		JWTSecret:   string(secret),
+		JWKSFile:    jwksFile,
+		JWTLeeway:   time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	apiKey := "ak_" + strings.Repeat("ab", 32)
+	conn.ExpectQuery("FROM\\s+api_keys").
+		WithArgs(auth.HashAPIKey(apiKey)).
+		WillReturnRows(pgxmock.NewRows([]string{"id", "name", "key_prefix", "key_hash", "created_at", "revoked_at"}).
+			AddRow(int64(7), "billing", apiKey[:11], auth.HashAPIKey(apiKey), time.Now(), nil))
+	conn.ExpectQuery("FROM\\s+api_keys").
+		WithArgs(auth.HashAPIKey("ak_revoked")).
+		WillReturnError(pgx.ErrNoRows)
+
+	r := chi.NewRouter()
+	r.Use(auth.NewAuthenticator(verifier, repo.New(conn)).Middleware)
+	r.Get("/public", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	r.With(auth.Required).Get("/me", func(w http.ResponseWriter, r *http.Request) {
+		p, _ := auth.PrincipalFrom(r.Context())
+		json.NewEncoder(w).Encode(p)
+	})
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	get := func(path string, header string, value string) (int, auth.Principal) {
+		req, _ := http.NewRequest(http.MethodGet, server.URL+path, nil)
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+		var p auth.Principal
+		if resp.StatusCode == http.StatusOK {
+			assert.NoError(t, json.NewDecoder(resp.Body).Decode(&p))
+		}
+		return resp.StatusCode, p
+	}
+	claims := func(overrides map[string]any) map[string]any {
+		c := map[string]any{
+			"sub":   "user-1",
+			"iss":   "https://auth.example.com",
+			"aud":   []string{"ecommerce", "other"},
+			"exp":   time.Now().Add(time.Hour).Unix(),
+			"roles": []string{"admin"},
+		}
+		for k, v := range overrides {
+			if v == nil {
+				delete(c, k)
+				continue
+			}
+			c[k] = v
+		}
+		return c
+	}
+
+	status, _ := get("/public", "", "")
+	assert.Equal(t, http.StatusNoContent, status)
+	status, _ = get("/me", "", "")
+	assert.Equal(t, http.StatusUnauthorized, status)
+
+	status, p := get("/me", "Authorization", "Bearer "+signToken(t, "", secret, claims(nil)))
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, auth.Principal{Subject: "user-1", Method: auth.MethodJWT, Roles: []string{"admin"}}, p)
+
+	status, p = get("/me", "Authorization", "Bearer "+signToken(t, "key-1", rsaKey, claims(map[string]any{"aud": "ecommerce"})))
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "user-1", p.Subject)
+
+	status, p = get("/me", auth.HeaderAPIKey, apiKey)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, auth.Principal{Subject: "api_key:7", Method: auth.MethodAPIKey, Roles: []string{auth.RoleService}}, p)
+
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	rejected := map[string]string{
+		"expired":         signToken(t, "", secret, claims(map[string]any{"exp": time.Now().Add(-2 * time.Minute).Unix()})),
+		"without expiry":  signToken(t, "", secret, claims(map[string]any{"exp": nil})),
+		"not valid yet":   signToken(t, "", secret, claims(map[string]any{"nbf": time.Now().Add(time.Hour).Unix()})),
+		"wrong issuer":    signToken(t, "", secret, claims(map[string]any{"iss": "https://evil.example.com"})),
+		"wrong audience":  signToken(t, "", secret, claims(map[string]any{"aud": "other"})),
+		"wrong secret":    signToken(t, "", []byte("another-secret"), claims(nil)),
+		"unknown key":     signToken(t, "key-2", rsaKey, claims(nil)),
+		"wrong key":       signToken(t, "key-1", otherKey, claims(nil)),
+		"unsigned":        unsignedToken(claims(nil)),
+		"malformed":       "not-a-token",
+		"without subject": signToken(t, "", secret, claims(map[string]any{"sub": nil})),
+	}
+	for name, token := range rejected {
+		status, _ := get("/public", "Authorization", "Bearer "+token)
+		assert.Equal(t, http.StatusUnauthorized, status, name)
+	}
+	status, _ = get("/public", auth.HeaderAPIKey, "ak_revoked")
+	assert.Equal(t, http.StatusUnauthorized, status)
+	assert.NoError(t, conn.ExpectationsWereMet())
+}
+
+func TestAuthorization(t *testing.T) {
+	conn, err := pgxmock.NewConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close(context.Background())
+
+	secret := []byte("a-very-long-hs256-test-secret")
+	verifier, err := newJWTVerifier(config.Auth{JWTSecret: string(secret)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := func(roles []string, customerId int64) string {
+		claims := map[string]any{"sub": "user", "exp": time.Now().Add(time.Hour).Unix(), "roles": roles}
+		if customerId != 0 {
+			claims["customer_id"] = customerId
+		}
+		return signToken(t, "", secret, claims)
+	}
+	admin := token([]string{auth.RoleAdmin}, 0)
+	staff := token([]string{auth.RoleStaff}, 0)
+	customer1 := token([]string{auth.RoleCustomer}, 1)
+	customer2 := token([]string{auth.RoleCustomer}, 2)
+
+	productsService := products.NewService(repo.New(conn), conn)
+	ordersService := orders.NewServiceWithDB(repo.New(conn), conn, productsService, products.SingleLocationPreferred{})
+	ordersHandler := orders.NewHandler(ordersService)
+	created := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	}
+	r := chi.NewRouter()
+	r.Use(auth.NewAuthenticator(verifier, repo.New(conn)).Middleware)
+	r.Use(auth.Required)
+	r.With(auth.Require(auth.PermissionWriteProducts)).Post("/products", created)
+	r.With(auth.Require(auth.PermissionWriteStock)).Put("/products/{id}/stock/{locationId}", created)
+	r.With(auth.Require(auth.PermissionPlaceOrders)).Post("/orders", ordersHandler.PlaceOrder)
+	r.With(auth.Require(auth.PermissionReadOrders)).Get("/orders/{id}", ordersHandler.FindOrderById)
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	do := func(method string, path string, token string, body string) *http.Response {
+		req, _ := http.NewRequest(method, server.URL+path, bytes.NewBufferString(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		return resp
+	}
+
+	// Only admins manage the catalog and the stock
+	for _, tc := range []struct {
+		method string
+		path   string
+		token  string
+		status int
+	}{
+		{http.MethodPost, "/products", "", http.StatusUnauthorized},
+		{http.MethodPost, "/products", customer1, http.StatusForbidden},
+		{http.MethodPost, "/products", staff, http.StatusForbidden},
+		{http.MethodPost, "/products", admin, http.StatusCreated},
+		{http.MethodPut, "/products/1/stock/1", staff, http.StatusForbidden},
+		{http.MethodPut, "/products/1/stock/1", admin, http.StatusCreated},
+		{http.MethodGet, "/orders/1", token([]string{auth.RoleCustomer}, 0), http.StatusUnauthorized},
+	} {
+		resp := do(tc.method, tc.path, tc.token, "")
+		resp.Body.Close()
+		assert.Equal(t, tc.status, resp.StatusCode, "%s %s", tc.method, tc.path)
+	}
+
+	// The order is placed for the calling customer, not the one of the body
+	createdAt := time.Date(2025, 12, 24, 14, 2, 58, 452793000, time.FixedZone("", -3*3600))
+	conn.ExpectBegin()
+	expectShippingAddress(conn, 1, 1)
+	conn.ExpectQuery("INSERT INTO orders").
+		WithArgs(int64(1)).
+		WillReturnRows(pgxmock.NewRows([]string{"id", "customer_id", "created_at", "status"}).
+			AddRow(int64(1), int64(1), createdAt, "pending"))
+	expectOrderAddress(conn, 1)
+	expectProductLock(conn, 1, 10)
+	expectProductStock(conn, 1, products.LocationStock{LocationId: 1, Quantity: 10})
+	expectStockChange(conn, 1, 1, -1, 9, products.ReasonSale, 1)
+	conn.ExpectQuery("INSERT INTO order_items").
+		WithArgs(int64(1), int64(1), int32(1), int32(10000)).
+		WillReturnRows(pgxmock.NewRows([]string{"id", "order_id", "product_id", "quantity", "price_cents"}).
+			AddRow(int64(1), int64(1), int64(1), int32(1), int32(10000)))
+	conn.ExpectQuery("INSERT INTO order_item_allocations").
+		WithArgs(int64(1), int64(1), int32(1)).
+		WillReturnRows(pgxmock.NewRows([]string{"id", "order_item_id", "location_id", "quantity"}).
+			AddRow(int64(1), int64(1), int64(1), int32(1)))
+	expectOutboxEvent(conn, outbox.AggregateOrder, 1, outbox.EventOrderPlaced)
+	conn.ExpectCommit()
+	resp := do(http.MethodPost, "/orders", customer1, `{"customer_id":2,"shipping_address_id":1,"items":[{"product_id":1,"quantity":1}]}`)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	var order repo.Order
+	json.NewDecoder(resp.Body).Decode(&order)
+	resp.Body.Close()
+	assert.Equal(t, int64(1), order.CustomerID)
+
+	// Another customer does not find the order
+	orderColumns := []string{"order_id", "customer_id", "created_at", "status", "order_item_id", "product_id", "quantity", "price_cents"}
+	expectOrder := func() {
+		conn.ExpectQuery("WHERE o.id").
+			WithArgs(int64(1)).
+			WillReturnRows(pgxmock.NewRows(orderColumns).
+				AddRow(int64(1), int64(1), createdAt, "pending", pgtype.Int8{Int64: 1, Valid: true}, pgtype.Int8{Int64: 1, Valid: true}, pgtype.Int4{Int32: 1, Valid: true}, pgtype.Int4{Int32: 10000, Valid: true}))
+	}
+	expectOrder()
+	resp = do(http.MethodGet, "/orders/1", customer2, "")
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	// Its customer and the staff do
+	for _, token := range []string{customer1, staff} {
+		expectOrder()
+		conn.ExpectQuery("FROM\\s+order_item_allocations").
+			WithArgs(int64(1)).
+			WillReturnRows(pgxmock.NewRows([]string{"id", "order_item_id", "product_id", "location_id", "quantity"}))
+		conn.ExpectQuery("FROM order_addresses").
+			WithArgs(int64(1)).
+			WillReturnRows(pgxmock.NewRows(orderAddressColumns))
+		resp = do(http.MethodGet, "/orders/1", token, "")
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+	assert.NoError(t, conn.ExpectationsWereMet())
+}
+
+func TestRateLimit(t *testing.T) {
+	policy := ratelimit.Policy{Name: "test", Limit: 2, Period: time.Hour}
+	newServer := func(store ratelimit.Store) *httptest.Server {
+		r := chi.NewRouter()
+		r.Use(middleware.RealIP)
+		r.With(ratelimit.NewLimiter(store).Limit(policy)).Get("/", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		})
+		return httptest.NewServer(r)
+	}
+	get := func(server *httptest.Server, ip string) *http.Response {
+		req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+		req.Header.Set("X-Forwarded-For", ip)
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		resp.Body.Close()
+		return resp
+	}
+
+	server := newServer(ratelimit.NewMemoryStore())
+	defer server.Close()
+	for _, remaining := range []string{"1", "0"} {
+		resp := get(server, "10.0.0.1")
+		assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+		assert.Equal(t, "2", resp.Header.Get(ratelimit.HeaderLimit))
+		assert.Equal(t, remaining, resp.Header.Get(ratelimit.HeaderRemaining))
+		assert.Equal(t, "2;w=3600", resp.Header.Get(ratelimit.HeaderPolicy))
+		assert.Empty(t, resp.Header.Get(ratelimit.HeaderRetryAfter))
+	}
+	resp := get(server, "10.0.0.1")
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, "0", resp.Header.Get(ratelimit.HeaderRemaining))
+	assert.Equal(t, "1800", resp.Header.Get(ratelimit.HeaderRetryAfter))
+	assert.Equal(t, "3600", resp.Header.Get(ratelimit.HeaderReset))
+	// Another client has its own bucket
+	resp = get(server, "10.0.0.2")
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	conn, err := pgxmock.NewConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close(context.Background())
+	conn.ExpectQuery("INSERT INTO rate_limit_buckets").
+		WithArgs("test:ip:10.0.0.1", float64(2), float64(2)/3600).
+		WillReturnRows(pgxmock.NewRows([]string{"tokens", "allowed"}).AddRow(0.5, false))
+	// The requests go through when the store fails
+	conn.ExpectQuery("INSERT INTO rate_limit_buckets").
+		WithArgs("test:ip:10.0.0.1", float64(2), float64(2)/3600).
+		WillReturnError(fmt.Errorf("connection refused"))
+	pgServer := newServer(ratelimit.NewPostgresStore(repo.New(conn)))
+	defer pgServer.Close()
+	resp = get(pgServer, "10.0.0.1")
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, "900", resp.Header.Get(ratelimit.HeaderRetryAfter))
+	resp = get(pgServer, "10.0.0.1")
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	assert.NoError(t, conn.ExpectationsWereMet())
+}
+
+func TestLimitFailedAuthentication(t *testing.T) {
+	policy := ratelimit.Policy{Name: "auth-failures", Limit: 2, Period: time.Hour}
+	newServer := func(store ratelimit.Store) *httptest.Server {
+		r := chi.NewRouter()
+		r.Use(middleware.RealIP)
+		r.Use(ratelimit.NewLimiter(store).LimitFailedAuthentication(policy))
+		r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get(auth.HeaderAPIKey) != "right" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		})
+		return httptest.NewServer(r)
+	}
+	get := func(server *httptest.Server, ip string, key string) *http.Response {
+		req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+		req.Header.Set("X-Forwarded-For", ip)
+		req.Header.Set(auth.HeaderAPIKey, key)
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		resp.Body.Close()
+		return resp
+	}
+
+	server := newServer(ratelimit.NewMemoryStore())
+	defer server.Close()
+	// Successful authentications are not counted
+	for range 3 {
+		assert.Equal(t, http.StatusNoContent, get(server, "10.0.0.1", "right").StatusCode)
+	}
+	for range 2 {
+		assert.Equal(t, http.StatusUnauthorized, get(server, "10.0.0.1", "wrong").StatusCode)
+	}
+	// Once the failures are used up, even the right key is rejected
+	resp := get(server, "10.0.0.1", "right")
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, "1800", resp.Header.Get(ratelimit.HeaderRetryAfter))
+	assert.Equal(t, http.StatusNoContent, get(server, "10.0.0.2", "right").StatusCode)
+
+	conn, err := pgxmock.NewConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close(context.Background())
+	conn.ExpectQuery("FROM\\s+rate_limit_buckets").
+		WithArgs(float64(2), float64(2)/3600, "auth-failures:ip:10.0.0.1").
+		WillReturnError(pgx.ErrNoRows)
+	conn.ExpectQuery("INSERT INTO rate_limit_buckets").
+		WithArgs("auth-failures:ip:10.0.0.1", float64(2), float64(2)/3600).
+		WillReturnRows(pgxmock.NewRows([]string{"tokens", "allowed"}).AddRow(0.5, true))
+	conn.ExpectQuery("FROM\\s+rate_limit_buckets").
+		WithArgs(float64(2), float64(2)/3600, "auth-failures:ip:10.0.0.1").
+		WillReturnRows(pgxmock.NewRows([]string{"tokens"}).AddRow(0.5))
+	pgServer := newServer(ratelimit.NewPostgresStore(repo.New(conn)))
+	defer pgServer.Close()
+	assert.Equal(t, http.StatusUnauthorized, get(pgServer, "10.0.0.1", "wrong").StatusCode)
+	resp = get(pgServer, "10.0.0.1", "right")
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, "900", resp.Header.Get(ratelimit.HeaderRetryAfter))
+	assert.NoError(t, conn.ExpectationsWereMet())
+}
+
+func TestGracefulShutdown(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	app := &application{config: config.Config{
+		Server:   config.Server{Addr: addr},
+		Shutdown: config.Shutdown{DrainTimeout: 5 * time.Second},
+	}}
+	started := make(chan struct{})
+	r := chi.NewRouter()
+	r.Get("/slow", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(200 * time.Millisecond)
+		w.WriteHeader(http.StatusNoContent)
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- app.run(ctx, r)
+	}()
+
+	// The request in flight when the shutdown starts is served
+	status := make(chan int, 1)
+	go func() {
+		var resp *http.Response
+		for i := 0; i < 50; i++ {
+			if resp, err = http.Get("http://" + addr + "/slow"); err == nil {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if !assert.NoError(t, err) {
+			close(started)
+			status <- 0
+			return
+		}
+		resp.Body.Close()
+		status <- resp.StatusCode
+	}()
+	<-started
+	cancel()
+	assert.Equal(t, http.StatusNoContent, <-status)
+	assert.NoError(t, <-done)
+	assert.True(t, app.draining.Load())
+	_, err = http.Get("http://" + addr + "/slow")
+	assert.Error(t, err)
+
+	// The workers stop in the order they were started
+	var (
+		mu      sync.Mutex
+		stopped []string
+	)
+	workers := workerGroup{heartbeats: health.NewHeartbeats()}
+	for _, name := range []string{"first", "second", "third"} {
+		workers.start(name, time.Second, func(ctx context.Context) {
+			<-ctx.Done()
+			mu.Lock()
+			defer mu.Unlock()
+			stopped = append(stopped, name)
+		})
+	}
+	workers.stop()
+	assert.Equal(t, []string{"first", "second", "third"}, stopped)
+}
+
+func TestProbesIgnoreCredentials(t *testing.T) {
+	// The database is unreachable, so the readiness fails without waiting
+	pool, err := pgxpool.New(context.Background(), "postgres://postgres@127.0.0.1:1/ecomm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+	app := &application{
+		config: config.Config{
+			Server: config.Server{RequestTimeout: 5 * time.Second},
+			Health: config.Health{Timeout: time.Second},
+		},
+		db:               pool,
+		rateLimits:       ratelimit.NewMemoryStore(),
+		globalLimit:      ratelimit.Policy{Name: "global", Limit: 100, Period: time.Minute},
+		placeOrderLimit:  ratelimit.Policy{Name: "place-order", Limit: 10, Period: time.Minute},
+		authFailureLimit: ratelimit.Policy{Name: "auth-failures", Limit: 1, Period: time.Hour},
+		heartbeats:       health.NewHeartbeats(),
+	}
+	server := httptest.NewServer(app.mount())
+	defer server.Close()
+	get := func(path string) int {
+		req, _ := http.NewRequest(http.MethodGet, server.URL+path, nil)
+		req.Header.Set("Authorization", "Bearer stale")
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// A probe with stale credentials gets its status, however many times
+	// it asks
+	for range 3 {
+		assert.Equal(t, http.StatusOK, get("/livez"))
+		assert.Equal(t, http.StatusServiceUnavailable, get("/readyz"))
+	}
+	// The verbose readiness is still authenticated
+	assert.Equal(t, http.StatusUnauthorized, get("/readyz?verbose=true"))
+	assert.Equal(t, http.StatusTooManyRequests, get("/products"))
+	assert.Equal(t, http.StatusOK, get("/livez"))
+}
+
+func TestReadiness(t *testing.T) {
+	conn, err := pgxmock.NewConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close(context.Background())
+	// The checks run concurrently
+	conn.MatchExpectationsInOrder(false)
+	latest, err := postgresql.LatestMigration()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		draining atomic.Bool
+		admin    bool
+	)
+	heartbeats := health.NewHeartbeats()
+	heartbeats.Register(context.Background(), "outbox-relay", time.Second)
+	checker := health.NewChecker(time.Second,
+		drainingCheck(&draining),
+		databaseCheck(conn),
+		migrationsCheck(conn),
+		outboxCheck(repo.New(conn), time.Minute),
+		heartbeats.Check(),
+	)
+	r := chi.NewRouter()
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if admin {
+				r = r.WithContext(auth.WithPrincipal(r.Context(), auth.Principal{Subject: "1", Roles: []string{auth.RoleAdmin}}))
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+	r.Get("/readyz", checker.ServeHTTP)
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	expectChecks := func(pingErr error, version int64, oldest time.Time) {
+		conn.ExpectPing().WillReturnError(pingErr)
+		conn.ExpectQuery("FROM goose_db_version").
+			WillReturnRows(pgxmock.NewRows([]string{"version_id"}).AddRow(version))
+		conn.ExpectQuery("FROM outbox").
+			WillReturnRows(pgxmock.NewRows([]string{"pending", "oldest_created_at"}).
+				AddRow(int64(3), pgtype.Timestamptz{Time: oldest, Valid: true}))
+	}
+	get := func(query string) (int, health.Report) {
+		resp, err := http.Get(server.URL + "/readyz" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var report health.Report
+		json.NewDecoder(resp.Body).Decode(&report)
+		return resp.StatusCode, report
+	}
+
+	// Everything is fine, only the statuses are reported
+	expectChecks(nil, latest, time.Now())
+	status, report := get("?verbose=true")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, health.StatusOK, report.Status)
+	assert.Len(t, report.Checks, 5)
+	assert.Equal(t, health.Result{Status: health.StatusOK}, report.Checks["database"])
+
+	// A backlog growing old only warns
+	admin = true
+	expectChecks(nil, latest, time.Now().Add(-time.Hour))
+	status, report = get("?verbose=true")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, health.StatusWarn, report.Status)
+	assert.Equal(t, health.StatusWarn, report.Checks["outbox"].Status)
+	assert.Equal(t, float64(3), report.Checks["outbox"].Details["pending"])
+	assert.Equal(t, float64(latest), report.Checks["migrations"].Details["expected"])
+
+	// The database being unreachable or behind fails the readiness
+	expectChecks(errors.New("connection refused"), latest-1, time.Now())
+	status, report = get("?verbose=true")
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+	assert.Equal(t, health.StatusFail, report.Status)
+	assert.Equal(t, "connection refused", report.Checks["database"].Error)
+	assert.Equal(t, health.StatusFail, report.Checks["migrations"].Status)
+
+	// So does the server draining
+	draining.Store(true)
+	expectChecks(nil, latest, time.Now())
+	status, report = get("")
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+	assert.Equal(t, health.StatusFail, report.Checks["server"].Status)
+	assert.Empty(t, report.Checks["server"].Error)
+	assert.NoError(t, conn.ExpectationsWereMet())
+}
+
+func TestMetrics(t *testing.T) {
+	r := chi.NewRouter()
+	r.Use(metrics.Middleware)
+	r.Get("/metrics", promhttp.Handler().ServeHTTP)
+	r.Get("/things/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if chi.URLParam(r, "id") == "0" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("thing"))
+	})
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	for _, path := range []string{"/things/1", "/things/2", "/things/0", "/nowhere/1"} {
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	resp, err := http.Get(server.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// The requests are labelled by route pattern, not by path
+	assert.Contains(t, string(body), `ecommerce_http_requests_total{method="GET",route="/things/{id}",status="200"} 2`)
+	assert.Contains(t, string(body), `ecommerce_http_requests_total{method="GET",route="/things/{id}",status="404"} 1`)
+	assert.Contains(t, string(body), `ecommerce_http_requests_total{method="GET",route="unmatched",status="404"} 1`)
+	assert.Contains(t, string(body), `ecommerce_http_request_duration_seconds_bucket{method="GET",route="/things/{id}",status="200",le="+Inf"} 2`)
+	assert.Contains(t, string(body), "ecommerce_orders_placed_total")
+
+	// The queries are named after their sqlc name
+	assert.Equal(t, "FindProductById", postgresql.QueryName("-- name: FindProductById :one\nSELECT id FROM products WHERE id = $1"))
+	assert.Equal(t, "begin", postgresql.QueryName("begin"))
+	assert.Equal(t, "select", postgresql.QueryName("SELECT version_id FROM goose_db_version"))
+}
+
+func TestTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	defer otel.SetTracerProvider(otel.GetTracerProvider())
+	otel.SetTracerProvider(provider)
+	if _, err := tracing.Setup(context.Background(), nil, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// The trace context is passed on to the services called
+	traceparent := make(chan string, 1)
+	downstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent <- r.Header.Get("traceparent")
+	}))
+	defer downstream.Close()
+	client := &http.Client{Transport: tracing.Transport(http.DefaultTransport)}
+
+	r := chi.NewRouter()
+	r.Use(tracing.Middleware)
+	r.Get("/things/{id}", func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := tracing.Start(r.Context(), "things.FindThing")
+		defer span.End()
+		var tracer tracing.QueryTracer
+		qctx := tracer.TraceQueryStart(ctx, nil, pgx.TraceQueryStartData{SQL: "-- name: FindThingById :one\nSELECT id FROM things WHERE id = $1"})
+		tracer.TraceQueryEnd(qctx, nil, pgx.TraceQueryEndData{})
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, downstream.URL, nil)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		resp.Body.Close()
+	})
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	// The trace of the caller is continued
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/things/1", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", span.SpanContext().TraceID().String())
+		spans[span.Name()] = span
+	}
+	if !assert.Len(t, spans, 4) {
+		t.FailNow()
+	}
+	route, service, query, call := spans["GET /things/{id}"], spans["things.FindThing"], spans["FindThingById"], spans["GET"]
+	assert.Equal(t, "00f067aa0ba902b7", route.Parent().SpanID().String())
+	assert.Equal(t, route.SpanContext().SpanID(), service.Parent().SpanID())
+	assert.Equal(t, service.SpanContext().SpanID(), query.Parent().SpanID())
+	assert.Equal(t, service.SpanContext().SpanID(), call.Parent().SpanID())
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-"+call.SpanContext().SpanID().String()+"-01", <-traceparent)
+
+	// The queries made outside of a trace are not traced
+	var tracer tracing.QueryTracer
+	ctx := tracer.TraceQueryStart(context.Background(), nil, pgx.TraceQueryStartData{SQL: "-- name: ClaimOutboxEvents :many"})
+	tracer.TraceQueryEnd(ctx, nil, pgx.TraceQueryEndData{})
+	assert.Len(t, recorder.Ended(), 4)
+}
+
+func TestRequestLogging(t *testing.T) {
+	var logs bytes.Buffer
+	logger, err := logging.New(&logs, logging.FormatJSON, "debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(logger)
+	if _, err := tracing.Setup(context.Background(), nil, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	secret := []byte("a-very-long-hs256-test-secret")
+	verifier, err := newJWTVerifier(config.Auth{JWTSecret: string(secret)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := chi.NewRouter()
+	r.Use(middleware.RequestID)
+	r.Use(tracing.Middleware)
+	r.Use(logging.Middleware)
+	r.Use(auth.NewAuthenticator(verifier, nil).Middleware)
+	r.Get("/orders/{id}", func(w http.ResponseWriter, r *http.Request) {
+		logging.FromContext(r.Context()).Warn("request failed", "error", orders.ErrOrderNotFound)
+		responses.NewJsonErrorResponse(w, http.StatusNotFound, "not_found", "order not found")
+	})
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	token := signToken(t, "", secret, map[string]any{
+		"sub":         "user-1",
+		"exp":         time.Now().Add(time.Hour).Unix(),
+		"roles":       []string{auth.RoleCustomer},
+		"customer_id": 42,
+	})
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/orders/1", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	var records []map[string]any
+	for line := range strings.Lines(logs.String()) {
+		var record map[string]any
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record)
+	}
+	if !assert.Len(t, records, 2) {
+		t.FailNow()
+	}
+	// The logs of the handlers carry the request, its route and principal
+	handler, access := records[0], records[1]
+	assert.Equal(t, "WARN", handler["level"])
+	assert.Equal(t, "order not found", handler["error"])
+	assert.NotEmpty(t, handler["request_id"])
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", handler["trace_id"])
+	assert.Equal(t, "/orders/{id}", handler["route"])
+	assert.Equal(t, "user-1", handler["principal"])
+	assert.Equal(t, float64(42), handler["customer_id"])
+
+	// The access log replaces the chi logger
+	assert.Equal(t, "request served", access["msg"])
+	assert.Equal(t, handler["request_id"], access["request_id"])
+	assert.Equal(t, "user-1", access["principal"])
+	assert.Equal(t, "/orders/{id}", access["route"])
+	assert.Equal(t, "/orders/1", access["path"])
+	assert.Equal(t, float64(http.StatusNotFound), access["status"])
+
+	_, err = logging.New(&logs, "xml", "info")
+	assert.Error(t, err)
+	_, err = logging.New(&logs, logging.FormatText, "verbose")
+	assert.Error(t, err)
+}
+
+func TestConfig(t *testing.T) {
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "config.yaml")
+	envFile := filepath.Join(dir, ".env")
+	if err := os.WriteFile(configFile, []byte(`
+server:
+  addr: ":8080"
+db:
+  max_conns: 5
+auth:
+  jwt_hs256_secret: a-very-long-hs256-test-secret
+metrics:
+  enabled: false
+outbox:
+  publishers: [webhooks, log]
+`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(envFile, []byte(`# Shared with goose
+GOOSE_DBSTRING="host=localhost user=postgres password=postgres dbname=ecomm"
+PAYMENT_GATEWAY=fake
+DB_MAX_CONNS=20
+export LOG_LEVEL=debug # for now
+CONFIG_FILE=`+configFile+`
+`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// The environment takes precedence over the .env file, which takes
+	// precedence over the YAML file
+	cfg, err := config.Load([]string{"ENV_FILE=" + envFile, "DB_MAX_CONNS=30", "WEBHOOK_TIMEOUT="})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, cfg.Validate())
+	assert.Equal(t, "host=localhost user=postgres password=postgres dbname=ecomm", cfg.DB.DSN)
+	assert.Equal(t, int32(30), cfg.DB.MaxConns)
+	assert.Equal(t, int32(2), cfg.DB.MinConns)
+	assert.Equal(t, "debug", cfg.Log.Level)
+	assert.Equal(t, ":8080", cfg.Server.Addr)
+	assert.Equal(t, "a-very-long-hs256-test-secret", cfg.Auth.JWTSecret)
+	assert.False(t, cfg.Metrics.Enabled)
+	assert.Equal(t, []string{"webhooks", "log"}, cfg.Outbox.Publishers)
+	assert.Equal(t, 10*time.Second, cfg.Webhooks.Timeout)
+	assert.Equal(t, 7*24*time.Hour, cfg.Carts.TTL)
+	assert.Equal(t, 5*time.Second, cfg.Shutdown.ReadinessDelay)
+
+	// The secrets are redacted when printed
+	var printed bytes.Buffer
+	assert.NoError(t, cfg.Print(&printed))
+	assert.Contains(t, printed.String(), `GOOSE_DBSTRING="[redacted]" # `+envFile+"\n")
+	assert.Contains(t, printed.String(), `AUTH_JWT_HS256_SECRET="[redacted]" # `+configFile+"\n")
+	assert.Contains(t, printed.String(), `DB_MAX_CONNS="30" # environment`+"\n")
+	assert.Contains(t, printed.String(), `OUTBOX_PUBLISHER="webhooks,log" # `+configFile+"\n")
+	assert.Contains(t, printed.String(), `CART_TTL="168h0m0s" # default`+"\n")
+	assert.Contains(t, printed.String(), `AUTH_JWT_ISSUER="" # default`+"\n")
+	assert.NotContains(t, printed.String(), "password")
+	assert.NotContains(t, printed.String(), "hs256-test-secret")
+
+	// The required settings must be set
+	emptyEnvFile := filepath.Join(dir, "empty.env")
+	if err := os.WriteFile(emptyEnvFile, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err = config.Load([]string{"ENV_FILE=" + emptyEnvFile})
+	assert.NoError(t, err)
+	assert.ErrorContains(t, cfg.Validate(), "GOOSE_DBSTRING is required")
+	assert.ErrorContains(t, cfg.Validate(), "PAYMENT_GATEWAY is required")
+
+	// The values must be of the type of the setting
+	_, err = config.Load([]string{"ENV_FILE=" + emptyEnvFile, "DB_MAX_CONNS=many", "CART_TTL=1 week"})
+	assert.ErrorContains(t, err, `DB_MAX_CONNS (environment): invalid value "many"`)
+	assert.ErrorContains(t, err, `CART_TTL (environment): invalid value "1 week"`)
+
+	// Typos in the YAML file and missing files are reported
+	if err := os.WriteFile(configFile, []byte("db:\n  max_con: 5\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	_, err = config.Load([]string{"ENV_FILE=" + emptyEnvFile, "CONFIG_FILE=" + configFile})
+	assert.ErrorContains(t, err, "unknown setting db.max_con")
+	_, err = config.Load([]string{"ENV_FILE=" + filepath.Join(dir, "missing.env")})
+	assert.Error(t, err)
 }
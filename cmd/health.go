@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/mellomaths/ecommerce-ms/internal/adapters/postgresql"
+	repo "github.com/mellomaths/ecommerce-ms/internal/adapters/postgresql/sqlc"
+	"github.com/mellomaths/ecommerce-ms/internal/health"
+)
+
+// readiness checks what the service needs to take requests. Only the server
+// draining, the database and its schema are critical: the outbox backlog and
+// the workers only warn, as the requests are still served.
+func (app *application) readiness() *health.Checker {
+	return health.NewChecker(app.config.Health.Timeout,
+		drainingCheck(&app.draining),
+		databaseCheck(app.db),
+		migrationsCheck(app.db),
+		outboxCheck(repo.New(app.db), app.config.Health.OutboxMaxAge),
+		app.heartbeats.Check(),
+	)
+}
+
+func drainingCheck(draining *atomic.Bool) health.Check {
+	return health.Check{Name: "server", Critical: true, Run: func(ctx context.Context) health.Result {
+		if draining.Load() {
+			return health.Failed(errors.New("server is shutting down"))
+		}
+		return health.Result{Status: health.StatusOK}
+	}}
+}
+
+type pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// databaseCheck pings the database, its duration is the latency.
+func databaseCheck(db pinger) health.Check {
+	return health.Check{Name: "database", Critical: true, Run: func(ctx context.Context) health.Result {
+		if err := db.Ping(ctx); err != nil {
+			return health.Failed(err)
+		}
+		return health.Result{Status: health.StatusOK}
+	}}
+}
+
+// migrationsCheck fails when the database has not been migrated to the last
+// migration shipped with the service. A database migrated further is fine, as
+// happens while a new version is rolled out.
+func migrationsCheck(db postgresql.Queryer) health.Check {
+	expected, expectedErr := postgresql.LatestMigration()
+	return health.Check{Name: "migrations", Critical: true, Run: func(ctx context.Context) health.Result {
+		if expectedErr != nil {
+			return health.Failed(expectedErr)
+		}
+		version, err := postgresql.MigrationVersion(ctx, db)
+		if err != nil {
+			return health.Failed(err)
+		}
+		result := health.Result{Status: health.StatusOK, Details: map[string]any{"version": version, "expected": expected}}
+		if version < expected {
+			result.Status = health.StatusFail
+			result.Error = fmt.Sprintf("database is at version %d, expected %d", version, expected)
+		}
+		return result
+	}}
+}
+
+// outboxCheck fails when the oldest event waiting to be relayed is older than
+// maxAge, which means the relay is stuck or falling behind.
+func outboxCheck(q repo.Querier, maxAge time.Duration) health.Check {
+	return health.Check{Name: "outbox", Run: func(ctx context.Context) health.Result {
+		backlog, err := q.OutboxBacklog(ctx)
+		if err != nil {
+			return health.Failed(err)
+		}
+		result := health.Result{Status: health.StatusOK, Details: map[string]any{"pending": backlog.Pending}}
+		if backlog.OldestCreatedAt.Valid {
+			age := time.Since(backlog.OldestCreatedAt.Time)
+			result.Details["oldest_age_seconds"] = int64(age.Seconds())
+			if age > maxAge {
+				result.Status = health.StatusFail
+				result.Error = fmt.Sprintf("oldest pending event is %s old", age.Round(time.Second))
+			}
+		}
+		return result
+	}}
+}
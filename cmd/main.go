@@ -2,36 +2,330 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log/slog"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
 
-	"github.com/jackc/pgx/v5"
-	"github.com/mellomaths/ecommerce-ms/internal/env"
+	"github.com/jackc/pgx/v5/multitracer"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/mellomaths/ecommerce-ms/internal/adapters/postgresql"
+	repo "github.com/mellomaths/ecommerce-ms/internal/adapters/postgresql/sqlc"
+	"github.com/mellomaths/ecommerce-ms/internal/auth"
+	"github.com/mellomaths/ecommerce-ms/internal/config"
+	"github.com/mellomaths/ecommerce-ms/internal/health"
+	"github.com/mellomaths/ecommerce-ms/internal/logging"
+	"github.com/mellomaths/ecommerce-ms/internal/metrics"
+	"github.com/mellomaths/ecommerce-ms/internal/outbox"
+	"github.com/mellomaths/ecommerce-ms/internal/payments"
+	"github.com/mellomaths/ecommerce-ms/internal/products"
+	"github.com/mellomaths/ecommerce-ms/internal/ratelimit"
+	"github.com/mellomaths/ecommerce-ms/internal/tracing"
+	"github.com/mellomaths/ecommerce-ms/internal/webhooks"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 )
 
 func main() {
-	ctx := context.Background()
-	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	// The server shuts down gracefully on SIGINT and SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	// A second signal kills the process instead of waiting for the drain
+	context.AfterFunc(ctx, stop)
+	cfg, err := config.Load(os.Environ())
+	// The configuration is printed even when it is invalid, to find out why
+	if len(os.Args) > 1 && os.Args[1] == "config" {
+		os.Exit(runConfigCommand(cfg, err, os.Args[2:]))
+	}
+	if err == nil {
+		err = cfg.Validate()
+	}
+	if err != nil {
+		slog.Error("invalid configuration", "error", err)
+		os.Exit(1)
+	}
+	logger, err := logging.New(os.Stdout, cfg.Log.Format, cfg.Log.Level)
+	if err != nil {
+		slog.Error("failed to configure the logs", "error", err)
+		os.Exit(1)
+	}
 	slog.SetDefault(logger)
-	cfg := config{
-		addr: ":3333",
-		db: dbConfig{
-			dsn: env.GetString("GOOSE_DBSTRING", "host=192.168.1.100 user=postgres password=postgres dbname=ecomm sslmode=disable"),
-		},
+	globalLimit, err := ratelimit.ParsePolicy("global", cfg.RateLimit.Global)
+	if err != nil {
+		slog.Error("failed to configure the rate limits", "error", err)
+		os.Exit(1)
+	}
+	placeOrderLimit, err := ratelimit.ParsePolicy("place-order", cfg.RateLimit.PlaceOrder)
+	if err != nil {
+		slog.Error("failed to configure the rate limits", "error", err)
+		os.Exit(1)
+	}
+	authFailureLimit, err := ratelimit.ParsePolicy("auth-failures", cfg.RateLimit.AuthFailures)
+	if err != nil {
+		slog.Error("failed to configure the rate limits", "error", err)
+		os.Exit(1)
+	}
+	gateway, err := newPaymentGateway(cfg.Payments.Gateway)
+	if err != nil {
+		slog.Error("failed to configure the payment gateway", "error", err)
+		os.Exit(1)
+	}
+	allocator, err := newStockAllocator(cfg.Stock.AllocationStrategy)
+	if err != nil {
+		slog.Error("failed to configure the stock allocation", "error", err)
+		os.Exit(1)
+	}
+	verifier, err := newJWTVerifier(cfg.Auth)
+	if err != nil {
+		slog.Error("failed to configure the authentication", "error", err)
+		os.Exit(1)
+	}
+	exporter, err := newSpanExporter(ctx, cfg.Tracing)
+	if err != nil {
+		slog.Error("failed to configure the tracing", "error", err)
+		os.Exit(1)
 	}
-	conn, err := pgx.Connect(ctx, cfg.db.dsn)
+	shutdownTracing, err := tracing.Setup(ctx, exporter, cfg.Tracing.SampleRatio)
+	if err != nil {
+		slog.Error("failed to configure the tracing", "error", err)
+		os.Exit(1)
+	}
+	pool, err := postgresql.NewPool(ctx, postgresql.PoolConfig{
+		DSN:               cfg.DB.DSN,
+		MinConns:          cfg.DB.MinConns,
+		MaxConns:          cfg.DB.MaxConns,
+		MaxConnLifetime:   cfg.DB.MaxConnLifetime,
+		MaxConnIdleTime:   cfg.DB.MaxConnIdleTime,
+		HealthCheckPeriod: cfg.DB.HealthCheckPeriod,
+		Tracer:            multitracer.New(metrics.QueryTracer{}, tracing.QueryTracer{}),
+	})
 	if err != nil {
 		slog.Error("failed to connect to postgres database", "error", err)
 		os.Exit(1)
 	}
-	defer conn.Close(ctx)
+	defer pool.Close()
 	logger.Info("connected to database")
+	prometheus.MustRegister(metrics.NewPoolCollector(pool))
+	if len(os.Args) > 1 {
+		code := runCommand(ctx, pool, os.Args[1:])
+		pool.Close()
+		os.Exit(code)
+	}
+	publisher, err := newOutboxPublisher(cfg.Outbox.Publishers, pool)
+	if err != nil {
+		slog.Error("failed to configure the outbox publisher", "error", err)
+		os.Exit(1)
+	}
+	rateLimits, err := newRateLimitStore(cfg.RateLimit.Store, pool)
+	if err != nil {
+		slog.Error("failed to configure the rate limits", "error", err)
+		os.Exit(1)
+	}
 	app := application{
-		config: cfg,
-		db:     conn,
+		config:           cfg,
+		db:               pool,
+		gateway:          gateway,
+		publisher:        publisher,
+		allocator:        allocator,
+		verifier:         verifier,
+		rateLimits:       rateLimits,
+		globalLimit:      globalLimit,
+		placeOrderLimit:  placeOrderLimit,
+		authFailureLimit: authFailureLimit,
+		heartbeats:       health.NewHeartbeats(),
+	}
+	// The workers keep running while the server drains and are stopped
+	// before the pool is closed, in order: the payment releaser cancels
+	// orders, which records events for the outbox relay, which queues
+	// deliveries for the webhook dispatcher. The refund resumer pays the
+	// refunds left behind by a crash.
+	workers := workerGroup{heartbeats: app.heartbeats}
+	workers.start("payment-releaser", cfg.Payments.ReleaseInterval, app.releasePaymentReservations)
+	workers.start("refund-resumer", cfg.Returns.ResumeInterval, app.resumeRefunds)
+	workers.start("outbox-relay", cfg.Outbox.RelayInterval, app.relayOutboxEvents)
+	workers.start("webhook-dispatcher", cfg.Webhooks.DispatchInterval, app.dispatchWebhooks)
+	if cfg.RateLimit.Store == ratelimit.StorePostgres {
+		workers.start("rate-limit-sweeper", cfg.RateLimit.SweepInterval, app.sweepRateLimitBuckets)
 	}
-	if err := app.run(app.mount()); err != nil {
-		slog.Error("server has failed to start", "error", err)
+	err = app.run(ctx, app.mount())
+	workers.stop()
+	pool.Close()
+	flushTracing(shutdownTracing)
+	if err != nil {
+		slog.Error("server has failed", "error", err)
 		os.Exit(1)
 	}
+	slog.Info("server has stopped")
+}
+
+// runConfigCommand runs the config commands, which need neither the database
+// nor a valid configuration, and returns the exit code.
+func runConfigCommand(cfg config.Config, loadErr error, args []string) int {
+	if len(args) != 1 || args[0] != "print" {
+		slog.Error("usage: config print")
+		return 1
+	}
+	if err := cfg.Print(os.Stdout); err != nil {
+		slog.Error("failed to print the configuration", "error", err)
+		return 1
+	}
+	if err := errors.Join(loadErr, cfg.Validate()); err != nil {
+		slog.Error("invalid configuration", "error", err)
+		return 1
+	}
+	return 0
+}
+
+// runCommand runs a one-off command instead of the server and returns the
+// exit code.
+func runCommand(ctx context.Context, pool *pgxpool.Pool, args []string) int {
+	name := args[0]
+	switch name {
+	case "reconcile-inventory":
+		drifted, err := reconcileInventory(ctx, products.NewService(repo.New(pool), pool), os.Stdout)
+		if err != nil {
+			slog.Error("failed to reconcile the inventory", "error", err)
+			return 1
+		}
+		if drifted > 0 {
+			return 2
+		}
+		return 0
+	case "create-api-key":
+		if len(args) != 2 {
+			slog.Error("usage: create-api-key <name>")
+			return 1
+		}
+		key, err := auth.CreateAPIKey(ctx, repo.New(pool), args[1])
+		if err != nil {
+			slog.Error("failed to create the api key", "error", err)
+			return 1
+		}
+		fmt.Printf("id: %d\nkey: %s\n", key.ID, key.Key)
+		return 0
+	case "revoke-api-key":
+		if len(args) != 2 {
+			slog.Error("usage: revoke-api-key <id>")
+			return 1
+		}
+		id, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			slog.Error("invalid api key id", "id", args[1])
+			return 1
+		}
+		if err := auth.RevokeAPIKey(ctx, repo.New(pool), id); err != nil {
+			slog.Error("failed to revoke the api key", "error", err)
+			return 1
+		}
+		return 0
+	}
+	slog.Error("unknown command", "command", name)
+	return 1
+}
+
+func newPaymentGateway(name string) (payments.Gateway, error) {
+	switch name {
+	case "fake":
+		return payments.NewFakeGateway(), nil
+	}
+	return nil, fmt.Errorf("unknown payment gateway %q", name)
+}
+
+func newStockAllocator(name string) (products.Allocator, error) {
+	switch name {
+	case products.AllocationSingleLocationPreferred:
+		return products.SingleLocationPreferred{}, nil
+	case products.AllocationLowestCost:
+		return products.LowestCost{}, nil
+	case products.AllocationSplit:
+		return products.Split{}, nil
+	}
+	return nil, fmt.Errorf("unknown stock allocation strategy %q", name)
+}
+
+// newJWTVerifier accepts the HS256 tokens signed with the secret and the RS256
+// tokens signed with the keys of the JWKS file, when they are configured.
+func newJWTVerifier(cfg config.Auth) (*auth.JWTVerifier, error) {
+	jwtConfig := auth.JWTConfig{
+		Issuer:     cfg.JWTIssuer,
+		Audience:   cfg.JWTAudience,
+		HMACSecret: []byte(cfg.JWTSecret),
+		Leeway:     cfg.JWTLeeway,
+	}
+	if cfg.JWKSFile != "" {
+		keys, err := auth.LoadJWKS(cfg.JWKSFile)
+		if err != nil {
+			return nil, err
+		}
+		jwtConfig.Keys = keys
+	}
+	return auth.NewJWTVerifier(jwtConfig), nil
+}
+
+func newRateLimitStore(name string, pool *pgxpool.Pool) (ratelimit.Store, error) {
+	switch name {
+	case ratelimit.StoreMemory:
+		return ratelimit.NewMemoryStore(), nil
+	case ratelimit.StorePostgres:
+		return ratelimit.NewPostgresStore(repo.New(pool)), nil
+	}
+	return nil, fmt.Errorf("unknown rate limit store %q", name)
+}
+
+// newSpanExporter builds the exporter of the traces, which is nil when the
+// traces are not exported. The OTLP exporter is configured with the standard
+// OTEL_EXPORTER_OTLP_* variables, the stdout exporter writes to the file when
+// one is given.
+func newSpanExporter(ctx context.Context, cfg config.Tracing) (sdktrace.SpanExporter, error) {
+	switch cfg.Exporter {
+	case tracing.ExporterNone:
+		return nil, nil
+	case tracing.ExporterOTLP:
+		return otlptracehttp.New(ctx)
+	case tracing.ExporterStdout:
+		if cfg.File == "" {
+			return stdouttrace.New()
+		}
+		f, err := os.OpenFile(cfg.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, err
+		}
+		return stdouttrace.New(stdouttrace.WithWriter(f))
+	default:
+		return nil, fmt.Errorf("unknown tracing exporter %q", cfg.Exporter)
+	}
+}
+
+// flushTracing exports the spans left before exiting.
+func flushTracing(shutdown func(ctx context.Context) error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := shutdown(ctx); err != nil {
+		slog.Error("failed to flush the traces", "error", err)
+	}
+}
+
+// newOutboxPublisher builds the publishers named.
+func newOutboxPublisher(names []string, pool *pgxpool.Pool) (outbox.Publisher, error) {
+	var publishers outbox.MultiPublisher
+	for _, name := range names {
+		switch name {
+		case "log":
+			publishers = append(publishers, outbox.NewLogPublisher())
+		case "webhooks":
+			publishers = append(publishers, webhooks.NewPublisher(repo.New(pool)))
+		default:
+			return nil, fmt.Errorf("unknown outbox publisher %q", name)
+		}
+	}
+	if len(publishers) == 1 {
+		return publishers[0], nil
+	}
+	return publishers, nil
 }
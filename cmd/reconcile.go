@@ -0,0 +1,32 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/mellomaths/ecommerce-ms/internal/products"
+)
+
+// reconcileInventory writes a report of the stock that drifted from the
+// inventory ledger and returns how many product locations drifted.
+func reconcileInventory(ctx context.Context, service products.Service, w io.Writer) (int, error) {
+	drifts, err := service.ReconcileInventory(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if len(drifts) == 0 {
+		fmt.Fprintln(w, "no inventory drift")
+		return 0, nil
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PRODUCT\tLOCATION\tQUANTITY\tLEDGER\tDRIFT")
+	for _, d := range drifts {
+		fmt.Fprintf(tw, "%d\t%d\t%d\t%d\t%+d\n", d.ProductID, d.LocationID, d.Quantity, d.LedgerQuantity, d.Drift)
+	}
+	if err := tw.Flush(); err != nil {
+		return 0, err
+	}
+	return len(drifts), nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/mellomaths/ecommerce-ms/internal/health"
+)
+
+// workerGroup runs the background workers until they are stopped, which is
+// done in the order they were started. Workers producing work for others are
+// started first, so they stop before the workers consuming it.
+type workerGroup struct {
+	heartbeats *health.Heartbeats
+	workers    []*worker
+}
+
+type worker struct {
+	name   string
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// start runs a worker looking for work every interval, which it reports to
+// the heartbeats.
+func (g *workerGroup) start(name string, interval time.Duration, run func(ctx context.Context)) {
+	ctx := g.heartbeats.Register(context.Background(), name, interval)
+	ctx, cancel := context.WithCancel(ctx)
+	w := &worker{name: name, cancel: cancel, done: make(chan struct{})}
+	g.workers = append(g.workers, w)
+	go func() {
+		defer close(w.done)
+		run(ctx)
+	}()
+}
+
+// stop stops the workers one after the other, waiting for each to return.
+func (g *workerGroup) stop() {
+	for _, w := range g.workers {
+		w.cancel()
+		<-w.done
+		slog.Info("worker has stopped", "worker", w.name)
+	}
+}
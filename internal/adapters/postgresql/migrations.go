@@ -0,0 +1,47 @@
+package postgresql
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// LatestMigration is the version of the last migration shipped with the
+// service, the one the database is expected to be at.
+func LatestMigration() (int64, error) {
+	files, err := fs.Glob(migrations, "migrations/*.sql")
+	if err != nil {
+		return 0, err
+	}
+	var latest int64
+	for _, file := range files {
+		name := strings.TrimPrefix(file, "migrations/")
+		prefix, _, ok := strings.Cut(name, "_")
+		version, err := strconv.ParseInt(prefix, 10, 64)
+		if !ok || err != nil {
+			return 0, fmt.Errorf("migration %s is not prefixed by its version", name)
+		}
+		latest = max(latest, version)
+	}
+	return latest, nil
+}
+
+// Queryer runs a single row query, like *pgxpool.Pool does.
+type Queryer interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// MigrationVersion is the version goose has migrated the database to.
+func MigrationVersion(ctx context.Context, db Queryer) (int64, error) {
+	var version int64
+	err := db.QueryRow(ctx, "SELECT version_id FROM goose_db_version WHERE is_applied ORDER BY id DESC LIMIT 1").Scan(&version)
+	return version, err
+}
@@ -0,0 +1,51 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS customers (
+  id BIGSERIAL PRIMARY KEY,
+  name TEXT NOT NULL,
+  email TEXT NOT NULL UNIQUE,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS customer_addresses (
+  id BIGSERIAL PRIMARY KEY,
+  customer_id BIGINT NOT NULL,
+  kind TEXT NOT NULL CHECK(kind IN ('shipping', 'billing')),
+  line1 TEXT NOT NULL,
+  line2 TEXT NOT NULL DEFAULT '',
+  city TEXT NOT NULL,
+  state TEXT NOT NULL DEFAULT '',
+  postal_code TEXT NOT NULL,
+  country TEXT NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  CONSTRAINT fk_customer FOREIGN KEY (customer_id) REFERENCES customers(id)
+);
+
+-- Orders placed before customers existed may reference unknown customers, so
+-- the constraint is only enforced for new rows.
+ALTER TABLE orders
+  ADD CONSTRAINT fk_customer FOREIGN KEY (customer_id) REFERENCES customers(id) NOT VALID;
+
+-- Snapshot of the address an order is shipped to, so later changes to the
+-- customer addresses do not rewrite history.
+CREATE TABLE IF NOT EXISTS order_addresses (
+  order_id BIGINT NOT NULL,
+  kind TEXT NOT NULL CHECK(kind IN ('shipping', 'billing')),
+  line1 TEXT NOT NULL,
+  line2 TEXT NOT NULL,
+  city TEXT NOT NULL,
+  state TEXT NOT NULL,
+  postal_code TEXT NOT NULL,
+  country TEXT NOT NULL,
+  PRIMARY KEY (order_id, kind),
+  CONSTRAINT fk_order FOREIGN KEY (order_id) REFERENCES orders(id)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS order_addresses;
+ALTER TABLE orders DROP CONSTRAINT IF EXISTS fk_customer;
+DROP TABLE IF EXISTS customer_addresses;
+DROP TABLE IF EXISTS customers;
+-- +goose StatementEnd
@@ -0,0 +1,24 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS payments (
+  id BIGSERIAL PRIMARY KEY,
+  order_id BIGINT NOT NULL,
+  amount_in_cents BIGINT NOT NULL CHECK(amount_in_cents >= 0),
+  status TEXT NOT NULL DEFAULT 'pending'
+    CHECK(status IN ('pending', 'authorized', 'captured', 'failed', 'voided', 'refunded')),
+  gateway_reference TEXT NOT NULL DEFAULT '',
+  failure_reason TEXT NOT NULL DEFAULT '',
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  CONSTRAINT fk_order FOREIGN KEY (order_id) REFERENCES orders(id)
+);
+
+-- An order can have many failed attempts but a single live payment.
+CREATE UNIQUE INDEX IF NOT EXISTS payments_order_live_idx ON payments (order_id)
+  WHERE status IN ('pending', 'authorized', 'captured');
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS payments;
+-- +goose StatementEnd
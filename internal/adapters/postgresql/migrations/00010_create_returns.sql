@@ -0,0 +1,37 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE payments
+  ADD COLUMN refunded_in_cents BIGINT NOT NULL DEFAULT 0 CHECK(refunded_in_cents >= 0);
+
+CREATE TABLE IF NOT EXISTS returns (
+  id BIGSERIAL PRIMARY KEY,
+  order_id BIGINT NOT NULL,
+  status TEXT NOT NULL DEFAULT 'requested'
+    CHECK(status IN ('requested', 'approved', 'rejected', 'received', 'refunded')),
+  reason TEXT NOT NULL DEFAULT '',
+  refund_amount_in_cents BIGINT NOT NULL CHECK(refund_amount_in_cents >= 0),
+  decided_by TEXT NOT NULL DEFAULT '',
+  decision_reason TEXT NOT NULL DEFAULT '',
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  CONSTRAINT fk_order FOREIGN KEY (order_id) REFERENCES orders(id)
+);
+
+CREATE TABLE IF NOT EXISTS return_items (
+  id BIGSERIAL PRIMARY KEY,
+  return_id BIGINT NOT NULL,
+  order_item_id BIGINT NOT NULL,
+  product_id BIGINT NOT NULL,
+  quantity INTEGER NOT NULL CHECK(quantity > 0),
+  price_cents INTEGER NOT NULL,
+  CONSTRAINT fk_return FOREIGN KEY (return_id) REFERENCES returns(id),
+  CONSTRAINT fk_order_item FOREIGN KEY (order_item_id) REFERENCES order_items(id)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS return_items;
+DROP TABLE IF EXISTS returns;
+ALTER TABLE payments DROP COLUMN IF EXISTS refunded_in_cents;
+-- +goose StatementEnd
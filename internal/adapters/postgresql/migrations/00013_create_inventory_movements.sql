@@ -0,0 +1,37 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS inventory_movements (
+  id BIGSERIAL PRIMARY KEY,
+  product_id BIGINT NOT NULL,
+  delta INTEGER NOT NULL CHECK(delta <> 0),
+  quantity INTEGER NOT NULL,
+  reason TEXT NOT NULL
+    CHECK(reason IN ('sale', 'cancellation', 'restock', 'adjustment', 'return')),
+  order_id BIGINT,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  CONSTRAINT fk_product FOREIGN KEY (product_id) REFERENCES products(id),
+  CONSTRAINT fk_order FOREIGN KEY (order_id) REFERENCES orders(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_inventory_movements_product ON inventory_movements (product_id, id);
+
+CREATE OR REPLACE FUNCTION inventory_movements_append_only() RETURNS trigger AS $$
+BEGIN
+  RAISE EXCEPTION 'inventory_movements is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER inventory_movements_append_only
+  BEFORE UPDATE OR DELETE ON inventory_movements
+  FOR EACH STATEMENT EXECUTE FUNCTION inventory_movements_append_only();
+
+-- The stock held before the ledger existed is its opening balance
+INSERT INTO inventory_movements (product_id, delta, quantity, reason)
+SELECT id, quantity, quantity, 'adjustment' FROM products WHERE quantity <> 0;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS inventory_movements;
+DROP FUNCTION IF EXISTS inventory_movements_append_only();
+-- +goose StatementEnd
@@ -0,0 +1,83 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS stock_locations (
+  id BIGSERIAL PRIMARY KEY,
+  name TEXT NOT NULL UNIQUE,
+  priority INTEGER NOT NULL DEFAULT 0,
+  shipping_cost_in_cents INTEGER NOT NULL DEFAULT 0 CHECK(shipping_cost_in_cents >= 0),
+  is_default BOOLEAN NOT NULL DEFAULT false,
+  active BOOLEAN NOT NULL DEFAULT true,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_stock_locations_default ON stock_locations (is_default) WHERE is_default;
+
+-- The stock held so far is at the default location, which takes the first id
+-- so the existing rows below can point to it
+INSERT INTO stock_locations (id, name, is_default) VALUES (1, 'default', true);
+SELECT setval(pg_get_serial_sequence('stock_locations', 'id'), 1);
+
+CREATE TABLE IF NOT EXISTS product_stock (
+  product_id BIGINT NOT NULL,
+  location_id BIGINT NOT NULL,
+  quantity INTEGER NOT NULL DEFAULT 0 CHECK(quantity >= 0),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  PRIMARY KEY (product_id, location_id),
+  CONSTRAINT fk_product FOREIGN KEY (product_id) REFERENCES products(id),
+  CONSTRAINT fk_location FOREIGN KEY (location_id) REFERENCES stock_locations(id)
+);
+
+INSERT INTO product_stock (product_id, location_id, quantity)
+SELECT id, 1, quantity FROM products WHERE quantity > 0;
+
+-- products.quantity is the total of the product over every location
+CREATE OR REPLACE FUNCTION product_stock_total() RETURNS trigger AS $$
+DECLARE
+  pid BIGINT;
+BEGIN
+  IF TG_OP = 'DELETE' THEN
+    pid := OLD.product_id;
+  ELSE
+    pid := NEW.product_id;
+  END IF;
+  UPDATE products
+  SET quantity = (SELECT COALESCE(SUM(quantity), 0) FROM product_stock WHERE product_id = pid)
+  WHERE id = pid;
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER product_stock_total
+  AFTER INSERT OR UPDATE OR DELETE ON product_stock
+  FOR EACH ROW EXECUTE FUNCTION product_stock_total();
+
+-- Adding the column with a default fills the existing movements without an
+-- UPDATE, which the ledger does not allow
+ALTER TABLE inventory_movements
+  ADD COLUMN location_id BIGINT NOT NULL DEFAULT 1 REFERENCES stock_locations(id);
+ALTER TABLE inventory_movements ALTER COLUMN location_id DROP DEFAULT;
+
+CREATE TABLE IF NOT EXISTS order_item_allocations (
+  id BIGSERIAL PRIMARY KEY,
+  order_item_id BIGINT NOT NULL,
+  location_id BIGINT NOT NULL,
+  quantity INTEGER NOT NULL CHECK(quantity > 0),
+  CONSTRAINT fk_order_item FOREIGN KEY (order_item_id) REFERENCES order_items(id),
+  CONSTRAINT fk_location FOREIGN KEY (location_id) REFERENCES stock_locations(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_order_item_allocations_item ON order_item_allocations (order_item_id);
+
+INSERT INTO order_item_allocations (order_item_id, location_id, quantity)
+SELECT id, 1, quantity FROM order_items WHERE quantity > 0;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS order_item_allocations;
+ALTER TABLE inventory_movements DROP COLUMN IF EXISTS location_id;
+DROP TABLE IF EXISTS product_stock;
+DROP FUNCTION IF EXISTS product_stock_total();
+DROP TABLE IF EXISTS stock_locations;
+-- +goose StatementEnd
@@ -0,0 +1,21 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE returns
+  DROP CONSTRAINT IF EXISTS returns_status_check;
+ALTER TABLE returns
+  ADD CONSTRAINT returns_status_check
+  CHECK(status IN ('requested', 'approved', 'rejected', 'received', 'refunding', 'refunded'));
+
+CREATE INDEX IF NOT EXISTS idx_returns_refunding ON returns (updated_at) WHERE status = 'refunding';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_returns_refunding;
+UPDATE returns SET status = 'received' WHERE status = 'refunding';
+ALTER TABLE returns
+  DROP CONSTRAINT IF EXISTS returns_status_check;
+ALTER TABLE returns
+  ADD CONSTRAINT returns_status_check
+  CHECK(status IN ('requested', 'approved', 'rejected', 'received', 'refunded'));
+-- +goose StatementEnd
@@ -0,0 +1,17 @@
+package postgresql
+
+import "strings"
+
+// QueryName is the name of the query given by sqlc in the comment heading the
+// generated queries or, for the others, their first keyword, as in begin or
+// commit.
+func QueryName(sql string) string {
+	sql = strings.TrimSpace(sql)
+	if rest, ok := strings.CutPrefix(sql, "-- name: "); ok {
+		if name, _, ok := strings.Cut(rest, " "); ok {
+			return name
+		}
+	}
+	keyword, _, _ := strings.Cut(sql, " ")
+	return strings.ToLower(strings.TrimRight(keyword, ";"))
+}
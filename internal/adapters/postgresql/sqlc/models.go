@@ -5,13 +5,96 @@
 package repo
 
 import (
+	"encoding/json"
+
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
+type ApiKey struct {
+	ID        int64              `json:"id"`
+	Name      string             `json:"name"`
+	KeyPrefix string             `json:"key_prefix"`
+	KeyHash   string             `json:"key_hash"`
+	CreatedAt pgtype.Timestamptz `json:"created_at"`
+	RevokedAt pgtype.Timestamptz `json:"revoked_at"`
+}
+
+type Cart struct {
+	ID         int64              `json:"id"`
+	CustomerID int64              `json:"customer_id"`
+	Status     string             `json:"status"`
+	OrderID    pgtype.Int8        `json:"order_id"`
+	ExpiresAt  pgtype.Timestamptz `json:"expires_at"`
+	CreatedAt  pgtype.Timestamptz `json:"created_at"`
+	UpdatedAt  pgtype.Timestamptz `json:"updated_at"`
+}
+
+type CartItem struct {
+	ID        int64              `json:"id"`
+	CartID    int64              `json:"cart_id"`
+	ProductID int64              `json:"product_id"`
+	Quantity  int32              `json:"quantity"`
+	CreatedAt pgtype.Timestamptz `json:"created_at"`
+}
+
+type Customer struct {
+	ID        int64              `json:"id"`
+	Name      string             `json:"name"`
+	Email     string             `json:"email"`
+	CreatedAt pgtype.Timestamptz `json:"created_at"`
+}
+
+type CustomerAddress struct {
+	ID         int64              `json:"id"`
+	CustomerID int64              `json:"customer_id"`
+	Kind       string             `json:"kind"`
+	Line1      string             `json:"line1"`
+	Line2      string             `json:"line2"`
+	City       string             `json:"city"`
+	State      string             `json:"state"`
+	PostalCode string             `json:"postal_code"`
+	Country    string             `json:"country"`
+	CreatedAt  pgtype.Timestamptz `json:"created_at"`
+}
+
+type IdempotencyKey struct {
+	Key          string             `json:"key"`
+	Scope        string             `json:"scope"`
+	Fingerprint  string             `json:"fingerprint"`
+	StatusCode   pgtype.Int4        `json:"status_code"`
+	ContentType  pgtype.Text        `json:"content_type"`
+	ResponseBody []byte             `json:"response_body"`
+	CreatedAt    pgtype.Timestamptz `json:"created_at"`
+	CompletedAt  pgtype.Timestamptz `json:"completed_at"`
+}
+
+type InventoryMovement struct {
+	ID         int64              `json:"id"`
+	ProductID  int64              `json:"product_id"`
+	Delta      int32              `json:"delta"`
+	Quantity   int32              `json:"quantity"`
+	Reason     string             `json:"reason"`
+	OrderID    pgtype.Int8        `json:"order_id"`
+	CreatedAt  pgtype.Timestamptz `json:"created_at"`
+	LocationID int64              `json:"location_id"`
+}
+
 type Order struct {
 	ID         int64              `json:"id"`
 	CustomerID int64              `json:"customer_id"`
 	CreatedAt  pgtype.Timestamptz `json:"created_at"`
+	Status     string             `json:"status"`
+}
+
+type OrderAddress struct {
+	OrderID    int64  `json:"order_id"`
+	Kind       string `json:"kind"`
+	Line1      string `json:"line1"`
+	Line2      string `json:"line2"`
+	City       string `json:"city"`
+	State      string `json:"state"`
+	PostalCode string `json:"postal_code"`
+	Country    string `json:"country"`
 }
 
 type OrderItem struct {
@@ -22,10 +105,134 @@ type OrderItem struct {
 	PriceCents int32 `json:"price_cents"`
 }
 
+type OrderItemAllocation struct {
+	ID          int64 `json:"id"`
+	OrderItemID int64 `json:"order_item_id"`
+	LocationID  int64 `json:"location_id"`
+	Quantity    int32 `json:"quantity"`
+}
+
+type OrderStatusChange struct {
+	ID         int64              `json:"id"`
+	OrderID    int64              `json:"order_id"`
+	FromStatus string             `json:"from_status"`
+	ToStatus   string             `json:"to_status"`
+	ChangedBy  string             `json:"changed_by"`
+	Reason     string             `json:"reason"`
+	CreatedAt  pgtype.Timestamptz `json:"created_at"`
+}
+
+type Outbox struct {
+	ID            int64              `json:"id"`
+	AggregateType string             `json:"aggregate_type"`
+	AggregateID   int64              `json:"aggregate_id"`
+	EventType     string             `json:"event_type"`
+	Payload       json.RawMessage    `json:"payload"`
+	Attempts      int32              `json:"attempts"`
+	LastError     string             `json:"last_error"`
+	NextAttemptAt pgtype.Timestamptz `json:"next_attempt_at"`
+	CreatedAt     pgtype.Timestamptz `json:"created_at"`
+	PublishedAt   pgtype.Timestamptz `json:"published_at"`
+}
+
+type Payment struct {
+	ID               int64              `json:"id"`
+	OrderID          int64              `json:"order_id"`
+	AmountInCents    int64              `json:"amount_in_cents"`
+	Status           string             `json:"status"`
+	GatewayReference string             `json:"gateway_reference"`
+	FailureReason    string             `json:"failure_reason"`
+	CreatedAt        pgtype.Timestamptz `json:"created_at"`
+	UpdatedAt        pgtype.Timestamptz `json:"updated_at"`
+	RefundedInCents  int64              `json:"refunded_in_cents"`
+}
+
 type Product struct {
 	ID           int64              `json:"id"`
 	Name         string             `json:"name"`
 	PriceInCents int32              `json:"price_in_cents"`
 	Quantity     int32              `json:"quantity"`
 	CreatedAt    pgtype.Timestamptz `json:"created_at"`
+	DeletedAt    pgtype.Timestamptz `json:"deleted_at"`
+}
+
+type ProductStock struct {
+	ProductID  int64              `json:"product_id"`
+	LocationID int64              `json:"location_id"`
+	Quantity   int32              `json:"quantity"`
+	UpdatedAt  pgtype.Timestamptz `json:"updated_at"`
+}
+
+type RateLimitBucket struct {
+	Key       string             `json:"key"`
+	Tokens    float64            `json:"tokens"`
+	Allowed   bool               `json:"allowed"`
+	UpdatedAt pgtype.Timestamptz `json:"updated_at"`
+}
+
+type Return struct {
+	ID                  int64              `json:"id"`
+	OrderID             int64              `json:"order_id"`
+	Status              string             `json:"status"`
+	Reason              string             `json:"reason"`
+	RefundAmountInCents int64              `json:"refund_amount_in_cents"`
+	DecidedBy           string             `json:"decided_by"`
+	DecisionReason      string             `json:"decision_reason"`
+	CreatedAt           pgtype.Timestamptz `json:"created_at"`
+	UpdatedAt           pgtype.Timestamptz `json:"updated_at"`
+}
+
+type ReturnItem struct {
+	ID          int64 `json:"id"`
+	ReturnID    int64 `json:"return_id"`
+	OrderItemID int64 `json:"order_item_id"`
+	ProductID   int64 `json:"product_id"`
+	Quantity    int32 `json:"quantity"`
+	PriceCents  int32 `json:"price_cents"`
+}
+
+type StockLocation struct {
+	ID                  int64              `json:"id"`
+	Name                string             `json:"name"`
+	Priority            int32              `json:"priority"`
+	ShippingCostInCents int32              `json:"shipping_cost_in_cents"`
+	IsDefault           bool               `json:"is_default"`
+	Active              bool               `json:"active"`
+	CreatedAt           pgtype.Timestamptz `json:"created_at"`
+	UpdatedAt           pgtype.Timestamptz `json:"updated_at"`
+}
+
+type WebhookDelivery struct {
+	ID             int64              `json:"id"`
+	SubscriptionID int64              `json:"subscription_id"`
+	EventID        int64              `json:"event_id"`
+	EventType      string             `json:"event_type"`
+	Payload        json.RawMessage    `json:"payload"`
+	Status         string             `json:"status"`
+	Attempts       int32              `json:"attempts"`
+	NextAttemptAt  pgtype.Timestamptz `json:"next_attempt_at"`
+	LastStatusCode pgtype.Int4        `json:"last_status_code"`
+	LastError      string             `json:"last_error"`
+	CreatedAt      pgtype.Timestamptz `json:"created_at"`
+	UpdatedAt      pgtype.Timestamptz `json:"updated_at"`
+	DeliveredAt    pgtype.Timestamptz `json:"delivered_at"`
+}
+
+type WebhookDeliveryAttempt struct {
+	ID         int64              `json:"id"`
+	DeliveryID int64              `json:"delivery_id"`
+	StatusCode pgtype.Int4        `json:"status_code"`
+	Error      string             `json:"error"`
+	DurationMs int32              `json:"duration_ms"`
+	CreatedAt  pgtype.Timestamptz `json:"created_at"`
+}
+
+type WebhookSubscription struct {
+	ID         int64              `json:"id"`
+	Url        string             `json:"url"`
+	Secret     string             `json:"secret"`
+	EventTypes []string           `json:"event_types"`
+	Active     bool               `json:"active"`
+	CreatedAt  pgtype.Timestamptz `json:"created_at"`
+	UpdatedAt  pgtype.Timestamptz `json:"updated_at"`
 }
@@ -6,16 +6,118 @@ package repo
 
 import (
 	"context"
+
+	"github.com/jackc/pgx/v5/pgtype"
 )
 
 type Querier interface {
+	AddCartItem(ctx context.Context, arg AddCartItemParams) (CartItem, error)
+	AddLocationStock(ctx context.Context, arg AddLocationStockParams) (ProductStock, error)
+	CheckoutCart(ctx context.Context, id int64) (Cart, error)
+	// Claimed deliveries are hidden from the other dispatchers for the lease, so
+	// the HTTP calls are made outside of a transaction.
+	ClaimWebhookDeliveries(ctx context.Context, arg ClaimWebhookDeliveriesParams) ([]WebhookDelivery, error)
+	CompleteIdempotencyKey(ctx context.Context, arg CompleteIdempotencyKeyParams) error
+	CreateApiKey(ctx context.Context, arg CreateApiKeyParams) (ApiKey, error)
+	CreateCart(ctx context.Context, arg CreateCartParams) (Cart, error)
+	CreateCustomer(ctx context.Context, arg CreateCustomerParams) (Customer, error)
+	CreateCustomerAddress(ctx context.Context, arg CreateCustomerAddressParams) (CustomerAddress, error)
+	// A key left in progress since before stale_before by a request that never
+	// completed it is taken over by a retry of the same request.
+	CreateIdempotencyKey(ctx context.Context, arg CreateIdempotencyKeyParams) (IdempotencyKey, error)
+	CreateInventoryMovement(ctx context.Context, arg CreateInventoryMovementParams) (InventoryMovement, error)
 	CreateOrder(ctx context.Context, customerID int64) (Order, error)
+	CreateOrderAddress(ctx context.Context, arg CreateOrderAddressParams) (OrderAddress, error)
 	CreateOrderItem(ctx context.Context, arg CreateOrderItemParams) (OrderItem, error)
+	CreateOrderItemAllocation(ctx context.Context, arg CreateOrderItemAllocationParams) (OrderItemAllocation, error)
+	CreateOrderStatusChange(ctx context.Context, arg CreateOrderStatusChangeParams) (OrderStatusChange, error)
+	CreateOutboxEvent(ctx context.Context, arg CreateOutboxEventParams) (Outbox, error)
+	CreatePayment(ctx context.Context, arg CreatePaymentParams) (Payment, error)
 	CreateProduct(ctx context.Context, arg CreateProductParams) (Product, error)
+	CreateReturn(ctx context.Context, arg CreateReturnParams) (Return, error)
+	CreateReturnItem(ctx context.Context, arg CreateReturnItemParams) (ReturnItem, error)
+	CreateStockLocation(ctx context.Context, arg CreateStockLocationParams) (StockLocation, error)
+	CreateWebhookDelivery(ctx context.Context, arg CreateWebhookDeliveryParams) error
+	CreateWebhookDeliveryAttempt(ctx context.Context, arg CreateWebhookDeliveryAttemptParams) (WebhookDeliveryAttempt, error)
+	CreateWebhookSubscription(ctx context.Context, arg CreateWebhookSubscriptionParams) (WebhookSubscription, error)
+	DecideReturn(ctx context.Context, arg DecideReturnParams) (Return, error)
+	DeleteCartItem(ctx context.Context, arg DeleteCartItemParams) (int64, error)
+	DeleteCustomerAddress(ctx context.Context, arg DeleteCustomerAddressParams) (int64, error)
+	DeleteIdempotencyKey(ctx context.Context, arg DeleteIdempotencyKeyParams) error
+	DeleteIdleRateLimitBuckets(ctx context.Context, idleSince pgtype.Timestamptz) (int64, error)
+	DeleteWebhookSubscription(ctx context.Context, id int64) (int64, error)
+	// Fails the payments left pending by a request that never got an answer from
+	// the gateway, which would block the next payment of their order.
+	ExpirePendingPayments(ctx context.Context, pendingBefore pgtype.Timestamptz) (int64, error)
+	FindActiveApiKeyByHash(ctx context.Context, keyHash string) (ApiKey, error)
+	FindCapturedPayment(ctx context.Context, orderID int64) (Payment, error)
+	FindCartById(ctx context.Context, id int64) (Cart, error)
+	FindCustomerAddress(ctx context.Context, arg FindCustomerAddressParams) (CustomerAddress, error)
+	FindCustomerById(ctx context.Context, id int64) (Customer, error)
+	FindDefaultStockLocation(ctx context.Context) (StockLocation, error)
+	FindIdempotencyKey(ctx context.Context, arg FindIdempotencyKeyParams) (IdempotencyKey, error)
 	FindOrderById(ctx context.Context, id int64) ([]FindOrderByIdRow, error)
+	FindOrderByIdForUpdate(ctx context.Context, id int64) (Order, error)
 	FindProductById(ctx context.Context, id int64) (Product, error)
-	ListProducts(ctx context.Context) ([]Product, error)
+	FindProductByIdForUpdate(ctx context.Context, id int64) (Product, error)
+	FindReturnById(ctx context.Context, id int64) (Return, error)
+	FindStockLocationById(ctx context.Context, id int64) (StockLocation, error)
+	FindWebhookDeliveryById(ctx context.Context, arg FindWebhookDeliveryByIdParams) (WebhookDelivery, error)
+	FindWebhookSubscriptionById(ctx context.Context, id int64) (WebhookSubscription, error)
+	ListCartItems(ctx context.Context, cartID int64) ([]ListCartItemsRow, error)
+	ListCustomerAddresses(ctx context.Context, customerID int64) ([]CustomerAddress, error)
+	// Lists the payments left authorized by a request that crashed before
+	// capturing them, which hold the money of the customer and block the next
+	// payment of their order until they are voided.
+	ListExpiredAuthorizedPayments(ctx context.Context, arg ListExpiredAuthorizedPaymentsParams) ([]Payment, error)
+	ListInventoryMovements(ctx context.Context, arg ListInventoryMovementsParams) ([]InventoryMovement, error)
+	ListOrderAddresses(ctx context.Context, orderID int64) ([]OrderAddress, error)
+	ListOrderItemAllocations(ctx context.Context, orderID int64) ([]ListOrderItemAllocationsRow, error)
+	ListOrderItems(ctx context.Context, orderID int64) ([]OrderItem, error)
+	ListOrderPayments(ctx context.Context, orderID int64) ([]Payment, error)
+	ListOrderReturns(ctx context.Context, orderID int64) ([]Return, error)
+	ListOrderStatusChanges(ctx context.Context, orderID int64) ([]OrderStatusChange, error)
+	ListOrders(ctx context.Context, arg ListOrdersParams) ([]ListOrdersRow, error)
+	ListOrdersWithFailedPayment(ctx context.Context, arg ListOrdersWithFailedPaymentParams) ([]int64, error)
+	// Only the oldest unpublished event of every aggregate is due, so the events
+	// of an aggregate are published in order and a failing one holds the rest.
+	ListPendingOutboxEvents(ctx context.Context, rowLimit int32) ([]Outbox, error)
+	ListProductStock(ctx context.Context, productID int64) ([]ListProductStockRow, error)
+	ListProducts(ctx context.Context, arg ListProductsParams) ([]Product, error)
+	ListRefundingReturns(ctx context.Context, arg ListRefundingReturnsParams) ([]Return, error)
+	ListReturnItems(ctx context.Context, returnID int64) ([]ReturnItem, error)
+	ListReturnedQuantities(ctx context.Context, orderID int64) ([]ListReturnedQuantitiesRow, error)
+	ListStockLocations(ctx context.Context) ([]StockLocation, error)
+	ListWebhookDeliveries(ctx context.Context, arg ListWebhookDeliveriesParams) ([]WebhookDelivery, error)
+	ListWebhookDeliveryAttempts(ctx context.Context, deliveryID int64) ([]WebhookDeliveryAttempt, error)
+	ListWebhookSubscriptions(ctx context.Context) ([]WebhookSubscription, error)
+	ListWebhookSubscriptionsForEvent(ctx context.Context, eventType string) ([]WebhookSubscription, error)
+	MarkOutboxEventFailed(ctx context.Context, arg MarkOutboxEventFailedParams) error
+	MarkOutboxEventPublished(ctx context.Context, id int64) error
+	OutboxBacklog(ctx context.Context) (OutboxBacklogRow, error)
+	// Returns the tokens the bucket would hold after being refilled, without
+	// taking any.
+	PeekRateLimitBucket(ctx context.Context, arg PeekRateLimitBucketParams) (float64, error)
+	ReconcileInventory(ctx context.Context) ([]ReconcileInventoryRow, error)
+	RecordWebhookDeliveryAttempt(ctx context.Context, arg RecordWebhookDeliveryAttemptParams) (WebhookDelivery, error)
+	RedriveWebhookDelivery(ctx context.Context, arg RedriveWebhookDeliveryParams) (WebhookDelivery, error)
+	RefundPayment(ctx context.Context, arg RefundPaymentParams) (Payment, error)
+	RemoveLocationStock(ctx context.Context, arg RemoveLocationStockParams) (ProductStock, error)
+	RevokeApiKey(ctx context.Context, id int64) (int64, error)
+	SetCartOrder(ctx context.Context, arg SetCartOrderParams) (Cart, error)
+	SoftDeleteProduct(ctx context.Context, id int64) (Product, error)
+	// Refills the bucket for the time elapsed since its last request and takes a
+	// token from it when there is one left.
+	TakeRateLimitToken(ctx context.Context, arg TakeRateLimitTokenParams) (TakeRateLimitTokenRow, error)
+	TouchCart(ctx context.Context, arg TouchCartParams) (Cart, error)
+	UpdateCartItem(ctx context.Context, arg UpdateCartItemParams) (CartItem, error)
+	UpdateCustomer(ctx context.Context, arg UpdateCustomerParams) (Customer, error)
+	UpdateOrderStatus(ctx context.Context, arg UpdateOrderStatusParams) (Order, error)
+	UpdatePayment(ctx context.Context, arg UpdatePaymentParams) (Payment, error)
 	UpdateProduct(ctx context.Context, arg UpdateProductParams) (Product, error)
+	UpdateReturnStatus(ctx context.Context, arg UpdateReturnStatusParams) (Return, error)
+	UpdateStockLocation(ctx context.Context, arg UpdateStockLocationParams) (StockLocation, error)
+	UpdateWebhookSubscription(ctx context.Context, arg UpdateWebhookSubscriptionParams) (WebhookSubscription, error)
 }
 
 var _ Querier = (*Queries)(nil)
@@ -2,7 +2,32 @@
 SELECT
     *
 FROM
-    products;
+    products
+WHERE
+    deleted_at IS NULL
+    AND (sqlc.narg(min_price)::int IS NULL OR price_in_cents >= sqlc.narg(min_price))
+    AND (sqlc.narg(max_price)::int IS NULL OR price_in_cents <= sqlc.narg(max_price))
+    AND (NOT sqlc.arg(in_stock)::bool OR quantity > 0)
+    AND (sqlc.narg(name_prefix)::text IS NULL OR name LIKE sqlc.narg(name_prefix) || '%')
+    AND (
+        sqlc.narg(cursor_id)::bigint IS NULL
+        OR (sqlc.arg(sort)::text = 'name' AND NOT sqlc.arg(descending)::bool AND (name, id) > (sqlc.narg(cursor_name)::text, sqlc.narg(cursor_id)))
+        OR (sqlc.arg(sort)::text = 'name' AND sqlc.arg(descending)::bool AND (name, id) < (sqlc.narg(cursor_name)::text, sqlc.narg(cursor_id)))
+        OR (sqlc.arg(sort)::text = 'price_in_cents' AND NOT sqlc.arg(descending)::bool AND (price_in_cents, id) > (sqlc.narg(cursor_price)::int, sqlc.narg(cursor_id)))
+        OR (sqlc.arg(sort)::text = 'price_in_cents' AND sqlc.arg(descending)::bool AND (price_in_cents, id) < (sqlc.narg(cursor_price)::int, sqlc.narg(cursor_id)))
+        OR (sqlc.arg(sort)::text = 'created_at' AND NOT sqlc.arg(descending)::bool AND (created_at, id) > (sqlc.narg(cursor_created_at)::timestamptz, sqlc.narg(cursor_id)))
+        OR (sqlc.arg(sort)::text = 'created_at' AND sqlc.arg(descending)::bool AND (created_at, id) < (sqlc.narg(cursor_created_at)::timestamptz, sqlc.narg(cursor_id)))
+    )
+ORDER BY
+    CASE WHEN sqlc.arg(sort)::text = 'name' AND NOT sqlc.arg(descending)::bool THEN name END ASC,
+    CASE WHEN sqlc.arg(sort)::text = 'name' AND sqlc.arg(descending)::bool THEN name END DESC,
+    CASE WHEN sqlc.arg(sort)::text = 'price_in_cents' AND NOT sqlc.arg(descending)::bool THEN price_in_cents END ASC,
+    CASE WHEN sqlc.arg(sort)::text = 'price_in_cents' AND sqlc.arg(descending)::bool THEN price_in_cents END DESC,
+    CASE WHEN sqlc.arg(sort)::text = 'created_at' AND NOT sqlc.arg(descending)::bool THEN created_at END ASC,
+    CASE WHEN sqlc.arg(sort)::text = 'created_at' AND sqlc.arg(descending)::bool THEN created_at END DESC,
+    CASE WHEN NOT sqlc.arg(descending)::bool THEN id END ASC,
+    CASE WHEN sqlc.arg(descending)::bool THEN id END DESC
+LIMIT sqlc.arg(row_limit);
 
 -- name: FindProductById :one
 SELECT
@@ -12,20 +37,33 @@ FROM
 WHERE
     id = $1;
 
+-- name: FindProductByIdForUpdate :one
+SELECT
+    *
+FROM
+    products
+WHERE
+    id = $1
+FOR UPDATE;
+
 -- name: CreateProduct :one
 INSERT INTO products (
 	name,
-	price_in_cents,
-	quantity
-) VALUES ($1, $2, $3) RETURNING *;
+	price_in_cents
+) VALUES ($1, $2) RETURNING *;
 
 -- name: UpdateProduct :one
 UPDATE products
 SET
 	name = $2,
-	price_in_cents = $3,
-	quantity = $4
-WHERE id = $1 RETURNING *;
+	price_in_cents = $3
+WHERE id = $1 AND deleted_at IS NULL RETURNING *;
+
+-- name: SoftDeleteProduct :one
+UPDATE products
+SET
+	deleted_at = now()
+WHERE id = $1 AND deleted_at IS NULL RETURNING *;
 
 -- name: CreateOrder :one
 INSERT INTO orders (
@@ -41,6 +79,7 @@ SELECT
 	o.id as order_id,
 	o.customer_id as customer_id,
 	o.created_at as created_at,
+	o.status as status,
 	oi.id as order_item_id,
 	oi.product_id as product_id,
 	oi.quantity as quantity,
@@ -50,3 +89,791 @@ FROM
 LEFT JOIN order_items as oi
 	ON o.id = oi.order_id
 WHERE o.id = $1;
+
+-- name: FindOrderByIdForUpdate :one
+SELECT
+	*
+FROM
+	orders
+WHERE
+	id = $1
+FOR UPDATE;
+
+-- name: UpdateOrderStatus :one
+UPDATE orders
+SET
+	status = $2
+WHERE id = $1 RETURNING *;
+
+-- name: CreateOrderStatusChange :one
+INSERT INTO order_status_changes (
+	order_id,
+	from_status,
+	to_status,
+	changed_by,
+	reason
+) VALUES ($1, $2, $3, $4, $5) RETURNING *;
+
+-- name: ListOrderStatusChanges :many
+SELECT
+	*
+FROM
+	order_status_changes
+WHERE
+	order_id = $1
+ORDER BY id;
+
+-- name: ListOrderItems :many
+SELECT
+	*
+FROM
+	order_items
+WHERE
+	order_id = $1
+ORDER BY product_id, id;
+
+-- name: CreateIdempotencyKey :one
+-- A key left in progress since before stale_before by a request that never
+-- completed it is taken over by a retry of the same request.
+INSERT INTO idempotency_keys (
+	key,
+	scope,
+	fingerprint
+) VALUES (sqlc.arg(key), sqlc.arg(scope), sqlc.arg(fingerprint))
+ON CONFLICT (key, scope) DO UPDATE
+SET
+	created_at = now()
+WHERE
+	idempotency_keys.completed_at IS NULL
+	AND idempotency_keys.fingerprint = EXCLUDED.fingerprint
+	AND idempotency_keys.created_at < sqlc.arg(stale_before)
+RETURNING *;
+
+-- name: FindIdempotencyKey :one
+SELECT
+	*
+FROM
+	idempotency_keys
+WHERE
+	key = $1 AND scope = $2;
+
+-- name: CompleteIdempotencyKey :exec
+UPDATE idempotency_keys
+SET
+	status_code = $3,
+	content_type = $4,
+	response_body = $5,
+	completed_at = now()
+WHERE key = $1 AND scope = $2;
+
+-- name: DeleteIdempotencyKey :exec
+DELETE FROM idempotency_keys
+WHERE key = $1 AND scope = $2;
+
+-- name: CreateCustomer :one
+INSERT INTO customers (
+	name,
+	email
+) VALUES ($1, $2) RETURNING *;
+
+-- name: FindCustomerById :one
+SELECT
+	*
+FROM
+	customers
+WHERE
+	id = $1;
+
+-- name: UpdateCustomer :one
+UPDATE customers
+SET
+	name = $2,
+	email = $3
+WHERE id = $1 RETURNING *;
+
+-- name: CreateCustomerAddress :one
+INSERT INTO customer_addresses (
+	customer_id,
+	kind,
+	line1,
+	line2,
+	city,
+	state,
+	postal_code,
+	country
+) VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING *;
+
+-- name: ListCustomerAddresses :many
+SELECT
+	*
+FROM
+	customer_addresses
+WHERE
+	customer_id = $1
+ORDER BY id;
+
+-- name: FindCustomerAddress :one
+SELECT
+	*
+FROM
+	customer_addresses
+WHERE
+	id = $1 AND customer_id = $2;
+
+-- name: DeleteCustomerAddress :execrows
+DELETE FROM customer_addresses
+WHERE id = $1 AND customer_id = $2;
+
+-- name: CreateOrderAddress :one
+INSERT INTO order_addresses (
+	order_id,
+	kind,
+	line1,
+	line2,
+	city,
+	state,
+	postal_code,
+	country
+) VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING *;
+
+-- name: ListOrderAddresses :many
+SELECT
+	*
+FROM
+	order_addresses
+WHERE
+	order_id = $1
+ORDER BY kind;
+
+-- name: ListOrders :many
+SELECT
+	o.id as id,
+	o.customer_id as customer_id,
+	o.created_at as created_at,
+	o.status as status,
+	COALESCE(SUM(oi.quantity::bigint * oi.price_cents), 0)::bigint as total_price_in_cents
+FROM
+	orders as o
+LEFT JOIN order_items as oi
+	ON o.id = oi.order_id
+WHERE
+	(sqlc.narg(customer_id)::bigint IS NULL OR o.customer_id = sqlc.narg(customer_id))
+	AND (sqlc.narg(status)::text IS NULL OR o.status = sqlc.narg(status))
+	AND (sqlc.narg(created_from)::timestamptz IS NULL OR o.created_at >= sqlc.narg(created_from))
+	AND (sqlc.narg(created_to)::timestamptz IS NULL OR o.created_at < sqlc.narg(created_to))
+	AND (sqlc.narg(cursor_id)::bigint IS NULL OR o.id < sqlc.narg(cursor_id))
+GROUP BY o.id
+HAVING
+	(sqlc.narg(min_total)::bigint IS NULL OR COALESCE(SUM(oi.quantity::bigint * oi.price_cents), 0) >= sqlc.narg(min_total))
+ORDER BY o.id DESC
+LIMIT sqlc.arg(row_limit);
+
+-- name: CreateCart :one
+INSERT INTO carts (
+	customer_id,
+	expires_at
+) VALUES ($1, $2) RETURNING *;
+
+-- name: FindCartById :one
+SELECT
+	*
+FROM
+	carts
+WHERE
+	id = $1;
+
+-- name: TouchCart :one
+UPDATE carts
+SET
+	expires_at = $2,
+	updated_at = now()
+WHERE id = $1 AND status = 'open' AND expires_at > now() RETURNING *;
+
+-- name: CheckoutCart :one
+UPDATE carts
+SET
+	status = 'checked_out',
+	updated_at = now()
+WHERE id = $1 AND status = 'open' AND expires_at > now() RETURNING *;
+
+-- name: SetCartOrder :one
+UPDATE carts
+SET
+	order_id = $2,
+	updated_at = now()
+WHERE id = $1 RETURNING *;
+
+-- name: AddCartItem :one
+INSERT INTO cart_items (
+	cart_id,
+	product_id,
+	quantity
+) VALUES ($1, $2, $3)
+ON CONFLICT (cart_id, product_id) DO UPDATE
+SET quantity = cart_items.quantity + EXCLUDED.quantity
+RETURNING *;
+
+-- name: UpdateCartItem :one
+UPDATE cart_items
+SET
+	quantity = $3
+WHERE cart_id = $1 AND product_id = $2 RETURNING *;
+
+-- name: DeleteCartItem :execrows
+DELETE FROM cart_items
+WHERE cart_id = $1 AND product_id = $2;
+
+-- name: ListCartItems :many
+SELECT
+	ci.id as id,
+	ci.product_id as product_id,
+	ci.quantity as quantity,
+	p.name as name,
+	p.price_in_cents as price_in_cents,
+	p.quantity as available_quantity,
+	p.deleted_at as deleted_at
+FROM
+	cart_items as ci
+JOIN products as p
+	ON p.id = ci.product_id
+WHERE ci.cart_id = $1
+ORDER BY ci.id;
+
+-- name: CreatePayment :one
+INSERT INTO payments (
+	order_id,
+	amount_in_cents
+) VALUES ($1, $2) RETURNING *;
+
+-- name: UpdatePayment :one
+UPDATE payments
+SET
+	status = $2,
+	gateway_reference = $3,
+	failure_reason = $4,
+	updated_at = now()
+WHERE id = $1 RETURNING *;
+
+-- name: ListOrderPayments :many
+SELECT
+	*
+FROM
+	payments
+WHERE
+	order_id = $1
+ORDER BY id;
+
+-- name: ExpirePendingPayments :execrows
+-- Fails the payments left pending by a request that never got an answer from
+-- the gateway, which would block the next payment of their order.
+UPDATE payments
+SET
+	status = 'failed',
+	failure_reason = 'payment expired',
+	updated_at = now()
+WHERE
+	status = 'pending'
+	AND updated_at < sqlc.arg(pending_before);
+
+-- name: ListExpiredAuthorizedPayments :many
+-- Lists the payments left authorized by a request that crashed before
+-- capturing them, which hold the money of the customer and block the next
+-- payment of their order until they are voided.
+SELECT
+	*
+FROM
+	payments
+WHERE
+	status = 'authorized'
+	AND updated_at < sqlc.arg(authorized_before)
+ORDER BY
+	id
+LIMIT sqlc.arg(row_limit);
+
+-- name: ListOrdersWithFailedPayment :many
+SELECT
+	o.id
+FROM
+	orders as o
+JOIN payments as p
+	ON p.order_id = o.id
+WHERE
+	o.status = 'pending'
+	AND p.status = 'failed'
+	AND p.updated_at < sqlc.arg(failed_before)
+	AND NOT EXISTS (
+		SELECT 1 FROM payments as newer WHERE newer.order_id = o.id AND newer.id > p.id
+	)
+ORDER BY o.id
+LIMIT sqlc.arg(row_limit);
+
+-- name: FindCapturedPayment :one
+SELECT
+	*
+FROM
+	payments
+WHERE
+	order_id = $1 AND status = 'captured';
+
+-- name: RefundPayment :one
+UPDATE payments
+SET
+	refunded_in_cents = refunded_in_cents + sqlc.arg(amount),
+	status = CASE WHEN refunded_in_cents + sqlc.arg(amount) = amount_in_cents THEN 'refunded' ELSE status END,
+	updated_at = now()
+WHERE id = sqlc.arg(id) AND refunded_in_cents + sqlc.arg(amount) <= amount_in_cents RETURNING *;
+
+-- name: CreateReturn :one
+INSERT INTO returns (
+	order_id,
+	reason,
+	refund_amount_in_cents
+) VALUES ($1, $2, $3) RETURNING *;
+
+-- name: CreateReturnItem :one
+INSERT INTO return_items (
+	return_id,
+	order_item_id,
+	product_id,
+	quantity,
+	price_cents
+) VALUES ($1, $2, $3, $4, $5) RETURNING *;
+
+-- name: FindReturnById :one
+SELECT
+	*
+FROM
+	returns
+WHERE
+	id = $1;
+
+-- name: ListOrderReturns :many
+SELECT
+	*
+FROM
+	returns
+WHERE
+	order_id = $1
+ORDER BY id;
+
+-- name: ListReturnItems :many
+SELECT
+	*
+FROM
+	return_items
+WHERE
+	return_id = $1
+ORDER BY id;
+
+-- name: ListReturnedQuantities :many
+SELECT
+	ri.order_item_id,
+	SUM(ri.quantity)::int as quantity
+FROM
+	return_items as ri
+JOIN returns as r
+	ON r.id = ri.return_id
+WHERE
+	r.order_id = $1 AND r.status <> 'rejected'
+GROUP BY ri.order_item_id;
+
+-- name: DecideReturn :one
+UPDATE returns
+SET
+	status = $2,
+	decided_by = $3,
+	decision_reason = $4,
+	updated_at = now()
+WHERE id = $1 AND status = 'requested' RETURNING *;
+
+-- name: ListRefundingReturns :many
+SELECT
+	*
+FROM
+	returns
+WHERE
+	status = 'refunding'
+	AND updated_at < sqlc.arg(refunding_before)
+ORDER BY
+	id
+LIMIT sqlc.arg(row_limit);
+
+-- name: UpdateReturnStatus :one
+UPDATE returns
+SET
+	status = sqlc.arg(to_status),
+	updated_at = now()
+WHERE id = sqlc.arg(id) AND status = sqlc.arg(from_status) RETURNING *;
+
+-- name: CreateOutboxEvent :one
+INSERT INTO outbox (
+	aggregate_type,
+	aggregate_id,
+	event_type,
+	payload
+) VALUES ($1, $2, $3, $4) RETURNING *;
+
+-- name: ListPendingOutboxEvents :many
+-- Only the oldest unpublished event of every aggregate is due, so the events
+-- of an aggregate are published in order and a failing one holds the rest.
+SELECT
+	*
+FROM
+	outbox as o
+WHERE
+	o.published_at IS NULL
+	AND o.next_attempt_at <= now()
+	AND NOT EXISTS (
+		SELECT 1
+		FROM outbox as p
+		WHERE p.aggregate_type = o.aggregate_type
+			AND p.aggregate_id = o.aggregate_id
+			AND p.published_at IS NULL
+			AND p.id < o.id
+	)
+ORDER BY o.id
+LIMIT sqlc.arg(row_limit)
+FOR UPDATE SKIP LOCKED;
+
+-- name: MarkOutboxEventPublished :exec
+UPDATE outbox
+SET
+	attempts = attempts + 1,
+	published_at = now()
+WHERE id = $1;
+
+-- name: MarkOutboxEventFailed :exec
+UPDATE outbox
+SET
+	attempts = attempts + 1,
+	last_error = $2,
+	next_attempt_at = $3
+WHERE id = $1;
+
+-- name: CreateWebhookSubscription :one
+INSERT INTO webhook_subscriptions (
+	url,
+	secret,
+	event_types
+) VALUES ($1, $2, $3) RETURNING *;
+
+-- name: FindWebhookSubscriptionById :one
+SELECT
+	*
+FROM
+	webhook_subscriptions
+WHERE
+	id = $1;
+
+-- name: ListWebhookSubscriptions :many
+SELECT
+	*
+FROM
+	webhook_subscriptions
+ORDER BY id;
+
+-- name: ListWebhookSubscriptionsForEvent :many
+SELECT
+	*
+FROM
+	webhook_subscriptions
+WHERE
+	active AND sqlc.arg(event_type)::text = ANY(event_types)
+ORDER BY id;
+
+-- name: UpdateWebhookSubscription :one
+UPDATE webhook_subscriptions
+SET
+	url = $2,
+	event_types = $3,
+	active = $4,
+	updated_at = now()
+WHERE id = $1 RETURNING *;
+
+-- name: DeleteWebhookSubscription :execrows
+DELETE FROM webhook_subscriptions
+WHERE id = $1;
+
+-- name: CreateWebhookDelivery :exec
+INSERT INTO webhook_deliveries (
+	subscription_id,
+	event_id,
+	event_type,
+	payload
+) VALUES ($1, $2, $3, $4)
+ON CONFLICT (subscription_id, event_id) DO NOTHING;
+
+-- name: ClaimWebhookDeliveries :many
+-- Claimed deliveries are hidden from the other dispatchers for the lease, so
+-- the HTTP calls are made outside of a transaction.
+UPDATE webhook_deliveries
+SET
+	next_attempt_at = now() + sqlc.arg(lease)::interval,
+	updated_at = now()
+WHERE id IN (
+	SELECT d.id
+	FROM webhook_deliveries as d
+	WHERE d.status = 'pending' AND d.next_attempt_at <= now()
+	ORDER BY d.next_attempt_at, d.id
+	LIMIT sqlc.arg(row_limit)
+	FOR UPDATE SKIP LOCKED
+) RETURNING *;
+
+-- name: RecordWebhookDeliveryAttempt :one
+UPDATE webhook_deliveries
+SET
+	status = $2,
+	attempts = attempts + 1,
+	next_attempt_at = $3,
+	last_status_code = $4,
+	last_error = $5,
+	delivered_at = CASE WHEN $2 = 'delivered' THEN now() ELSE delivered_at END,
+	updated_at = now()
+WHERE id = $1 RETURNING *;
+
+-- name: CreateWebhookDeliveryAttempt :one
+INSERT INTO webhook_delivery_attempts (
+	delivery_id,
+	status_code,
+	error,
+	duration_ms
+) VALUES ($1, $2, $3, $4) RETURNING *;
+
+-- name: FindWebhookDeliveryById :one
+SELECT
+	*
+FROM
+	webhook_deliveries
+WHERE
+	id = $1 AND subscription_id = $2;
+
+-- name: ListWebhookDeliveries :many
+SELECT
+	*
+FROM
+	webhook_deliveries
+WHERE
+	subscription_id = sqlc.arg(subscription_id)
+	AND (sqlc.narg(status)::text IS NULL OR status = sqlc.narg(status))
+	AND (sqlc.narg(cursor_id)::bigint IS NULL OR id < sqlc.narg(cursor_id))
+ORDER BY id DESC
+LIMIT sqlc.arg(row_limit);
+
+-- name: ListWebhookDeliveryAttempts :many
+SELECT
+	*
+FROM
+	webhook_delivery_attempts
+WHERE
+	delivery_id = $1
+ORDER BY id;
+
+-- name: RedriveWebhookDelivery :one
+UPDATE webhook_deliveries
+SET
+	status = 'pending',
+	attempts = 0,
+	next_attempt_at = now(),
+	updated_at = now()
+WHERE id = $1 AND subscription_id = $2 AND status = 'dead' RETURNING *;
+
+-- name: CreateInventoryMovement :one
+INSERT INTO inventory_movements (
+	product_id,
+	location_id,
+	delta,
+	quantity,
+	reason,
+	order_id
+) VALUES ($1, $2, $3, $4, $5, $6) RETURNING *;
+
+-- name: ListInventoryMovements :many
+SELECT
+	*
+FROM
+	inventory_movements
+WHERE
+	product_id = sqlc.arg(product_id)
+	AND (sqlc.narg(cursor_id)::bigint IS NULL OR id < sqlc.narg(cursor_id))
+ORDER BY id DESC
+LIMIT sqlc.arg(row_limit);
+
+-- name: ReconcileInventory :many
+SELECT
+	COALESCE(s.product_id, m.product_id)::bigint as product_id,
+	COALESCE(s.location_id, m.location_id)::bigint as location_id,
+	COALESCE(s.quantity, 0)::int as quantity,
+	COALESCE(m.quantity, 0)::int as ledger_quantity,
+	(COALESCE(s.quantity, 0) - COALESCE(m.quantity, 0))::int as drift
+FROM
+	product_stock as s
+FULL JOIN (
+	SELECT
+		product_id,
+		location_id,
+		SUM(delta) as quantity
+	FROM
+		inventory_movements
+	GROUP BY product_id, location_id
+) as m
+	ON m.product_id = s.product_id AND m.location_id = s.location_id
+WHERE
+	COALESCE(s.quantity, 0) <> COALESCE(m.quantity, 0)
+ORDER BY 1, 2;
+
+-- name: CreateStockLocation :one
+INSERT INTO stock_locations (
+	name,
+	priority,
+	shipping_cost_in_cents
+) VALUES ($1, $2, $3) RETURNING *;
+
+-- name: FindStockLocationById :one
+SELECT
+	*
+FROM
+	stock_locations
+WHERE
+	id = $1;
+
+-- name: FindDefaultStockLocation :one
+SELECT
+	*
+FROM
+	stock_locations
+WHERE
+	is_default;
+
+-- name: ListStockLocations :many
+SELECT
+	*
+FROM
+	stock_locations
+ORDER BY priority, id;
+
+-- name: UpdateStockLocation :one
+UPDATE stock_locations
+SET
+	name = $2,
+	priority = $3,
+	shipping_cost_in_cents = $4,
+	active = $5,
+	updated_at = now()
+WHERE id = $1 RETURNING *;
+
+-- name: ListProductStock :many
+SELECT
+	s.location_id,
+	l.name,
+	l.priority,
+	l.shipping_cost_in_cents,
+	l.active,
+	s.quantity
+FROM
+	product_stock as s
+JOIN stock_locations as l
+	ON l.id = s.location_id
+WHERE
+	s.product_id = $1
+ORDER BY l.priority, l.id;
+
+-- name: AddLocationStock :one
+INSERT INTO product_stock (
+	product_id,
+	location_id,
+	quantity
+) VALUES ($1, $2, $3)
+ON CONFLICT (product_id, location_id) DO UPDATE
+SET
+	quantity = product_stock.quantity + EXCLUDED.quantity,
+	updated_at = now()
+RETURNING *;
+
+-- name: RemoveLocationStock :one
+UPDATE product_stock
+SET
+	quantity = quantity - sqlc.arg(quantity),
+	updated_at = now()
+WHERE product_id = sqlc.arg(product_id) AND location_id = sqlc.arg(location_id) AND quantity >= sqlc.arg(quantity) RETURNING *;
+
+-- name: CreateOrderItemAllocation :one
+INSERT INTO order_item_allocations (
+	order_item_id,
+	location_id,
+	quantity
+) VALUES ($1, $2, $3) RETURNING *;
+
+-- name: ListOrderItemAllocations :many
+SELECT
+	a.id,
+	a.order_item_id,
+	oi.product_id,
+	a.location_id,
+	a.quantity
+FROM
+	order_item_allocations as a
+JOIN order_items as oi
+	ON oi.id = a.order_item_id
+WHERE
+	oi.order_id = $1
+ORDER BY oi.product_id, a.id;
+
+-- name: CreateApiKey :one
+INSERT INTO api_keys (
+	name,
+	key_prefix,
+	key_hash
+) VALUES ($1, $2, $3) RETURNING *;
+
+-- name: FindActiveApiKeyByHash :one
+SELECT
+	*
+FROM
+	api_keys
+WHERE
+	key_hash = $1 AND revoked_at IS NULL;
+
+-- name: RevokeApiKey :execrows
+UPDATE api_keys
+SET
+	revoked_at = now()
+WHERE id = $1 AND revoked_at IS NULL;
+
+-- name: TakeRateLimitToken :one
+-- Refills the bucket for the time elapsed since its last request and takes a
+-- token from it when there is one left.
+INSERT INTO rate_limit_buckets AS b (
+	key,
+	tokens,
+	allowed
+) VALUES (sqlc.arg(key), sqlc.arg(burst)::float8 - 1, TRUE)
+ON CONFLICT (key) DO UPDATE
+SET
+	tokens = LEAST(sqlc.arg(burst)::float8, b.tokens + EXTRACT(EPOCH FROM now() - b.updated_at)::float8 * sqlc.arg(rate)::float8)
+		- CASE WHEN LEAST(sqlc.arg(burst)::float8, b.tokens + EXTRACT(EPOCH FROM now() - b.updated_at)::float8 * sqlc.arg(rate)::float8) >= 1 THEN 1 ELSE 0 END,
+	allowed = LEAST(sqlc.arg(burst)::float8, b.tokens + EXTRACT(EPOCH FROM now() - b.updated_at)::float8 * sqlc.arg(rate)::float8) >= 1,
+	updated_at = now()
+RETURNING tokens, allowed;
+
+-- name: PeekRateLimitBucket :one
+-- Returns the tokens the bucket would hold after being refilled, without
+-- taking any.
+SELECT
+	LEAST(sqlc.arg(burst)::float8, tokens + EXTRACT(EPOCH FROM now() - updated_at)::float8 * sqlc.arg(rate)::float8)::float8 AS tokens
+FROM
+	rate_limit_buckets
+WHERE
+	key = sqlc.arg(key);
+
+-- name: DeleteIdleRateLimitBuckets :execrows
+DELETE FROM rate_limit_buckets
+WHERE updated_at < sqlc.arg(idle_since);
+
+-- name: OutboxBacklog :one
+SELECT
+	count(*) AS pending,
+	min(created_at)::timestamptz AS oldest_created_at
+FROM
+	outbox
+WHERE
+	published_at IS NULL;
@@ -7,123 +7,140 @@ package repo
 
 import (
 	"context"
+	"encoding/json"
 
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
-const createOrder = `-- name: CreateOrder :one
-INSERT INTO orders (
-  customer_id
-) VALUES ($1) RETURNING id, customer_id, created_at
+const addCartItem = `-- name: AddCartItem :one
+INSERT INTO cart_items (
+	cart_id,
+	product_id,
+	quantity
+) VALUES ($1, $2, $3)
+ON CONFLICT (cart_id, product_id) DO UPDATE
+SET quantity = cart_items.quantity + EXCLUDED.quantity
+RETURNING id, cart_id, product_id, quantity, created_at
 `
 
-func (q *Queries) CreateOrder(ctx context.Context, customerID int64) (Order, error) {
-	row := q.db.QueryRow(ctx, createOrder, customerID)
-	var i Order
-	err := row.Scan(&i.ID, &i.CustomerID, &i.CreatedAt)
+type AddCartItemParams struct {
+	CartID    int64 `json:"cart_id"`
+	ProductID int64 `json:"product_id"`
+	Quantity  int32 `json:"quantity"`
+}
+
+func (q *Queries) AddCartItem(ctx context.Context, arg AddCartItemParams) (CartItem, error) {
+	row := q.db.QueryRow(ctx, addCartItem, arg.CartID, arg.ProductID, arg.Quantity)
+	var i CartItem
+	err := row.Scan(
+		&i.ID,
+		&i.CartID,
+		&i.ProductID,
+		&i.Quantity,
+		&i.CreatedAt,
+	)
 	return i, err
 }
 
-const createOrderItem = `-- name: CreateOrderItem :one
-INSERT INTO order_items (order_id, product_id, quantity, price_cents)
-VALUES ($1, $2, $3, $4) RETURNING id, order_id, product_id, quantity, price_cents
+const addLocationStock = `-- name: AddLocationStock :one
+INSERT INTO product_stock (
+	product_id,
+	location_id,
+	quantity
+) VALUES ($1, $2, $3)
+ON CONFLICT (product_id, location_id) DO UPDATE
+SET
+	quantity = product_stock.quantity + EXCLUDED.quantity,
+	updated_at = now()
+RETURNING product_id, location_id, quantity, updated_at
 `
 
-type CreateOrderItemParams struct {
-	OrderID    int64 `json:"order_id"`
+type AddLocationStockParams struct {
 	ProductID  int64 `json:"product_id"`
+	LocationID int64 `json:"location_id"`
 	Quantity   int32 `json:"quantity"`
-	PriceCents int32 `json:"price_cents"`
 }
 
-func (q *Queries) CreateOrderItem(ctx context.Context, arg CreateOrderItemParams) (OrderItem, error) {
-	row := q.db.QueryRow(ctx, createOrderItem,
-		arg.OrderID,
-		arg.ProductID,
-		arg.Quantity,
-		arg.PriceCents,
-	)
-	var i OrderItem
+func (q *Queries) AddLocationStock(ctx context.Context, arg AddLocationStockParams) (ProductStock, error) {
+	row := q.db.QueryRow(ctx, addLocationStock, arg.ProductID, arg.LocationID, arg.Quantity)
+	var i ProductStock
 	err := row.Scan(
-		&i.ID,
-		&i.OrderID,
 		&i.ProductID,
+		&i.LocationID,
 		&i.Quantity,
-		&i.PriceCents,
+		&i.UpdatedAt,
 	)
 	return i, err
 }
 
-const createProduct = `-- name: CreateProduct :one
-INSERT INTO products (
-	name,
-	price_in_cents,
-	quantity
-) VALUES ($1, $2, $3) RETURNING id, name, price_in_cents, quantity, created_at
+const checkoutCart = `-- name: CheckoutCart :one
+UPDATE carts
+SET
+	status = 'checked_out',
+	updated_at = now()
+WHERE id = $1 AND status = 'open' AND expires_at > now() RETURNING id, customer_id, status, order_id, expires_at, created_at, updated_at
 `
 
-type CreateProductParams struct {
-	Name         string `json:"name"`
-	PriceInCents int32  `json:"price_in_cents"`
-	Quantity     int32  `json:"quantity"`
-}
-
-func (q *Queries) CreateProduct(ctx context.Context, arg CreateProductParams) (Product, error) {
-	row := q.db.QueryRow(ctx, createProduct, arg.Name, arg.PriceInCents, arg.Quantity)
-	var i Product
+func (q *Queries) CheckoutCart(ctx context.Context, id int64) (Cart, error) {
+	row := q.db.QueryRow(ctx, checkoutCart, id)
+	var i Cart
 	err := row.Scan(
 		&i.ID,
-		&i.Name,
-		&i.PriceInCents,
-		&i.Quantity,
+		&i.CustomerID,
+		&i.Status,
+		&i.OrderID,
+		&i.ExpiresAt,
 		&i.CreatedAt,
+		&i.UpdatedAt,
 	)
 	return i, err
 }
 
-const findOrderById = `-- name: FindOrderById :many
-SELECT 
-	o.id as order_id,
-	o.customer_id as customer_id,
-	o.created_at as created_at,
-	oi.id as order_item_id,
-	oi.product_id as product_id,
-	oi.quantity as quantity,
-	oi.price_cents as price_cents
-FROM 
-	orders as o
-LEFT JOIN order_items as oi
-	ON o.id = oi.order_id
-WHERE o.id = $1
+const claimWebhookDeliveries = `-- name: ClaimWebhookDeliveries :many
+UPDATE webhook_deliveries
+SET
+	next_attempt_at = now() + $1::interval,
+	updated_at = now()
+WHERE id IN (
+	SELECT d.id
+	FROM webhook_deliveries as d
+	WHERE d.status = 'pending' AND d.next_attempt_at <= now()
+	ORDER BY d.next_attempt_at, d.id
+	LIMIT $2
+	FOR UPDATE SKIP LOCKED
+) RETURNING id, subscription_id, event_id, event_type, payload, status, attempts, next_attempt_at, last_status_code, last_error, created_at, updated_at, delivered_at
 `
 
-type FindOrderByIdRow struct {
-	OrderID     int64              `json:"order_id"`
-	CustomerID  int64              `json:"customer_id"`
-	CreatedAt   pgtype.Timestamptz `json:"created_at"`
-	OrderItemID pgtype.Int8        `json:"order_item_id"`
-	ProductID   pgtype.Int8        `json:"product_id"`
-	Quantity    pgtype.Int4        `json:"quantity"`
-	PriceCents  pgtype.Int4        `json:"price_cents"`
+type ClaimWebhookDeliveriesParams struct {
+	Lease    pgtype.Interval `json:"lease"`
+	RowLimit int32           `json:"row_limit"`
 }
 
-func (q *Queries) FindOrderById(ctx context.Context, id int64) ([]FindOrderByIdRow, error) {
-	rows, err := q.db.Query(ctx, findOrderById, id)
+// Claimed deliveries are hidden from the other dispatchers for the lease, so
+// the HTTP calls are made outside of a transaction.
+func (q *Queries) ClaimWebhookDeliveries(ctx context.Context, arg ClaimWebhookDeliveriesParams) ([]WebhookDelivery, error) {
+	rows, err := q.db.Query(ctx, claimWebhookDeliveries, arg.Lease, arg.RowLimit)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var items []FindOrderByIdRow
+	var items []WebhookDelivery
 	for rows.Next() {
-		var i FindOrderByIdRow
+		var i WebhookDelivery
 		if err := rows.Scan(
-			&i.OrderID,
-			&i.CustomerID,
+			&i.ID,
+			&i.SubscriptionID,
+			&i.EventID,
+			&i.EventType,
+			&i.Payload,
+			&i.Status,
+			&i.Attempts,
+			&i.NextAttemptAt,
+			&i.LastStatusCode,
+			&i.LastError,
 			&i.CreatedAt,
-			&i.OrderItemID,
-			&i.ProductID,
-			&i.Quantity,
-			&i.PriceCents,
+			&i.UpdatedAt,
+			&i.DeliveredAt,
 		); err != nil {
 			return nil, err
 		}
@@ -135,91 +152,2985 @@ func (q *Queries) FindOrderById(ctx context.Context, id int64) ([]FindOrderByIdR
 	return items, nil
 }
 
-const findProductById = `-- name: FindProductById :one
-SELECT
-    id, name, price_in_cents, quantity, created_at
-FROM
-    products
-WHERE
-    id = $1
+const completeIdempotencyKey = `-- name: CompleteIdempotencyKey :exec
+UPDATE idempotency_keys
+SET
+	status_code = $3,
+	content_type = $4,
+	response_body = $5,
+	completed_at = now()
+WHERE key = $1 AND scope = $2
 `
 
-func (q *Queries) FindProductById(ctx context.Context, id int64) (Product, error) {
-	row := q.db.QueryRow(ctx, findProductById, id)
-	var i Product
+type CompleteIdempotencyKeyParams struct {
+	Key          string      `json:"key"`
+	Scope        string      `json:"scope"`
+	StatusCode   pgtype.Int4 `json:"status_code"`
+	ContentType  pgtype.Text `json:"content_type"`
+	ResponseBody []byte      `json:"response_body"`
+}
+
+func (q *Queries) CompleteIdempotencyKey(ctx context.Context, arg CompleteIdempotencyKeyParams) error {
+	_, err := q.db.Exec(ctx, completeIdempotencyKey,
+		arg.Key,
+		arg.Scope,
+		arg.StatusCode,
+		arg.ContentType,
+		arg.ResponseBody,
+	)
+	return err
+}
+
+const createApiKey = `-- name: CreateApiKey :one
+INSERT INTO api_keys (
+	name,
+	key_prefix,
+	key_hash
+) VALUES ($1, $2, $3) RETURNING id, name, key_prefix, key_hash, created_at, revoked_at
+`
+
+type CreateApiKeyParams struct {
+	Name      string `json:"name"`
+	KeyPrefix string `json:"key_prefix"`
+	KeyHash   string `json:"key_hash"`
+}
+
+func (q *Queries) CreateApiKey(ctx context.Context, arg CreateApiKeyParams) (ApiKey, error) {
+	row := q.db.QueryRow(ctx, createApiKey, arg.Name, arg.KeyPrefix, arg.KeyHash)
+	var i ApiKey
 	err := row.Scan(
 		&i.ID,
 		&i.Name,
-		&i.PriceInCents,
-		&i.Quantity,
+		&i.KeyPrefix,
+		&i.KeyHash,
 		&i.CreatedAt,
+		&i.RevokedAt,
 	)
 	return i, err
 }
 
-const listProducts = `-- name: ListProducts :many
-SELECT
-    id, name, price_in_cents, quantity, created_at
-FROM
-    products
+const createCart = `-- name: CreateCart :one
+INSERT INTO carts (
+	customer_id,
+	expires_at
+) VALUES ($1, $2) RETURNING id, customer_id, status, order_id, expires_at, created_at, updated_at
 `
 
-func (q *Queries) ListProducts(ctx context.Context) ([]Product, error) {
-	rows, err := q.db.Query(ctx, listProducts)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-	var items []Product
-	for rows.Next() {
-		var i Product
-		if err := rows.Scan(
-			&i.ID,
-			&i.Name,
-			&i.PriceInCents,
-			&i.Quantity,
-			&i.CreatedAt,
-		); err != nil {
-			return nil, err
-		}
-		items = append(items, i)
-	}
-	if err := rows.Err(); err != nil {
-		return nil, err
-	}
-	return items, nil
+type CreateCartParams struct {
+	CustomerID int64              `json:"customer_id"`
+	ExpiresAt  pgtype.Timestamptz `json:"expires_at"`
 }
 
-const updateProduct = `-- name: UpdateProduct :one
-UPDATE products
+func (q *Queries) CreateCart(ctx context.Context, arg CreateCartParams) (Cart, error) {
+	row := q.db.QueryRow(ctx, createCart, arg.CustomerID, arg.ExpiresAt)
+	var i Cart
+	err := row.Scan(
+		&i.ID,
+		&i.CustomerID,
+		&i.Status,
+		&i.OrderID,
+		&i.ExpiresAt,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const createCustomer = `-- name: CreateCustomer :one
+INSERT INTO customers (
+	name,
+	email
+) VALUES ($1, $2) RETURNING id, name, email, created_at
+`
+
+type CreateCustomerParams struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+func (q *Queries) CreateCustomer(ctx context.Context, arg CreateCustomerParams) (Customer, error) {
+	row := q.db.QueryRow(ctx, createCustomer, arg.Name, arg.Email)
+	var i Customer
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Email,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const createCustomerAddress = `-- name: CreateCustomerAddress :one
+INSERT INTO customer_addresses (
+	customer_id,
+	kind,
+	line1,
+	line2,
+	city,
+	state,
+	postal_code,
+	country
+) VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id, customer_id, kind, line1, line2, city, state, postal_code, country, created_at
+`
+
+type CreateCustomerAddressParams struct {
+	CustomerID int64  `json:"customer_id"`
+	Kind       string `json:"kind"`
+	Line1      string `json:"line1"`
+	Line2      string `json:"line2"`
+	City       string `json:"city"`
+	State      string `json:"state"`
+	PostalCode string `json:"postal_code"`
+	Country    string `json:"country"`
+}
+
+func (q *Queries) CreateCustomerAddress(ctx context.Context, arg CreateCustomerAddressParams) (CustomerAddress, error) {
+	row := q.db.QueryRow(ctx, createCustomerAddress,
+		arg.CustomerID,
+		arg.Kind,
+		arg.Line1,
+		arg.Line2,
+		arg.City,
+		arg.State,
+		arg.PostalCode,
+		arg.Country,
+	)
+	var i CustomerAddress
+	err := row.Scan(
+		&i.ID,
+		&i.CustomerID,
+		&i.Kind,
+		&i.Line1,
+		&i.Line2,
+		&i.City,
+		&i.State,
+		&i.PostalCode,
+		&i.Country,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const createIdempotencyKey = `-- name: CreateIdempotencyKey :one
+INSERT INTO idempotency_keys (
+	key,
+	scope,
+	fingerprint
+) VALUES ($1, $2, $3)
+ON CONFLICT (key, scope) DO UPDATE
 SET
-	name = $2,
-	price_in_cents = $3,
-	quantity = $4
-WHERE id = $1 RETURNING id, name, price_in_cents, quantity, created_at
+	created_at = now()
+WHERE
+	idempotency_keys.completed_at IS NULL
+	AND idempotency_keys.fingerprint = EXCLUDED.fingerprint
+	AND idempotency_keys.created_at < $4
+RETURNING key, scope, fingerprint, status_code, content_type, response_body, created_at, completed_at
 `
 
-type UpdateProductParams struct {
-	ID           int64  `json:"id"`
-	Name         string `json:"name"`
-	PriceInCents int32  `json:"price_in_cents"`
-	Quantity     int32  `json:"quantity"`
+type CreateIdempotencyKeyParams struct {
+	Key         string             `json:"key"`
+	Scope       string             `json:"scope"`
+	Fingerprint string             `json:"fingerprint"`
+	StaleBefore pgtype.Timestamptz `json:"stale_before"`
 }
 
-func (q *Queries) UpdateProduct(ctx context.Context, arg UpdateProductParams) (Product, error) {
-	row := q.db.QueryRow(ctx, updateProduct,
-		arg.ID,
-		arg.Name,
-		arg.PriceInCents,
+// A key left in progress since before stale_before by a request that never
+// completed it is taken over by a retry of the same request.
+func (q *Queries) CreateIdempotencyKey(ctx context.Context, arg CreateIdempotencyKeyParams) (IdempotencyKey, error) {
+	row := q.db.QueryRow(ctx, createIdempotencyKey,
+		arg.Key,
+		arg.Scope,
+		arg.Fingerprint,
+		arg.StaleBefore,
+	)
+	var i IdempotencyKey
+	err := row.Scan(
+		&i.Key,
+		&i.Scope,
+		&i.Fingerprint,
+		&i.StatusCode,
+		&i.ContentType,
+		&i.ResponseBody,
+		&i.CreatedAt,
+		&i.CompletedAt,
+	)
+	return i, err
+}
+
+const createInventoryMovement = `-- name: CreateInventoryMovement :one
+INSERT INTO inventory_movements (
+	product_id,
+	location_id,
+	delta,
+	quantity,
+	reason,
+	order_id
+) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id, product_id, delta, quantity, reason, order_id, created_at, location_id
+`
+
+type CreateInventoryMovementParams struct {
+	ProductID  int64       `json:"product_id"`
+	LocationID int64       `json:"location_id"`
+	Delta      int32       `json:"delta"`
+	Quantity   int32       `json:"quantity"`
+	Reason     string      `json:"reason"`
+	OrderID    pgtype.Int8 `json:"order_id"`
+}
+
+func (q *Queries) CreateInventoryMovement(ctx context.Context, arg CreateInventoryMovementParams) (InventoryMovement, error) {
+	row := q.db.QueryRow(ctx, createInventoryMovement,
+		arg.ProductID,
+		arg.LocationID,
+		arg.Delta,
 		arg.Quantity,
+		arg.Reason,
+		arg.OrderID,
 	)
-	var i Product
+	var i InventoryMovement
 	err := row.Scan(
 		&i.ID,
-		&i.Name,
-		&i.PriceInCents,
+		&i.ProductID,
+		&i.Delta,
+		&i.Quantity,
+		&i.Reason,
+		&i.OrderID,
+		&i.CreatedAt,
+		&i.LocationID,
+	)
+	return i, err
+}
+
+const createOrder = `-- name: CreateOrder :one
+INSERT INTO orders (
+  customer_id
+) VALUES ($1) RETURNING id, customer_id, created_at, status
+`
+
+func (q *Queries) CreateOrder(ctx context.Context, customerID int64) (Order, error) {
+	row := q.db.QueryRow(ctx, createOrder, customerID)
+	var i Order
+	err := row.Scan(
+		&i.ID,
+		&i.CustomerID,
+		&i.CreatedAt,
+		&i.Status,
+	)
+	return i, err
+}
+
+const createOrderAddress = `-- name: CreateOrderAddress :one
+INSERT INTO order_addresses (
+	order_id,
+	kind,
+	line1,
+	line2,
+	city,
+	state,
+	postal_code,
+	country
+) VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING order_id, kind, line1, line2, city, state, postal_code, country
+`
+
+type CreateOrderAddressParams struct {
+	OrderID    int64  `json:"order_id"`
+	Kind       string `json:"kind"`
+	Line1      string `json:"line1"`
+	Line2      string `json:"line2"`
+	City       string `json:"city"`
+	State      string `json:"state"`
+	PostalCode string `json:"postal_code"`
+	Country    string `json:"country"`
+}
+
+func (q *Queries) CreateOrderAddress(ctx context.Context, arg CreateOrderAddressParams) (OrderAddress, error) {
+	row := q.db.QueryRow(ctx, createOrderAddress,
+		arg.OrderID,
+		arg.Kind,
+		arg.Line1,
+		arg.Line2,
+		arg.City,
+		arg.State,
+		arg.PostalCode,
+		arg.Country,
+	)
+	var i OrderAddress
+	err := row.Scan(
+		&i.OrderID,
+		&i.Kind,
+		&i.Line1,
+		&i.Line2,
+		&i.City,
+		&i.State,
+		&i.PostalCode,
+		&i.Country,
+	)
+	return i, err
+}
+
+const createOrderItem = `-- name: CreateOrderItem :one
+INSERT INTO order_items (order_id, product_id, quantity, price_cents)
+VALUES ($1, $2, $3, $4) RETURNING id, order_id, product_id, quantity, price_cents
+`
+
+type CreateOrderItemParams struct {
+	OrderID    int64 `json:"order_id"`
+	ProductID  int64 `json:"product_id"`
+	Quantity   int32 `json:"quantity"`
+	PriceCents int32 `json:"price_cents"`
+}
+
+func (q *Queries) CreateOrderItem(ctx context.Context, arg CreateOrderItemParams) (OrderItem, error) {
+	row := q.db.QueryRow(ctx, createOrderItem,
+		arg.OrderID,
+		arg.ProductID,
+		arg.Quantity,
+		arg.PriceCents,
+	)
+	var i OrderItem
+	err := row.Scan(
+		&i.ID,
+		&i.OrderID,
+		&i.ProductID,
 		&i.Quantity,
+		&i.PriceCents,
+	)
+	return i, err
+}
+
+const createOrderItemAllocation = `-- name: CreateOrderItemAllocation :one
+INSERT INTO order_item_allocations (
+	order_item_id,
+	location_id,
+	quantity
+) VALUES ($1, $2, $3) RETURNING id, order_item_id, location_id, quantity
+`
+
+type CreateOrderItemAllocationParams struct {
+	OrderItemID int64 `json:"order_item_id"`
+	LocationID  int64 `json:"location_id"`
+	Quantity    int32 `json:"quantity"`
+}
+
+func (q *Queries) CreateOrderItemAllocation(ctx context.Context, arg CreateOrderItemAllocationParams) (OrderItemAllocation, error) {
+	row := q.db.QueryRow(ctx, createOrderItemAllocation, arg.OrderItemID, arg.LocationID, arg.Quantity)
+	var i OrderItemAllocation
+	err := row.Scan(
+		&i.ID,
+		&i.OrderItemID,
+		&i.LocationID,
+		&i.Quantity,
+	)
+	return i, err
+}
+
+const createOrderStatusChange = `-- name: CreateOrderStatusChange :one
+INSERT INTO order_status_changes (
+	order_id,
+	from_status,
+	to_status,
+	changed_by,
+	reason
+) VALUES ($1, $2, $3, $4, $5) RETURNING id, order_id, from_status, to_status, changed_by, reason, created_at
+`
+
+type CreateOrderStatusChangeParams struct {
+	OrderID    int64  `json:"order_id"`
+	FromStatus string `json:"from_status"`
+	ToStatus   string `json:"to_status"`
+	ChangedBy  string `json:"changed_by"`
+	Reason     string `json:"reason"`
+}
+
+func (q *Queries) CreateOrderStatusChange(ctx context.Context, arg CreateOrderStatusChangeParams) (OrderStatusChange, error) {
+	row := q.db.QueryRow(ctx, createOrderStatusChange,
+		arg.OrderID,
+		arg.FromStatus,
+		arg.ToStatus,
+		arg.ChangedBy,
+		arg.Reason,
+	)
+	var i OrderStatusChange
+	err := row.Scan(
+		&i.ID,
+		&i.OrderID,
+		&i.FromStatus,
+		&i.ToStatus,
+		&i.ChangedBy,
+		&i.Reason,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const createOutboxEvent = `-- name: CreateOutboxEvent :one
+INSERT INTO outbox (
+	aggregate_type,
+	aggregate_id,
+	event_type,
+	payload
+) VALUES ($1, $2, $3, $4) RETURNING id, aggregate_type, aggregate_id, event_type, payload, attempts, last_error, next_attempt_at, created_at, published_at
+`
+
+type CreateOutboxEventParams struct {
+	AggregateType string          `json:"aggregate_type"`
+	AggregateID   int64           `json:"aggregate_id"`
+	EventType     string          `json:"event_type"`
+	Payload       json.RawMessage `json:"payload"`
+}
+
+func (q *Queries) CreateOutboxEvent(ctx context.Context, arg CreateOutboxEventParams) (Outbox, error) {
+	row := q.db.QueryRow(ctx, createOutboxEvent,
+		arg.AggregateType,
+		arg.AggregateID,
+		arg.EventType,
+		arg.Payload,
+	)
+	var i Outbox
+	err := row.Scan(
+		&i.ID,
+		&i.AggregateType,
+		&i.AggregateID,
+		&i.EventType,
+		&i.Payload,
+		&i.Attempts,
+		&i.LastError,
+		&i.NextAttemptAt,
+		&i.CreatedAt,
+		&i.PublishedAt,
+	)
+	return i, err
+}
+
+const createPayment = `-- name: CreatePayment :one
+INSERT INTO payments (
+	order_id,
+	amount_in_cents
+) VALUES ($1, $2) RETURNING id, order_id, amount_in_cents, status, gateway_reference, failure_reason, created_at, updated_at, refunded_in_cents
+`
+
+type CreatePaymentParams struct {
+	OrderID       int64 `json:"order_id"`
+	AmountInCents int64 `json:"amount_in_cents"`
+}
+
+func (q *Queries) CreatePayment(ctx context.Context, arg CreatePaymentParams) (Payment, error) {
+	row := q.db.QueryRow(ctx, createPayment, arg.OrderID, arg.AmountInCents)
+	var i Payment
+	err := row.Scan(
+		&i.ID,
+		&i.OrderID,
+		&i.AmountInCents,
+		&i.Status,
+		&i.GatewayReference,
+		&i.FailureReason,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.RefundedInCents,
+	)
+	return i, err
+}
+
+const createProduct = `-- name: CreateProduct :one
+INSERT INTO products (
+	name,
+	price_in_cents
+) VALUES ($1, $2) RETURNING id, name, price_in_cents, quantity, created_at, deleted_at
+`
+
+type CreateProductParams struct {
+	Name         string `json:"name"`
+	PriceInCents int32  `json:"price_in_cents"`
+}
+
+func (q *Queries) CreateProduct(ctx context.Context, arg CreateProductParams) (Product, error) {
+	row := q.db.QueryRow(ctx, createProduct, arg.Name, arg.PriceInCents)
+	var i Product
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.PriceInCents,
+		&i.Quantity,
+		&i.CreatedAt,
+		&i.DeletedAt,
+	)
+	return i, err
+}
+
+const createReturn = `-- name: CreateReturn :one
+INSERT INTO returns (
+	order_id,
+	reason,
+	refund_amount_in_cents
+) VALUES ($1, $2, $3) RETURNING id, order_id, status, reason, refund_amount_in_cents, decided_by, decision_reason, created_at, updated_at
+`
+
+type CreateReturnParams struct {
+	OrderID             int64  `json:"order_id"`
+	Reason              string `json:"reason"`
+	RefundAmountInCents int64  `json:"refund_amount_in_cents"`
+}
+
+func (q *Queries) CreateReturn(ctx context.Context, arg CreateReturnParams) (Return, error) {
+	row := q.db.QueryRow(ctx, createReturn, arg.OrderID, arg.Reason, arg.RefundAmountInCents)
+	var i Return
+	err := row.Scan(
+		&i.ID,
+		&i.OrderID,
+		&i.Status,
+		&i.Reason,
+		&i.RefundAmountInCents,
+		&i.DecidedBy,
+		&i.DecisionReason,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const createReturnItem = `-- name: CreateReturnItem :one
+INSERT INTO return_items (
+	return_id,
+	order_item_id,
+	product_id,
+	quantity,
+	price_cents
+) VALUES ($1, $2, $3, $4, $5) RETURNING id, return_id, order_item_id, product_id, quantity, price_cents
+`
+
+type CreateReturnItemParams struct {
+	ReturnID    int64 `json:"return_id"`
+	OrderItemID int64 `json:"order_item_id"`
+	ProductID   int64 `json:"product_id"`
+	Quantity    int32 `json:"quantity"`
+	PriceCents  int32 `json:"price_cents"`
+}
+
+func (q *Queries) CreateReturnItem(ctx context.Context, arg CreateReturnItemParams) (ReturnItem, error) {
+	row := q.db.QueryRow(ctx, createReturnItem,
+		arg.ReturnID,
+		arg.OrderItemID,
+		arg.ProductID,
+		arg.Quantity,
+		arg.PriceCents,
+	)
+	var i ReturnItem
+	err := row.Scan(
+		&i.ID,
+		&i.ReturnID,
+		&i.OrderItemID,
+		&i.ProductID,
+		&i.Quantity,
+		&i.PriceCents,
+	)
+	return i, err
+}
+
+const createStockLocation = `-- name: CreateStockLocation :one
+INSERT INTO stock_locations (
+	name,
+	priority,
+	shipping_cost_in_cents
+) VALUES ($1, $2, $3) RETURNING id, name, priority, shipping_cost_in_cents, is_default, active, created_at, updated_at
+`
+
+type CreateStockLocationParams struct {
+	Name                string `json:"name"`
+	Priority            int32  `json:"priority"`
+	ShippingCostInCents int32  `json:"shipping_cost_in_cents"`
+}
+
+func (q *Queries) CreateStockLocation(ctx context.Context, arg CreateStockLocationParams) (StockLocation, error) {
+	row := q.db.QueryRow(ctx, createStockLocation, arg.Name, arg.Priority, arg.ShippingCostInCents)
+	var i StockLocation
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Priority,
+		&i.ShippingCostInCents,
+		&i.IsDefault,
+		&i.Active,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const createWebhookDelivery = `-- name: CreateWebhookDelivery :exec
+INSERT INTO webhook_deliveries (
+	subscription_id,
+	event_id,
+	event_type,
+	payload
+) VALUES ($1, $2, $3, $4)
+ON CONFLICT (subscription_id, event_id) DO NOTHING
+`
+
+type CreateWebhookDeliveryParams struct {
+	SubscriptionID int64           `json:"subscription_id"`
+	EventID        int64           `json:"event_id"`
+	EventType      string          `json:"event_type"`
+	Payload        json.RawMessage `json:"payload"`
+}
+
+func (q *Queries) CreateWebhookDelivery(ctx context.Context, arg CreateWebhookDeliveryParams) error {
+	_, err := q.db.Exec(ctx, createWebhookDelivery,
+		arg.SubscriptionID,
+		arg.EventID,
+		arg.EventType,
+		arg.Payload,
+	)
+	return err
+}
+
+const createWebhookDeliveryAttempt = `-- name: CreateWebhookDeliveryAttempt :one
+INSERT INTO webhook_delivery_attempts (
+	delivery_id,
+	status_code,
+	error,
+	duration_ms
+) VALUES ($1, $2, $3, $4) RETURNING id, delivery_id, status_code, error, duration_ms, created_at
+`
+
+type CreateWebhookDeliveryAttemptParams struct {
+	DeliveryID int64       `json:"delivery_id"`
+	StatusCode pgtype.Int4 `json:"status_code"`
+	Error      string      `json:"error"`
+	DurationMs int32       `json:"duration_ms"`
+}
+
+func (q *Queries) CreateWebhookDeliveryAttempt(ctx context.Context, arg CreateWebhookDeliveryAttemptParams) (WebhookDeliveryAttempt, error) {
+	row := q.db.QueryRow(ctx, createWebhookDeliveryAttempt,
+		arg.DeliveryID,
+		arg.StatusCode,
+		arg.Error,
+		arg.DurationMs,
+	)
+	var i WebhookDeliveryAttempt
+	err := row.Scan(
+		&i.ID,
+		&i.DeliveryID,
+		&i.StatusCode,
+		&i.Error,
+		&i.DurationMs,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const createWebhookSubscription = `-- name: CreateWebhookSubscription :one
+INSERT INTO webhook_subscriptions (
+	url,
+	secret,
+	event_types
+) VALUES ($1, $2, $3) RETURNING id, url, secret, event_types, active, created_at, updated_at
+`
+
+type CreateWebhookSubscriptionParams struct {
+	Url        string   `json:"url"`
+	Secret     string   `json:"secret"`
+	EventTypes []string `json:"event_types"`
+}
+
+func (q *Queries) CreateWebhookSubscription(ctx context.Context, arg CreateWebhookSubscriptionParams) (WebhookSubscription, error) {
+	row := q.db.QueryRow(ctx, createWebhookSubscription, arg.Url, arg.Secret, arg.EventTypes)
+	var i WebhookSubscription
+	err := row.Scan(
+		&i.ID,
+		&i.Url,
+		&i.Secret,
+		&i.EventTypes,
+		&i.Active,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const decideReturn = `-- name: DecideReturn :one
+UPDATE returns
+SET
+	status = $2,
+	decided_by = $3,
+	decision_reason = $4,
+	updated_at = now()
+WHERE id = $1 AND status = 'requested' RETURNING id, order_id, status, reason, refund_amount_in_cents, decided_by, decision_reason, created_at, updated_at
+`
+
+type DecideReturnParams struct {
+	ID             int64  `json:"id"`
+	Status         string `json:"status"`
+	DecidedBy      string `json:"decided_by"`
+	DecisionReason string `json:"decision_reason"`
+}
+
+func (q *Queries) DecideReturn(ctx context.Context, arg DecideReturnParams) (Return, error) {
+	row := q.db.QueryRow(ctx, decideReturn,
+		arg.ID,
+		arg.Status,
+		arg.DecidedBy,
+		arg.DecisionReason,
+	)
+	var i Return
+	err := row.Scan(
+		&i.ID,
+		&i.OrderID,
+		&i.Status,
+		&i.Reason,
+		&i.RefundAmountInCents,
+		&i.DecidedBy,
+		&i.DecisionReason,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const deleteCartItem = `-- name: DeleteCartItem :execrows
+DELETE FROM cart_items
+WHERE cart_id = $1 AND product_id = $2
+`
+
+type DeleteCartItemParams struct {
+	CartID    int64 `json:"cart_id"`
+	ProductID int64 `json:"product_id"`
+}
+
+func (q *Queries) DeleteCartItem(ctx context.Context, arg DeleteCartItemParams) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteCartItem, arg.CartID, arg.ProductID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteCustomerAddress = `-- name: DeleteCustomerAddress :execrows
+DELETE FROM customer_addresses
+WHERE id = $1 AND customer_id = $2
+`
+
+type DeleteCustomerAddressParams struct {
+	ID         int64 `json:"id"`
+	CustomerID int64 `json:"customer_id"`
+}
+
+func (q *Queries) DeleteCustomerAddress(ctx context.Context, arg DeleteCustomerAddressParams) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteCustomerAddress, arg.ID, arg.CustomerID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteIdempotencyKey = `-- name: DeleteIdempotencyKey :exec
+DELETE FROM idempotency_keys
+WHERE key = $1 AND scope = $2
+`
+
+type DeleteIdempotencyKeyParams struct {
+	Key   string `json:"key"`
+	Scope string `json:"scope"`
+}
+
+func (q *Queries) DeleteIdempotencyKey(ctx context.Context, arg DeleteIdempotencyKeyParams) error {
+	_, err := q.db.Exec(ctx, deleteIdempotencyKey, arg.Key, arg.Scope)
+	return err
+}
+
+const deleteIdleRateLimitBuckets = `-- name: DeleteIdleRateLimitBuckets :execrows
+DELETE FROM rate_limit_buckets
+WHERE updated_at < $1
+`
+
+func (q *Queries) DeleteIdleRateLimitBuckets(ctx context.Context, idleSince pgtype.Timestamptz) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteIdleRateLimitBuckets, idleSince)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteWebhookSubscription = `-- name: DeleteWebhookSubscription :execrows
+DELETE FROM webhook_subscriptions
+WHERE id = $1
+`
+
+func (q *Queries) DeleteWebhookSubscription(ctx context.Context, id int64) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteWebhookSubscription, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const expirePendingPayments = `-- name: ExpirePendingPayments :execrows
+UPDATE payments
+SET
+	status = 'failed',
+	failure_reason = 'payment expired',
+	updated_at = now()
+WHERE
+	status = 'pending'
+	AND updated_at < $1
+`
+
+// Fails the payments left pending by a request that never got an answer from
+// the gateway, which would block the next payment of their order.
+func (q *Queries) ExpirePendingPayments(ctx context.Context, pendingBefore pgtype.Timestamptz) (int64, error) {
+	result, err := q.db.Exec(ctx, expirePendingPayments, pendingBefore)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const findActiveApiKeyByHash = `-- name: FindActiveApiKeyByHash :one
+SELECT
+	id, name, key_prefix, key_hash, created_at, revoked_at
+FROM
+	api_keys
+WHERE
+	key_hash = $1 AND revoked_at IS NULL
+`
+
+func (q *Queries) FindActiveApiKeyByHash(ctx context.Context, keyHash string) (ApiKey, error) {
+	row := q.db.QueryRow(ctx, findActiveApiKeyByHash, keyHash)
+	var i ApiKey
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.KeyPrefix,
+		&i.KeyHash,
+		&i.CreatedAt,
+		&i.RevokedAt,
+	)
+	return i, err
+}
+
+const findCapturedPayment = `-- name: FindCapturedPayment :one
+SELECT
+	id, order_id, amount_in_cents, status, gateway_reference, failure_reason, created_at, updated_at, refunded_in_cents
+FROM
+	payments
+WHERE
+	order_id = $1 AND status = 'captured'
+`
+
+func (q *Queries) FindCapturedPayment(ctx context.Context, orderID int64) (Payment, error) {
+	row := q.db.QueryRow(ctx, findCapturedPayment, orderID)
+	var i Payment
+	err := row.Scan(
+		&i.ID,
+		&i.OrderID,
+		&i.AmountInCents,
+		&i.Status,
+		&i.GatewayReference,
+		&i.FailureReason,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.RefundedInCents,
+	)
+	return i, err
+}
+
+const findCartById = `-- name: FindCartById :one
+SELECT
+	id, customer_id, status, order_id, expires_at, created_at, updated_at
+FROM
+	carts
+WHERE
+	id = $1
+`
+
+func (q *Queries) FindCartById(ctx context.Context, id int64) (Cart, error) {
+	row := q.db.QueryRow(ctx, findCartById, id)
+	var i Cart
+	err := row.Scan(
+		&i.ID,
+		&i.CustomerID,
+		&i.Status,
+		&i.OrderID,
+		&i.ExpiresAt,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const findCustomerAddress = `-- name: FindCustomerAddress :one
+SELECT
+	id, customer_id, kind, line1, line2, city, state, postal_code, country, created_at
+FROM
+	customer_addresses
+WHERE
+	id = $1 AND customer_id = $2
+`
+
+type FindCustomerAddressParams struct {
+	ID         int64 `json:"id"`
+	CustomerID int64 `json:"customer_id"`
+}
+
+func (q *Queries) FindCustomerAddress(ctx context.Context, arg FindCustomerAddressParams) (CustomerAddress, error) {
+	row := q.db.QueryRow(ctx, findCustomerAddress, arg.ID, arg.CustomerID)
+	var i CustomerAddress
+	err := row.Scan(
+		&i.ID,
+		&i.CustomerID,
+		&i.Kind,
+		&i.Line1,
+		&i.Line2,
+		&i.City,
+		&i.State,
+		&i.PostalCode,
+		&i.Country,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const findCustomerById = `-- name: FindCustomerById :one
+SELECT
+	id, name, email, created_at
+FROM
+	customers
+WHERE
+	id = $1
+`
+
+func (q *Queries) FindCustomerById(ctx context.Context, id int64) (Customer, error) {
+	row := q.db.QueryRow(ctx, findCustomerById, id)
+	var i Customer
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Email,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const findDefaultStockLocation = `-- name: FindDefaultStockLocation :one
+SELECT
+	id, name, priority, shipping_cost_in_cents, is_default, active, created_at, updated_at
+FROM
+	stock_locations
+WHERE
+	is_default
+`
+
+func (q *Queries) FindDefaultStockLocation(ctx context.Context) (StockLocation, error) {
+	row := q.db.QueryRow(ctx, findDefaultStockLocation)
+	var i StockLocation
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Priority,
+		&i.ShippingCostInCents,
+		&i.IsDefault,
+		&i.Active,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const findIdempotencyKey = `-- name: FindIdempotencyKey :one
+SELECT
+	key, scope, fingerprint, status_code, content_type, response_body, created_at, completed_at
+FROM
+	idempotency_keys
+WHERE
+	key = $1 AND scope = $2
+`
+
+type FindIdempotencyKeyParams struct {
+	Key   string `json:"key"`
+	Scope string `json:"scope"`
+}
+
+func (q *Queries) FindIdempotencyKey(ctx context.Context, arg FindIdempotencyKeyParams) (IdempotencyKey, error) {
+	row := q.db.QueryRow(ctx, findIdempotencyKey, arg.Key, arg.Scope)
+	var i IdempotencyKey
+	err := row.Scan(
+		&i.Key,
+		&i.Scope,
+		&i.Fingerprint,
+		&i.StatusCode,
+		&i.ContentType,
+		&i.ResponseBody,
+		&i.CreatedAt,
+		&i.CompletedAt,
+	)
+	return i, err
+}
+
+const findOrderById = `-- name: FindOrderById :many
+SELECT 
+	o.id as order_id,
+	o.customer_id as customer_id,
+	o.created_at as created_at,
+	o.status as status,
+	oi.id as order_item_id,
+	oi.product_id as product_id,
+	oi.quantity as quantity,
+	oi.price_cents as price_cents
+FROM 
+	orders as o
+LEFT JOIN order_items as oi
+	ON o.id = oi.order_id
+WHERE o.id = $1
+`
+
+type FindOrderByIdRow struct {
+	OrderID     int64              `json:"order_id"`
+	CustomerID  int64              `json:"customer_id"`
+	CreatedAt   pgtype.Timestamptz `json:"created_at"`
+	Status      string             `json:"status"`
+	OrderItemID pgtype.Int8        `json:"order_item_id"`
+	ProductID   pgtype.Int8        `json:"product_id"`
+	Quantity    pgtype.Int4        `json:"quantity"`
+	PriceCents  pgtype.Int4        `json:"price_cents"`
+}
+
+func (q *Queries) FindOrderById(ctx context.Context, id int64) ([]FindOrderByIdRow, error) {
+	rows, err := q.db.Query(ctx, findOrderById, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []FindOrderByIdRow
+	for rows.Next() {
+		var i FindOrderByIdRow
+		if err := rows.Scan(
+			&i.OrderID,
+			&i.CustomerID,
+			&i.CreatedAt,
+			&i.Status,
+			&i.OrderItemID,
+			&i.ProductID,
+			&i.Quantity,
+			&i.PriceCents,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const findOrderByIdForUpdate = `-- name: FindOrderByIdForUpdate :one
+SELECT
+	id, customer_id, created_at, status
+FROM
+	orders
+WHERE
+	id = $1
+FOR UPDATE
+`
+
+func (q *Queries) FindOrderByIdForUpdate(ctx context.Context, id int64) (Order, error) {
+	row := q.db.QueryRow(ctx, findOrderByIdForUpdate, id)
+	var i Order
+	err := row.Scan(
+		&i.ID,
+		&i.CustomerID,
+		&i.CreatedAt,
+		&i.Status,
+	)
+	return i, err
+}
+
+const findProductById = `-- name: FindProductById :one
+SELECT
+    id, name, price_in_cents, quantity, created_at, deleted_at
+FROM
+    products
+WHERE
+    id = $1
+`
+
+func (q *Queries) FindProductById(ctx context.Context, id int64) (Product, error) {
+	row := q.db.QueryRow(ctx, findProductById, id)
+	var i Product
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.PriceInCents,
+		&i.Quantity,
+		&i.CreatedAt,
+		&i.DeletedAt,
+	)
+	return i, err
+}
+
+const findProductByIdForUpdate = `-- name: FindProductByIdForUpdate :one
+SELECT
+    id, name, price_in_cents, quantity, created_at, deleted_at
+FROM
+    products
+WHERE
+    id = $1
+FOR UPDATE
+`
+
+func (q *Queries) FindProductByIdForUpdate(ctx context.Context, id int64) (Product, error) {
+	row := q.db.QueryRow(ctx, findProductByIdForUpdate, id)
+	var i Product
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.PriceInCents,
+		&i.Quantity,
+		&i.CreatedAt,
+		&i.DeletedAt,
+	)
+	return i, err
+}
+
+const findReturnById = `-- name: FindReturnById :one
+SELECT
+	id, order_id, status, reason, refund_amount_in_cents, decided_by, decision_reason, created_at, updated_at
+FROM
+	returns
+WHERE
+	id = $1
+`
+
+func (q *Queries) FindReturnById(ctx context.Context, id int64) (Return, error) {
+	row := q.db.QueryRow(ctx, findReturnById, id)
+	var i Return
+	err := row.Scan(
+		&i.ID,
+		&i.OrderID,
+		&i.Status,
+		&i.Reason,
+		&i.RefundAmountInCents,
+		&i.DecidedBy,
+		&i.DecisionReason,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const findStockLocationById = `-- name: FindStockLocationById :one
+SELECT
+	id, name, priority, shipping_cost_in_cents, is_default, active, created_at, updated_at
+FROM
+	stock_locations
+WHERE
+	id = $1
+`
+
+func (q *Queries) FindStockLocationById(ctx context.Context, id int64) (StockLocation, error) {
+	row := q.db.QueryRow(ctx, findStockLocationById, id)
+	var i StockLocation
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Priority,
+		&i.ShippingCostInCents,
+		&i.IsDefault,
+		&i.Active,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const findWebhookDeliveryById = `-- name: FindWebhookDeliveryById :one
+SELECT
+	id, subscription_id, event_id, event_type, payload, status, attempts, next_attempt_at, last_status_code, last_error, created_at, updated_at, delivered_at
+FROM
+	webhook_deliveries
+WHERE
+	id = $1 AND subscription_id = $2
+`
+
+type FindWebhookDeliveryByIdParams struct {
+	ID             int64 `json:"id"`
+	SubscriptionID int64 `json:"subscription_id"`
+}
+
+func (q *Queries) FindWebhookDeliveryById(ctx context.Context, arg FindWebhookDeliveryByIdParams) (WebhookDelivery, error) {
+	row := q.db.QueryRow(ctx, findWebhookDeliveryById, arg.ID, arg.SubscriptionID)
+	var i WebhookDelivery
+	err := row.Scan(
+		&i.ID,
+		&i.SubscriptionID,
+		&i.EventID,
+		&i.EventType,
+		&i.Payload,
+		&i.Status,
+		&i.Attempts,
+		&i.NextAttemptAt,
+		&i.LastStatusCode,
+		&i.LastError,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.DeliveredAt,
+	)
+	return i, err
+}
+
+const findWebhookSubscriptionById = `-- name: FindWebhookSubscriptionById :one
+SELECT
+	id, url, secret, event_types, active, created_at, updated_at
+FROM
+	webhook_subscriptions
+WHERE
+	id = $1
+`
+
+func (q *Queries) FindWebhookSubscriptionById(ctx context.Context, id int64) (WebhookSubscription, error) {
+	row := q.db.QueryRow(ctx, findWebhookSubscriptionById, id)
+	var i WebhookSubscription
+	err := row.Scan(
+		&i.ID,
+		&i.Url,
+		&i.Secret,
+		&i.EventTypes,
+		&i.Active,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const listCartItems = `-- name: ListCartItems :many
+SELECT
+	ci.id as id,
+	ci.product_id as product_id,
+	ci.quantity as quantity,
+	p.name as name,
+	p.price_in_cents as price_in_cents,
+	p.quantity as available_quantity,
+	p.deleted_at as deleted_at
+FROM
+	cart_items as ci
+JOIN products as p
+	ON p.id = ci.product_id
+WHERE ci.cart_id = $1
+ORDER BY ci.id
+`
+
+type ListCartItemsRow struct {
+	ID                int64              `json:"id"`
+	ProductID         int64              `json:"product_id"`
+	Quantity          int32              `json:"quantity"`
+	Name              string             `json:"name"`
+	PriceInCents      int32              `json:"price_in_cents"`
+	AvailableQuantity int32              `json:"available_quantity"`
+	DeletedAt         pgtype.Timestamptz `json:"deleted_at"`
+}
+
+func (q *Queries) ListCartItems(ctx context.Context, cartID int64) ([]ListCartItemsRow, error) {
+	rows, err := q.db.Query(ctx, listCartItems, cartID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListCartItemsRow
+	for rows.Next() {
+		var i ListCartItemsRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.ProductID,
+			&i.Quantity,
+			&i.Name,
+			&i.PriceInCents,
+			&i.AvailableQuantity,
+			&i.DeletedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listCustomerAddresses = `-- name: ListCustomerAddresses :many
+SELECT
+	id, customer_id, kind, line1, line2, city, state, postal_code, country, created_at
+FROM
+	customer_addresses
+WHERE
+	customer_id = $1
+ORDER BY id
+`
+
+func (q *Queries) ListCustomerAddresses(ctx context.Context, customerID int64) ([]CustomerAddress, error) {
+	rows, err := q.db.Query(ctx, listCustomerAddresses, customerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []CustomerAddress
+	for rows.Next() {
+		var i CustomerAddress
+		if err := rows.Scan(
+			&i.ID,
+			&i.CustomerID,
+			&i.Kind,
+			&i.Line1,
+			&i.Line2,
+			&i.City,
+			&i.State,
+			&i.PostalCode,
+			&i.Country,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listExpiredAuthorizedPayments = `-- name: ListExpiredAuthorizedPayments :many
+SELECT
+	id, order_id, amount_in_cents, status, gateway_reference, failure_reason, created_at, updated_at, refunded_in_cents
+FROM
+	payments
+WHERE
+	status = 'authorized'
+	AND updated_at < $1
+ORDER BY
+	id
+LIMIT $2
+`
+
+type ListExpiredAuthorizedPaymentsParams struct {
+	AuthorizedBefore pgtype.Timestamptz `json:"authorized_before"`
+	RowLimit         int32              `json:"row_limit"`
+}
+
+// Lists the payments left authorized by a request that crashed before
+// capturing them, which hold the money of the customer and block the next
+// payment of their order until they are voided.
+func (q *Queries) ListExpiredAuthorizedPayments(ctx context.Context, arg ListExpiredAuthorizedPaymentsParams) ([]Payment, error) {
+	rows, err := q.db.Query(ctx, listExpiredAuthorizedPayments, arg.AuthorizedBefore, arg.RowLimit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Payment
+	for rows.Next() {
+		var i Payment
+		if err := rows.Scan(
+			&i.ID,
+			&i.OrderID,
+			&i.AmountInCents,
+			&i.Status,
+			&i.GatewayReference,
+			&i.FailureReason,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.RefundedInCents,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listInventoryMovements = `-- name: ListInventoryMovements :many
+SELECT
+	id, product_id, delta, quantity, reason, order_id, created_at, location_id
+FROM
+	inventory_movements
+WHERE
+	product_id = $1
+	AND ($2::bigint IS NULL OR id < $2)
+ORDER BY id DESC
+LIMIT $3
+`
+
+type ListInventoryMovementsParams struct {
+	ProductID int64       `json:"product_id"`
+	CursorID  pgtype.Int8 `json:"cursor_id"`
+	RowLimit  int32       `json:"row_limit"`
+}
+
+func (q *Queries) ListInventoryMovements(ctx context.Context, arg ListInventoryMovementsParams) ([]InventoryMovement, error) {
+	rows, err := q.db.Query(ctx, listInventoryMovements, arg.ProductID, arg.CursorID, arg.RowLimit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []InventoryMovement
+	for rows.Next() {
+		var i InventoryMovement
+		if err := rows.Scan(
+			&i.ID,
+			&i.ProductID,
+			&i.Delta,
+			&i.Quantity,
+			&i.Reason,
+			&i.OrderID,
+			&i.CreatedAt,
+			&i.LocationID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listOrderAddresses = `-- name: ListOrderAddresses :many
+SELECT
+	order_id, kind, line1, line2, city, state, postal_code, country
+FROM
+	order_addresses
+WHERE
+	order_id = $1
+ORDER BY kind
+`
+
+func (q *Queries) ListOrderAddresses(ctx context.Context, orderID int64) ([]OrderAddress, error) {
+	rows, err := q.db.Query(ctx, listOrderAddresses, orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []OrderAddress
+	for rows.Next() {
+		var i OrderAddress
+		if err := rows.Scan(
+			&i.OrderID,
+			&i.Kind,
+			&i.Line1,
+			&i.Line2,
+			&i.City,
+			&i.State,
+			&i.PostalCode,
+			&i.Country,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listOrderItemAllocations = `-- name: ListOrderItemAllocations :many
+SELECT
+	a.id,
+	a.order_item_id,
+	oi.product_id,
+	a.location_id,
+	a.quantity
+FROM
+	order_item_allocations as a
+JOIN order_items as oi
+	ON oi.id = a.order_item_id
+WHERE
+	oi.order_id = $1
+ORDER BY oi.product_id, a.id
+`
+
+type ListOrderItemAllocationsRow struct {
+	ID          int64 `json:"id"`
+	OrderItemID int64 `json:"order_item_id"`
+	ProductID   int64 `json:"product_id"`
+	LocationID  int64 `json:"location_id"`
+	Quantity    int32 `json:"quantity"`
+}
+
+func (q *Queries) ListOrderItemAllocations(ctx context.Context, orderID int64) ([]ListOrderItemAllocationsRow, error) {
+	rows, err := q.db.Query(ctx, listOrderItemAllocations, orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListOrderItemAllocationsRow
+	for rows.Next() {
+		var i ListOrderItemAllocationsRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.OrderItemID,
+			&i.ProductID,
+			&i.LocationID,
+			&i.Quantity,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listOrderItems = `-- name: ListOrderItems :many
+SELECT
+	id, order_id, product_id, quantity, price_cents
+FROM
+	order_items
+WHERE
+	order_id = $1
+ORDER BY product_id, id
+`
+
+func (q *Queries) ListOrderItems(ctx context.Context, orderID int64) ([]OrderItem, error) {
+	rows, err := q.db.Query(ctx, listOrderItems, orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []OrderItem
+	for rows.Next() {
+		var i OrderItem
+		if err := rows.Scan(
+			&i.ID,
+			&i.OrderID,
+			&i.ProductID,
+			&i.Quantity,
+			&i.PriceCents,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listOrderPayments = `-- name: ListOrderPayments :many
+SELECT
+	id, order_id, amount_in_cents, status, gateway_reference, failure_reason, created_at, updated_at, refunded_in_cents
+FROM
+	payments
+WHERE
+	order_id = $1
+ORDER BY id
+`
+
+func (q *Queries) ListOrderPayments(ctx context.Context, orderID int64) ([]Payment, error) {
+	rows, err := q.db.Query(ctx, listOrderPayments, orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Payment
+	for rows.Next() {
+		var i Payment
+		if err := rows.Scan(
+			&i.ID,
+			&i.OrderID,
+			&i.AmountInCents,
+			&i.Status,
+			&i.GatewayReference,
+			&i.FailureReason,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.RefundedInCents,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listOrderReturns = `-- name: ListOrderReturns :many
+SELECT
+	id, order_id, status, reason, refund_amount_in_cents, decided_by, decision_reason, created_at, updated_at
+FROM
+	returns
+WHERE
+	order_id = $1
+ORDER BY id
+`
+
+func (q *Queries) ListOrderReturns(ctx context.Context, orderID int64) ([]Return, error) {
+	rows, err := q.db.Query(ctx, listOrderReturns, orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Return
+	for rows.Next() {
+		var i Return
+		if err := rows.Scan(
+			&i.ID,
+			&i.OrderID,
+			&i.Status,
+			&i.Reason,
+			&i.RefundAmountInCents,
+			&i.DecidedBy,
+			&i.DecisionReason,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listOrderStatusChanges = `-- name: ListOrderStatusChanges :many
+SELECT
+	id, order_id, from_status, to_status, changed_by, reason, created_at
+FROM
+	order_status_changes
+WHERE
+	order_id = $1
+ORDER BY id
+`
+
+func (q *Queries) ListOrderStatusChanges(ctx context.Context, orderID int64) ([]OrderStatusChange, error) {
+	rows, err := q.db.Query(ctx, listOrderStatusChanges, orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []OrderStatusChange
+	for rows.Next() {
+		var i OrderStatusChange
+		if err := rows.Scan(
+			&i.ID,
+			&i.OrderID,
+			&i.FromStatus,
+			&i.ToStatus,
+			&i.ChangedBy,
+			&i.Reason,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listOrders = `-- name: ListOrders :many
+SELECT
+	o.id as id,
+	o.customer_id as customer_id,
+	o.created_at as created_at,
+	o.status as status,
+	COALESCE(SUM(oi.quantity::bigint * oi.price_cents), 0)::bigint as total_price_in_cents
+FROM
+	orders as o
+LEFT JOIN order_items as oi
+	ON o.id = oi.order_id
+WHERE
+	($1::bigint IS NULL OR o.customer_id = $1)
+	AND ($2::text IS NULL OR o.status = $2)
+	AND ($3::timestamptz IS NULL OR o.created_at >= $3)
+	AND ($4::timestamptz IS NULL OR o.created_at < $4)
+	AND ($5::bigint IS NULL OR o.id < $5)
+GROUP BY o.id
+HAVING
+	($6::bigint IS NULL OR COALESCE(SUM(oi.quantity::bigint * oi.price_cents), 0) >= $6)
+ORDER BY o.id DESC
+LIMIT $7
+`
+
+type ListOrdersParams struct {
+	CustomerID  pgtype.Int8        `json:"customer_id"`
+	Status      pgtype.Text        `json:"status"`
+	CreatedFrom pgtype.Timestamptz `json:"created_from"`
+	CreatedTo   pgtype.Timestamptz `json:"created_to"`
+	CursorID    pgtype.Int8        `json:"cursor_id"`
+	MinTotal    pgtype.Int8        `json:"min_total"`
+	RowLimit    int32              `json:"row_limit"`
+}
+
+type ListOrdersRow struct {
+	ID                int64              `json:"id"`
+	CustomerID        int64              `json:"customer_id"`
+	CreatedAt         pgtype.Timestamptz `json:"created_at"`
+	Status            string             `json:"status"`
+	TotalPriceInCents int64              `json:"total_price_in_cents"`
+}
+
+func (q *Queries) ListOrders(ctx context.Context, arg ListOrdersParams) ([]ListOrdersRow, error) {
+	rows, err := q.db.Query(ctx, listOrders,
+		arg.CustomerID,
+		arg.Status,
+		arg.CreatedFrom,
+		arg.CreatedTo,
+		arg.CursorID,
+		arg.MinTotal,
+		arg.RowLimit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListOrdersRow
+	for rows.Next() {
+		var i ListOrdersRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.CustomerID,
+			&i.CreatedAt,
+			&i.Status,
+			&i.TotalPriceInCents,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listOrdersWithFailedPayment = `-- name: ListOrdersWithFailedPayment :many
+SELECT
+	o.id
+FROM
+	orders as o
+JOIN payments as p
+	ON p.order_id = o.id
+WHERE
+	o.status = 'pending'
+	AND p.status = 'failed'
+	AND p.updated_at < $1
+	AND NOT EXISTS (
+		SELECT 1 FROM payments as newer WHERE newer.order_id = o.id AND newer.id > p.id
+	)
+ORDER BY o.id
+LIMIT $2
+`
+
+type ListOrdersWithFailedPaymentParams struct {
+	FailedBefore pgtype.Timestamptz `json:"failed_before"`
+	RowLimit     int32              `json:"row_limit"`
+}
+
+func (q *Queries) ListOrdersWithFailedPayment(ctx context.Context, arg ListOrdersWithFailedPaymentParams) ([]int64, error) {
+	rows, err := q.db.Query(ctx, listOrdersWithFailedPayment, arg.FailedBefore, arg.RowLimit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		items = append(items, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listPendingOutboxEvents = `-- name: ListPendingOutboxEvents :many
+SELECT
+	id, aggregate_type, aggregate_id, event_type, payload, attempts, last_error, next_attempt_at, created_at, published_at
+FROM
+	outbox as o
+WHERE
+	o.published_at IS NULL
+	AND o.next_attempt_at <= now()
+	AND NOT EXISTS (
+		SELECT 1
+		FROM outbox as p
+		WHERE p.aggregate_type = o.aggregate_type
+			AND p.aggregate_id = o.aggregate_id
+			AND p.published_at IS NULL
+			AND p.id < o.id
+	)
+ORDER BY o.id
+LIMIT $1
+FOR UPDATE SKIP LOCKED
+`
+
+// Only the oldest unpublished event of every aggregate is due, so the events
+// of an aggregate are published in order and a failing one holds the rest.
+func (q *Queries) ListPendingOutboxEvents(ctx context.Context, rowLimit int32) ([]Outbox, error) {
+	rows, err := q.db.Query(ctx, listPendingOutboxEvents, rowLimit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Outbox
+	for rows.Next() {
+		var i Outbox
+		if err := rows.Scan(
+			&i.ID,
+			&i.AggregateType,
+			&i.AggregateID,
+			&i.EventType,
+			&i.Payload,
+			&i.Attempts,
+			&i.LastError,
+			&i.NextAttemptAt,
+			&i.CreatedAt,
+			&i.PublishedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listProductStock = `-- name: ListProductStock :many
+SELECT
+	s.location_id,
+	l.name,
+	l.priority,
+	l.shipping_cost_in_cents,
+	l.active,
+	s.quantity
+FROM
+	product_stock as s
+JOIN stock_locations as l
+	ON l.id = s.location_id
+WHERE
+	s.product_id = $1
+ORDER BY l.priority, l.id
+`
+
+type ListProductStockRow struct {
+	LocationID          int64  `json:"location_id"`
+	Name                string `json:"name"`
+	Priority            int32  `json:"priority"`
+	ShippingCostInCents int32  `json:"shipping_cost_in_cents"`
+	Active              bool   `json:"active"`
+	Quantity            int32  `json:"quantity"`
+}
+
+func (q *Queries) ListProductStock(ctx context.Context, productID int64) ([]ListProductStockRow, error) {
+	rows, err := q.db.Query(ctx, listProductStock, productID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListProductStockRow
+	for rows.Next() {
+		var i ListProductStockRow
+		if err := rows.Scan(
+			&i.LocationID,
+			&i.Name,
+			&i.Priority,
+			&i.ShippingCostInCents,
+			&i.Active,
+			&i.Quantity,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listProducts = `-- name: ListProducts :many
+SELECT
+    id, name, price_in_cents, quantity, created_at, deleted_at
+FROM
+    products
+WHERE
+    deleted_at IS NULL
+    AND ($1::int IS NULL OR price_in_cents >= $1)
+    AND ($2::int IS NULL OR price_in_cents <= $2)
+    AND (NOT $3::bool OR quantity > 0)
+    AND ($4::text IS NULL OR name LIKE $4 || '%')
+    AND (
+        $5::bigint IS NULL
+        OR ($6::text = 'name' AND NOT $7::bool AND (name, id) > ($8::text, $5))
+        OR ($6::text = 'name' AND $7::bool AND (name, id) < ($8::text, $5))
+        OR ($6::text = 'price_in_cents' AND NOT $7::bool AND (price_in_cents, id) > ($9::int, $5))
+        OR ($6::text = 'price_in_cents' AND $7::bool AND (price_in_cents, id) < ($9::int, $5))
+        OR ($6::text = 'created_at' AND NOT $7::bool AND (created_at, id) > ($10::timestamptz, $5))
+        OR ($6::text = 'created_at' AND $7::bool AND (created_at, id) < ($10::timestamptz, $5))
+    )
+ORDER BY
+    CASE WHEN $6::text = 'name' AND NOT $7::bool THEN name END ASC,
+    CASE WHEN $6::text = 'name' AND $7::bool THEN name END DESC,
+    CASE WHEN $6::text = 'price_in_cents' AND NOT $7::bool THEN price_in_cents END ASC,
+    CASE WHEN $6::text = 'price_in_cents' AND $7::bool THEN price_in_cents END DESC,
+    CASE WHEN $6::text = 'created_at' AND NOT $7::bool THEN created_at END ASC,
+    CASE WHEN $6::text = 'created_at' AND $7::bool THEN created_at END DESC,
+    CASE WHEN NOT $7::bool THEN id END ASC,
+    CASE WHEN $7::bool THEN id END DESC
+LIMIT $11
+`
+
+type ListProductsParams struct {
+	MinPrice        pgtype.Int4        `json:"min_price"`
+	MaxPrice        pgtype.Int4        `json:"max_price"`
+	InStock         bool               `json:"in_stock"`
+	NamePrefix      pgtype.Text        `json:"name_prefix"`
+	CursorID        pgtype.Int8        `json:"cursor_id"`
+	Sort            string             `json:"sort"`
+	Descending      bool               `json:"descending"`
+	CursorName      pgtype.Text        `json:"cursor_name"`
+	CursorPrice     pgtype.Int4        `json:"cursor_price"`
+	CursorCreatedAt pgtype.Timestamptz `json:"cursor_created_at"`
+	RowLimit        int32              `json:"row_limit"`
+}
+
+func (q *Queries) ListProducts(ctx context.Context, arg ListProductsParams) ([]Product, error) {
+	rows, err := q.db.Query(ctx, listProducts,
+		arg.MinPrice,
+		arg.MaxPrice,
+		arg.InStock,
+		arg.NamePrefix,
+		arg.CursorID,
+		arg.Sort,
+		arg.Descending,
+		arg.CursorName,
+		arg.CursorPrice,
+		arg.CursorCreatedAt,
+		arg.RowLimit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Product
+	for rows.Next() {
+		var i Product
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.PriceInCents,
+			&i.Quantity,
+			&i.CreatedAt,
+			&i.DeletedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listRefundingReturns = `-- name: ListRefundingReturns :many
+SELECT
+	id, order_id, status, reason, refund_amount_in_cents, decided_by, decision_reason, created_at, updated_at
+FROM
+	returns
+WHERE
+	status = 'refunding'
+	AND updated_at < $1
+ORDER BY
+	id
+LIMIT $2
+`
+
+type ListRefundingReturnsParams struct {
+	RefundingBefore pgtype.Timestamptz `json:"refunding_before"`
+	RowLimit        int32              `json:"row_limit"`
+}
+
+func (q *Queries) ListRefundingReturns(ctx context.Context, arg ListRefundingReturnsParams) ([]Return, error) {
+	rows, err := q.db.Query(ctx, listRefundingReturns, arg.RefundingBefore, arg.RowLimit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Return
+	for rows.Next() {
+		var i Return
+		if err := rows.Scan(
+			&i.ID,
+			&i.OrderID,
+			&i.Status,
+			&i.Reason,
+			&i.RefundAmountInCents,
+			&i.DecidedBy,
+			&i.DecisionReason,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listReturnItems = `-- name: ListReturnItems :many
+SELECT
+	id, return_id, order_item_id, product_id, quantity, price_cents
+FROM
+	return_items
+WHERE
+	return_id = $1
+ORDER BY id
+`
+
+func (q *Queries) ListReturnItems(ctx context.Context, returnID int64) ([]ReturnItem, error) {
+	rows, err := q.db.Query(ctx, listReturnItems, returnID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ReturnItem
+	for rows.Next() {
+		var i ReturnItem
+		if err := rows.Scan(
+			&i.ID,
+			&i.ReturnID,
+			&i.OrderItemID,
+			&i.ProductID,
+			&i.Quantity,
+			&i.PriceCents,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listReturnedQuantities = `-- name: ListReturnedQuantities :many
+SELECT
+	ri.order_item_id,
+	SUM(ri.quantity)::int as quantity
+FROM
+	return_items as ri
+JOIN returns as r
+	ON r.id = ri.return_id
+WHERE
+	r.order_id = $1 AND r.status <> 'rejected'
+GROUP BY ri.order_item_id
+`
+
+type ListReturnedQuantitiesRow struct {
+	OrderItemID int64 `json:"order_item_id"`
+	Quantity    int32 `json:"quantity"`
+}
+
+func (q *Queries) ListReturnedQuantities(ctx context.Context, orderID int64) ([]ListReturnedQuantitiesRow, error) {
+	rows, err := q.db.Query(ctx, listReturnedQuantities, orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListReturnedQuantitiesRow
+	for rows.Next() {
+		var i ListReturnedQuantitiesRow
+		if err := rows.Scan(&i.OrderItemID, &i.Quantity); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listStockLocations = `-- name: ListStockLocations :many
+SELECT
+	id, name, priority, shipping_cost_in_cents, is_default, active, created_at, updated_at
+FROM
+	stock_locations
+ORDER BY priority, id
+`
+
+func (q *Queries) ListStockLocations(ctx context.Context) ([]StockLocation, error) {
+	rows, err := q.db.Query(ctx, listStockLocations)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []StockLocation
+	for rows.Next() {
+		var i StockLocation
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Priority,
+			&i.ShippingCostInCents,
+			&i.IsDefault,
+			&i.Active,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listWebhookDeliveries = `-- name: ListWebhookDeliveries :many
+SELECT
+	id, subscription_id, event_id, event_type, payload, status, attempts, next_attempt_at, last_status_code, last_error, created_at, updated_at, delivered_at
+FROM
+	webhook_deliveries
+WHERE
+	subscription_id = $1
+	AND ($2::text IS NULL OR status = $2)
+	AND ($3::bigint IS NULL OR id < $3)
+ORDER BY id DESC
+LIMIT $4
+`
+
+type ListWebhookDeliveriesParams struct {
+	SubscriptionID int64       `json:"subscription_id"`
+	Status         pgtype.Text `json:"status"`
+	CursorID       pgtype.Int8 `json:"cursor_id"`
+	RowLimit       int32       `json:"row_limit"`
+}
+
+func (q *Queries) ListWebhookDeliveries(ctx context.Context, arg ListWebhookDeliveriesParams) ([]WebhookDelivery, error) {
+	rows, err := q.db.Query(ctx, listWebhookDeliveries,
+		arg.SubscriptionID,
+		arg.Status,
+		arg.CursorID,
+		arg.RowLimit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []WebhookDelivery
+	for rows.Next() {
+		var i WebhookDelivery
+		if err := rows.Scan(
+			&i.ID,
+			&i.SubscriptionID,
+			&i.EventID,
+			&i.EventType,
+			&i.Payload,
+			&i.Status,
+			&i.Attempts,
+			&i.NextAttemptAt,
+			&i.LastStatusCode,
+			&i.LastError,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.DeliveredAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listWebhookDeliveryAttempts = `-- name: ListWebhookDeliveryAttempts :many
+SELECT
+	id, delivery_id, status_code, error, duration_ms, created_at
+FROM
+	webhook_delivery_attempts
+WHERE
+	delivery_id = $1
+ORDER BY id
+`
+
+func (q *Queries) ListWebhookDeliveryAttempts(ctx context.Context, deliveryID int64) ([]WebhookDeliveryAttempt, error) {
+	rows, err := q.db.Query(ctx, listWebhookDeliveryAttempts, deliveryID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []WebhookDeliveryAttempt
+	for rows.Next() {
+		var i WebhookDeliveryAttempt
+		if err := rows.Scan(
+			&i.ID,
+			&i.DeliveryID,
+			&i.StatusCode,
+			&i.Error,
+			&i.DurationMs,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listWebhookSubscriptions = `-- name: ListWebhookSubscriptions :many
+SELECT
+	id, url, secret, event_types, active, created_at, updated_at
+FROM
+	webhook_subscriptions
+ORDER BY id
+`
+
+func (q *Queries) ListWebhookSubscriptions(ctx context.Context) ([]WebhookSubscription, error) {
+	rows, err := q.db.Query(ctx, listWebhookSubscriptions)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []WebhookSubscription
+	for rows.Next() {
+		var i WebhookSubscription
+		if err := rows.Scan(
+			&i.ID,
+			&i.Url,
+			&i.Secret,
+			&i.EventTypes,
+			&i.Active,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listWebhookSubscriptionsForEvent = `-- name: ListWebhookSubscriptionsForEvent :many
+SELECT
+	id, url, secret, event_types, active, created_at, updated_at
+FROM
+	webhook_subscriptions
+WHERE
+	active AND $1::text = ANY(event_types)
+ORDER BY id
+`
+
+func (q *Queries) ListWebhookSubscriptionsForEvent(ctx context.Context, eventType string) ([]WebhookSubscription, error) {
+	rows, err := q.db.Query(ctx, listWebhookSubscriptionsForEvent, eventType)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []WebhookSubscription
+	for rows.Next() {
+		var i WebhookSubscription
+		if err := rows.Scan(
+			&i.ID,
+			&i.Url,
+			&i.Secret,
+			&i.EventTypes,
+			&i.Active,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const markOutboxEventFailed = `-- name: MarkOutboxEventFailed :exec
+UPDATE outbox
+SET
+	attempts = attempts + 1,
+	last_error = $2,
+	next_attempt_at = $3
+WHERE id = $1
+`
+
+type MarkOutboxEventFailedParams struct {
+	ID            int64              `json:"id"`
+	LastError     string             `json:"last_error"`
+	NextAttemptAt pgtype.Timestamptz `json:"next_attempt_at"`
+}
+
+func (q *Queries) MarkOutboxEventFailed(ctx context.Context, arg MarkOutboxEventFailedParams) error {
+	_, err := q.db.Exec(ctx, markOutboxEventFailed, arg.ID, arg.LastError, arg.NextAttemptAt)
+	return err
+}
+
+const markOutboxEventPublished = `-- name: MarkOutboxEventPublished :exec
+UPDATE outbox
+SET
+	attempts = attempts + 1,
+	published_at = now()
+WHERE id = $1
+`
+
+func (q *Queries) MarkOutboxEventPublished(ctx context.Context, id int64) error {
+	_, err := q.db.Exec(ctx, markOutboxEventPublished, id)
+	return err
+}
+
+const outboxBacklog = `-- name: OutboxBacklog :one
+SELECT
+	count(*) AS pending,
+	min(created_at)::timestamptz AS oldest_created_at
+FROM
+	outbox
+WHERE
+	published_at IS NULL
+`
+
+type OutboxBacklogRow struct {
+	Pending         int64              `json:"pending"`
+	OldestCreatedAt pgtype.Timestamptz `json:"oldest_created_at"`
+}
+
+func (q *Queries) OutboxBacklog(ctx context.Context) (OutboxBacklogRow, error) {
+	row := q.db.QueryRow(ctx, outboxBacklog)
+	var i OutboxBacklogRow
+	err := row.Scan(&i.Pending, &i.OldestCreatedAt)
+	return i, err
+}
+
+const peekRateLimitBucket = `-- name: PeekRateLimitBucket :one
+SELECT
+	LEAST($1::float8, tokens + EXTRACT(EPOCH FROM now() - updated_at)::float8 * $2::float8)::float8 AS tokens
+FROM
+	rate_limit_buckets
+WHERE
+	key = $3
+`
+
+type PeekRateLimitBucketParams struct {
+	Burst float64 `json:"burst"`
+	Rate  float64 `json:"rate"`
+	Key   string  `json:"key"`
+}
+
+// Returns the tokens the bucket would hold after being refilled, without
+// taking any.
+func (q *Queries) PeekRateLimitBucket(ctx context.Context, arg PeekRateLimitBucketParams) (float64, error) {
+	row := q.db.QueryRow(ctx, peekRateLimitBucket, arg.Burst, arg.Rate, arg.Key)
+	var tokens float64
+	err := row.Scan(&tokens)
+	return tokens, err
+}
+
+const reconcileInventory = `-- name: ReconcileInventory :many
+SELECT
+	COALESCE(s.product_id, m.product_id)::bigint as product_id,
+	COALESCE(s.location_id, m.location_id)::bigint as location_id,
+	COALESCE(s.quantity, 0)::int as quantity,
+	COALESCE(m.quantity, 0)::int as ledger_quantity,
+	(COALESCE(s.quantity, 0) - COALESCE(m.quantity, 0))::int as drift
+FROM
+	product_stock as s
+FULL JOIN (
+	SELECT
+		product_id,
+		location_id,
+		SUM(delta) as quantity
+	FROM
+		inventory_movements
+	GROUP BY product_id, location_id
+) as m
+	ON m.product_id = s.product_id AND m.location_id = s.location_id
+WHERE
+	COALESCE(s.quantity, 0) <> COALESCE(m.quantity, 0)
+ORDER BY 1, 2
+`
+
+type ReconcileInventoryRow struct {
+	ProductID      int64 `json:"product_id"`
+	LocationID     int64 `json:"location_id"`
+	Quantity       int32 `json:"quantity"`
+	LedgerQuantity int32 `json:"ledger_quantity"`
+	Drift          int32 `json:"drift"`
+}
+
+func (q *Queries) ReconcileInventory(ctx context.Context) ([]ReconcileInventoryRow, error) {
+	rows, err := q.db.Query(ctx, reconcileInventory)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ReconcileInventoryRow
+	for rows.Next() {
+		var i ReconcileInventoryRow
+		if err := rows.Scan(
+			&i.ProductID,
+			&i.LocationID,
+			&i.Quantity,
+			&i.LedgerQuantity,
+			&i.Drift,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const recordWebhookDeliveryAttempt = `-- name: RecordWebhookDeliveryAttempt :one
+UPDATE webhook_deliveries
+SET
+	status = $2,
+	attempts = attempts + 1,
+	next_attempt_at = $3,
+	last_status_code = $4,
+	last_error = $5,
+	delivered_at = CASE WHEN $2 = 'delivered' THEN now() ELSE delivered_at END,
+	updated_at = now()
+WHERE id = $1 RETURNING id, subscription_id, event_id, event_type, payload, status, attempts, next_attempt_at, last_status_code, last_error, created_at, updated_at, delivered_at
+`
+
+type RecordWebhookDeliveryAttemptParams struct {
+	ID             int64              `json:"id"`
+	Status         string             `json:"status"`
+	NextAttemptAt  pgtype.Timestamptz `json:"next_attempt_at"`
+	LastStatusCode pgtype.Int4        `json:"last_status_code"`
+	LastError      string             `json:"last_error"`
+}
+
+func (q *Queries) RecordWebhookDeliveryAttempt(ctx context.Context, arg RecordWebhookDeliveryAttemptParams) (WebhookDelivery, error) {
+	row := q.db.QueryRow(ctx, recordWebhookDeliveryAttempt,
+		arg.ID,
+		arg.Status,
+		arg.NextAttemptAt,
+		arg.LastStatusCode,
+		arg.LastError,
+	)
+	var i WebhookDelivery
+	err := row.Scan(
+		&i.ID,
+		&i.SubscriptionID,
+		&i.EventID,
+		&i.EventType,
+		&i.Payload,
+		&i.Status,
+		&i.Attempts,
+		&i.NextAttemptAt,
+		&i.LastStatusCode,
+		&i.LastError,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.DeliveredAt,
+	)
+	return i, err
+}
+
+const redriveWebhookDelivery = `-- name: RedriveWebhookDelivery :one
+UPDATE webhook_deliveries
+SET
+	status = 'pending',
+	attempts = 0,
+	next_attempt_at = now(),
+	updated_at = now()
+WHERE id = $1 AND subscription_id = $2 AND status = 'dead' RETURNING id, subscription_id, event_id, event_type, payload, status, attempts, next_attempt_at, last_status_code, last_error, created_at, updated_at, delivered_at
+`
+
+type RedriveWebhookDeliveryParams struct {
+	ID             int64 `json:"id"`
+	SubscriptionID int64 `json:"subscription_id"`
+}
+
+func (q *Queries) RedriveWebhookDelivery(ctx context.Context, arg RedriveWebhookDeliveryParams) (WebhookDelivery, error) {
+	row := q.db.QueryRow(ctx, redriveWebhookDelivery, arg.ID, arg.SubscriptionID)
+	var i WebhookDelivery
+	err := row.Scan(
+		&i.ID,
+		&i.SubscriptionID,
+		&i.EventID,
+		&i.EventType,
+		&i.Payload,
+		&i.Status,
+		&i.Attempts,
+		&i.NextAttemptAt,
+		&i.LastStatusCode,
+		&i.LastError,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.DeliveredAt,
+	)
+	return i, err
+}
+
+const refundPayment = `-- name: RefundPayment :one
+UPDATE payments
+SET
+	refunded_in_cents = refunded_in_cents + $1,
+	status = CASE WHEN refunded_in_cents + $1 = amount_in_cents THEN 'refunded' ELSE status END,
+	updated_at = now()
+WHERE id = $2 AND refunded_in_cents + $1 <= amount_in_cents RETURNING id, order_id, amount_in_cents, status, gateway_reference, failure_reason, created_at, updated_at, refunded_in_cents
+`
+
+type RefundPaymentParams struct {
+	Amount int64 `json:"amount"`
+	ID     int64 `json:"id"`
+}
+
+func (q *Queries) RefundPayment(ctx context.Context, arg RefundPaymentParams) (Payment, error) {
+	row := q.db.QueryRow(ctx, refundPayment, arg.Amount, arg.ID)
+	var i Payment
+	err := row.Scan(
+		&i.ID,
+		&i.OrderID,
+		&i.AmountInCents,
+		&i.Status,
+		&i.GatewayReference,
+		&i.FailureReason,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.RefundedInCents,
+	)
+	return i, err
+}
+
+const removeLocationStock = `-- name: RemoveLocationStock :one
+UPDATE product_stock
+SET
+	quantity = quantity - $1,
+	updated_at = now()
+WHERE product_id = $2 AND location_id = $3 AND quantity >= $1 RETURNING product_id, location_id, quantity, updated_at
+`
+
+type RemoveLocationStockParams struct {
+	Quantity   int32 `json:"quantity"`
+	ProductID  int64 `json:"product_id"`
+	LocationID int64 `json:"location_id"`
+}
+
+func (q *Queries) RemoveLocationStock(ctx context.Context, arg RemoveLocationStockParams) (ProductStock, error) {
+	row := q.db.QueryRow(ctx, removeLocationStock, arg.Quantity, arg.ProductID, arg.LocationID)
+	var i ProductStock
+	err := row.Scan(
+		&i.ProductID,
+		&i.LocationID,
+		&i.Quantity,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const revokeApiKey = `-- name: RevokeApiKey :execrows
+UPDATE api_keys
+SET
+	revoked_at = now()
+WHERE id = $1 AND revoked_at IS NULL
+`
+
+func (q *Queries) RevokeApiKey(ctx context.Context, id int64) (int64, error) {
+	result, err := q.db.Exec(ctx, revokeApiKey, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const setCartOrder = `-- name: SetCartOrder :one
+UPDATE carts
+SET
+	order_id = $2,
+	updated_at = now()
+WHERE id = $1 RETURNING id, customer_id, status, order_id, expires_at, created_at, updated_at
+`
+
+type SetCartOrderParams struct {
+	ID      int64       `json:"id"`
+	OrderID pgtype.Int8 `json:"order_id"`
+}
+
+func (q *Queries) SetCartOrder(ctx context.Context, arg SetCartOrderParams) (Cart, error) {
+	row := q.db.QueryRow(ctx, setCartOrder, arg.ID, arg.OrderID)
+	var i Cart
+	err := row.Scan(
+		&i.ID,
+		&i.CustomerID,
+		&i.Status,
+		&i.OrderID,
+		&i.ExpiresAt,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const softDeleteProduct = `-- name: SoftDeleteProduct :one
+UPDATE products
+SET
+	deleted_at = now()
+WHERE id = $1 AND deleted_at IS NULL RETURNING id, name, price_in_cents, quantity, created_at, deleted_at
+`
+
+func (q *Queries) SoftDeleteProduct(ctx context.Context, id int64) (Product, error) {
+	row := q.db.QueryRow(ctx, softDeleteProduct, id)
+	var i Product
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.PriceInCents,
+		&i.Quantity,
+		&i.CreatedAt,
+		&i.DeletedAt,
+	)
+	return i, err
+}
+
+const takeRateLimitToken = `-- name: TakeRateLimitToken :one
+INSERT INTO rate_limit_buckets AS b (
+	key,
+	tokens,
+	allowed
+) VALUES ($1, $2::float8 - 1, TRUE)
+ON CONFLICT (key) DO UPDATE
+SET
+	tokens = LEAST($2::float8, b.tokens + EXTRACT(EPOCH FROM now() - b.updated_at)::float8 * $3::float8)
+		- CASE WHEN LEAST($2::float8, b.tokens + EXTRACT(EPOCH FROM now() - b.updated_at)::float8 * $3::float8) >= 1 THEN 1 ELSE 0 END,
+	allowed = LEAST($2::float8, b.tokens + EXTRACT(EPOCH FROM now() - b.updated_at)::float8 * $3::float8) >= 1,
+	updated_at = now()
+RETURNING tokens, allowed
+`
+
+type TakeRateLimitTokenParams struct {
+	Key   string  `json:"key"`
+	Burst float64 `json:"burst"`
+	Rate  float64 `json:"rate"`
+}
+
+type TakeRateLimitTokenRow struct {
+	Tokens  float64 `json:"tokens"`
+	Allowed bool    `json:"allowed"`
+}
+
+// Refills the bucket for the time elapsed since its last request and takes a
+// token from it when there is one left.
+func (q *Queries) TakeRateLimitToken(ctx context.Context, arg TakeRateLimitTokenParams) (TakeRateLimitTokenRow, error) {
+	row := q.db.QueryRow(ctx, takeRateLimitToken, arg.Key, arg.Burst, arg.Rate)
+	var i TakeRateLimitTokenRow
+	err := row.Scan(&i.Tokens, &i.Allowed)
+	return i, err
+}
+
+const touchCart = `-- name: TouchCart :one
+UPDATE carts
+SET
+	expires_at = $2,
+	updated_at = now()
+WHERE id = $1 AND status = 'open' AND expires_at > now() RETURNING id, customer_id, status, order_id, expires_at, created_at, updated_at
+`
+
+type TouchCartParams struct {
+	ID        int64              `json:"id"`
+	ExpiresAt pgtype.Timestamptz `json:"expires_at"`
+}
+
+func (q *Queries) TouchCart(ctx context.Context, arg TouchCartParams) (Cart, error) {
+	row := q.db.QueryRow(ctx, touchCart, arg.ID, arg.ExpiresAt)
+	var i Cart
+	err := row.Scan(
+		&i.ID,
+		&i.CustomerID,
+		&i.Status,
+		&i.OrderID,
+		&i.ExpiresAt,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const updateCartItem = `-- name: UpdateCartItem :one
+UPDATE cart_items
+SET
+	quantity = $3
+WHERE cart_id = $1 AND product_id = $2 RETURNING id, cart_id, product_id, quantity, created_at
+`
+
+type UpdateCartItemParams struct {
+	CartID    int64 `json:"cart_id"`
+	ProductID int64 `json:"product_id"`
+	Quantity  int32 `json:"quantity"`
+}
+
+func (q *Queries) UpdateCartItem(ctx context.Context, arg UpdateCartItemParams) (CartItem, error) {
+	row := q.db.QueryRow(ctx, updateCartItem, arg.CartID, arg.ProductID, arg.Quantity)
+	var i CartItem
+	err := row.Scan(
+		&i.ID,
+		&i.CartID,
+		&i.ProductID,
+		&i.Quantity,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const updateCustomer = `-- name: UpdateCustomer :one
+UPDATE customers
+SET
+	name = $2,
+	email = $3
+WHERE id = $1 RETURNING id, name, email, created_at
+`
+
+type UpdateCustomerParams struct {
+	ID    int64  `json:"id"`
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+func (q *Queries) UpdateCustomer(ctx context.Context, arg UpdateCustomerParams) (Customer, error) {
+	row := q.db.QueryRow(ctx, updateCustomer, arg.ID, arg.Name, arg.Email)
+	var i Customer
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Email,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const updateOrderStatus = `-- name: UpdateOrderStatus :one
+UPDATE orders
+SET
+	status = $2
+WHERE id = $1 RETURNING id, customer_id, created_at, status
+`
+
+type UpdateOrderStatusParams struct {
+	ID     int64  `json:"id"`
+	Status string `json:"status"`
+}
+
+func (q *Queries) UpdateOrderStatus(ctx context.Context, arg UpdateOrderStatusParams) (Order, error) {
+	row := q.db.QueryRow(ctx, updateOrderStatus, arg.ID, arg.Status)
+	var i Order
+	err := row.Scan(
+		&i.ID,
+		&i.CustomerID,
+		&i.CreatedAt,
+		&i.Status,
+	)
+	return i, err
+}
+
+const updatePayment = `-- name: UpdatePayment :one
+UPDATE payments
+SET
+	status = $2,
+	gateway_reference = $3,
+	failure_reason = $4,
+	updated_at = now()
+WHERE id = $1 RETURNING id, order_id, amount_in_cents, status, gateway_reference, failure_reason, created_at, updated_at, refunded_in_cents
+`
+
+type UpdatePaymentParams struct {
+	ID               int64  `json:"id"`
+	Status           string `json:"status"`
+	GatewayReference string `json:"gateway_reference"`
+	FailureReason    string `json:"failure_reason"`
+}
+
+func (q *Queries) UpdatePayment(ctx context.Context, arg UpdatePaymentParams) (Payment, error) {
+	row := q.db.QueryRow(ctx, updatePayment,
+		arg.ID,
+		arg.Status,
+		arg.GatewayReference,
+		arg.FailureReason,
+	)
+	var i Payment
+	err := row.Scan(
+		&i.ID,
+		&i.OrderID,
+		&i.AmountInCents,
+		&i.Status,
+		&i.GatewayReference,
+		&i.FailureReason,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.RefundedInCents,
+	)
+	return i, err
+}
+
+const updateProduct = `-- name: UpdateProduct :one
+UPDATE products
+SET
+	name = $2,
+	price_in_cents = $3
+WHERE id = $1 AND deleted_at IS NULL RETURNING id, name, price_in_cents, quantity, created_at, deleted_at
+`
+
+type UpdateProductParams struct {
+	ID           int64  `json:"id"`
+	Name         string `json:"name"`
+	PriceInCents int32  `json:"price_in_cents"`
+}
+
+func (q *Queries) UpdateProduct(ctx context.Context, arg UpdateProductParams) (Product, error) {
+	row := q.db.QueryRow(ctx, updateProduct, arg.ID, arg.Name, arg.PriceInCents)
+	var i Product
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.PriceInCents,
+		&i.Quantity,
+		&i.CreatedAt,
+		&i.DeletedAt,
+	)
+	return i, err
+}
+
+const updateReturnStatus = `-- name: UpdateReturnStatus :one
+UPDATE returns
+SET
+	status = $1,
+	updated_at = now()
+WHERE id = $2 AND status = $3 RETURNING id, order_id, status, reason, refund_amount_in_cents, decided_by, decision_reason, created_at, updated_at
+`
+
+type UpdateReturnStatusParams struct {
+	ToStatus   string `json:"to_status"`
+	ID         int64  `json:"id"`
+	FromStatus string `json:"from_status"`
+}
+
+func (q *Queries) UpdateReturnStatus(ctx context.Context, arg UpdateReturnStatusParams) (Return, error) {
+	row := q.db.QueryRow(ctx, updateReturnStatus, arg.ToStatus, arg.ID, arg.FromStatus)
+	var i Return
+	err := row.Scan(
+		&i.ID,
+		&i.OrderID,
+		&i.Status,
+		&i.Reason,
+		&i.RefundAmountInCents,
+		&i.DecidedBy,
+		&i.DecisionReason,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const updateStockLocation = `-- name: UpdateStockLocation :one
+UPDATE stock_locations
+SET
+	name = $2,
+	priority = $3,
+	shipping_cost_in_cents = $4,
+	active = $5,
+	updated_at = now()
+WHERE id = $1 RETURNING id, name, priority, shipping_cost_in_cents, is_default, active, created_at, updated_at
+`
+
+type UpdateStockLocationParams struct {
+	ID                  int64  `json:"id"`
+	Name                string `json:"name"`
+	Priority            int32  `json:"priority"`
+	ShippingCostInCents int32  `json:"shipping_cost_in_cents"`
+	Active              bool   `json:"active"`
+}
+
+func (q *Queries) UpdateStockLocation(ctx context.Context, arg UpdateStockLocationParams) (StockLocation, error) {
+	row := q.db.QueryRow(ctx, updateStockLocation,
+		arg.ID,
+		arg.Name,
+		arg.Priority,
+		arg.ShippingCostInCents,
+		arg.Active,
+	)
+	var i StockLocation
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Priority,
+		&i.ShippingCostInCents,
+		&i.IsDefault,
+		&i.Active,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const updateWebhookSubscription = `-- name: UpdateWebhookSubscription :one
+UPDATE webhook_subscriptions
+SET
+	url = $2,
+	event_types = $3,
+	active = $4,
+	updated_at = now()
+WHERE id = $1 RETURNING id, url, secret, event_types, active, created_at, updated_at
+`
+
+type UpdateWebhookSubscriptionParams struct {
+	ID         int64    `json:"id"`
+	Url        string   `json:"url"`
+	EventTypes []string `json:"event_types"`
+	Active     bool     `json:"active"`
+}
+
+func (q *Queries) UpdateWebhookSubscription(ctx context.Context, arg UpdateWebhookSubscriptionParams) (WebhookSubscription, error) {
+	row := q.db.QueryRow(ctx, updateWebhookSubscription,
+		arg.ID,
+		arg.Url,
+		arg.EventTypes,
+		arg.Active,
+	)
+	var i WebhookSubscription
+	err := row.Scan(
+		&i.ID,
+		&i.Url,
+		&i.Secret,
+		&i.EventTypes,
+		&i.Active,
 		&i.CreatedAt,
+		&i.UpdatedAt,
 	)
 	return i, err
 }
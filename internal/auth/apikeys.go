@@ -0,0 +1,68 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+
+	repo "github.com/mellomaths/ecommerce-ms/internal/adapters/postgresql/sqlc"
+)
+
+var (
+	ErrAPIKeyNotFound = errors.New("api key not found")
+	ErrInvalidAPIKey  = errors.New("invalid api key")
+)
+
+// apiKeyPrefix starts every API key, so leaked keys are easy to spot.
+const apiKeyPrefix = "ak_"
+
+// CreatedAPIKey is a new API key, the only time the key itself is known: only
+// its hash is stored.
+type CreatedAPIKey struct {
+	repo.ApiKey
+	Key string `json:"key"`
+}
+
+// CreateAPIKey generates an API key for a service.
+func CreateAPIKey(ctx context.Context, q repo.Querier, name string) (CreatedAPIKey, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return CreatedAPIKey{}, ErrInvalidAPIKey
+	}
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return CreatedAPIKey{}, err
+	}
+	key := apiKeyPrefix + hex.EncodeToString(b)
+	apiKey, err := q.CreateApiKey(ctx, repo.CreateApiKeyParams{
+		Name:      name,
+		KeyPrefix: key[:len(apiKeyPrefix)+8],
+		KeyHash:   HashAPIKey(key),
+	})
+	if err != nil {
+		return CreatedAPIKey{}, err
+	}
+	return CreatedAPIKey{ApiKey: apiKey, Key: key}, nil
+}
+
+// RevokeAPIKey stops an API key from authenticating any further request.
+func RevokeAPIKey(ctx context.Context, q repo.Querier, id int64) error {
+	revoked, err := q.RevokeApiKey(ctx, id)
+	if err != nil {
+		return err
+	}
+	if revoked == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+// HashAPIKey is the hash an API key is stored and looked up by. The keys are
+// random, so a plain SHA-256 is enough to keep them from being recovered.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,175 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+var ErrInvalidToken = errors.New("invalid token")
+
+// Signing algorithms accepted for the tokens.
+const (
+	AlgHS256 = "HS256"
+	AlgRS256 = "RS256"
+)
+
+// JWTConfig is how the tokens are verified. HMACSecret enables HS256 and Keys,
+// by key id, enables RS256. Tokens signed with an algorithm that has no key
+// configured are rejected.
+type JWTConfig struct {
+	Issuer     string
+	Audience   string
+	HMACSecret []byte
+	Keys       map[string]*rsa.PublicKey
+	// Leeway is the clock skew allowed when checking exp and nbf.
+	Leeway time.Duration
+}
+
+// Claims are the registered claims of a token, plus the roles granted to its
+// subject and the customer it acts for.
+type Claims struct {
+	Subject    string   `json:"sub"`
+	Issuer     string   `json:"iss"`
+	Audience   audience `json:"aud"`
+	ExpiresAt  *int64   `json:"exp"`
+	NotBefore  *int64   `json:"nbf"`
+	Roles      []string `json:"roles"`
+	CustomerId int64    `json:"customer_id"`
+}
+
+type header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// audience is the aud claim, which is either a string or an array of strings.
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*a = audience{s}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*a = list
+	return nil
+}
+
+type JWTVerifier struct {
+	config JWTConfig
+	now    func() time.Time
+}
+
+func NewJWTVerifier(config JWTConfig) *JWTVerifier {
+	return &JWTVerifier{config: config, now: time.Now}
+}
+
+// Verify checks the signature and the claims of a compact serialized token.
+// Tokens without an exp claim are rejected, so none is valid forever.
+func (v *JWTVerifier) Verify(token string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Claims{}, fmt.Errorf("%w: malformed", ErrInvalidToken)
+	}
+	var h header
+	if err := decodeSegment(parts[0], &h); err != nil {
+		return Claims{}, fmt.Errorf("%w: malformed header", ErrInvalidToken)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Claims{}, fmt.Errorf("%w: malformed signature", ErrInvalidToken)
+	}
+	if err := v.verifySignature(h, parts[0]+"."+parts[1], signature); err != nil {
+		return Claims{}, err
+	}
+	var c Claims
+	if err := decodeSegment(parts[1], &c); err != nil {
+		return Claims{}, fmt.Errorf("%w: malformed claims", ErrInvalidToken)
+	}
+	if err := v.verifyClaims(c); err != nil {
+		return Claims{}, err
+	}
+	return c, nil
+}
+
+func (v *JWTVerifier) verifySignature(h header, signed string, signature []byte) error {
+	switch h.Alg {
+	case AlgHS256:
+		if len(v.config.HMACSecret) == 0 {
+			break
+		}
+		mac := hmac.New(sha256.New, v.config.HMACSecret)
+		mac.Write([]byte(signed))
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return fmt.Errorf("%w: bad signature", ErrInvalidToken)
+		}
+		return nil
+	case AlgRS256:
+		key, ok := v.rsaKey(h.Kid)
+		if !ok {
+			return fmt.Errorf("%w: unknown key %q", ErrInvalidToken, h.Kid)
+		}
+		digest := sha256.Sum256([]byte(signed))
+		if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+			return fmt.Errorf("%w: bad signature", ErrInvalidToken)
+		}
+		return nil
+	}
+	return fmt.Errorf("%w: unsupported algorithm %q", ErrInvalidToken, h.Alg)
+}
+
+// rsaKey finds the key a token was signed with. Tokens without a key id are
+// only accepted when there is a single key.
+func (v *JWTVerifier) rsaKey(kid string) (*rsa.PublicKey, bool) {
+	if kid == "" && len(v.config.Keys) == 1 {
+		for _, key := range v.config.Keys {
+			return key, true
+		}
+	}
+	key, ok := v.config.Keys[kid]
+	return key, ok && kid != ""
+}
+
+func (v *JWTVerifier) verifyClaims(c Claims) error {
+	now := v.now()
+	if c.Subject == "" {
+		return fmt.Errorf("%w: missing subject", ErrInvalidToken)
+	}
+	if v.config.Issuer != "" && c.Issuer != v.config.Issuer {
+		return fmt.Errorf("%w: unexpected issuer", ErrInvalidToken)
+	}
+	if v.config.Audience != "" && !slices.Contains(c.Audience, v.config.Audience) {
+		return fmt.Errorf("%w: unexpected audience", ErrInvalidToken)
+	}
+	if c.ExpiresAt == nil {
+		return fmt.Errorf("%w: missing expiry", ErrInvalidToken)
+	}
+	if !now.Before(time.Unix(*c.ExpiresAt, 0).Add(v.config.Leeway)) {
+		return fmt.Errorf("%w: expired", ErrInvalidToken)
+	}
+	if c.NotBefore != nil && now.Add(v.config.Leeway).Before(time.Unix(*c.NotBefore, 0)) {
+		return fmt.Errorf("%w: not valid yet", ErrInvalidToken)
+	}
+	return nil
+}
+
+func decodeSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
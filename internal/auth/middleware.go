@@ -0,0 +1,108 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	repo "github.com/mellomaths/ecommerce-ms/internal/adapters/postgresql/sqlc"
+	"github.com/mellomaths/ecommerce-ms/internal/logging"
+	"github.com/mellomaths/ecommerce-ms/internal/responses"
+)
+
+const HeaderAPIKey = "X-API-Key"
+
+type Authenticator struct {
+	verifier *JWTVerifier
+	repo     repo.Querier
+}
+
+// NewAuthenticator authenticates the requests with the tokens accepted by the
+// verifier and the API keys stored in the repo. A nil verifier rejects every
+// token.
+func NewAuthenticator(verifier *JWTVerifier, repo repo.Querier) *Authenticator {
+	return &Authenticator{verifier: verifier, repo: repo}
+}
+
+// Middleware puts the principal of the request into its context. Requests
+// without credentials go through anonymous, those with bad credentials are
+// rejected.
+func (a *Authenticator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var (
+			p   Principal
+			err error
+		)
+		if key := r.Header.Get(HeaderAPIKey); key != "" {
+			p, err = a.authenticateAPIKey(r, key)
+		} else if authorization := r.Header.Get("Authorization"); authorization != "" {
+			p, err = a.authenticateToken(authorization)
+		} else {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if err != nil {
+			logging.FromContext(r.Context()).Info("invalid credentials", "error", err)
+			unauthorized(w, "invalid credentials")
+			return
+		}
+		logging.With(r.Context(), "principal", p.Subject)
+		if p.CustomerId != 0 {
+			logging.With(r.Context(), "customer_id", p.CustomerId)
+		}
+		next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), p)))
+	})
+}
+
+func (a *Authenticator) authenticateToken(authorization string) (Principal, error) {
+	scheme, token, ok := strings.Cut(authorization, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || a.verifier == nil {
+		return Principal{}, ErrInvalidToken
+	}
+	claims, err := a.verifier.Verify(strings.TrimSpace(token))
+	if err != nil {
+		return Principal{}, err
+	}
+	p := Principal{Subject: claims.Subject, Method: MethodJWT, Roles: claims.Roles, CustomerId: claims.CustomerId}
+	if p.HasRole(RoleCustomer) && p.CustomerId <= 0 {
+		return Principal{}, fmt.Errorf("%w: customer without customer_id", ErrInvalidToken)
+	}
+	return p, nil
+}
+
+func (a *Authenticator) authenticateAPIKey(r *http.Request, key string) (Principal, error) {
+	if !strings.HasPrefix(key, apiKeyPrefix) {
+		return Principal{}, ErrInvalidAPIKey
+	}
+	apiKey, err := a.repo.FindActiveApiKeyByHash(r.Context(), HashAPIKey(key))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Principal{}, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return Principal{}, err
+	}
+	return Principal{
+		Subject: "api_key:" + strconv.FormatInt(apiKey.ID, 10),
+		Method:  MethodAPIKey,
+		Roles:   []string{RoleService},
+	}, nil
+}
+
+// Required rejects the anonymous requests.
+func Required(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := PrincipalFrom(r.Context()); !ok {
+			unauthorized(w, "authentication required")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func unauthorized(w http.ResponseWriter, msg string) {
+	w.Header().Set("WWW-Authenticate", `Bearer`)
+	responses.NewJsonErrorResponse(w, http.StatusUnauthorized, "unauthorized", msg)
+}
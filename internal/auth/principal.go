@@ -0,0 +1,34 @@
+package auth
+
+import "context"
+
+// Ways a principal can be authenticated.
+const (
+	MethodJWT    = "jwt"
+	MethodAPIKey = "api_key"
+)
+
+// Principal is the identity behind a request.
+type Principal struct {
+	// Subject is the sub claim of a token, or "api_key:<id>" for an API key.
+	Subject string   `json:"subject"`
+	Method  string   `json:"method"`
+	Roles   []string `json:"roles"`
+	// CustomerId is the customer a principal with the customer role acts
+	// for.
+	CustomerId int64 `json:"customer_id,omitempty"`
+}
+
+type principalKey struct{}
+
+// WithPrincipal returns a copy of ctx carrying the principal.
+func WithPrincipal(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// PrincipalFrom returns the principal of the request, false when it is
+// anonymous.
+func PrincipalFrom(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(Principal)
+	return p, ok
+}
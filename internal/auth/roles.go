@@ -0,0 +1,116 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"slices"
+
+	"github.com/mellomaths/ecommerce-ms/internal/responses"
+)
+
+// Roles granted to the principals. API keys always have the service role.
+const (
+	RoleAdmin    = "admin"
+	RoleStaff    = "staff"
+	RoleCustomer = "customer"
+	RoleService  = "service"
+)
+
+// Permission is an action allowed on the routes of a resource.
+type Permission string
+
+const (
+	PermissionReadAdmin       Permission = "admin:read"
+	PermissionWriteProducts   Permission = "products:write"
+	PermissionReadStock       Permission = "stock:read"
+	PermissionWriteStock      Permission = "stock:write"
+	PermissionCreateCustomers Permission = "customers:create"
+	PermissionReadCustomers   Permission = "customers:read"
+	PermissionWriteCustomers  Permission = "customers:write"
+	PermissionReadOrders      Permission = "orders:read"
+	PermissionPlaceOrders     Permission = "orders:place"
+	PermissionManageOrders    Permission = "orders:manage"
+	PermissionManageReturns   Permission = "returns:manage"
+	PermissionManageWebhooks  Permission = "webhooks:manage"
+)
+
+// rolePermissions are the permissions of every role. The permissions of a
+// customer only apply to its own customer, which the services enforce.
+var rolePermissions = map[string][]Permission{
+	RoleAdmin: {
+		PermissionReadAdmin, PermissionWriteProducts, PermissionReadStock, PermissionWriteStock,
+		PermissionCreateCustomers, PermissionReadCustomers, PermissionWriteCustomers,
+		PermissionReadOrders, PermissionPlaceOrders, PermissionManageOrders,
+		PermissionManageReturns, PermissionManageWebhooks,
+	},
+	RoleStaff: {
+		PermissionReadStock,
+		PermissionCreateCustomers, PermissionReadCustomers, PermissionWriteCustomers,
+		PermissionReadOrders, PermissionPlaceOrders, PermissionManageOrders,
+		PermissionManageReturns,
+	},
+	RoleService: {
+		PermissionReadStock,
+		PermissionCreateCustomers, PermissionReadCustomers,
+		PermissionReadOrders, PermissionPlaceOrders, PermissionManageOrders,
+	},
+	RoleCustomer: {
+		PermissionReadCustomers, PermissionWriteCustomers,
+		PermissionReadOrders, PermissionPlaceOrders,
+	},
+}
+
+func (p Principal) HasRole(role string) bool {
+	return slices.Contains(p.Roles, role)
+}
+
+// Can tells whether any role of the principal grants the permission.
+func (p Principal) Can(permission Permission) bool {
+	for _, role := range p.Roles {
+		if slices.Contains(rolePermissions[role], permission) {
+			return true
+		}
+	}
+	return false
+}
+
+// CustomerFrom returns the customer the request is restricted to, which is the
+// case for principals that have no role besides the customer one. Anonymous
+// requests and background jobs are not restricted.
+func CustomerFrom(ctx context.Context) (int64, bool) {
+	p, ok := PrincipalFrom(ctx)
+	if !ok || !p.HasRole(RoleCustomer) {
+		return 0, false
+	}
+	for _, role := range p.Roles {
+		if role != RoleCustomer {
+			return 0, false
+		}
+	}
+	return p.CustomerId, true
+}
+
+// CanAccessCustomer tells whether the request may see the resources of the
+// customer.
+func CanAccessCustomer(ctx context.Context, customerId int64) bool {
+	id, restricted := CustomerFrom(ctx)
+	return !restricted || id == customerId
+}
+
+// Require rejects the requests whose principal does not have the permission.
+func Require(permission Permission) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			p, ok := PrincipalFrom(r.Context())
+			if !ok {
+				unauthorized(w, "authentication required")
+				return
+			}
+			if !p.Can(permission) {
+				responses.NewJsonErrorResponse(w, http.StatusForbidden, "forbidden", "missing permission "+string(permission))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,169 @@
+package carts
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mellomaths/ecommerce-ms/internal/customers"
+	"github.com/mellomaths/ecommerce-ms/internal/logging"
+	"github.com/mellomaths/ecommerce-ms/internal/orders"
+	"github.com/mellomaths/ecommerce-ms/internal/products"
+	"github.com/mellomaths/ecommerce-ms/internal/requests"
+	"github.com/mellomaths/ecommerce-ms/internal/responses"
+)
+
+type handler struct {
+	service Service
+}
+
+func NewHandler(service Service) *handler {
+	return &handler{
+		service: service,
+	}
+}
+
+func (h *handler) CreateCart(w http.ResponseWriter, r *http.Request) {
+	var cartParams CreateCartParams
+	if err := requests.DecodeJsonBody(r, &cartParams); err != nil {
+		logging.FromContext(r.Context()).Info("invalid request", "error", err)
+		responses.NewJsonErrorResponse(w, http.StatusBadRequest, "validation_error", "invalid cart")
+		return
+	}
+	c, err := h.service.CreateCart(r.Context(), cartParams)
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("request failed", "error", err)
+		writeError(w, err, "unexpected error when creating a new cart")
+		return
+	}
+	responses.NewJsonResponse(w, http.StatusCreated, c)
+}
+
+func (h *handler) FindCartById(w http.ResponseWriter, r *http.Request) {
+	cartId, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		logging.FromContext(r.Context()).Info("invalid request", "error", err)
+		responses.NewJsonErrorResponse(w, http.StatusBadRequest, "validation_error", "invalid cart id")
+		return
+	}
+	c, err := h.service.FindCartById(r.Context(), cartId)
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("request failed", "error", err)
+		writeError(w, err, "unexpected error when finding the cart")
+		return
+	}
+	responses.NewJsonResponse(w, http.StatusOK, c)
+}
+
+func (h *handler) AddItem(w http.ResponseWriter, r *http.Request) {
+	cartId, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		logging.FromContext(r.Context()).Info("invalid request", "error", err)
+		responses.NewJsonErrorResponse(w, http.StatusBadRequest, "validation_error", "invalid cart id")
+		return
+	}
+	var itemParams CartItemParams
+	if err := requests.DecodeJsonBody(r, &itemParams); err != nil {
+		logging.FromContext(r.Context()).Info("invalid request", "error", err)
+		responses.NewJsonErrorResponse(w, http.StatusBadRequest, "validation_error", "invalid cart item")
+		return
+	}
+	c, err := h.service.AddItem(r.Context(), cartId, itemParams)
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("request failed", "error", err)
+		writeError(w, err, "unexpected error when adding the item to the cart")
+		return
+	}
+	responses.NewJsonResponse(w, http.StatusOK, c)
+}
+
+func (h *handler) UpdateItem(w http.ResponseWriter, r *http.Request) {
+	cartId, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		logging.FromContext(r.Context()).Info("invalid request", "error", err)
+		responses.NewJsonErrorResponse(w, http.StatusBadRequest, "validation_error", "invalid cart id")
+		return
+	}
+	productId, err := strconv.ParseInt(chi.URLParam(r, "productId"), 10, 64)
+	if err != nil {
+		logging.FromContext(r.Context()).Info("invalid request", "error", err)
+		responses.NewJsonErrorResponse(w, http.StatusBadRequest, "validation_error", "invalid product id")
+		return
+	}
+	var itemParams UpdateCartItemParams
+	if err := requests.DecodeJsonBody(r, &itemParams); err != nil {
+		logging.FromContext(r.Context()).Info("invalid request", "error", err)
+		responses.NewJsonErrorResponse(w, http.StatusBadRequest, "validation_error", "invalid cart item")
+		return
+	}
+	c, err := h.service.UpdateItem(r.Context(), cartId, productId, itemParams)
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("request failed", "error", err)
+		writeError(w, err, "unexpected error when updating the cart item")
+		return
+	}
+	responses.NewJsonResponse(w, http.StatusOK, c)
+}
+
+func (h *handler) RemoveItem(w http.ResponseWriter, r *http.Request) {
+	cartId, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		logging.FromContext(r.Context()).Info("invalid request", "error", err)
+		responses.NewJsonErrorResponse(w, http.StatusBadRequest, "validation_error", "invalid cart id")
+		return
+	}
+	productId, err := strconv.ParseInt(chi.URLParam(r, "productId"), 10, 64)
+	if err != nil {
+		logging.FromContext(r.Context()).Info("invalid request", "error", err)
+		responses.NewJsonErrorResponse(w, http.StatusBadRequest, "validation_error", "invalid product id")
+		return
+	}
+	c, err := h.service.RemoveItem(r.Context(), cartId, productId)
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("request failed", "error", err)
+		writeError(w, err, "unexpected error when removing the cart item")
+		return
+	}
+	responses.NewJsonResponse(w, http.StatusOK, c)
+}
+
+func (h *handler) Checkout(w http.ResponseWriter, r *http.Request) {
+	cartId, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		logging.FromContext(r.Context()).Info("invalid request", "error", err)
+		responses.NewJsonErrorResponse(w, http.StatusBadRequest, "validation_error", "invalid cart id")
+		return
+	}
+	var checkoutParams CheckoutParams
+	if err := requests.DecodeJsonBody(r, &checkoutParams); err != nil {
+		logging.FromContext(r.Context()).Info("invalid request", "error", err)
+		responses.NewJsonErrorResponse(w, http.StatusBadRequest, "validation_error", "invalid checkout")
+		return
+	}
+	o, err := h.service.Checkout(r.Context(), cartId, checkoutParams)
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("request failed", "error", err)
+		writeError(w, err, "unexpected error when checking out the cart")
+		return
+	}
+	responses.NewJsonResponse(w, http.StatusCreated, o)
+}
+
+func writeError(w http.ResponseWriter, err error, serverErrMsg string) {
+	switch err {
+	case ErrCartNotFound, ErrCartItemNotFound, customers.ErrCustomerNotFound:
+		responses.NewJsonErrorResponse(w, http.StatusNotFound, "not_found", err.Error())
+	case products.ErrProductNotFound, customers.ErrAddressNotFound:
+		responses.NewJsonErrorResponse(w, http.StatusNotFound, "validation_error", err.Error())
+	case ErrInvalidCart, ErrCartEmpty, orders.ErrInvalidOrder:
+		responses.NewJsonErrorResponse(w, http.StatusBadRequest, "validation_error", err.Error())
+	case ErrCartExpired, products.ErrProductDeleted:
+		responses.NewJsonErrorResponse(w, http.StatusGone, "validation_error", err.Error())
+	case ErrCartCheckedOut:
+		responses.NewJsonErrorResponse(w, http.StatusConflict, "cart_checked_out", err.Error())
+	case orders.ErrProductNoStock:
+		responses.NewJsonErrorResponse(w, http.StatusExpectationFailed, "validation_error", err.Error())
+	default:
+		responses.NewJsonErrorResponse(w, http.StatusInternalServerError, "server_error", serverErrMsg)
+	}
+}
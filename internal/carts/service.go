@@ -0,0 +1,345 @@
+package carts
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	repo "github.com/mellomaths/ecommerce-ms/internal/adapters/postgresql/sqlc"
+	"github.com/mellomaths/ecommerce-ms/internal/auth"
+	"github.com/mellomaths/ecommerce-ms/internal/customers"
+	"github.com/mellomaths/ecommerce-ms/internal/orders"
+	"github.com/mellomaths/ecommerce-ms/internal/products"
+	"github.com/mellomaths/ecommerce-ms/internal/tracing"
+)
+
+var (
+	ErrCartNotFound     = errors.New("cart not found")
+	ErrCartItemNotFound = errors.New("cart item not found")
+	ErrCartExpired      = errors.New("cart has expired")
+	ErrCartCheckedOut   = errors.New("cart has already been checked out")
+	ErrCartEmpty        = errors.New("cart is empty")
+	ErrInvalidCart      = errors.New("invalid cart")
+)
+
+const (
+	StatusOpen       = "open"
+	StatusCheckedOut = "checked_out"
+)
+
+// Issues reported on the cart lines when the cart is read.
+const (
+	IssueProductUnavailable = "product_unavailable"
+	IssueOutOfStock         = "out_of_stock"
+	IssueInsufficientStock  = "insufficient_stock"
+)
+
+type CreateCartParams struct {
+	CustomerId int64 `json:"customer_id"`
+}
+
+type CartItemParams struct {
+	ProductId int64 `json:"product_id"`
+	Quantity  int32 `json:"quantity"`
+}
+
+type UpdateCartItemParams struct {
+	Quantity int32 `json:"quantity"`
+}
+
+type CheckoutParams struct {
+	ShippingAddressId int64 `json:"shipping_address_id"`
+}
+
+// CartLine is a cart item validated against the current product price and
+// stock.
+type CartLine struct {
+	ProductId         int64  `json:"product_id"`
+	Name              string `json:"name"`
+	Quantity          int32  `json:"quantity"`
+	PriceInCents      int32  `json:"price_in_cents"`
+	AvailableQuantity int32  `json:"available_quantity"`
+	LineTotalInCents  int64  `json:"line_total_in_cents"`
+	Issue             string `json:"issue,omitempty"`
+}
+
+type CartView struct {
+	repo.Cart
+	Expired           bool       `json:"expired"`
+	Lines             []CartLine `json:"lines"`
+	TotalPriceInCents int64      `json:"total_price_in_cents"`
+	// Valid tells whether the cart can be checked out as it is.
+	Valid bool `json:"valid"`
+}
+
+type Service interface {
+	CreateCart(ctx context.Context, cp CreateCartParams) (repo.Cart, error)
+	FindCartById(ctx context.Context, id int64) (CartView, error)
+	AddItem(ctx context.Context, cartId int64, ip CartItemParams) (CartView, error)
+	UpdateItem(ctx context.Context, cartId int64, productId int64, ip UpdateCartItemParams) (CartView, error)
+	RemoveItem(ctx context.Context, cartId int64, productId int64) (CartView, error)
+	Checkout(ctx context.Context, cartId int64, cp CheckoutParams) (repo.Order, error)
+}
+
+type svc struct {
+	repo          repo.Querier
+	ordersService orders.Service
+	ttl           time.Duration
+}
+
+// NewService creates the carts service, carts expire after ttl without being
+// modified.
+func NewService(repo repo.Querier, os orders.Service, ttl time.Duration) Service {
+	return &svc{repo: repo, ordersService: os, ttl: ttl}
+}
+
+// CreateCart creates a cart for the customer of the request when it is made by
+// a customer, whatever the customer id of the params.
+func (s *svc) CreateCart(ctx context.Context, cp CreateCartParams) (repo.Cart, error) {
+	ctx, span := tracing.Start(ctx, "carts.CreateCart")
+	defer span.End()
+	if customerId, ok := auth.CustomerFrom(ctx); ok {
+		cp.CustomerId = customerId
+	}
+	if _, err := customers.FindCustomer(ctx, s.repo, cp.CustomerId); err != nil {
+		return repo.Cart{}, err
+	}
+	return s.repo.CreateCart(ctx, repo.CreateCartParams{
+		CustomerID: cp.CustomerId,
+		ExpiresAt:  s.expiresAt(),
+	})
+}
+
+func (s *svc) FindCartById(ctx context.Context, id int64) (CartView, error) {
+	ctx, span := tracing.Start(ctx, "carts.FindCartById")
+	defer span.End()
+	cart, err := s.repo.FindCartById(ctx, id)
+	if errors.Is(err, pgx.ErrNoRows) || (err == nil && !auth.CanAccessCustomer(ctx, cart.CustomerID)) {
+		return CartView{}, ErrCartNotFound
+	}
+	if err != nil {
+		return CartView{}, err
+	}
+	items, err := s.repo.ListCartItems(ctx, id)
+	if err != nil {
+		return CartView{}, err
+	}
+	v := CartView{
+		Cart:    cart,
+		Expired: isExpired(cart),
+		Lines:   []CartLine{},
+		Valid:   cart.Status == StatusOpen && !isExpired(cart) && len(items) > 0,
+	}
+	for _, item := range items {
+		line := CartLine{
+			ProductId:         item.ProductID,
+			Name:              item.Name,
+			Quantity:          item.Quantity,
+			PriceInCents:      item.PriceInCents,
+			AvailableQuantity: item.AvailableQuantity,
+			LineTotalInCents:  int64(item.Quantity) * int64(item.PriceInCents),
+		}
+		switch {
+		case item.DeletedAt.Valid:
+			line.Issue = IssueProductUnavailable
+		case item.AvailableQuantity == 0:
+			line.Issue = IssueOutOfStock
+		case item.AvailableQuantity < item.Quantity:
+			line.Issue = IssueInsufficientStock
+		}
+		if line.Issue != "" {
+			v.Valid = false
+		}
+		v.Lines = append(v.Lines, line)
+		v.TotalPriceInCents += line.LineTotalInCents
+	}
+	return v, nil
+}
+
+func (s *svc) AddItem(ctx context.Context, cartId int64, ip CartItemParams) (CartView, error) {
+	ctx, span := tracing.Start(ctx, "carts.AddItem")
+	defer span.End()
+	if ip.Quantity <= 0 {
+		return CartView{}, ErrInvalidCart
+	}
+	if err := s.authorize(ctx, cartId); err != nil {
+		return CartView{}, err
+	}
+	product, err := s.repo.FindProductById(ctx, ip.ProductId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return CartView{}, products.ErrProductNotFound
+	}
+	if err != nil {
+		return CartView{}, err
+	}
+	if product.DeletedAt.Valid {
+		return CartView{}, products.ErrProductDeleted
+	}
+	if err := s.touch(ctx, cartId); err != nil {
+		return CartView{}, err
+	}
+	_, err = s.repo.AddCartItem(ctx, repo.AddCartItemParams{
+		CartID:    cartId,
+		ProductID: ip.ProductId,
+		Quantity:  ip.Quantity,
+	})
+	if err != nil {
+		return CartView{}, err
+	}
+	return s.FindCartById(ctx, cartId)
+}
+
+func (s *svc) UpdateItem(ctx context.Context, cartId int64, productId int64, ip UpdateCartItemParams) (CartView, error) {
+	ctx, span := tracing.Start(ctx, "carts.UpdateItem")
+	defer span.End()
+	if ip.Quantity <= 0 {
+		return CartView{}, ErrInvalidCart
+	}
+	if err := s.authorize(ctx, cartId); err != nil {
+		return CartView{}, err
+	}
+	if err := s.touch(ctx, cartId); err != nil {
+		return CartView{}, err
+	}
+	_, err := s.repo.UpdateCartItem(ctx, repo.UpdateCartItemParams{
+		CartID:    cartId,
+		ProductID: productId,
+		Quantity:  ip.Quantity,
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return CartView{}, ErrCartItemNotFound
+	}
+	if err != nil {
+		return CartView{}, err
+	}
+	return s.FindCartById(ctx, cartId)
+}
+
+func (s *svc) RemoveItem(ctx context.Context, cartId int64, productId int64) (CartView, error) {
+	ctx, span := tracing.Start(ctx, "carts.RemoveItem")
+	defer span.End()
+	if err := s.authorize(ctx, cartId); err != nil {
+		return CartView{}, err
+	}
+	if err := s.touch(ctx, cartId); err != nil {
+		return CartView{}, err
+	}
+	deleted, err := s.repo.DeleteCartItem(ctx, repo.DeleteCartItemParams{
+		CartID:    cartId,
+		ProductID: productId,
+	})
+	if err != nil {
+		return CartView{}, err
+	}
+	if deleted == 0 {
+		return CartView{}, ErrCartItemNotFound
+	}
+	return s.FindCartById(ctx, cartId)
+}
+
+// Checkout converts the cart into an order through
+// orders.Service.PlaceOrderWith. The cart is marked as checked out and linked
+// to the order in the transaction of the order, so a refused order leaves the
+// cart open and concurrent checkouts of the same cart cannot place two orders.
+func (s *svc) Checkout(ctx context.Context, cartId int64, cp CheckoutParams) (repo.Order, error) {
+	ctx, span := tracing.Start(ctx, "carts.Checkout")
+	defer span.End()
+	if err := s.authorize(ctx, cartId); err != nil {
+		return repo.Order{}, err
+	}
+	cart, err := s.repo.FindCartById(ctx, cartId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return repo.Order{}, ErrCartNotFound
+	}
+	if err != nil {
+		return repo.Order{}, err
+	}
+	if cart.Status == StatusCheckedOut {
+		return repo.Order{}, ErrCartCheckedOut
+	}
+	if isExpired(cart) {
+		return repo.Order{}, ErrCartExpired
+	}
+	items, err := s.repo.ListCartItems(ctx, cartId)
+	if err != nil {
+		return repo.Order{}, err
+	}
+	if len(items) == 0 {
+		return repo.Order{}, ErrCartEmpty
+	}
+	op := orders.CreateOrderParams{
+		CustomerId:        cart.CustomerID,
+		ShippingAddressId: cp.ShippingAddressId,
+	}
+	for _, item := range items {
+		op.Items = append(op.Items, orders.OrderItemsParams{
+			ProductId: item.ProductID,
+			Quantity:  item.Quantity,
+		})
+	}
+	return s.ordersService.PlaceOrderWith(ctx, op, func(qtx *repo.Queries, order repo.Order) error {
+		if _, err := qtx.CheckoutCart(ctx, cartId); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return s.unavailable(ctx, cartId)
+			}
+			return err
+		}
+		_, err := qtx.SetCartOrder(ctx, repo.SetCartOrderParams{
+			ID:      cartId,
+			OrderID: pgtype.Int8{Int64: order.ID, Valid: true},
+		})
+		return err
+	})
+}
+
+// authorize hides the carts of the other customers when the request is made
+// by a customer.
+func (s *svc) authorize(ctx context.Context, cartId int64) error {
+	customerId, ok := auth.CustomerFrom(ctx)
+	if !ok {
+		return nil
+	}
+	cart, err := s.repo.FindCartById(ctx, cartId)
+	if errors.Is(err, pgx.ErrNoRows) || (err == nil && cart.CustomerID != customerId) {
+		return ErrCartNotFound
+	}
+	return err
+}
+
+// touch extends the expiry of an open cart, it fails if the cart cannot be
+// modified anymore.
+func (s *svc) touch(ctx context.Context, cartId int64) error {
+	_, err := s.repo.TouchCart(ctx, repo.TouchCartParams{
+		ID:        cartId,
+		ExpiresAt: s.expiresAt(),
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return s.unavailable(ctx, cartId)
+	}
+	return err
+}
+
+// unavailable explains why an open, unexpired cart could not be found.
+func (s *svc) unavailable(ctx context.Context, cartId int64) error {
+	cart, err := s.repo.FindCartById(ctx, cartId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrCartNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if cart.Status == StatusCheckedOut {
+		return ErrCartCheckedOut
+	}
+	return ErrCartExpired
+}
+
+func (s *svc) expiresAt() pgtype.Timestamptz {
+	return pgtype.Timestamptz{Time: time.Now().Add(s.ttl), Valid: true}
+}
+
+func isExpired(cart repo.Cart) bool {
+	return cart.Status == StatusOpen && !cart.ExpiresAt.Time.After(time.Now())
+}
@@ -0,0 +1,131 @@
+// Package config loads the configuration of the service from the environment,
+// the .env files and an optional YAML file.
+//
+// Every setting is a field of a section of Config, tagged with its environment
+// variable, its key in the section of the YAML file and its default value.
+// Fields tagged required must be set, fields tagged secret are redacted when
+// printed.
+package config
+
+import "time"
+
+type Config struct {
+	Server      Server      `yaml:"server"`
+	Log         Log         `yaml:"log"`
+	DB          DB          `yaml:"db"`
+	Auth        Auth        `yaml:"auth"`
+	RateLimit   RateLimit   `yaml:"rate_limit"`
+	Idempotency Idempotency `yaml:"idempotency"`
+	Health      Health      `yaml:"health"`
+	Metrics     Metrics     `yaml:"metrics"`
+	Tracing     Tracing     `yaml:"tracing"`
+	Shutdown    Shutdown    `yaml:"shutdown"`
+	Carts       Carts       `yaml:"carts"`
+	Stock       Stock       `yaml:"stock"`
+	Payments    Payments    `yaml:"payments"`
+	Returns     Returns     `yaml:"returns"`
+	Outbox      Outbox      `yaml:"outbox"`
+	Webhooks    Webhooks    `yaml:"webhooks"`
+
+	// sources tells where every setting comes from, by environment variable.
+	sources map[string]string
+}
+
+type Server struct {
+	Addr           string        `env:"SERVER_ADDR" yaml:"addr" default:":3333"`
+	ReadTimeout    time.Duration `env:"SERVER_READ_TIMEOUT" yaml:"read_timeout" default:"10s"`
+	WriteTimeout   time.Duration `env:"SERVER_WRITE_TIMEOUT" yaml:"write_timeout" default:"30s"`
+	IdleTimeout    time.Duration `env:"SERVER_IDLE_TIMEOUT" yaml:"idle_timeout" default:"1m"`
+	RequestTimeout time.Duration `env:"SERVER_REQUEST_TIMEOUT" yaml:"request_timeout" default:"60s"`
+}
+
+type Log struct {
+	Format string `env:"LOG_FORMAT" yaml:"format" default:"text"`
+	Level  string `env:"LOG_LEVEL" yaml:"level" default:"info"`
+}
+
+type DB struct {
+	// DSN is named after the variable goose reads, so both share the .env file.
+	DSN               string        `env:"GOOSE_DBSTRING" yaml:"dsn" required:"true" secret:"true"`
+	MinConns          int32         `env:"DB_MIN_CONNS" yaml:"min_conns" default:"2"`
+	MaxConns          int32         `env:"DB_MAX_CONNS" yaml:"max_conns" default:"10"`
+	MaxConnLifetime   time.Duration `env:"DB_MAX_CONN_LIFETIME" yaml:"max_conn_lifetime" default:"1h"`
+	MaxConnIdleTime   time.Duration `env:"DB_MAX_CONN_IDLE_TIME" yaml:"max_conn_idle_time" default:"30m"`
+	HealthCheckPeriod time.Duration `env:"DB_HEALTH_CHECK_PERIOD" yaml:"health_check_period" default:"1m"`
+}
+
+type Auth struct {
+	JWTIssuer   string        `env:"AUTH_JWT_ISSUER" yaml:"jwt_issuer"`
+	JWTAudience string        `env:"AUTH_JWT_AUDIENCE" yaml:"jwt_audience"`
+	JWTSecret   string        `env:"AUTH_JWT_HS256_SECRET" yaml:"jwt_hs256_secret" secret:"true"`
+	JWKSFile    string        `env:"AUTH_JWKS_FILE" yaml:"jwks_file"`
+	JWTLeeway   time.Duration `env:"AUTH_JWT_LEEWAY" yaml:"jwt_leeway" default:"30s"`
+}
+
+type RateLimit struct {
+	Global        string        `env:"RATE_LIMIT" yaml:"global" default:"300/1m"`
+	PlaceOrder    string        `env:"RATE_LIMIT_PLACE_ORDER" yaml:"place_order" default:"10/1m"`
+	AuthFailures  string        `env:"RATE_LIMIT_AUTH_FAILURES" yaml:"auth_failures" default:"20/1m"`
+	Store         string        `env:"RATE_LIMIT_STORE" yaml:"store" default:"memory"`
+	SweepInterval time.Duration `env:"RATE_LIMIT_SWEEP_INTERVAL" yaml:"sweep_interval" default:"1m"`
+}
+
+type Idempotency struct {
+	LockTimeout time.Duration `env:"IDEMPOTENCY_LOCK_TIMEOUT" yaml:"lock_timeout" default:"5m"`
+}
+
+type Health struct {
+	Timeout      time.Duration `env:"HEALTH_CHECK_TIMEOUT" yaml:"timeout" default:"2s"`
+	OutboxMaxAge time.Duration `env:"HEALTH_OUTBOX_MAX_AGE" yaml:"outbox_max_age" default:"5m"`
+}
+
+type Metrics struct {
+	Enabled bool `env:"METRICS_ENABLED" yaml:"enabled" default:"true"`
+}
+
+type Tracing struct {
+	Exporter    string  `env:"TRACING_EXPORTER" yaml:"exporter" default:"none"`
+	File        string  `env:"TRACING_FILE" yaml:"file"`
+	SampleRatio float64 `env:"TRACING_SAMPLE_RATIO" yaml:"sample_ratio" default:"1"`
+}
+
+type Shutdown struct {
+	DrainTimeout   time.Duration `env:"SHUTDOWN_DRAIN_TIMEOUT" yaml:"drain_timeout" default:"30s"`
+	ReadinessDelay time.Duration `env:"SHUTDOWN_READINESS_DELAY" yaml:"readiness_delay" default:"5s"`
+}
+
+type Carts struct {
+	TTL time.Duration `env:"CART_TTL" yaml:"ttl" default:"168h"`
+}
+
+type Stock struct {
+	AllocationStrategy string `env:"STOCK_ALLOCATION_STRATEGY" yaml:"allocation_strategy" default:"single-location-preferred"`
+}
+
+type Payments struct {
+	// Gateway has no default, so a deployment never charges with the fake
+	// gateway by mistake.
+	Gateway           string        `env:"PAYMENT_GATEWAY" yaml:"gateway" required:"true"`
+	ReservationWindow time.Duration `env:"PAYMENT_RESERVATION_WINDOW" yaml:"reservation_window" default:"30m"`
+	ReleaseInterval   time.Duration `env:"PAYMENT_RELEASE_INTERVAL" yaml:"release_interval" default:"1m"`
+}
+
+type Returns struct {
+	RefundTimeout  time.Duration `env:"RETURN_REFUND_TIMEOUT" yaml:"refund_timeout" default:"5m"`
+	ResumeInterval time.Duration `env:"RETURN_RESUME_INTERVAL" yaml:"resume_interval" default:"1m"`
+}
+
+type Outbox struct {
+	Publishers    []string      `env:"OUTBOX_PUBLISHER" yaml:"publishers" default:"webhooks"`
+	RelayInterval time.Duration `env:"OUTBOX_RELAY_INTERVAL" yaml:"relay_interval" default:"1s"`
+	BatchSize     int32         `env:"OUTBOX_BATCH_SIZE" yaml:"batch_size" default:"100"`
+	MaxBackoff    time.Duration `env:"OUTBOX_MAX_BACKOFF" yaml:"max_backoff" default:"5m"`
+}
+
+type Webhooks struct {
+	DispatchInterval time.Duration `env:"WEBHOOK_DISPATCH_INTERVAL" yaml:"dispatch_interval" default:"1s"`
+	BatchSize        int32         `env:"WEBHOOK_BATCH_SIZE" yaml:"batch_size" default:"20"`
+	MaxAttempts      int32         `env:"WEBHOOK_MAX_ATTEMPTS" yaml:"max_attempts" default:"10"`
+	MaxBackoff       time.Duration `env:"WEBHOOK_MAX_BACKOFF" yaml:"max_backoff" default:"1h"`
+	Timeout          time.Duration `env:"WEBHOOK_TIMEOUT" yaml:"timeout" default:"10s"`
+}
@@ -0,0 +1,266 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Variables locating the files the configuration is loaded from, read from
+// the environment and, for CONFIG_FILE, the .env files.
+const (
+	VarEnvFile    = "ENV_FILE"
+	VarConfigFile = "CONFIG_FILE"
+)
+
+// defaultEnvFile is loaded when ENV_FILE is not set, if it exists.
+const defaultEnvFile = ".env"
+
+// Sources of the settings besides the files, which are named by their path.
+const (
+	SourceDefault     = "default"
+	SourceEnvironment = "environment"
+)
+
+// Load loads the configuration from environ, formatted as by os.Environ, the
+// comma separated list of .env files in ENV_FILE and the YAML file in
+// CONFIG_FILE. The environment takes precedence over the .env files, the
+// later .env files over the earlier ones and the .env files over the YAML
+// file. Empty values are taken as unset. The configuration must then be
+// validated with Validate.
+func Load(environ []string) (Config, error) {
+	env := make(map[string]string)
+	for _, kv := range environ {
+		if key, value, ok := strings.Cut(kv, "="); ok && value != "" {
+			env[key] = value
+		}
+	}
+
+	envFiles, required := []string{defaultEnvFile}, false
+	if v, ok := env[VarEnvFile]; ok {
+		envFiles, required = splitList(v), true
+	}
+	dotenv := make(map[string]string)
+	dotenvSource := make(map[string]string)
+	for _, path := range envFiles {
+		vars, err := readEnvFile(path)
+		if errors.Is(err, fs.ErrNotExist) && !required {
+			continue
+		}
+		if err != nil {
+			return Config{}, err
+		}
+		for key, value := range vars {
+			dotenv[key], dotenvSource[key] = value, path
+		}
+	}
+
+	var file map[string]string
+	configFile := env[VarConfigFile]
+	if configFile == "" {
+		configFile = dotenv[VarConfigFile]
+	}
+	if configFile != "" {
+		var err error
+		if file, err = readYAMLFile(configFile); err != nil {
+			return Config{}, err
+		}
+	}
+
+	c := Config{sources: make(map[string]string)}
+	var errs []error
+	known := make(map[string]bool)
+	for _, s := range settings(&c) {
+		known[s.key] = true
+		value, source := s.def, SourceDefault
+		if v, ok := file[s.key]; ok {
+			value, source = v, configFile
+		}
+		if v, ok := dotenv[s.env]; ok && v != "" {
+			value, source = v, dotenvSource[s.env]
+		}
+		if v, ok := env[s.env]; ok {
+			value, source = v, SourceEnvironment
+		}
+		if err := set(s.value, value); err != nil {
+			errs = append(errs, fmt.Errorf("%s (%s): invalid value %q: %w", s.env, source, value, err))
+		}
+		c.sources[s.env] = source
+	}
+	for key := range file {
+		if !known[key] {
+			errs = append(errs, fmt.Errorf("%s: unknown setting %s", configFile, key))
+		}
+	}
+	return c, errors.Join(errs...)
+}
+
+// Validate checks that the required settings are set.
+func (c Config) Validate() error {
+	var errs []error
+	for _, s := range settings(&c) {
+		if s.required && s.value.IsZero() {
+			errs = append(errs, fmt.Errorf("%s is required", s.env))
+		}
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		errs = append(errs, errors.New("TRACING_SAMPLE_RATIO must be between 0 and 1"))
+	}
+	return errors.Join(errs...)
+}
+
+// setting is a field of a section of Config.
+type setting struct {
+	env      string
+	key      string // section.key in the YAML file
+	def      string
+	required bool
+	secret   bool
+	value    reflect.Value
+}
+
+func settings(c *Config) []setting {
+	var all []setting
+	v := reflect.ValueOf(c).Elem()
+	for i, section := range reflect.VisibleFields(v.Type()) {
+		if !section.IsExported() {
+			continue
+		}
+		for j, f := range reflect.VisibleFields(section.Type) {
+			all = append(all, setting{
+				env:      f.Tag.Get("env"),
+				key:      section.Tag.Get("yaml") + "." + f.Tag.Get("yaml"),
+				def:      f.Tag.Get("default"),
+				required: f.Tag.Get("required") == "true",
+				secret:   f.Tag.Get("secret") == "true",
+				value:    v.Field(i).Field(j),
+			})
+		}
+	}
+	return all
+}
+
+var durationType = reflect.TypeFor[time.Duration]()
+
+// set parses s into v, lists being comma separated.
+func set(v reflect.Value, s string) error {
+	if v.Type() == durationType {
+		if s == "" {
+			v.SetInt(0)
+			return nil
+		}
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil && s != "" {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil && s != "" {
+			return err
+		}
+		v.SetInt(i)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil && s != "" {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		v.Set(reflect.ValueOf(splitList(s)))
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+func splitList(s string) []string {
+	list := []string{}
+	for item := range strings.SplitSeq(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// readEnvFile reads the KEY=value lines of a .env file. Blank lines and lines
+// starting with # are skipped, the values may be quoted, the lines prefixed by
+// export and followed by a comment.
+func readEnvFile(path string) (map[string]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	vars := make(map[string]string)
+	for i, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(strings.TrimPrefix(line, "export "), "=")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: expected KEY=value", path, i+1)
+		}
+		value = strings.TrimSpace(value)
+		if value != "" && (value[0] == '"' || value[0] == '\'') {
+			if end := strings.IndexByte(value[1:], value[0]); end >= 0 {
+				value = value[1 : end+1]
+			}
+		} else if comment := strings.Index(value, " #"); comment >= 0 {
+			value = strings.TrimSpace(value[:comment])
+		}
+		vars[strings.TrimSpace(key)] = value
+	}
+	return vars, nil
+}
+
+// readYAMLFile reads the settings of a YAML file by section.key, the lists
+// being joined as set expects them.
+func readYAMLFile(path string) (map[string]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var sections map[string]map[string]any
+	if err := yaml.Unmarshal(b, &sections); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	settings := make(map[string]string)
+	for section, values := range sections {
+		for key, value := range values {
+			switch value := value.(type) {
+			case nil:
+			case []any:
+				items := make([]string, len(value))
+				for i, item := range value {
+					items[i] = fmt.Sprint(item)
+				}
+				settings[section+"."+key] = strings.Join(items, ",")
+			case map[string]any:
+				return nil, fmt.Errorf("%s: %s.%s is not a value", path, section, key)
+			default:
+				settings[section+"."+key] = fmt.Sprint(value)
+			}
+		}
+	}
+	return settings, nil
+}
@@ -0,0 +1,43 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// redacted replaces the secrets set when printed.
+const redacted = "[redacted]"
+
+// Print writes the settings as a .env file, commented with where they come
+// from. The secrets are redacted.
+func (c Config) Print(w io.Writer) error {
+	for _, s := range settings(&c) {
+		value := format(s.value)
+		if s.secret && value != "" {
+			value = redacted
+		}
+		source := c.sources[s.env]
+		if source == "" {
+			source = SourceDefault
+		}
+		if _, err := fmt.Fprintf(w, "%s=%s # %s\n", s.env, strconv.Quote(value), source); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// format is the inverse of set.
+func format(v reflect.Value) string {
+	if v.Type() == durationType {
+		return time.Duration(v.Int()).String()
+	}
+	if list, ok := v.Interface().([]string); ok {
+		return strings.Join(list, ",")
+	}
+	return fmt.Sprint(v.Interface())
+}
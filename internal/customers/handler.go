@@ -0,0 +1,147 @@
+package customers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mellomaths/ecommerce-ms/internal/logging"
+	"github.com/mellomaths/ecommerce-ms/internal/requests"
+	"github.com/mellomaths/ecommerce-ms/internal/responses"
+)
+
+type handler struct {
+	service Service
+}
+
+func NewHandler(service Service) *handler {
+	return &handler{
+		service: service,
+	}
+}
+
+func (h *handler) CreateCustomer(w http.ResponseWriter, r *http.Request) {
+	var customerParams CustomerParams
+	if err := requests.DecodeJsonBody(r, &customerParams); err != nil {
+		logging.FromContext(r.Context()).Info("invalid request", "error", err)
+		responses.NewJsonErrorResponse(w, http.StatusBadRequest, "validation_error", "invalid customer")
+		return
+	}
+	c, err := h.service.CreateCustomer(r.Context(), customerParams)
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("request failed", "error", err)
+		writeError(w, err, "unexpected error when creating a new customer")
+		return
+	}
+	responses.NewJsonResponse(w, http.StatusCreated, c)
+}
+
+func (h *handler) FindCustomerById(w http.ResponseWriter, r *http.Request) {
+	customerId, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		logging.FromContext(r.Context()).Info("invalid request", "error", err)
+		responses.NewJsonErrorResponse(w, http.StatusBadRequest, "validation_error", "invalid customer id")
+		return
+	}
+	c, err := h.service.FindCustomerById(r.Context(), customerId)
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("request failed", "error", err)
+		writeError(w, err, "unexpected error when finding the customer")
+		return
+	}
+	responses.NewJsonResponse(w, http.StatusOK, c)
+}
+
+func (h *handler) UpdateCustomer(w http.ResponseWriter, r *http.Request) {
+	customerId, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		logging.FromContext(r.Context()).Info("invalid request", "error", err)
+		responses.NewJsonErrorResponse(w, http.StatusBadRequest, "validation_error", "invalid customer id")
+		return
+	}
+	var customerParams CustomerParams
+	if err := requests.DecodeJsonBody(r, &customerParams); err != nil {
+		logging.FromContext(r.Context()).Info("invalid request", "error", err)
+		responses.NewJsonErrorResponse(w, http.StatusBadRequest, "validation_error", "invalid customer")
+		return
+	}
+	c, err := h.service.UpdateCustomer(r.Context(), customerId, customerParams)
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("request failed", "error", err)
+		writeError(w, err, "unexpected error when updating the customer")
+		return
+	}
+	responses.NewJsonResponse(w, http.StatusOK, c)
+}
+
+func (h *handler) AddAddress(w http.ResponseWriter, r *http.Request) {
+	customerId, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		logging.FromContext(r.Context()).Info("invalid request", "error", err)
+		responses.NewJsonErrorResponse(w, http.StatusBadRequest, "validation_error", "invalid customer id")
+		return
+	}
+	var addressParams AddressParams
+	if err := requests.DecodeJsonBody(r, &addressParams); err != nil {
+		logging.FromContext(r.Context()).Info("invalid request", "error", err)
+		responses.NewJsonErrorResponse(w, http.StatusBadRequest, "validation_error", "invalid address")
+		return
+	}
+	a, err := h.service.AddAddress(r.Context(), customerId, addressParams)
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("request failed", "error", err)
+		writeError(w, err, "unexpected error when adding the address")
+		return
+	}
+	responses.NewJsonResponse(w, http.StatusCreated, a)
+}
+
+func (h *handler) ListAddresses(w http.ResponseWriter, r *http.Request) {
+	customerId, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		logging.FromContext(r.Context()).Info("invalid request", "error", err)
+		responses.NewJsonErrorResponse(w, http.StatusBadRequest, "validation_error", "invalid customer id")
+		return
+	}
+	addresses, err := h.service.ListAddresses(r.Context(), customerId)
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("request failed", "error", err)
+		writeError(w, err, "unexpected error when listing the addresses")
+		return
+	}
+	responses.NewJsonResponse(w, http.StatusOK, addresses)
+}
+
+func (h *handler) DeleteAddress(w http.ResponseWriter, r *http.Request) {
+	customerId, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		logging.FromContext(r.Context()).Info("invalid request", "error", err)
+		responses.NewJsonErrorResponse(w, http.StatusBadRequest, "validation_error", "invalid customer id")
+		return
+	}
+	addressId, err := strconv.ParseInt(chi.URLParam(r, "addressId"), 10, 64)
+	if err != nil {
+		logging.FromContext(r.Context()).Info("invalid request", "error", err)
+		responses.NewJsonErrorResponse(w, http.StatusBadRequest, "validation_error", "invalid address id")
+		return
+	}
+	if err := h.service.DeleteAddress(r.Context(), customerId, addressId); err != nil {
+		logging.FromContext(r.Context()).Warn("request failed", "error", err)
+		writeError(w, err, "unexpected error when deleting the address")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeError(w http.ResponseWriter, err error, serverErrMsg string) {
+	switch err {
+	case ErrCustomerNotFound, ErrAddressNotFound:
+		responses.NewJsonErrorResponse(w, http.StatusNotFound, "not_found", err.Error())
+	case ErrInvalidCustomer, ErrInvalidAddress:
+		responses.NewJsonErrorResponse(w, http.StatusBadRequest, "validation_error", err.Error())
+	case ErrEmailTaken:
+		responses.NewJsonErrorResponse(w, http.StatusConflict, "email_taken", err.Error())
+	default:
+		responses.NewJsonErrorResponse(w, http.StatusInternalServerError, "server_error", serverErrMsg)
+	}
+}
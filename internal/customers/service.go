@@ -0,0 +1,216 @@
+package customers
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	repo "github.com/mellomaths/ecommerce-ms/internal/adapters/postgresql/sqlc"
+	"github.com/mellomaths/ecommerce-ms/internal/auth"
+	"github.com/mellomaths/ecommerce-ms/internal/tracing"
+)
+
+var (
+	ErrCustomerNotFound = errors.New("customer not found")
+	ErrAddressNotFound  = errors.New("address not found")
+	ErrInvalidCustomer  = errors.New("invalid customer")
+	ErrInvalidAddress   = errors.New("invalid address")
+	ErrEmailTaken       = errors.New("email is already in use")
+)
+
+const (
+	AddressShipping = "shipping"
+	AddressBilling  = "billing"
+)
+
+type CustomerParams struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+func (cp CustomerParams) valid() bool {
+	return cp.Name != "" && cp.Email != ""
+}
+
+type AddressParams struct {
+	Kind       string `json:"kind"`
+	Line1      string `json:"line1"`
+	Line2      string `json:"line2"`
+	City       string `json:"city"`
+	State      string `json:"state"`
+	PostalCode string `json:"postal_code"`
+	Country    string `json:"country"`
+}
+
+func (ap AddressParams) valid() bool {
+	if ap.Kind != AddressShipping && ap.Kind != AddressBilling {
+		return false
+	}
+	return ap.Line1 != "" && ap.City != "" && ap.PostalCode != "" && ap.Country != ""
+}
+
+type CustomerWithAddresses struct {
+	repo.Customer
+	Addresses []repo.CustomerAddress `json:"addresses"`
+}
+
+type Service interface {
+	CreateCustomer(ctx context.Context, cp CustomerParams) (repo.Customer, error)
+	FindCustomerById(ctx context.Context, id int64) (CustomerWithAddresses, error)
+	UpdateCustomer(ctx context.Context, id int64, cp CustomerParams) (repo.Customer, error)
+	AddAddress(ctx context.Context, customerId int64, ap AddressParams) (repo.CustomerAddress, error)
+	ListAddresses(ctx context.Context, customerId int64) ([]repo.CustomerAddress, error)
+	DeleteAddress(ctx context.Context, customerId int64, addressId int64) error
+}
+
+type svc struct {
+	repo repo.Querier
+}
+
+func NewService(repo repo.Querier) Service {
+	return &svc{repo: repo}
+}
+
+func (s *svc) CreateCustomer(ctx context.Context, cp CustomerParams) (repo.Customer, error) {
+	ctx, span := tracing.Start(ctx, "customers.CreateCustomer")
+	defer span.End()
+	if !cp.valid() {
+		return repo.Customer{}, ErrInvalidCustomer
+	}
+	customer, err := s.repo.CreateCustomer(ctx, repo.CreateCustomerParams{
+		Name:  cp.Name,
+		Email: cp.Email,
+	})
+	if isUniqueViolation(err) {
+		return repo.Customer{}, ErrEmailTaken
+	}
+	return customer, err
+}
+
+// FindCustomerById finds the customer with its addresses. Like the other
+// methods taking a customer id, it does not find the other customers when the
+// request is made by a customer.
+func (s *svc) FindCustomerById(ctx context.Context, id int64) (CustomerWithAddresses, error) {
+	ctx, span := tracing.Start(ctx, "customers.FindCustomerById")
+	defer span.End()
+	if !auth.CanAccessCustomer(ctx, id) {
+		return CustomerWithAddresses{}, ErrCustomerNotFound
+	}
+	customer, err := FindCustomer(ctx, s.repo, id)
+	if err != nil {
+		return CustomerWithAddresses{}, err
+	}
+	addresses, err := s.repo.ListCustomerAddresses(ctx, id)
+	if err != nil {
+		return CustomerWithAddresses{}, err
+	}
+	if addresses == nil {
+		addresses = []repo.CustomerAddress{}
+	}
+	return CustomerWithAddresses{Customer: customer, Addresses: addresses}, nil
+}
+
+func (s *svc) UpdateCustomer(ctx context.Context, id int64, cp CustomerParams) (repo.Customer, error) {
+	ctx, span := tracing.Start(ctx, "customers.UpdateCustomer")
+	defer span.End()
+	if !cp.valid() {
+		return repo.Customer{}, ErrInvalidCustomer
+	}
+	if !auth.CanAccessCustomer(ctx, id) {
+		return repo.Customer{}, ErrCustomerNotFound
+	}
+	customer, err := s.repo.UpdateCustomer(ctx, repo.UpdateCustomerParams{
+		ID:    id,
+		Name:  cp.Name,
+		Email: cp.Email,
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return repo.Customer{}, ErrCustomerNotFound
+	}
+	if isUniqueViolation(err) {
+		return repo.Customer{}, ErrEmailTaken
+	}
+	return customer, err
+}
+
+func (s *svc) AddAddress(ctx context.Context, customerId int64, ap AddressParams) (repo.CustomerAddress, error) {
+	ctx, span := tracing.Start(ctx, "customers.AddAddress")
+	defer span.End()
+	if !ap.valid() {
+		return repo.CustomerAddress{}, ErrInvalidAddress
+	}
+	if !auth.CanAccessCustomer(ctx, customerId) {
+		return repo.CustomerAddress{}, ErrCustomerNotFound
+	}
+	if _, err := FindCustomer(ctx, s.repo, customerId); err != nil {
+		return repo.CustomerAddress{}, err
+	}
+	return s.repo.CreateCustomerAddress(ctx, repo.CreateCustomerAddressParams{
+		CustomerID: customerId,
+		Kind:       ap.Kind,
+		Line1:      ap.Line1,
+		Line2:      ap.Line2,
+		City:       ap.City,
+		State:      ap.State,
+		PostalCode: ap.PostalCode,
+		Country:    ap.Country,
+	})
+}
+
+func (s *svc) ListAddresses(ctx context.Context, customerId int64) ([]repo.CustomerAddress, error) {
+	ctx, span := tracing.Start(ctx, "customers.ListAddresses")
+	defer span.End()
+	c, err := s.FindCustomerById(ctx, customerId)
+	return c.Addresses, err
+}
+
+func (s *svc) DeleteAddress(ctx context.Context, customerId int64, addressId int64) error {
+	ctx, span := tracing.Start(ctx, "customers.DeleteAddress")
+	defer span.End()
+	if !auth.CanAccessCustomer(ctx, customerId) {
+		return ErrCustomerNotFound
+	}
+	deleted, err := s.repo.DeleteCustomerAddress(ctx, repo.DeleteCustomerAddressParams{
+		ID:         addressId,
+		CustomerID: customerId,
+	})
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return ErrAddressNotFound
+	}
+	return nil
+}
+
+// FindCustomer looks the customer up with the given querier, so it can be
+// used inside another service transaction.
+func FindCustomer(ctx context.Context, q repo.Querier, id int64) (repo.Customer, error) {
+	customer, err := q.FindCustomerById(ctx, id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return repo.Customer{}, ErrCustomerNotFound
+	}
+	return customer, err
+}
+
+// FindShippingAddress returns the shipping address of the customer, it fails
+// if the customer does not exist or the address belongs to someone else.
+func FindShippingAddress(ctx context.Context, q repo.Querier, customerId int64, addressId int64) (repo.CustomerAddress, error) {
+	if _, err := FindCustomer(ctx, q, customerId); err != nil {
+		return repo.CustomerAddress{}, err
+	}
+	address, err := q.FindCustomerAddress(ctx, repo.FindCustomerAddressParams{
+		ID:         addressId,
+		CustomerID: customerId,
+	})
+	if errors.Is(err, pgx.ErrNoRows) || (err == nil && address.Kind != AddressShipping) {
+		return repo.CustomerAddress{}, ErrAddressNotFound
+	}
+	return address, err
+}
+
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
@@ -1,11 +0,0 @@
-package env
-
-import "os"
-
-func GetString(key, fallback string) string {
-	if str := os.Getenv(key); str != "" {
-		return str
-	}
-
-	return fallback
-}
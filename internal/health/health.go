@@ -0,0 +1,113 @@
+package health
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mellomaths/ecommerce-ms/internal/auth"
+	"github.com/mellomaths/ecommerce-ms/internal/responses"
+)
+
+const (
+	StatusOK = "ok"
+	// StatusWarn is reported by the failing checks that are not critical, the
+	// service keeps taking requests.
+	StatusWarn = "warn"
+	StatusFail = "fail"
+)
+
+// Result is the outcome of a check. Details and Error are only reported in
+// verbose mode.
+type Result struct {
+	Status     string         `json:"status"`
+	DurationMs float64        `json:"duration_ms,omitempty"`
+	Details    map[string]any `json:"details,omitempty"`
+	Error      string         `json:"error,omitempty"`
+}
+
+// Check is a check of a dependency. A critical check failing makes the
+// service unready.
+type Check struct {
+	Name     string
+	Critical bool
+	Run      func(ctx context.Context) Result
+}
+
+type Report struct {
+	Status string            `json:"status"`
+	Checks map[string]Result `json:"checks"`
+}
+
+type Checker struct {
+	checks  []Check
+	timeout time.Duration
+}
+
+// NewChecker runs the checks concurrently, each within the timeout.
+func NewChecker(timeout time.Duration, checks ...Check) *Checker {
+	return &Checker{checks: checks, timeout: timeout}
+}
+
+func (c *Checker) Check(ctx context.Context) Report {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	results := make([]Result, len(c.checks))
+	var wg sync.WaitGroup
+	for i, check := range c.checks {
+		wg.Go(func() {
+			start := time.Now()
+			result := check.Run(ctx)
+			result.DurationMs = float64(time.Since(start).Microseconds()) / 1000
+			if result.Status == StatusFail && !check.Critical {
+				result.Status = StatusWarn
+			}
+			results[i] = result
+		})
+	}
+	wg.Wait()
+
+	report := Report{Status: StatusOK, Checks: make(map[string]Result, len(c.checks))}
+	for i, check := range c.checks {
+		result := results[i]
+		switch {
+		case result.Status == StatusFail:
+			report.Status = StatusFail
+		case result.Status == StatusWarn && report.Status == StatusOK:
+			report.Status = StatusWarn
+		}
+		report.Checks[check.Name] = result
+	}
+	return report
+}
+
+// ServeHTTP reports the checks, with a 503 when a critical one fails. Only the
+// status of the checks is reported, unless the verbose query parameter is set
+// by an operator allowed to read the admin routes, so the details of the
+// dependencies are not given away to anyone.
+func (c *Checker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	report := c.Check(r.Context())
+	if !verbose(r) {
+		for name, result := range report.Checks {
+			report.Checks[name] = Result{Status: result.Status}
+		}
+	}
+	status := http.StatusOK
+	if report.Status == StatusFail {
+		status = http.StatusServiceUnavailable
+	}
+	responses.NewJsonResponse(w, status, report)
+}
+
+func verbose(r *http.Request) bool {
+	v, _ := strconv.ParseBool(r.URL.Query().Get("verbose"))
+	p, ok := auth.PrincipalFrom(r.Context())
+	return v && ok && p.Can(auth.PermissionReadAdmin)
+}
+
+// Failed is the result of a failed check.
+func Failed(err error) Result {
+	return Result{Status: StatusFail, Error: err.Error()}
+}
@@ -0,0 +1,80 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// missedBeats is the number of beats a worker can miss before it is reported
+// as stalled, on top of a minute for the batches that take long.
+const missedBeats = 3
+
+// Heartbeats tracks the background workers, which beat every time they look
+// for work.
+type Heartbeats struct {
+	mu      sync.Mutex
+	workers map[string]*heartbeat
+	names   []string
+	now     func() time.Time
+}
+
+type heartbeat struct {
+	interval time.Duration
+	last     time.Time
+}
+
+func NewHeartbeats() *Heartbeats {
+	return &Heartbeats{workers: make(map[string]*heartbeat), now: time.Now}
+}
+
+type heartbeatKey struct{}
+
+// Register tracks a worker expected to beat every interval and returns the
+// context to run it with, through which it beats.
+func (h *Heartbeats) Register(ctx context.Context, name string, interval time.Duration) context.Context {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.workers[name] = &heartbeat{interval: interval, last: h.now()}
+	h.names = append(h.names, name)
+	return context.WithValue(ctx, heartbeatKey{}, func() { h.beat(name) })
+}
+
+func (h *Heartbeats) beat(name string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.workers[name].last = h.now()
+}
+
+// Beat records that the worker running with ctx is alive. It does nothing
+// when the worker is not tracked.
+func Beat(ctx context.Context) {
+	if beat, ok := ctx.Value(heartbeatKey{}).(func()); ok {
+		beat()
+	}
+}
+
+// Check fails when a worker has stalled.
+func (h *Heartbeats) Check() Check {
+	return Check{Name: "workers", Run: func(ctx context.Context) Result {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		now := h.now()
+		result := Result{Status: StatusOK, Details: map[string]any{}}
+		var stalled []string
+		for _, name := range h.names {
+			w := h.workers[name]
+			since := now.Sub(w.last)
+			result.Details[name] = map[string]any{"last_beat": w.last, "seconds_since": int64(since.Seconds())}
+			if since > missedBeats*w.interval+time.Minute {
+				stalled = append(stalled, name)
+			}
+		}
+		if len(stalled) > 0 {
+			result.Status = StatusFail
+			result.Error = fmt.Sprintf("stalled workers: %v", stalled)
+		}
+		return result
+	}}
+}
@@ -0,0 +1,163 @@
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	repo "github.com/mellomaths/ecommerce-ms/internal/adapters/postgresql/sqlc"
+	"github.com/mellomaths/ecommerce-ms/internal/auth"
+	"github.com/mellomaths/ecommerce-ms/internal/logging"
+	"github.com/mellomaths/ecommerce-ms/internal/responses"
+)
+
+const (
+	HeaderKey      = "Idempotency-Key"
+	HeaderReplayed = "Idempotent-Replayed"
+)
+
+type middlewareHandler struct {
+	repo        repo.Querier
+	lockTimeout time.Duration
+}
+
+// NewMiddleware makes POST requests carrying an Idempotency-Key header safe to
+// retry. The first request with a key runs the handler and stores its
+// response, replays with the same body get the stored response back and
+// replays with a different body are rejected. A key still in progress after
+// lockTimeout, left by a request that crashed before storing its response, is
+// taken over by the next replay.
+func NewMiddleware(repo repo.Querier, lockTimeout time.Duration) func(next http.Handler) http.Handler {
+	m := &middlewareHandler{repo: repo, lockTimeout: lockTimeout}
+	return m.handle
+}
+
+func (m *middlewareHandler) handle(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(HeaderKey)
+		if r.Method != http.MethodPost || key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			logging.FromContext(r.Context()).Info("invalid request", "error", err)
+			responses.NewJsonErrorResponse(w, http.StatusBadRequest, "validation_error", "invalid request body")
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		scope := scopeOf(r)
+
+		_, err = m.repo.CreateIdempotencyKey(r.Context(), repo.CreateIdempotencyKeyParams{
+			Key:         key,
+			Scope:       scope,
+			Fingerprint: fingerprint(scope, body),
+			StaleBefore: pgtype.Timestamptz{Time: time.Now().Add(-m.lockTimeout), Valid: true},
+		})
+		if errors.Is(err, pgx.ErrNoRows) {
+			// The key has been used before
+			m.replay(w, r, key, scope, body)
+			return
+		}
+		if err != nil {
+			logging.FromContext(r.Context()).Error("failed to create the idempotency key", "error", err)
+			responses.NewJsonErrorResponse(w, http.StatusInternalServerError, "server_error", "unexpected error when checking the idempotency key")
+			return
+		}
+
+		// The key is released or completed even when the client went away or
+		// the request timed out, which is when the client retries. A panic
+		// releases the key before being passed on to the recoverer.
+		ctx := context.WithoutCancel(r.Context())
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		var response bytes.Buffer
+		ww.Tee(&response)
+		defer func() {
+			if rvr := recover(); rvr != nil {
+				m.release(ctx, key, scope)
+				panic(rvr)
+			}
+		}()
+		next.ServeHTTP(ww, r)
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		if status >= http.StatusInternalServerError {
+			// Server errors are not stored, so the client can retry them
+			m.release(ctx, key, scope)
+			return
+		}
+		err = m.repo.CompleteIdempotencyKey(ctx, repo.CompleteIdempotencyKeyParams{
+			Key:          key,
+			Scope:        scope,
+			StatusCode:   pgtype.Int4{Int32: int32(status), Valid: true},
+			ContentType:  pgtype.Text{String: ww.Header().Get("Content-Type"), Valid: true},
+			ResponseBody: response.Bytes(),
+		})
+		if err != nil {
+			logging.FromContext(ctx).Error("failed to store the idempotent response", "error", err)
+		}
+	})
+}
+
+func (m *middlewareHandler) release(ctx context.Context, key, scope string) {
+	if err := m.repo.DeleteIdempotencyKey(ctx, repo.DeleteIdempotencyKeyParams{Key: key, Scope: scope}); err != nil {
+		logging.FromContext(ctx).Error("failed to release the idempotency key", "error", err)
+	}
+}
+
+func (m *middlewareHandler) replay(w http.ResponseWriter, r *http.Request, key, scope string, body []byte) {
+	stored, err := m.repo.FindIdempotencyKey(r.Context(), repo.FindIdempotencyKeyParams{Key: key, Scope: scope})
+	if errors.Is(err, pgx.ErrNoRows) {
+		// The first request failed and released the key in the meantime
+		responses.NewJsonErrorResponse(w, http.StatusConflict, "idempotency_key_in_progress", "a request with this idempotency key is in progress")
+		return
+	}
+	if err != nil {
+		logging.FromContext(r.Context()).Error("failed to find the idempotency key", "error", err)
+		responses.NewJsonErrorResponse(w, http.StatusInternalServerError, "server_error", "unexpected error when checking the idempotency key")
+		return
+	}
+	if stored.Fingerprint != fingerprint(scope, body) {
+		responses.NewJsonErrorResponse(w, http.StatusUnprocessableEntity, "idempotency_key_reused", "idempotency key has already been used with a different request")
+		return
+	}
+	if !stored.CompletedAt.Valid {
+		responses.NewJsonErrorResponse(w, http.StatusConflict, "idempotency_key_in_progress", "a request with this idempotency key is in progress")
+		return
+	}
+	if stored.ContentType.String != "" {
+		w.Header().Set("Content-Type", stored.ContentType.String)
+	}
+	w.Header().Set(HeaderReplayed, "true")
+	w.WriteHeader(int(stored.StatusCode.Int32))
+	w.Write(stored.ResponseBody)
+}
+
+// scopeOf scopes the keys to the route and the principal, so clients choosing
+// the same key never get the response of one another.
+func scopeOf(r *http.Request) string {
+	scope := r.Method + " " + r.URL.Path
+	if p, ok := auth.PrincipalFrom(r.Context()); ok {
+		scope = p.Subject + " " + scope
+	}
+	return scope
+}
+
+// fingerprint identifies the request a key was first used with.
+func fingerprint(scope string, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(scope + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,98 @@
+package locations
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mellomaths/ecommerce-ms/internal/logging"
+	"github.com/mellomaths/ecommerce-ms/internal/requests"
+	"github.com/mellomaths/ecommerce-ms/internal/responses"
+)
+
+type handler struct {
+	service Service
+}
+
+func NewHandler(service Service) *handler {
+	return &handler{
+		service: service,
+	}
+}
+
+func (h *handler) CreateLocation(w http.ResponseWriter, r *http.Request) {
+	var locationParams LocationParams
+	if err := requests.DecodeJsonBody(r, &locationParams); err != nil {
+		logging.FromContext(r.Context()).Info("invalid request", "error", err)
+		responses.NewJsonErrorResponse(w, http.StatusBadRequest, "validation_error", "invalid stock location")
+		return
+	}
+	l, err := h.service.CreateLocation(r.Context(), locationParams)
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("request failed", "error", err)
+		writeError(w, err, "unexpected error when creating a new stock location")
+		return
+	}
+	responses.NewJsonResponse(w, http.StatusCreated, l)
+}
+
+func (h *handler) ListLocations(w http.ResponseWriter, r *http.Request) {
+	l, err := h.service.ListLocations(r.Context())
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("request failed", "error", err)
+		writeError(w, err, "unexpected error when listing the stock locations")
+		return
+	}
+	responses.NewJsonResponse(w, http.StatusOK, l)
+}
+
+func (h *handler) FindLocationById(w http.ResponseWriter, r *http.Request) {
+	locationId, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		logging.FromContext(r.Context()).Info("invalid request", "error", err)
+		responses.NewJsonErrorResponse(w, http.StatusBadRequest, "validation_error", "invalid stock location id")
+		return
+	}
+	l, err := h.service.FindLocationById(r.Context(), locationId)
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("request failed", "error", err)
+		writeError(w, err, "unexpected error when finding the stock location")
+		return
+	}
+	responses.NewJsonResponse(w, http.StatusOK, l)
+}
+
+func (h *handler) UpdateLocation(w http.ResponseWriter, r *http.Request) {
+	locationId, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		logging.FromContext(r.Context()).Info("invalid request", "error", err)
+		responses.NewJsonErrorResponse(w, http.StatusBadRequest, "validation_error", "invalid stock location id")
+		return
+	}
+	var locationParams UpdateLocationParams
+	if err := requests.DecodeJsonBody(r, &locationParams); err != nil {
+		logging.FromContext(r.Context()).Info("invalid request", "error", err)
+		responses.NewJsonErrorResponse(w, http.StatusBadRequest, "validation_error", "invalid stock location")
+		return
+	}
+	l, err := h.service.UpdateLocation(r.Context(), locationId, locationParams)
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("request failed", "error", err)
+		writeError(w, err, "unexpected error when updating the stock location")
+		return
+	}
+	responses.NewJsonResponse(w, http.StatusOK, l)
+}
+
+func writeError(w http.ResponseWriter, err error, serverErrMsg string) {
+	switch err {
+	case ErrLocationNotFound:
+		responses.NewJsonErrorResponse(w, http.StatusNotFound, "not_found", err.Error())
+	case ErrInvalidLocation:
+		responses.NewJsonErrorResponse(w, http.StatusBadRequest, "validation_error", err.Error())
+	case ErrNameTaken:
+		responses.NewJsonErrorResponse(w, http.StatusConflict, "name_taken", err.Error())
+	default:
+		responses.NewJsonErrorResponse(w, http.StatusInternalServerError, "server_error", serverErrMsg)
+	}
+}
@@ -0,0 +1,125 @@
+package locations
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	repo "github.com/mellomaths/ecommerce-ms/internal/adapters/postgresql/sqlc"
+	"github.com/mellomaths/ecommerce-ms/internal/tracing"
+)
+
+var (
+	ErrLocationNotFound = errors.New("stock location not found")
+	ErrInvalidLocation  = errors.New("invalid stock location")
+	ErrNameTaken        = errors.New("stock location name is already in use")
+)
+
+// LocationParams describes a stock location. Orders are allocated to the
+// locations with the lowest priority first, and ShippingCostInCents is the
+// cost of shipping one unit from the location.
+type LocationParams struct {
+	Name                string `json:"name"`
+	Priority            int32  `json:"priority"`
+	ShippingCostInCents int32  `json:"shipping_cost_in_cents"`
+}
+
+func (lp LocationParams) valid() bool {
+	return lp.Name != "" && lp.ShippingCostInCents >= 0
+}
+
+// UpdateLocationParams replaces a stock location. An inactive location keeps
+// its stock but no order is allocated to it.
+type UpdateLocationParams struct {
+	LocationParams
+	Active bool `json:"active"`
+}
+
+type Service interface {
+	CreateLocation(ctx context.Context, lp LocationParams) (repo.StockLocation, error)
+	ListLocations(ctx context.Context) ([]repo.StockLocation, error)
+	FindLocationById(ctx context.Context, id int64) (repo.StockLocation, error)
+	UpdateLocation(ctx context.Context, id int64, up UpdateLocationParams) (repo.StockLocation, error)
+}
+
+type svc struct {
+	repo repo.Querier
+}
+
+func NewService(repo repo.Querier) Service {
+	return &svc{repo: repo}
+}
+
+func (s *svc) CreateLocation(ctx context.Context, lp LocationParams) (repo.StockLocation, error) {
+	ctx, span := tracing.Start(ctx, "locations.CreateLocation")
+	defer span.End()
+	if !lp.valid() {
+		return repo.StockLocation{}, ErrInvalidLocation
+	}
+	location, err := s.repo.CreateStockLocation(ctx, repo.CreateStockLocationParams{
+		Name:                lp.Name,
+		Priority:            lp.Priority,
+		ShippingCostInCents: lp.ShippingCostInCents,
+	})
+	if isUniqueViolation(err) {
+		return repo.StockLocation{}, ErrNameTaken
+	}
+	return location, err
+}
+
+func (s *svc) ListLocations(ctx context.Context) ([]repo.StockLocation, error) {
+	ctx, span := tracing.Start(ctx, "locations.ListLocations")
+	defer span.End()
+	locations, err := s.repo.ListStockLocations(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if locations == nil {
+		locations = []repo.StockLocation{}
+	}
+	return locations, nil
+}
+
+func (s *svc) FindLocationById(ctx context.Context, id int64) (repo.StockLocation, error) {
+	ctx, span := tracing.Start(ctx, "locations.FindLocationById")
+	defer span.End()
+	return FindLocation(ctx, s.repo, id)
+}
+
+func (s *svc) UpdateLocation(ctx context.Context, id int64, up UpdateLocationParams) (repo.StockLocation, error) {
+	ctx, span := tracing.Start(ctx, "locations.UpdateLocation")
+	defer span.End()
+	if !up.valid() {
+		return repo.StockLocation{}, ErrInvalidLocation
+	}
+	location, err := s.repo.UpdateStockLocation(ctx, repo.UpdateStockLocationParams{
+		ID:                  id,
+		Name:                up.Name,
+		Priority:            up.Priority,
+		ShippingCostInCents: up.ShippingCostInCents,
+		Active:              up.Active,
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return repo.StockLocation{}, ErrLocationNotFound
+	}
+	if isUniqueViolation(err) {
+		return repo.StockLocation{}, ErrNameTaken
+	}
+	return location, err
+}
+
+// FindLocation looks the stock location up with the given querier, so it can
+// be used inside another service transaction.
+func FindLocation(ctx context.Context, q repo.Querier, id int64) (repo.StockLocation, error) {
+	location, err := q.FindStockLocationById(ctx, id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return repo.StockLocation{}, ErrLocationNotFound
+	}
+	return location, err
+}
+
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
@@ -0,0 +1,79 @@
+// Package logging builds the slog logger of the service and the logger of
+// every request, carrying its request id, route, principal and trace id.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Formats of the logs.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// New builds a logger writing in the format the records of level and above,
+// the level being one of debug, info, warn or error.
+func New(w io.Writer, format string, level string) (*slog.Logger, error) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("unknown log level %q", level)
+	}
+	opts := &slog.HandlerOptions{Level: l}
+	switch strings.ToLower(format) {
+	case FormatText:
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case FormatJSON:
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("unknown log format %q", format)
+	}
+}
+
+type requestLoggerKey struct{}
+
+// requestLogger is shared by the handlers of a request, so the middlewares
+// running after the access log can add to it.
+type requestLogger struct {
+	mu     sync.Mutex
+	logger *slog.Logger
+}
+
+func (rl *requestLogger) get() *slog.Logger {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	return rl.logger
+}
+
+// FromContext is the logger of the request, with its route once it is routed,
+// or the default logger outside of a request.
+func FromContext(ctx context.Context) *slog.Logger {
+	rl, ok := ctx.Value(requestLoggerKey{}).(*requestLogger)
+	if !ok {
+		return slog.Default()
+	}
+	logger := rl.get()
+	if rctx := chi.RouteContext(ctx); rctx != nil && rctx.RoutePattern() != "" {
+		logger = logger.With("route", rctx.RoutePattern())
+	}
+	return logger
+}
+
+// With adds the attributes to the logger of the request, as in the principal
+// once authenticated. It does nothing outside of a request.
+func With(ctx context.Context, args ...any) {
+	rl, ok := ctx.Value(requestLoggerKey{}).(*requestLogger)
+	if !ok {
+		return
+	}
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.logger = rl.logger.With(args...)
+}
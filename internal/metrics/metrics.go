@@ -0,0 +1,34 @@
+// Package metrics exposes the Prometheus metrics of the service, registered on
+// the default registry next to the Go runtime and process metrics.
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "ecommerce"
+
+// Business counters, updated once the order is committed.
+var (
+	OrdersPlaced = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "orders_placed_total",
+		Help:      "Orders placed.",
+	})
+	ItemsSold = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "items_sold_total",
+		Help:      "Units of products sold in the orders placed.",
+	})
+	RevenueCents = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "revenue_cents_total",
+		Help:      "Total price in cents of the orders placed.",
+	})
+	OutOfStockRejections = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "out_of_stock_rejections_total",
+		Help:      "Orders rejected as a product has not enough stock.",
+	})
+)
@@ -1,11 +1,18 @@
 package orders
 
 import (
-	"log"
+	"errors"
 	"net/http"
+	"net/url"
 	"strconv"
+	"time"
 
 	"github.com/go-chi/chi/v5"
+	repo "github.com/mellomaths/ecommerce-ms/internal/adapters/postgresql/sqlc"
+	"github.com/mellomaths/ecommerce-ms/internal/auth"
+	"github.com/mellomaths/ecommerce-ms/internal/customers"
+	"github.com/mellomaths/ecommerce-ms/internal/logging"
+	"github.com/mellomaths/ecommerce-ms/internal/pagination"
 	"github.com/mellomaths/ecommerce-ms/internal/products"
 	"github.com/mellomaths/ecommerce-ms/internal/requests"
 	"github.com/mellomaths/ecommerce-ms/internal/responses"
@@ -24,17 +31,25 @@ func NewHandler(service Service) *handler {
 func (h *handler) PlaceOrder(w http.ResponseWriter, r *http.Request) {
 	var orderParams CreateOrderParams
 	if err := requests.DecodeJsonBody(r, &orderParams); err != nil {
-		log.Println(err)
+		logging.FromContext(r.Context()).Info("invalid request", "error", err)
 		responses.NewJsonErrorResponse(w, http.StatusBadRequest, "validation_error", "invalid order")
 		return
 	}
 	o, err := h.service.PlaceOrder(r.Context(), orderParams)
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(r.Context()).Warn("request failed", "error", err)
+		if err == customers.ErrCustomerNotFound || err == customers.ErrAddressNotFound {
+			responses.NewJsonErrorResponse(w, http.StatusNotFound, "validation_error", err.Error())
+			return
+		}
 		if err == products.ErrProductNotFound {
 			responses.NewJsonErrorResponse(w, http.StatusNotFound, "validation_error", err.Error())
 			return
 		}
+		if err == products.ErrProductDeleted {
+			responses.NewJsonErrorResponse(w, http.StatusGone, "validation_error", err.Error())
+			return
+		}
 		if err == ErrProductNoStock {
 			responses.NewJsonErrorResponse(w, http.StatusExpectationFailed, "validation_error", err.Error())
 			return
@@ -52,10 +67,175 @@ func (h *handler) PlaceOrder(w http.ResponseWriter, r *http.Request) {
 func (h *handler) FindOrderById(w http.ResponseWriter, r *http.Request) {
 	orderId, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(r.Context()).Info("invalid request", "error", err)
 		responses.NewJsonErrorResponse(w, http.StatusBadRequest, "validation_error", "invalid order id")
 		return
 	}
 	order, err := h.service.FindOrderById(r.Context(), orderId)
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("request failed", "error", err)
+		if err == ErrOrderNotFound {
+			responses.NewJsonErrorResponse(w, http.StatusNotFound, "not_found", err.Error())
+			return
+		}
+		responses.NewJsonErrorResponse(w, http.StatusInternalServerError, "server_error", "unexpected error when finding the order")
+		return
+	}
 	responses.NewJsonResponse(w, http.StatusOK, order)
 }
+
+func (h *handler) TransitionOrder(w http.ResponseWriter, r *http.Request) {
+	orderId, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		logging.FromContext(r.Context()).Info("invalid request", "error", err)
+		responses.NewJsonErrorResponse(w, http.StatusBadRequest, "validation_error", "invalid order id")
+		return
+	}
+	var transitionParams TransitionParams
+	if err := requests.DecodeJsonBody(r, &transitionParams); err != nil {
+		logging.FromContext(r.Context()).Info("invalid request", "error", err)
+		responses.NewJsonErrorResponse(w, http.StatusBadRequest, "validation_error", "invalid transition")
+		return
+	}
+	transitionParams.ChangedBy = changedBy(r)
+	o, err := h.service.TransitionOrder(r.Context(), orderId, transitionParams)
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("request failed", "error", err)
+		if err == ErrOrderNotFound {
+			responses.NewJsonErrorResponse(w, http.StatusNotFound, "not_found", err.Error())
+			return
+		}
+		if err == ErrInvalidStatus || err == ErrInvalidOrder {
+			responses.NewJsonErrorResponse(w, http.StatusBadRequest, "validation_error", err.Error())
+			return
+		}
+		if err == ErrInvalidTransition {
+			responses.NewJsonErrorResponse(w, http.StatusConflict, "invalid_transition", err.Error())
+			return
+		}
+		if err == ErrOrderPaid {
+			responses.NewJsonErrorResponse(w, http.StatusConflict, "order_paid", err.Error())
+			return
+		}
+		responses.NewJsonErrorResponse(w, http.StatusInternalServerError, "server_error", "unexpected error when changing the order status")
+		return
+	}
+	responses.NewJsonResponse(w, http.StatusOK, o)
+}
+
+func (h *handler) ListOrderTransitions(w http.ResponseWriter, r *http.Request) {
+	orderId, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		logging.FromContext(r.Context()).Info("invalid request", "error", err)
+		responses.NewJsonErrorResponse(w, http.StatusBadRequest, "validation_error", "invalid order id")
+		return
+	}
+	changes, err := h.service.ListOrderTransitions(r.Context(), orderId)
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("request failed", "error", err)
+		if err == ErrOrderNotFound {
+			responses.NewJsonErrorResponse(w, http.StatusNotFound, "not_found", err.Error())
+			return
+		}
+		responses.NewJsonErrorResponse(w, http.StatusInternalServerError, "server_error", "unexpected error when listing the order transitions")
+		return
+	}
+	responses.NewJsonResponse(w, http.StatusOK, changes)
+}
+
+func (h *handler) ListOrders(w http.ResponseWriter, r *http.Request) {
+	listParams, err := parseListOrdersParams(r.URL.Query())
+	if err != nil {
+		logging.FromContext(r.Context()).Info("invalid request", "error", err)
+		responses.NewJsonErrorResponse(w, http.StatusBadRequest, "validation_error", err.Error())
+		return
+	}
+	if v := r.URL.Query().Get("customer_id"); v != "" {
+		customerId, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			logging.FromContext(r.Context()).Info("invalid request", "error", err)
+			responses.NewJsonErrorResponse(w, http.StatusBadRequest, "validation_error", "invalid customer_id")
+			return
+		}
+		listParams.CustomerId = &customerId
+	}
+	page, err := h.service.ListOrders(r.Context(), listParams)
+	h.writeOrdersPage(w, r, page, err)
+}
+
+func (h *handler) ListCustomerOrders(w http.ResponseWriter, r *http.Request) {
+	customerId, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		logging.FromContext(r.Context()).Info("invalid request", "error", err)
+		responses.NewJsonErrorResponse(w, http.StatusBadRequest, "validation_error", "invalid customer id")
+		return
+	}
+	listParams, err := parseListOrdersParams(r.URL.Query())
+	if err != nil {
+		logging.FromContext(r.Context()).Info("invalid request", "error", err)
+		responses.NewJsonErrorResponse(w, http.StatusBadRequest, "validation_error", err.Error())
+		return
+	}
+	page, err := h.service.ListCustomerOrders(r.Context(), customerId, listParams)
+	h.writeOrdersPage(w, r, page, err)
+}
+
+func (h *handler) writeOrdersPage(w http.ResponseWriter, r *http.Request, page pagination.Page[repo.ListOrdersRow], err error) {
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("request failed", "error", err)
+		if err == customers.ErrCustomerNotFound {
+			responses.NewJsonErrorResponse(w, http.StatusNotFound, "not_found", err.Error())
+			return
+		}
+		if err == ErrInvalidStatus || err == pagination.ErrInvalidCursor {
+			responses.NewJsonErrorResponse(w, http.StatusBadRequest, "validation_error", err.Error())
+			return
+		}
+		responses.NewJsonErrorResponse(w, http.StatusInternalServerError, "server_error", "unexpected error when listing orders")
+		return
+	}
+	responses.NewJsonResponse(w, http.StatusOK, page)
+}
+
+func parseListOrdersParams(q url.Values) (ListOrdersParams, error) {
+	limit, err := pagination.ParseLimit(q.Get("limit"))
+	if err != nil {
+		return ListOrdersParams{}, err
+	}
+	lp := ListOrdersParams{
+		Cursor: q.Get("cursor"),
+		Limit:  limit,
+		Status: Status(q.Get("status")),
+	}
+	if lp.CreatedFrom, err = parseTime(q.Get("from")); err != nil {
+		return ListOrdersParams{}, errors.New("invalid from")
+	}
+	if lp.CreatedTo, err = parseTime(q.Get("to")); err != nil {
+		return ListOrdersParams{}, errors.New("invalid to")
+	}
+	if v := q.Get("min_total"); v != "" {
+		minTotal, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return ListOrdersParams{}, errors.New("invalid min_total")
+		}
+		lp.MinTotal = &minTotal
+	}
+	return lp, nil
+}
+
+func parseTime(s string) (*time.Time, error) {
+	if s == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// changedBy is the principal recorded on the changes of status.
+func changedBy(r *http.Request) string {
+	p, _ := auth.PrincipalFrom(r.Context())
+	return p.Subject
+}
@@ -1,23 +1,44 @@
 package orders
 
 import (
+	"cmp"
 	"context"
 	"errors"
+	"slices"
+	"time"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
 	repo "github.com/mellomaths/ecommerce-ms/internal/adapters/postgresql/sqlc"
+	"github.com/mellomaths/ecommerce-ms/internal/auth"
+	"github.com/mellomaths/ecommerce-ms/internal/customers"
+	"github.com/mellomaths/ecommerce-ms/internal/metrics"
+	"github.com/mellomaths/ecommerce-ms/internal/outbox"
+	"github.com/mellomaths/ecommerce-ms/internal/pagination"
 	"github.com/mellomaths/ecommerce-ms/internal/products"
+	"github.com/mellomaths/ecommerce-ms/internal/tracing"
 	"github.com/mellomaths/ecommerce-ms/internal/utils"
 )
 
 var (
-	ErrProductNoStock = errors.New("product has not enough stock")
+	ErrProductNoStock = products.ErrProductNoStock
 	ErrInvalidOrder   = errors.New("invalid order")
+	ErrOrderNotFound  = errors.New("order not found")
+	ErrInvalidStatus  = errors.New("invalid order status")
+	// ErrInvalidTransition is returned when the order cannot move from its
+	// current status to the requested one.
+	ErrInvalidTransition = errors.New("invalid order status transition")
+	ErrOrderShipped      = errors.New("order has already been shipped")
+	// ErrOrderPaid is returned when a paid order would be cancelled without
+	// refunding its payment.
+	ErrOrderPaid = errors.New("order has been paid, it must be refunded when cancelled")
 )
 
 type CreateOrderParams struct {
-	CustomerId int64              `json:"customer_id"`
-	Items      []OrderItemsParams `json:"items"`
+	CustomerId        int64              `json:"customer_id"`
+	ShippingAddressId int64              `json:"shipping_address_id"`
+	Items             []OrderItemsParams `json:"items"`
 }
 
 type OrderItemsParams struct {
@@ -25,84 +46,252 @@ type OrderItemsParams struct {
 	Quantity  int32 `json:"quantity"`
 }
 
+// TransitionParams is a change of status. ChangedBy is not read from the
+// request, the handlers set it to the principal.
+type TransitionParams struct {
+	Status    Status `json:"status"`
+	ChangedBy string `json:"-"`
+	Reason    string `json:"reason"`
+}
+
+type CancelParams struct {
+	ChangedBy string `json:"-"`
+	Reason    string `json:"reason"`
+}
+
+// ListOrdersParams filters and paginates the orders listing, newest first.
+// CreatedTo is exclusive.
+type ListOrdersParams struct {
+	Cursor      string
+	Limit       int32
+	CustomerId  *int64
+	Status      Status
+	CreatedFrom *time.Time
+	CreatedTo   *time.Time
+	MinTotal    *int64
+}
+
+type orderCursor struct {
+	ID int64 `json:"id"`
+}
+
+// OrderItem is an order line with the locations it is shipped from.
+type OrderItem struct {
+	repo.OrderItem
+	Allocations []products.Allocation `json:"allocations"`
+}
+
 type OrderCompleted struct {
-	Order             repo.Order       `json:"order"`
-	Items             []repo.OrderItem `json:"items"`
-	TotalPriceInCents int64            `json:"total_price_in_cents"`
+	Order             repo.Order         `json:"order"`
+	Items             []OrderItem        `json:"items"`
+	ShippingAddress   *repo.OrderAddress `json:"shipping_address"`
+	TotalPriceInCents int64              `json:"total_price_in_cents"`
 }
 
 type Service interface {
 	PlaceOrder(ctx context.Context, op CreateOrderParams) (repo.Order, error)
+	PlaceOrderWith(ctx context.Context, op CreateOrderParams, fn func(qtx *repo.Queries, order repo.Order) error) (repo.Order, error)
 	FindOrderById(ctx context.Context, id int64) (OrderCompleted, error)
+	TransitionOrder(ctx context.Context, id int64, tp TransitionParams) (repo.Order, error)
+	ListOrderTransitions(ctx context.Context, id int64) ([]repo.OrderStatusChange, error)
+	CancelOrder(ctx context.Context, id int64, cp CancelParams) (repo.Order, error)
+	CancelOrderWith(ctx context.Context, id int64, cp CancelParams, refund func(qtx *repo.Queries, order repo.Order) error) (repo.Order, error)
+	ListOrders(ctx context.Context, lp ListOrdersParams) (pagination.Page[repo.ListOrdersRow], error)
+	ListCustomerOrders(ctx context.Context, customerId int64, lp ListOrdersParams) (pagination.Page[repo.ListOrdersRow], error)
 }
 
 type svc struct {
 	repo            *repo.Queries
 	db              utils.DBConn
 	productsService products.Service
+	allocator       products.Allocator
 }
 
-func NewService(repo *repo.Queries, db *pgx.Conn, ps products.Service) Service {
-	return &svc{repo: repo, db: db, productsService: ps}
+func NewService(repo *repo.Queries, db *pgxpool.Pool, ps products.Service, allocator products.Allocator) Service {
+	return &svc{repo: repo, db: db, productsService: ps, allocator: allocator}
 }
 
 // NewServiceWithDB allows injecting a dbConn interface for testing
-func NewServiceWithDB(repo *repo.Queries, db utils.DBConn, ps products.Service) Service {
-	return &svc{repo: repo, db: db, productsService: ps}
+func NewServiceWithDB(repo *repo.Queries, db utils.DBConn, ps products.Service, allocator products.Allocator) Service {
+	return &svc{repo: repo, db: db, productsService: ps, allocator: allocator}
 }
 
+// PlaceOrder places the order for the customer of the request when it is made
+// by a customer, whatever the customer id of the params.
 func (s *svc) PlaceOrder(ctx context.Context, op CreateOrderParams) (repo.Order, error) {
-	if op.CustomerId == 0 {
+	ctx, span := tracing.Start(ctx, "orders.PlaceOrder")
+	defer span.End()
+	return s.place(ctx, op, nil)
+}
+
+// PlaceOrderWith places the order like PlaceOrder and runs fn in the
+// transaction of the order once it is placed, so the changes of the caller
+// are committed with the order or not at all.
+func (s *svc) PlaceOrderWith(ctx context.Context, op CreateOrderParams, fn func(qtx *repo.Queries, order repo.Order) error) (repo.Order, error) {
+	ctx, span := tracing.Start(ctx, "orders.PlaceOrderWith")
+	defer span.End()
+	return s.place(ctx, op, fn)
+}
+
+func (s *svc) place(ctx context.Context, op CreateOrderParams, fn func(qtx *repo.Queries, order repo.Order) error) (repo.Order, error) {
+	if customerId, ok := auth.CustomerFrom(ctx); ok {
+		op.CustomerId = customerId
+	}
+	if op.CustomerId == 0 || op.ShippingAddressId == 0 {
 		return repo.Order{}, ErrInvalidOrder
 	}
 	if len(op.Items) == 0 {
 		return repo.Order{}, ErrInvalidOrder
 	}
+	order, placed, err := s.placeOrder(ctx, op, fn)
+	if err != nil {
+		if errors.Is(err, ErrProductNoStock) {
+			metrics.OutOfStockRejections.Inc()
+		}
+		return repo.Order{}, err
+	}
+	metrics.OrdersPlaced.Inc()
+	for _, item := range placed.Items {
+		metrics.ItemsSold.Add(float64(item.Quantity))
+	}
+	metrics.RevenueCents.Add(float64(placed.TotalPriceInCents))
+	return order, nil
+}
+
+// placeOrder places the order in a transaction and returns the order.placed
+// event recorded with it.
+func (s *svc) placeOrder(ctx context.Context, op CreateOrderParams, fn func(qtx *repo.Queries, order repo.Order) error) (repo.Order, outbox.OrderPlaced, error) {
 	// transactional
-	// 1. create order
-	// 2. look for the product if exists
-	// 3. create order items
+	// 1. validate the customer and create the order with a snapshot of the
+	//    shipping address
+	// 2. reserve the stock of every product at the locations chosen by the
+	//    allocator, with the product row locked so concurrent orders can
+	//    never oversell the same units
+	// 3. create order items with the price at the time of the order and
+	//    their allocation
+	// 4. record the order.placed event
+	// 5. run the changes of the caller, if any
 	tx, err := s.db.Begin(ctx) // begin transaction
 	if err != nil {
-		return repo.Order{}, err
+		return repo.Order{}, outbox.OrderPlaced{}, err
 	}
 	defer tx.Rollback(ctx) // if anything goes wrong, rollback
 	qtx := s.repo.WithTx(tx)
+	address, err := customers.FindShippingAddress(ctx, qtx, op.CustomerId, op.ShippingAddressId)
+	if err != nil {
+		return repo.Order{}, outbox.OrderPlaced{}, err
+	}
 	order, err := qtx.CreateOrder(ctx, op.CustomerId)
 	if err != nil {
-		return repo.Order{}, err
+		return repo.Order{}, outbox.OrderPlaced{}, err
 	}
-	for _, item := range op.Items {
-		product, err := s.repo.FindProductById(ctx, item.ProductId)
-		if err != nil {
-			return repo.Order{}, products.ErrProductNotFound
+	_, err = qtx.CreateOrderAddress(ctx, repo.CreateOrderAddressParams{
+		OrderID:    order.ID,
+		Kind:       customers.AddressShipping,
+		Line1:      address.Line1,
+		Line2:      address.Line2,
+		City:       address.City,
+		State:      address.State,
+		PostalCode: address.PostalCode,
+		Country:    address.Country,
+	})
+	if err != nil {
+		return repo.Order{}, outbox.OrderPlaced{}, err
+	}
+	// Reserve stock in product id order so that two orders touching the same
+	// products always lock the rows in the same sequence and cannot deadlock.
+	reserve := make([]int, len(op.Items))
+	for i := range reserve {
+		reserve[i] = i
+	}
+	slices.SortStableFunc(reserve, func(a, b int) int {
+		return cmp.Compare(op.Items[a].ProductId, op.Items[b].ProductId)
+	})
+	reserved := make(map[int64]repo.Product, len(reserve))
+	allocations := make([][]products.Allocation, len(op.Items))
+	for _, i := range reserve {
+		item := op.Items[i]
+		if item.Quantity <= 0 {
+			return repo.Order{}, outbox.OrderPlaced{}, ErrInvalidOrder
 		}
-		if product.Quantity < item.Quantity {
-			return repo.Order{}, ErrProductNoStock
+		product, allocation, err := products.AllocateStock(ctx, qtx, s.allocator, item.ProductId, item.Quantity, products.Movement{
+			Reason:  products.ReasonSale,
+			OrderId: order.ID,
+		})
+		if err != nil {
+			return repo.Order{}, outbox.OrderPlaced{}, err
 		}
-		_, err = qtx.CreateOrderItem(ctx, repo.CreateOrderItemParams{
+		reserved[product.ID] = product
+		allocations[i] = allocation
+	}
+	placed := outbox.OrderPlaced{OrderId: order.ID, CustomerId: order.CustomerID}
+	for i, item := range op.Items {
+		product := reserved[item.ProductId]
+		orderItem, err := qtx.CreateOrderItem(ctx, repo.CreateOrderItemParams{
 			OrderID:    order.ID,
 			ProductID:  product.ID,
 			Quantity:   item.Quantity,
 			PriceCents: product.PriceInCents,
 		})
-		_, err = s.productsService.RemoveProductStock(ctx, product.ID, item.Quantity)
 		if err != nil {
-			return repo.Order{}, err
+			return repo.Order{}, outbox.OrderPlaced{}, err
 		}
+		for _, a := range allocations[i] {
+			_, err = qtx.CreateOrderItemAllocation(ctx, repo.CreateOrderItemAllocationParams{
+				OrderItemID: orderItem.ID,
+				LocationID:  a.LocationId,
+				Quantity:    a.Quantity,
+			})
+			if err != nil {
+				return repo.Order{}, outbox.OrderPlaced{}, err
+			}
+		}
+		placed.Items = append(placed.Items, outbox.OrderPlacedItem{
+			ProductId:  product.ID,
+			Quantity:   item.Quantity,
+			PriceCents: product.PriceInCents,
+		})
+		placed.TotalPriceInCents += int64(item.Quantity) * int64(product.PriceInCents)
 	}
-	tx.Commit(ctx)
-	return order, nil
+	if err := outbox.Record(ctx, qtx, outbox.AggregateOrder, order.ID, outbox.EventOrderPlaced, placed); err != nil {
+		return repo.Order{}, outbox.OrderPlaced{}, err
+	}
+	if fn != nil {
+		if err := fn(qtx, order); err != nil {
+			return repo.Order{}, outbox.OrderPlaced{}, err
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return repo.Order{}, outbox.OrderPlaced{}, err
+	}
+	return order, placed, nil
 }
 
 func (s *svc) FindOrderById(ctx context.Context, id int64) (OrderCompleted, error) {
+	ctx, span := tracing.Start(ctx, "orders.FindOrderById")
+	defer span.End()
 	rows, err := s.repo.FindOrderById(ctx, id)
 	if err != nil {
 		return OrderCompleted{}, err
 	}
+	// The orders of other customers are hidden from a customer
+	if len(rows) == 0 || !auth.CanAccessCustomer(ctx, rows[0].CustomerID) {
+		return OrderCompleted{}, ErrOrderNotFound
+	}
+	allocations, err := s.repo.ListOrderItemAllocations(ctx, id)
+	if err != nil {
+		return OrderCompleted{}, err
+	}
+	allocated := make(map[int64][]products.Allocation, len(allocations))
+	for _, a := range allocations {
+		allocated[a.OrderItemID] = append(allocated[a.OrderItemID], products.Allocation{
+			LocationId: a.LocationID,
+			Quantity:   a.Quantity,
+		})
+	}
 	o := OrderCompleted{
 		Order:             repo.Order{},
-		Items:             []repo.OrderItem{},
+		Items:             []OrderItem{},
 		TotalPriceInCents: 0,
 	}
 	for _, r := range rows {
@@ -110,16 +299,283 @@ func (s *svc) FindOrderById(ctx context.Context, id int64) (OrderCompleted, erro
 			ID:         r.OrderID,
 			CustomerID: r.CustomerID,
 			CreatedAt:  r.CreatedAt,
+			Status:     r.Status,
+		}
+		i := OrderItem{
+			OrderItem: repo.OrderItem{
+				ID:         r.OrderItemID.Int64,
+				OrderID:    r.OrderID,
+				ProductID:  r.ProductID.Int64,
+				Quantity:   r.Quantity.Int32,
+				PriceCents: r.PriceCents.Int32,
+			},
+			Allocations: allocated[r.OrderItemID.Int64],
 		}
-		i := repo.OrderItem{
-			ID:         r.OrderItemID.Int64,
-			OrderID:    r.OrderID,
-			ProductID:  r.ProductID.Int64,
-			Quantity:   r.Quantity.Int32,
-			PriceCents: r.PriceCents.Int32,
+		if i.Allocations == nil {
+			i.Allocations = []products.Allocation{}
 		}
 		o.Items = append(o.Items, i)
 		o.TotalPriceInCents += int64(r.Quantity.Int32) * int64(r.PriceCents.Int32)
 	}
+	addresses, err := s.repo.ListOrderAddresses(ctx, id)
+	if err != nil {
+		return OrderCompleted{}, err
+	}
+	for _, a := range addresses {
+		if a.Kind == customers.AddressShipping {
+			o.ShippingAddress = &a
+		}
+	}
 	return o, nil
 }
+
+func (s *svc) TransitionOrder(ctx context.Context, id int64, tp TransitionParams) (repo.Order, error) {
+	ctx, span := tracing.Start(ctx, "orders.TransitionOrder")
+	defer span.End()
+	if !tp.Status.Valid() {
+		return repo.Order{}, ErrInvalidStatus
+	}
+	if tp.ChangedBy == "" {
+		return repo.Order{}, ErrInvalidOrder
+	}
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return repo.Order{}, err
+	}
+	defer tx.Rollback(ctx)
+	qtx := s.repo.WithTx(tx)
+	order, err := findOrderForUpdate(ctx, qtx, id)
+	if err != nil {
+		return repo.Order{}, err
+	}
+	if tp.Status == StatusCancelled && paid(order) {
+		return repo.Order{}, ErrOrderPaid
+	}
+	order, err = transition(ctx, qtx, order, tp)
+	if err != nil {
+		return repo.Order{}, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return repo.Order{}, err
+	}
+	return order, nil
+}
+
+// findOrderForUpdate locks the order row until the transaction ends.
+func findOrderForUpdate(ctx context.Context, qtx *repo.Queries, id int64) (repo.Order, error) {
+	order, err := qtx.FindOrderByIdForUpdate(ctx, id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return repo.Order{}, ErrOrderNotFound
+	}
+	return order, err
+}
+
+// transition validates the move of a locked order against the state machine
+// and records who requested it. It must run inside a transaction.
+func transition(ctx context.Context, qtx *repo.Queries, order repo.Order, tp TransitionParams) (repo.Order, error) {
+	id := order.ID
+	from := Status(order.Status)
+	if !from.CanTransitionTo(tp.Status) {
+		return repo.Order{}, ErrInvalidTransition
+	}
+	order, err := qtx.UpdateOrderStatus(ctx, repo.UpdateOrderStatusParams{
+		ID:     id,
+		Status: string(tp.Status),
+	})
+	if err != nil {
+		return repo.Order{}, err
+	}
+	if tp.Status == StatusCancelled {
+		if err := restock(ctx, qtx, id); err != nil {
+			return repo.Order{}, err
+		}
+		err := outbox.Record(ctx, qtx, outbox.AggregateOrder, id, outbox.EventOrderCancelled, outbox.OrderCancelled{
+			OrderId:    id,
+			CustomerId: order.CustomerID,
+			FromStatus: string(from),
+			ChangedBy:  tp.ChangedBy,
+			Reason:     tp.Reason,
+		})
+		if err != nil {
+			return repo.Order{}, err
+		}
+	}
+	_, err = qtx.CreateOrderStatusChange(ctx, repo.CreateOrderStatusChangeParams{
+		OrderID:    id,
+		FromStatus: string(from),
+		ToStatus:   string(tp.Status),
+		ChangedBy:  tp.ChangedBy,
+		Reason:     tp.Reason,
+	})
+	if err != nil {
+		return repo.Order{}, err
+	}
+	return order, nil
+}
+
+func (s *svc) ListOrderTransitions(ctx context.Context, id int64) ([]repo.OrderStatusChange, error) {
+	ctx, span := tracing.Start(ctx, "orders.ListOrderTransitions")
+	defer span.End()
+	if _, err := s.FindOrderById(ctx, id); err != nil {
+		return nil, err
+	}
+	changes, err := s.repo.ListOrderStatusChanges(ctx, id)
+	if changes == nil {
+		return []repo.OrderStatusChange{}, err
+	}
+	return changes, err
+}
+
+// CancelOrder cancels the order and returns its items to stock. Cancelling an
+// order that is already cancelled is a no-op, so clients can safely retry. A
+// customer only cancels its own orders. A paid order is not cancelled, as its
+// payment must be refunded with CancelOrderWith.
+func (s *svc) CancelOrder(ctx context.Context, id int64, cp CancelParams) (repo.Order, error) {
+	ctx, span := tracing.Start(ctx, "orders.CancelOrder")
+	defer span.End()
+	return s.cancel(ctx, id, cp, nil)
+}
+
+// CancelOrderWith cancels the order like CancelOrder and, when the order has
+// been paid, runs refund in the transaction of the cancellation, so the order
+// stays paid when the refund fails.
+func (s *svc) CancelOrderWith(ctx context.Context, id int64, cp CancelParams, refund func(qtx *repo.Queries, order repo.Order) error) (repo.Order, error) {
+	ctx, span := tracing.Start(ctx, "orders.CancelOrderWith")
+	defer span.End()
+	return s.cancel(ctx, id, cp, refund)
+}
+
+func (s *svc) cancel(ctx context.Context, id int64, cp CancelParams, refund func(qtx *repo.Queries, order repo.Order) error) (repo.Order, error) {
+	if cp.ChangedBy == "" {
+		return repo.Order{}, ErrInvalidOrder
+	}
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return repo.Order{}, err
+	}
+	defer tx.Rollback(ctx)
+	qtx := s.repo.WithTx(tx)
+	order, err := findOrderForUpdate(ctx, qtx, id)
+	if err == nil && !auth.CanAccessCustomer(ctx, order.CustomerID) {
+		err = ErrOrderNotFound
+	}
+	if err != nil {
+		return repo.Order{}, err
+	}
+	switch Status(order.Status) {
+	case StatusCancelled:
+		return order, nil
+	case StatusShipped, StatusDelivered:
+		return repo.Order{}, ErrOrderShipped
+	}
+	wasPaid := paid(order)
+	if wasPaid && refund == nil {
+		return repo.Order{}, ErrOrderPaid
+	}
+	order, err = transition(ctx, qtx, order, TransitionParams{
+		Status:    StatusCancelled,
+		ChangedBy: cp.ChangedBy,
+		Reason:    cp.Reason,
+	})
+	if err != nil {
+		return repo.Order{}, err
+	}
+	if wasPaid {
+		if err := refund(qtx, order); err != nil {
+			return repo.Order{}, err
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return repo.Order{}, err
+	}
+	return order, nil
+}
+
+// paid reports whether the customer has paid for the order, which has not
+// shipped yet.
+func paid(order repo.Order) bool {
+	status := Status(order.Status)
+	return status == StatusPaid || status == StatusFulfilled
+}
+
+// restock returns every item of the order to the locations it was allocated
+// from.
+func restock(ctx context.Context, qtx *repo.Queries, orderId int64) error {
+	allocations, err := qtx.ListOrderItemAllocations(ctx, orderId)
+	if err != nil {
+		return err
+	}
+	for _, a := range allocations {
+		if _, err := products.AddStock(ctx, qtx, a.ProductID, a.Quantity, products.Movement{
+			Reason:     products.ReasonCancellation,
+			OrderId:    orderId,
+			LocationId: a.LocationID,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListOrders lists the orders with their total, which is computed by the
+// database the same way FindOrderById computes it. A customer only gets its own
+// orders.
+func (s *svc) ListOrders(ctx context.Context, lp ListOrdersParams) (pagination.Page[repo.ListOrdersRow], error) {
+	ctx, span := tracing.Start(ctx, "orders.ListOrders")
+	defer span.End()
+	if customerId, ok := auth.CustomerFrom(ctx); ok {
+		lp.CustomerId = &customerId
+	}
+	if lp.Status != "" && !lp.Status.Valid() {
+		return pagination.Page[repo.ListOrdersRow]{}, ErrInvalidStatus
+	}
+	if lp.Limit == 0 {
+		lp.Limit = pagination.DefaultLimit
+	}
+	params := repo.ListOrdersParams{RowLimit: lp.Limit + 1}
+	if lp.CustomerId != nil {
+		params.CustomerID = pgtype.Int8{Int64: *lp.CustomerId, Valid: true}
+	}
+	if lp.Status != "" {
+		params.Status = pgtype.Text{String: string(lp.Status), Valid: true}
+	}
+	if lp.CreatedFrom != nil {
+		params.CreatedFrom = pgtype.Timestamptz{Time: *lp.CreatedFrom, Valid: true}
+	}
+	if lp.CreatedTo != nil {
+		params.CreatedTo = pgtype.Timestamptz{Time: *lp.CreatedTo, Valid: true}
+	}
+	if lp.MinTotal != nil {
+		params.MinTotal = pgtype.Int8{Int64: *lp.MinTotal, Valid: true}
+	}
+	if lp.Cursor != "" {
+		var c orderCursor
+		if err := pagination.DecodeCursor(lp.Cursor, &c); err != nil {
+			return pagination.Page[repo.ListOrdersRow]{}, err
+		}
+		params.CursorID = pgtype.Int8{Int64: c.ID, Valid: true}
+	}
+	rows, err := s.repo.ListOrders(ctx, params)
+	if err != nil {
+		return pagination.Page[repo.ListOrdersRow]{}, err
+	}
+	return pagination.NewPage(rows, lp.Limit, func(last repo.ListOrdersRow) (string, error) {
+		return pagination.EncodeCursor(orderCursor{ID: last.ID})
+	})
+}
+
+func (s *svc) ListCustomerOrders(ctx context.Context, customerId int64, lp ListOrdersParams) (pagination.Page[repo.ListOrdersRow], error) {
+	ctx, span := tracing.Start(ctx, "orders.ListCustomerOrders")
+	defer span.End()
+	if lp.Status != "" && !lp.Status.Valid() {
+		return pagination.Page[repo.ListOrdersRow]{}, ErrInvalidStatus
+	}
+	if !auth.CanAccessCustomer(ctx, customerId) {
+		return pagination.Page[repo.ListOrdersRow]{}, customers.ErrCustomerNotFound
+	}
+	if _, err := customers.FindCustomer(ctx, s.repo, customerId); err != nil {
+		return pagination.Page[repo.ListOrdersRow]{}, err
+	}
+	lp.CustomerId = &customerId
+	return s.ListOrders(ctx, lp)
+}
@@ -0,0 +1,87 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+
+	repo "github.com/mellomaths/ecommerce-ms/internal/adapters/postgresql/sqlc"
+)
+
+// Aggregates the events belong to. Events of the same aggregate are published
+// in the order they were recorded.
+const (
+	AggregateOrder   = "order"
+	AggregateProduct = "product"
+)
+
+const (
+	EventOrderPlaced         = "order.placed"
+	EventOrderCancelled      = "order.cancelled"
+	EventProductCreated      = "product.created"
+	EventProductStockChanged = "product.stock_changed"
+)
+
+// EventTypes lists every event type recorded in the outbox.
+var EventTypes = []string{
+	EventOrderPlaced,
+	EventOrderCancelled,
+	EventProductCreated,
+	EventProductStockChanged,
+}
+
+type OrderPlacedItem struct {
+	ProductId  int64 `json:"product_id"`
+	Quantity   int32 `json:"quantity"`
+	PriceCents int32 `json:"price_cents"`
+}
+
+type OrderPlaced struct {
+	OrderId           int64             `json:"order_id"`
+	CustomerId        int64             `json:"customer_id"`
+	Items             []OrderPlacedItem `json:"items"`
+	TotalPriceInCents int64             `json:"total_price_in_cents"`
+}
+
+type OrderCancelled struct {
+	OrderId    int64  `json:"order_id"`
+	CustomerId int64  `json:"customer_id"`
+	FromStatus string `json:"from_status"`
+	ChangedBy  string `json:"changed_by"`
+	Reason     string `json:"reason"`
+}
+
+type ProductCreated struct {
+	ProductId    int64  `json:"product_id"`
+	Name         string `json:"name"`
+	PriceInCents int32  `json:"price_in_cents"`
+	Quantity     int32  `json:"quantity"`
+}
+
+// ProductStockChanged carries the change at a location, the total quantity
+// after it and the reason of the inventory movement. OrderId is 0 when no
+// order is involved.
+type ProductStockChanged struct {
+	ProductId  int64  `json:"product_id"`
+	LocationId int64  `json:"location_id"`
+	Delta      int32  `json:"delta"`
+	Quantity   int32  `json:"quantity"`
+	Reason     string `json:"reason"`
+	OrderId    int64  `json:"order_id,omitempty"`
+}
+
+// Record writes an event to the outbox. Pass the queries of the transaction
+// that makes the change, so the event is stored if and only if the change is
+// committed.
+func Record(ctx context.Context, q repo.Querier, aggregateType string, aggregateId int64, eventType string, payload any) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	_, err = q.CreateOutboxEvent(ctx, repo.CreateOutboxEventParams{
+		AggregateType: aggregateType,
+		AggregateID:   aggregateId,
+		EventType:     eventType,
+		Payload:       data,
+	})
+	return err
+}
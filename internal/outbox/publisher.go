@@ -0,0 +1,48 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	repo "github.com/mellomaths/ecommerce-ms/internal/adapters/postgresql/sqlc"
+)
+
+// Publisher delivers the events to the outside world. Delivery is at least
+// once: an event may be published again if the relay stops before recording
+// it, so consumers should deduplicate on the event id.
+type Publisher interface {
+	Publish(ctx context.Context, event repo.Outbox) error
+}
+
+// LogPublisher writes the events to the log, for development.
+type LogPublisher struct{}
+
+func NewLogPublisher() *LogPublisher {
+	return &LogPublisher{}
+}
+
+func (p *LogPublisher) Publish(ctx context.Context, event repo.Outbox) error {
+	slog.InfoContext(ctx, "event published",
+		"id", event.ID,
+		"type", event.EventType,
+		"aggregate_type", event.AggregateType,
+		"aggregate_id", event.AggregateID,
+		"payload", string(event.Payload),
+	)
+	return nil
+}
+
+// MultiPublisher publishes every event to all its publishers. An event is
+// published again to all of them if any fails.
+type MultiPublisher []Publisher
+
+func (m MultiPublisher) Publish(ctx context.Context, event repo.Outbox) error {
+	var errs []error
+	for _, p := range m {
+		if err := p.Publish(ctx, event); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
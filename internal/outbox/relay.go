@@ -0,0 +1,112 @@
+package outbox
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	repo "github.com/mellomaths/ecommerce-ms/internal/adapters/postgresql/sqlc"
+	"github.com/mellomaths/ecommerce-ms/internal/health"
+	"github.com/mellomaths/ecommerce-ms/internal/utils"
+)
+
+// minBackoff is the wait before the first retry of a failed event, it doubles
+// on every attempt up to the relay maximum.
+const minBackoff = time.Second
+
+// Relay periodically publishes the pending outbox events. Several relays can
+// run at once, the rows they are publishing are skipped by the others.
+type Relay struct {
+	repo       *repo.Queries
+	db         utils.DBConn
+	publisher  Publisher
+	interval   time.Duration
+	batchSize  int32
+	maxBackoff time.Duration
+}
+
+func NewRelay(repo *repo.Queries, db utils.DBConn, publisher Publisher, interval time.Duration, batchSize int32, maxBackoff time.Duration) *Relay {
+	return &Relay{
+		repo:       repo,
+		db:         db,
+		publisher:  publisher,
+		interval:   interval,
+		batchSize:  batchSize,
+		maxBackoff: maxBackoff,
+	}
+}
+
+// Run relays the pending events every interval until ctx is done.
+func (r *Relay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			// Keep going while there is work, a batch only holds the next
+			// event of every aggregate
+			for {
+				health.Beat(ctx)
+				relayed, err := r.RelayBatch(ctx)
+				if err != nil {
+					slog.Error("failed to relay outbox events", "error", err)
+					break
+				}
+				if relayed == 0 {
+					break
+				}
+			}
+		}
+	}
+}
+
+// RelayBatch publishes one batch of due events and returns how many were
+// attempted. A failed event is rescheduled with an exponential backoff and
+// holds back the later events of its aggregate.
+func (r *Relay) RelayBatch(ctx context.Context) (int, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+	qtx := r.repo.WithTx(tx)
+	events, err := qtx.ListPendingOutboxEvents(ctx, r.batchSize)
+	if err != nil {
+		return 0, err
+	}
+	for _, event := range events {
+		if err := r.publisher.Publish(ctx, event); err != nil {
+			slog.Warn("failed to publish outbox event", "id", event.ID, "type", event.EventType, "attempts", event.Attempts+1, "error", err)
+			err = qtx.MarkOutboxEventFailed(ctx, repo.MarkOutboxEventFailedParams{
+				ID:            event.ID,
+				LastError:     err.Error(),
+				NextAttemptAt: pgtype.Timestamptz{Time: time.Now().Add(r.backoff(event.Attempts)), Valid: true},
+			})
+			if err != nil {
+				return 0, err
+			}
+			continue
+		}
+		if err := qtx.MarkOutboxEventPublished(ctx, event.ID); err != nil {
+			return 0, err
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+	return len(events), nil
+}
+
+func (r *Relay) backoff(attempts int32) time.Duration {
+	d := minBackoff
+	for range attempts {
+		d *= 2
+		if d >= r.maxBackoff {
+			return r.maxBackoff
+		}
+	}
+	return min(d, r.maxBackoff)
+}
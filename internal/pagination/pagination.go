@@ -0,0 +1,77 @@
+package pagination
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strconv"
+)
+
+const (
+	DefaultLimit = 20
+	MaxLimit     = 100
+)
+
+var (
+	ErrInvalidCursor = errors.New("invalid cursor")
+	ErrInvalidLimit  = errors.New("invalid limit")
+)
+
+// Page is the envelope returned by every paginated listing. NextCursor is
+// empty on the last page.
+type Page[T any] struct {
+	Data       []T    `json:"data"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// NewPage builds a page out of rows fetched with limit+1, using the extra row
+// only to know whether there is a next page. cursor builds the cursor of the
+// last row returned.
+func NewPage[T any](rows []T, limit int32, cursor func(last T) (string, error)) (Page[T], error) {
+	if len(rows) <= int(limit) {
+		if rows == nil {
+			rows = []T{}
+		}
+		return Page[T]{Data: rows}, nil
+	}
+	rows = rows[:limit]
+	next, err := cursor(rows[len(rows)-1])
+	if err != nil {
+		return Page[T]{}, err
+	}
+	return Page[T]{Data: rows, NextCursor: next}, nil
+}
+
+// ParseLimit parses the limit query parameter, an empty value falls back to
+// DefaultLimit.
+func ParseLimit(s string) (int32, error) {
+	if s == "" {
+		return DefaultLimit, nil
+	}
+	limit, err := strconv.ParseInt(s, 10, 32)
+	if err != nil || limit < 1 || limit > MaxLimit {
+		return 0, ErrInvalidLimit
+	}
+	return int32(limit), nil
+}
+
+// EncodeCursor serializes the position of a row into an opaque cursor.
+func EncodeCursor(v any) (string, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// DecodeCursor reads a cursor created by EncodeCursor into v.
+func DecodeCursor(cursor string, v any) error {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return ErrInvalidCursor
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return ErrInvalidCursor
+	}
+	return nil
+}
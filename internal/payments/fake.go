@@ -0,0 +1,94 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Payment tokens understood by FakeGateway. Any other token is authorized.
+const (
+	FakeTokenDeclined      = "tok_declined"
+	FakeTokenCaptureFailed = "tok_capture_failed"
+	FakeTokenUnavailable   = "tok_unavailable"
+)
+
+var errFakeUnavailable = errors.New("fake gateway unavailable")
+
+type fakeAuthorization struct {
+	amountInCents int64
+	captured      int64
+	refunded      int64
+	voided        bool
+	failCapture   bool
+}
+
+// FakeGateway is an in-process gateway for development and tests. Its outcome
+// only depends on the payment token, so runs are reproducible.
+type FakeGateway struct {
+	mu             sync.Mutex
+	authorizations map[string]*fakeAuthorization
+	refunds        map[string]bool
+}
+
+func NewFakeGateway() *FakeGateway {
+	return &FakeGateway{authorizations: map[string]*fakeAuthorization{}, refunds: map[string]bool{}}
+}
+
+func (g *FakeGateway) Authorize(ctx context.Context, req AuthorizeRequest) (string, error) {
+	switch req.PaymentToken {
+	case FakeTokenDeclined:
+		return "", ErrPaymentDeclined
+	case FakeTokenUnavailable:
+		return "", errFakeUnavailable
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	reference := fmt.Sprintf("fake_%d_%d", req.OrderId, req.PaymentId)
+	g.authorizations[reference] = &fakeAuthorization{
+		amountInCents: req.AmountInCents,
+		failCapture:   req.PaymentToken == FakeTokenCaptureFailed,
+	}
+	return reference, nil
+}
+
+func (g *FakeGateway) Capture(ctx context.Context, reference string, amountInCents int64) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	a, ok := g.authorizations[reference]
+	if !ok || a.voided || amountInCents > a.amountInCents-a.captured {
+		return fmt.Errorf("fake gateway: cannot capture %s", reference)
+	}
+	if a.failCapture {
+		return ErrPaymentDeclined
+	}
+	a.captured += amountInCents
+	return nil
+}
+
+func (g *FakeGateway) Void(ctx context.Context, reference string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	a, ok := g.authorizations[reference]
+	if !ok || a.captured > 0 {
+		return fmt.Errorf("fake gateway: cannot void %s", reference)
+	}
+	a.voided = true
+	return nil
+}
+
+func (g *FakeGateway) Refund(ctx context.Context, req RefundRequest) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.refunds[req.IdempotencyKey] {
+		return nil
+	}
+	a, ok := g.authorizations[req.Reference]
+	if !ok || req.AmountInCents > a.captured-a.refunded {
+		return fmt.Errorf("fake gateway: cannot refund %s", req.Reference)
+	}
+	a.refunded += req.AmountInCents
+	g.refunds[req.IdempotencyKey] = true
+	return nil
+}
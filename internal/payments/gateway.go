@@ -0,0 +1,37 @@
+package payments
+
+import (
+	"context"
+	"errors"
+)
+
+var (
+	// ErrPaymentDeclined is returned by a gateway when the payment method has
+	// been refused, retrying with the same payment method will not help.
+	ErrPaymentDeclined = errors.New("payment declined")
+)
+
+type AuthorizeRequest struct {
+	OrderId       int64
+	PaymentId     int64
+	AmountInCents int64
+	PaymentToken  string
+}
+
+// RefundRequest is a refund of a captured payment. A refund retried with the
+// same IdempotencyKey is only made once, so it is safe to retry a refund whose
+// outcome is unknown.
+type RefundRequest struct {
+	Reference      string
+	AmountInCents  int64
+	IdempotencyKey string
+}
+
+// Gateway is the payment provider. Authorizations are referenced by the
+// identifier the gateway returns from Authorize.
+type Gateway interface {
+	Authorize(ctx context.Context, req AuthorizeRequest) (string, error)
+	Capture(ctx context.Context, reference string, amountInCents int64) error
+	Void(ctx context.Context, reference string) error
+	Refund(ctx context.Context, req RefundRequest) error
+}
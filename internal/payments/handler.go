@@ -0,0 +1,117 @@
+package payments
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mellomaths/ecommerce-ms/internal/auth"
+	"github.com/mellomaths/ecommerce-ms/internal/logging"
+	"github.com/mellomaths/ecommerce-ms/internal/orders"
+	"github.com/mellomaths/ecommerce-ms/internal/requests"
+	"github.com/mellomaths/ecommerce-ms/internal/responses"
+)
+
+type handler struct {
+	service Service
+}
+
+func NewHandler(service Service) *handler {
+	return &handler{
+		service: service,
+	}
+}
+
+func (h *handler) PayOrder(w http.ResponseWriter, r *http.Request) {
+	orderId, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		logging.FromContext(r.Context()).Info("invalid request", "error", err)
+		responses.NewJsonErrorResponse(w, http.StatusBadRequest, "validation_error", "invalid order id")
+		return
+	}
+	var paymentParams PaymentParams
+	if err := requests.DecodeJsonBody(r, &paymentParams); err != nil {
+		logging.FromContext(r.Context()).Info("invalid request", "error", err)
+		responses.NewJsonErrorResponse(w, http.StatusBadRequest, "validation_error", "invalid payment")
+		return
+	}
+	p, err := h.service.PayOrder(r.Context(), orderId, paymentParams)
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("request failed", "error", err)
+		switch err {
+		case orders.ErrOrderNotFound:
+			responses.NewJsonErrorResponse(w, http.StatusNotFound, "not_found", err.Error())
+		case ErrInvalidPayment:
+			responses.NewJsonErrorResponse(w, http.StatusBadRequest, "validation_error", err.Error())
+		case ErrPaymentDeclined:
+			responses.NewJsonErrorResponse(w, http.StatusPaymentRequired, "payment_declined", err.Error())
+		case ErrOrderNotPayable, orders.ErrInvalidTransition:
+			responses.NewJsonErrorResponse(w, http.StatusConflict, "order_not_payable", err.Error())
+		case ErrPaymentInProgress:
+			responses.NewJsonErrorResponse(w, http.StatusConflict, "payment_in_progress", err.Error())
+		case ErrGatewayFailure:
+			responses.NewJsonErrorResponse(w, http.StatusBadGateway, "gateway_error", err.Error())
+		default:
+			responses.NewJsonErrorResponse(w, http.StatusInternalServerError, "server_error", "unexpected error when paying the order")
+		}
+		return
+	}
+	responses.NewJsonResponse(w, http.StatusCreated, p)
+}
+
+func (h *handler) ListOrderPayments(w http.ResponseWriter, r *http.Request) {
+	orderId, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		logging.FromContext(r.Context()).Info("invalid request", "error", err)
+		responses.NewJsonErrorResponse(w, http.StatusBadRequest, "validation_error", "invalid order id")
+		return
+	}
+	payments, err := h.service.ListOrderPayments(r.Context(), orderId)
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("request failed", "error", err)
+		if err == orders.ErrOrderNotFound {
+			responses.NewJsonErrorResponse(w, http.StatusNotFound, "not_found", err.Error())
+			return
+		}
+		responses.NewJsonErrorResponse(w, http.StatusInternalServerError, "server_error", "unexpected error when listing the order payments")
+		return
+	}
+	responses.NewJsonResponse(w, http.StatusOK, payments)
+}
+
+func (h *handler) CancelOrder(w http.ResponseWriter, r *http.Request) {
+	orderId, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		logging.FromContext(r.Context()).Info("invalid request", "error", err)
+		responses.NewJsonErrorResponse(w, http.StatusBadRequest, "validation_error", "invalid order id")
+		return
+	}
+	var cancelParams orders.CancelParams
+	if err := requests.DecodeJsonBody(r, &cancelParams); err != nil {
+		logging.FromContext(r.Context()).Info("invalid request", "error", err)
+		responses.NewJsonErrorResponse(w, http.StatusBadRequest, "validation_error", "invalid cancellation")
+		return
+	}
+	p, _ := auth.PrincipalFrom(r.Context())
+	cancelParams.ChangedBy = p.Subject
+	o, err := h.service.CancelOrder(r.Context(), orderId, cancelParams)
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("request failed", "error", err)
+		switch err {
+		case orders.ErrOrderNotFound:
+			responses.NewJsonErrorResponse(w, http.StatusNotFound, "not_found", err.Error())
+		case orders.ErrInvalidOrder:
+			responses.NewJsonErrorResponse(w, http.StatusBadRequest, "validation_error", err.Error())
+		case orders.ErrOrderShipped:
+			responses.NewJsonErrorResponse(w, http.StatusConflict, "order_shipped", err.Error())
+		case orders.ErrInvalidTransition:
+			responses.NewJsonErrorResponse(w, http.StatusConflict, "invalid_transition", err.Error())
+		case ErrGatewayFailure:
+			responses.NewJsonErrorResponse(w, http.StatusBadGateway, "gateway_error", err.Error())
+		default:
+			responses.NewJsonErrorResponse(w, http.StatusInternalServerError, "server_error", "unexpected error when cancelling the order")
+		}
+		return
+	}
+	responses.NewJsonResponse(w, http.StatusOK, o)
+}
@@ -0,0 +1,43 @@
+package payments
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/mellomaths/ecommerce-ms/internal/health"
+)
+
+// Releaser periodically cancels the orders whose payment failed and were not
+// paid within the reservation window, so their stock goes back on sale.
+type Releaser struct {
+	service  Service
+	window   time.Duration
+	interval time.Duration
+}
+
+func NewReleaser(service Service, window time.Duration, interval time.Duration) *Releaser {
+	return &Releaser{service: service, window: window, interval: interval}
+}
+
+// Run releases the expired reservations every interval until ctx is done.
+func (r *Releaser) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			health.Beat(ctx)
+			released, err := r.service.ReleaseFailedReservations(ctx, r.window)
+			if err != nil {
+				slog.Error("failed to release stock reservations", "error", err)
+				continue
+			}
+			if released > 0 {
+				slog.Info("released stock reservations", "orders", released)
+			}
+		}
+	}
+}
@@ -0,0 +1,313 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+	repo "github.com/mellomaths/ecommerce-ms/internal/adapters/postgresql/sqlc"
+	"github.com/mellomaths/ecommerce-ms/internal/logging"
+	"github.com/mellomaths/ecommerce-ms/internal/orders"
+	"github.com/mellomaths/ecommerce-ms/internal/tracing"
+)
+
+var (
+	ErrInvalidPayment    = errors.New("invalid payment")
+	ErrOrderNotPayable   = errors.New("order is not waiting for a payment")
+	ErrPaymentInProgress = errors.New("order already has a payment in progress")
+	ErrGatewayFailure    = errors.New("payment gateway failure")
+	ErrNoCapturedPayment = errors.New("order has no captured payment")
+	ErrRefundTooLarge    = errors.New("refund exceeds the amount left on the payment")
+)
+
+const (
+	StatusPending    = "pending"
+	StatusAuthorized = "authorized"
+	StatusCaptured   = "captured"
+	StatusFailed     = "failed"
+	StatusVoided     = "voided"
+	StatusRefunded   = "refunded"
+)
+
+// changedBy is recorded on the order transitions made by the payments.
+const changedBy = "payments"
+
+type PaymentParams struct {
+	PaymentToken string `json:"payment_token"`
+}
+
+type Service interface {
+	PayOrder(ctx context.Context, orderId int64, pp PaymentParams) (repo.Payment, error)
+	ListOrderPayments(ctx context.Context, orderId int64) ([]repo.Payment, error)
+	ReleaseFailedReservations(ctx context.Context, window time.Duration) (int, error)
+	RefundOrder(ctx context.Context, orderId int64, amountInCents int64, key string) (repo.Payment, error)
+	CancelOrder(ctx context.Context, orderId int64, cp orders.CancelParams) (repo.Order, error)
+}
+
+type svc struct {
+	repo          repo.Querier
+	gateway       Gateway
+	ordersService orders.Service
+}
+
+func NewService(repo repo.Querier, gateway Gateway, os orders.Service) Service {
+	return &svc{repo: repo, gateway: gateway, ordersService: os}
+}
+
+// PayOrder charges the order total and moves the order from pending to paid.
+// A failed payment leaves the order pending with its stock reserved, so the
+// customer can retry until ReleaseFailedReservations cancels it.
+func (s *svc) PayOrder(ctx context.Context, orderId int64, pp PaymentParams) (repo.Payment, error) {
+	ctx, span := tracing.Start(ctx, "payments.PayOrder")
+	defer span.End()
+	if pp.PaymentToken == "" {
+		return repo.Payment{}, ErrInvalidPayment
+	}
+	order, err := s.ordersService.FindOrderById(ctx, orderId)
+	if err != nil {
+		return repo.Payment{}, err
+	}
+	if orders.Status(order.Order.Status) != orders.StatusPending {
+		return repo.Payment{}, ErrOrderNotPayable
+	}
+	payment, err := s.repo.CreatePayment(ctx, repo.CreatePaymentParams{
+		OrderID:       orderId,
+		AmountInCents: order.TotalPriceInCents,
+	})
+	if isUniqueViolation(err) {
+		return repo.Payment{}, ErrPaymentInProgress
+	}
+	if err != nil {
+		return repo.Payment{}, err
+	}
+
+	reference, err := s.gateway.Authorize(ctx, AuthorizeRequest{
+		OrderId:       orderId,
+		PaymentId:     payment.ID,
+		AmountInCents: payment.AmountInCents,
+		PaymentToken:  pp.PaymentToken,
+	})
+	if err != nil {
+		return s.fail(ctx, payment, "", err)
+	}
+	payment, err = s.update(ctx, payment, StatusAuthorized, reference, "")
+	if err != nil {
+		return repo.Payment{}, err
+	}
+	if err := s.gateway.Capture(ctx, reference, payment.AmountInCents); err != nil {
+		if voidErr := s.gateway.Void(ctx, reference); voidErr != nil {
+			logging.FromContext(ctx).Error("failed to void the payment", "payment", payment.ID, "error", voidErr)
+		}
+		return s.fail(ctx, payment, reference, err)
+	}
+	payment, err = s.update(ctx, payment, StatusCaptured, reference, "")
+	if err != nil {
+		return repo.Payment{}, err
+	}
+
+	_, err = s.ordersService.TransitionOrder(ctx, orderId, orders.TransitionParams{
+		Status:    orders.StatusPaid,
+		ChangedBy: changedBy,
+		Reason:    fmt.Sprintf("payment %d captured", payment.ID),
+	})
+	if err != nil {
+		// The order changed while it was being paid (e.g. it was cancelled),
+		// so the money goes back to the customer.
+		refundErr := s.gateway.Refund(ctx, RefundRequest{
+			Reference:      reference,
+			AmountInCents:  payment.AmountInCents,
+			IdempotencyKey: fmt.Sprintf("payment-%d", payment.ID),
+		})
+		if refundErr != nil {
+			logging.FromContext(ctx).Error("failed to refund the payment of a changed order", "payment", payment.ID, "error", refundErr)
+			return repo.Payment{}, err
+		}
+		if _, updateErr := s.update(ctx, payment, StatusRefunded, reference, err.Error()); updateErr != nil {
+			logging.FromContext(ctx).Error("failed to record the refund of the payment", "payment", payment.ID, "error", updateErr)
+		}
+		return repo.Payment{}, err
+	}
+	return payment, nil
+}
+
+func (s *svc) ListOrderPayments(ctx context.Context, orderId int64) ([]repo.Payment, error) {
+	ctx, span := tracing.Start(ctx, "payments.ListOrderPayments")
+	defer span.End()
+	if _, err := s.ordersService.FindOrderById(ctx, orderId); err != nil {
+		return nil, err
+	}
+	payments, err := s.repo.ListOrderPayments(ctx, orderId)
+	if payments == nil {
+		return []repo.Payment{}, err
+	}
+	return payments, err
+}
+
+// ReleaseFailedReservations cancels the pending orders whose last payment
+// failed more than window ago, returning their stock. It returns how many
+// orders were cancelled.
+//
+// The payments still pending or authorized after window, left by a request
+// that crashed or never heard back from the gateway, are failed first so the
+// customer can pay again, the authorized ones once voided at the gateway.
+// Their orders are cancelled a window later if they are not paid.
+func (s *svc) ReleaseFailedReservations(ctx context.Context, window time.Duration) (int, error) {
+	ctx, span := tracing.Start(ctx, "payments.ReleaseFailedReservations")
+	defer span.End()
+	expired, err := s.repo.ExpirePendingPayments(ctx, pgtype.Timestamptz{Time: time.Now().Add(-window), Valid: true})
+	if err != nil {
+		return 0, err
+	}
+	if expired > 0 {
+		logging.FromContext(ctx).Warn("failed the payments left pending", "payments", expired)
+	}
+	authorized, err := s.repo.ListExpiredAuthorizedPayments(ctx, repo.ListExpiredAuthorizedPaymentsParams{
+		AuthorizedBefore: pgtype.Timestamptz{Time: time.Now().Add(-window), Valid: true},
+		RowLimit:         100,
+	})
+	if err != nil {
+		return 0, err
+	}
+	for _, payment := range authorized {
+		if err := s.gateway.Void(ctx, payment.GatewayReference); err != nil {
+			// Retried on the next run
+			logging.FromContext(ctx).Error("failed to void the payment left authorized", "payment", payment.ID, "error", err)
+			continue
+		}
+		if _, err := s.update(ctx, payment, StatusFailed, payment.GatewayReference, "payment expired"); err != nil {
+			return 0, err
+		}
+		logging.FromContext(ctx).Warn("voided the payment left authorized", "payment", payment.ID)
+	}
+	ids, err := s.repo.ListOrdersWithFailedPayment(ctx, repo.ListOrdersWithFailedPaymentParams{
+		FailedBefore: pgtype.Timestamptz{Time: time.Now().Add(-window), Valid: true},
+		RowLimit:     100,
+	})
+	if err != nil {
+		return 0, err
+	}
+	released := 0
+	for _, id := range ids {
+		_, err := s.ordersService.CancelOrder(ctx, id, orders.CancelParams{
+			ChangedBy: changedBy,
+			Reason:    "payment window expired",
+		})
+		if err != nil {
+			// The order may have been paid or cancelled in the meantime
+			logging.FromContext(ctx).Warn("failed to cancel the order with a failed payment", "order", id, "error", err)
+			continue
+		}
+		released++
+	}
+	return released, nil
+}
+
+// RefundOrder refunds part or all of the captured payment of the order at the
+// gateway and returns the payment. The refund is not recorded on the payment,
+// the caller records it with RecordRefund along with its own changes, and
+// retries it with the same key until it is recorded.
+func (s *svc) RefundOrder(ctx context.Context, orderId int64, amountInCents int64, key string) (repo.Payment, error) {
+	ctx, span := tracing.Start(ctx, "payments.RefundOrder")
+	defer span.End()
+	if amountInCents <= 0 {
+		return repo.Payment{}, ErrInvalidPayment
+	}
+	payment, err := s.repo.FindCapturedPayment(ctx, orderId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return repo.Payment{}, ErrNoCapturedPayment
+	}
+	if err != nil {
+		return repo.Payment{}, err
+	}
+	if amountInCents > payment.AmountInCents-payment.RefundedInCents {
+		return repo.Payment{}, ErrRefundTooLarge
+	}
+	err = s.gateway.Refund(ctx, RefundRequest{
+		Reference:      payment.GatewayReference,
+		AmountInCents:  amountInCents,
+		IdempotencyKey: key,
+	})
+	if err != nil {
+		logging.FromContext(ctx).Error("failed to refund the payment", "payment", payment.ID, "error", err)
+		return repo.Payment{}, ErrGatewayFailure
+	}
+	return payment, nil
+}
+
+// RecordRefund adds the amount to the refunds of the payment, which becomes
+// refunded once nothing is left to refund.
+func RecordRefund(ctx context.Context, q repo.Querier, paymentId int64, amountInCents int64) (repo.Payment, error) {
+	payment, err := q.RefundPayment(ctx, repo.RefundPaymentParams{
+		ID:     paymentId,
+		Amount: amountInCents,
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		// Another refund was recorded since the payment was read
+		return repo.Payment{}, ErrRefundTooLarge
+	}
+	return payment, err
+}
+
+// CancelOrder cancels the order and, when it has been paid, refunds what is
+// left of its captured payment. The refund is recorded in the transaction of
+// the cancellation and the order stays paid when the gateway fails.
+func (s *svc) CancelOrder(ctx context.Context, orderId int64, cp orders.CancelParams) (repo.Order, error) {
+	ctx, span := tracing.Start(ctx, "payments.CancelOrder")
+	defer span.End()
+	return s.ordersService.CancelOrderWith(ctx, orderId, cp, func(qtx *repo.Queries, order repo.Order) error {
+		payment, err := qtx.FindCapturedPayment(ctx, order.ID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			// The order was marked as paid without a payment
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		amountInCents := payment.AmountInCents - payment.RefundedInCents
+		if amountInCents == 0 {
+			return nil
+		}
+		if _, err := RecordRefund(ctx, qtx, payment.ID, amountInCents); err != nil {
+			return err
+		}
+		err = s.gateway.Refund(ctx, RefundRequest{
+			Reference:      payment.GatewayReference,
+			AmountInCents:  amountInCents,
+			IdempotencyKey: fmt.Sprintf("order-%d-cancellation", order.ID),
+		})
+		if err != nil {
+			logging.FromContext(ctx).Error("failed to refund the payment of a cancelled order", "payment", payment.ID, "error", err)
+			return ErrGatewayFailure
+		}
+		return nil
+	})
+}
+
+func (s *svc) fail(ctx context.Context, payment repo.Payment, reference string, cause error) (repo.Payment, error) {
+	if _, err := s.update(ctx, payment, StatusFailed, reference, cause.Error()); err != nil {
+		return repo.Payment{}, err
+	}
+	if errors.Is(cause, ErrPaymentDeclined) {
+		return repo.Payment{}, ErrPaymentDeclined
+	}
+	logging.FromContext(ctx).Error("payment gateway has failed", "payment", payment.ID, "error", cause)
+	return repo.Payment{}, ErrGatewayFailure
+}
+
+func (s *svc) update(ctx context.Context, payment repo.Payment, status, reference, reason string) (repo.Payment, error) {
+	return s.repo.UpdatePayment(ctx, repo.UpdatePaymentParams{
+		ID:               payment.ID,
+		Status:           status,
+		GatewayReference: reference,
+		FailureReason:    reason,
+	})
+}
+
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
@@ -0,0 +1,82 @@
+package products
+
+import (
+	"cmp"
+	"slices"
+)
+
+// Names of the allocation strategies.
+const (
+	AllocationSingleLocationPreferred = "single-location-preferred"
+	AllocationLowestCost              = "lowest-cost"
+	AllocationSplit                   = "split"
+)
+
+// LocationStock is the stock of a product at an active location, as offered
+// to an Allocator.
+type LocationStock struct {
+	LocationId          int64
+	Priority            int32
+	ShippingCostInCents int32
+	Quantity            int32
+}
+
+// Allocation is the quantity of an order line taken from a location.
+type Allocation struct {
+	LocationId int64 `json:"location_id"`
+	Quantity   int32 `json:"quantity"`
+}
+
+// Allocator chooses the locations an order line is taken from. The stock is
+// given in priority order and only holds locations with units available. It
+// returns false when the stock cannot cover the quantity.
+type Allocator interface {
+	Allocate(stock []LocationStock, quantity int32) ([]Allocation, bool)
+}
+
+// SingleLocationPreferred takes the whole line from the first location that
+// can cover it, and splits it in priority order when none can.
+type SingleLocationPreferred struct{}
+
+func (SingleLocationPreferred) Allocate(stock []LocationStock, quantity int32) ([]Allocation, bool) {
+	for _, s := range stock {
+		if s.Quantity >= quantity {
+			return []Allocation{{LocationId: s.LocationId, Quantity: quantity}}, true
+		}
+	}
+	return fill(stock, quantity)
+}
+
+// LowestCost takes the line from the locations with the cheapest shipping
+// first, so the cost of the line is the lowest possible.
+type LowestCost struct{}
+
+func (LowestCost) Allocate(stock []LocationStock, quantity int32) ([]Allocation, bool) {
+	byCost := slices.Clone(stock)
+	slices.SortStableFunc(byCost, func(a, b LocationStock) int {
+		return cmp.Compare(a.ShippingCostInCents, b.ShippingCostInCents)
+	})
+	return fill(byCost, quantity)
+}
+
+// Split takes the line in priority order, spreading it over as many
+// locations as needed so the first ones are drained first.
+type Split struct{}
+
+func (Split) Allocate(stock []LocationStock, quantity int32) ([]Allocation, bool) {
+	return fill(stock, quantity)
+}
+
+// fill takes the quantity from the locations in the given order.
+func fill(stock []LocationStock, quantity int32) ([]Allocation, bool) {
+	var allocations []Allocation
+	for _, s := range stock {
+		if quantity == 0 {
+			break
+		}
+		take := min(s.Quantity, quantity)
+		allocations = append(allocations, Allocation{LocationId: s.LocationId, Quantity: take})
+		quantity -= take
+	}
+	return allocations, quantity == 0
+}
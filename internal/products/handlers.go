@@ -1,11 +1,16 @@
 package products
 
 import (
-	"log"
+	"errors"
+	"io"
 	"net/http"
+	"net/url"
 	"strconv"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/mellomaths/ecommerce-ms/internal/locations"
+	"github.com/mellomaths/ecommerce-ms/internal/logging"
+	"github.com/mellomaths/ecommerce-ms/internal/pagination"
 	"github.com/mellomaths/ecommerce-ms/internal/requests"
 	"github.com/mellomaths/ecommerce-ms/internal/responses"
 )
@@ -21,25 +26,72 @@ func NewHandler(service Service) *handler {
 }
 
 func (h *handler) ListProducts(w http.ResponseWriter, r *http.Request) {
-	products, err := h.service.ListProducts(r.Context())
+	listParams, err := parseListProductsParams(r.URL.Query())
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(r.Context()).Info("invalid request", "error", err)
+		responses.NewJsonErrorResponse(w, http.StatusBadRequest, "validation_error", err.Error())
+		return
+	}
+	products, err := h.service.ListProducts(r.Context(), listParams)
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("request failed", "error", err)
+		if err == ErrInvalidSort || err == pagination.ErrInvalidCursor {
+			responses.NewJsonErrorResponse(w, http.StatusBadRequest, "validation_error", err.Error())
+			return
+		}
 		responses.NewJsonErrorResponse(w, http.StatusInternalServerError, "server_error", "unexpected error when listing products")
 		return
 	}
 	responses.NewJsonResponse(w, http.StatusOK, products)
 }
 
+func parseListProductsParams(q url.Values) (ListProductsParams, error) {
+	limit, err := pagination.ParseLimit(q.Get("limit"))
+	if err != nil {
+		return ListProductsParams{}, err
+	}
+	lp := ListProductsParams{
+		Cursor:     q.Get("cursor"),
+		Limit:      limit,
+		Sort:       q.Get("sort"),
+		NamePrefix: q.Get("name_prefix"),
+	}
+	if v := q.Get("in_stock"); v != "" {
+		if lp.InStock, err = strconv.ParseBool(v); err != nil {
+			return ListProductsParams{}, errors.New("invalid in_stock")
+		}
+	}
+	if lp.MinPrice, err = parsePrice(q.Get("min_price")); err != nil {
+		return ListProductsParams{}, errors.New("invalid min_price")
+	}
+	if lp.MaxPrice, err = parsePrice(q.Get("max_price")); err != nil {
+		return ListProductsParams{}, errors.New("invalid max_price")
+	}
+	return lp, nil
+}
+
+func parsePrice(s string) (*int32, error) {
+	if s == "" {
+		return nil, nil
+	}
+	price, err := strconv.ParseInt(s, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+	p := int32(price)
+	return &p, nil
+}
+
 func (h *handler) FindProductById(w http.ResponseWriter, r *http.Request) {
 	productId, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(r.Context()).Info("invalid request", "error", err)
 		responses.NewJsonErrorResponse(w, http.StatusBadRequest, "validation_error", "invalid product id")
 		return
 	}
 	product, err := h.service.FindProductById(r.Context(), productId)
 	if product.ID == 0 {
-		log.Println("product not found")
+		logging.FromContext(r.Context()).Info("product not found")
 		responses.NewJsonErrorResponse(w, http.StatusNotFound, "not found", "product not found")
 		return
 	}
@@ -49,15 +101,183 @@ func (h *handler) FindProductById(w http.ResponseWriter, r *http.Request) {
 func (h *handler) CreateProduct(w http.ResponseWriter, r *http.Request) {
 	var productParams CreateProductParams
 	if err := requests.DecodeJsonBody(r, &productParams); err != nil {
-		log.Println(err)
+		logging.FromContext(r.Context()).Info("invalid request", "error", err)
 		responses.NewJsonErrorResponse(w, http.StatusBadRequest, "validation_error", "invalid product")
 		return
 	}
 	p, err := h.service.CreateProduct(r.Context(), productParams)
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(r.Context()).Warn("request failed", "error", err)
 		responses.NewJsonErrorResponse(w, http.StatusInternalServerError, "server_error", "unexpected error when creating a new product")
 		return
 	}
 	responses.NewJsonResponse(w, http.StatusCreated, p)
 }
+
+func (h *handler) UpdateProduct(w http.ResponseWriter, r *http.Request) {
+	productId, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		logging.FromContext(r.Context()).Info("invalid request", "error", err)
+		responses.NewJsonErrorResponse(w, http.StatusBadRequest, "validation_error", "invalid product id")
+		return
+	}
+	var productParams UpdateProductParams
+	if err := requests.DecodeJsonBody(r, &productParams); err != nil {
+		logging.FromContext(r.Context()).Info("invalid request", "error", err)
+		responses.NewJsonErrorResponse(w, http.StatusBadRequest, "validation_error", "invalid product")
+		return
+	}
+	p, err := h.service.UpdateProduct(r.Context(), productId, productParams)
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("request failed", "error", err)
+		h.writeUpdateError(w, err)
+		return
+	}
+	responses.NewJsonResponse(w, http.StatusOK, p)
+}
+
+func (h *handler) PatchProduct(w http.ResponseWriter, r *http.Request) {
+	productId, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		logging.FromContext(r.Context()).Info("invalid request", "error", err)
+		responses.NewJsonErrorResponse(w, http.StatusBadRequest, "validation_error", "invalid product id")
+		return
+	}
+	patch, err := io.ReadAll(r.Body)
+	if err != nil {
+		logging.FromContext(r.Context()).Info("invalid request", "error", err)
+		responses.NewJsonErrorResponse(w, http.StatusBadRequest, "validation_error", "invalid product")
+		return
+	}
+	p, err := h.service.PatchProduct(r.Context(), productId, patch)
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("request failed", "error", err)
+		h.writeUpdateError(w, err)
+		return
+	}
+	responses.NewJsonResponse(w, http.StatusOK, p)
+}
+
+func (h *handler) writeUpdateError(w http.ResponseWriter, err error) {
+	if err == ErrProductNotFound {
+		responses.NewJsonErrorResponse(w, http.StatusNotFound, "not_found", err.Error())
+		return
+	}
+	if err == ErrInvalidProduct {
+		responses.NewJsonErrorResponse(w, http.StatusBadRequest, "validation_error", err.Error())
+		return
+	}
+	if err == ErrProductNoStock {
+		// Lowering the quantity takes the units from the default location
+		responses.NewJsonErrorResponse(w, http.StatusConflict, "product_no_stock", "not enough stock at the default location")
+		return
+	}
+	responses.NewJsonErrorResponse(w, http.StatusInternalServerError, "server_error", "unexpected error when updating the product")
+}
+
+func (h *handler) DeleteProduct(w http.ResponseWriter, r *http.Request) {
+	productId, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		logging.FromContext(r.Context()).Info("invalid request", "error", err)
+		responses.NewJsonErrorResponse(w, http.StatusBadRequest, "validation_error", "invalid product id")
+		return
+	}
+	if err := h.service.DeleteProduct(r.Context(), productId); err != nil {
+		logging.FromContext(r.Context()).Warn("request failed", "error", err)
+		if err == ErrProductNotFound {
+			responses.NewJsonErrorResponse(w, http.StatusNotFound, "not_found", err.Error())
+			return
+		}
+		responses.NewJsonErrorResponse(w, http.StatusInternalServerError, "server_error", "unexpected error when deleting the product")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *handler) ListInventoryMovements(w http.ResponseWriter, r *http.Request) {
+	productId, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		logging.FromContext(r.Context()).Info("invalid request", "error", err)
+		responses.NewJsonErrorResponse(w, http.StatusBadRequest, "validation_error", "invalid product id")
+		return
+	}
+	q := r.URL.Query()
+	limit, err := pagination.ParseLimit(q.Get("limit"))
+	if err != nil {
+		logging.FromContext(r.Context()).Info("invalid request", "error", err)
+		responses.NewJsonErrorResponse(w, http.StatusBadRequest, "validation_error", err.Error())
+		return
+	}
+	page, err := h.service.ListInventoryMovements(r.Context(), productId, ListMovementsParams{
+		Cursor: q.Get("cursor"),
+		Limit:  limit,
+	})
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("request failed", "error", err)
+		switch err {
+		case ErrProductNotFound:
+			responses.NewJsonErrorResponse(w, http.StatusNotFound, "not_found", err.Error())
+		case pagination.ErrInvalidCursor:
+			responses.NewJsonErrorResponse(w, http.StatusBadRequest, "validation_error", err.Error())
+		default:
+			responses.NewJsonErrorResponse(w, http.StatusInternalServerError, "server_error", "unexpected error when listing the inventory movements")
+		}
+		return
+	}
+	responses.NewJsonResponse(w, http.StatusOK, page)
+}
+
+func (h *handler) ListProductStock(w http.ResponseWriter, r *http.Request) {
+	productId, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		logging.FromContext(r.Context()).Info("invalid request", "error", err)
+		responses.NewJsonErrorResponse(w, http.StatusBadRequest, "validation_error", "invalid product id")
+		return
+	}
+	stock, err := h.service.ListProductStock(r.Context(), productId)
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("request failed", "error", err)
+		writeStockError(w, err, "unexpected error when listing the product stock")
+		return
+	}
+	responses.NewJsonResponse(w, http.StatusOK, stock)
+}
+
+func (h *handler) SetLocationStock(w http.ResponseWriter, r *http.Request) {
+	productId, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		logging.FromContext(r.Context()).Info("invalid request", "error", err)
+		responses.NewJsonErrorResponse(w, http.StatusBadRequest, "validation_error", "invalid product id")
+		return
+	}
+	locationId, err := strconv.ParseInt(chi.URLParam(r, "locationId"), 10, 64)
+	if err != nil {
+		logging.FromContext(r.Context()).Info("invalid request", "error", err)
+		responses.NewJsonErrorResponse(w, http.StatusBadRequest, "validation_error", "invalid stock location id")
+		return
+	}
+	var stockParams SetStockParams
+	if err := requests.DecodeJsonBody(r, &stockParams); err != nil {
+		logging.FromContext(r.Context()).Info("invalid request", "error", err)
+		responses.NewJsonErrorResponse(w, http.StatusBadRequest, "validation_error", "invalid stock")
+		return
+	}
+	stock, err := h.service.SetLocationStock(r.Context(), productId, locationId, stockParams)
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("request failed", "error", err)
+		writeStockError(w, err, "unexpected error when setting the product stock")
+		return
+	}
+	responses.NewJsonResponse(w, http.StatusOK, stock)
+}
+
+func writeStockError(w http.ResponseWriter, err error, serverErrMsg string) {
+	switch err {
+	case ErrProductNotFound, locations.ErrLocationNotFound:
+		responses.NewJsonErrorResponse(w, http.StatusNotFound, "not_found", err.Error())
+	case ErrInvalidStock:
+		responses.NewJsonErrorResponse(w, http.StatusBadRequest, "validation_error", err.Error())
+	default:
+		responses.NewJsonErrorResponse(w, http.StatusInternalServerError, "server_error", serverErrMsg)
+	}
+}
@@ -0,0 +1,184 @@
+package products
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	repo "github.com/mellomaths/ecommerce-ms/internal/adapters/postgresql/sqlc"
+	"github.com/mellomaths/ecommerce-ms/internal/locations"
+	"github.com/mellomaths/ecommerce-ms/internal/pagination"
+	"github.com/mellomaths/ecommerce-ms/internal/tracing"
+)
+
+// Reasons of the inventory movements.
+const (
+	ReasonSale         = "sale"
+	ReasonCancellation = "cancellation"
+	ReasonRestock      = "restock"
+	ReasonAdjustment   = "adjustment"
+	ReasonReturn       = "return"
+)
+
+// Movement tells why the stock of a product changes. OrderId is the order
+// behind a sale, cancellation or return, and 0 otherwise. LocationId is the
+// stock location, 0 for the default one.
+type Movement struct {
+	Reason     string
+	OrderId    int64
+	LocationId int64
+}
+
+// SetStockParams sets the quantity of a product at a location. Reason is
+// restock or adjustment, the default.
+type SetStockParams struct {
+	Quantity int32  `json:"quantity"`
+	Reason   string `json:"reason"`
+}
+
+func (sp SetStockParams) valid() bool {
+	return sp.Quantity >= 0 && (sp.Reason == ReasonRestock || sp.Reason == ReasonAdjustment)
+}
+
+type ListMovementsParams struct {
+	Cursor string
+	Limit  int32
+}
+
+type movementCursor struct {
+	ID int64 `json:"id"`
+}
+
+// ListProductStock is the stock of the product at every location holding it,
+// in allocation priority order.
+func (s *svc) ListProductStock(ctx context.Context, id int64) ([]repo.ListProductStockRow, error) {
+	ctx, span := tracing.Start(ctx, "products.ListProductStock")
+	defer span.End()
+	if _, err := s.repo.FindProductById(ctx, id); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrProductNotFound
+		}
+		return nil, err
+	}
+	return listProductStock(ctx, s.repo, id)
+}
+
+// SetLocationStock sets the quantity of the product at a location, recording
+// the difference in the ledger, and returns the stock of the product.
+func (s *svc) SetLocationStock(ctx context.Context, id int64, locationId int64, sp SetStockParams) ([]repo.ListProductStockRow, error) {
+	ctx, span := tracing.Start(ctx, "products.SetLocationStock")
+	defer span.End()
+	if sp.Reason == "" {
+		sp.Reason = ReasonAdjustment
+	}
+	if !sp.valid() {
+		return nil, ErrInvalidStock
+	}
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+	qtx := s.repo.WithTx(tx)
+	p, err := lockProduct(ctx, qtx, id)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := locations.FindLocation(ctx, qtx, locationId); err != nil {
+		return nil, err
+	}
+	stock, err := listProductStock(ctx, qtx, id)
+	if err != nil {
+		return nil, err
+	}
+	var current int32
+	for _, st := range stock {
+		if st.LocationID == locationId {
+			current = st.Quantity
+		}
+	}
+	if sp.Quantity != current {
+		_, err = changeStock(ctx, qtx, p, locationId, sp.Quantity-current, Movement{Reason: sp.Reason})
+		if err != nil {
+			return nil, err
+		}
+		if stock, err = listProductStock(ctx, qtx, id); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return stock, nil
+}
+
+func listProductStock(ctx context.Context, q repo.Querier, id int64) ([]repo.ListProductStockRow, error) {
+	stock, err := q.ListProductStock(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if stock == nil {
+		stock = []repo.ListProductStockRow{}
+	}
+	return stock, nil
+}
+
+// ListInventoryMovements is the stock history of the product, newest first.
+func (s *svc) ListInventoryMovements(ctx context.Context, productId int64, lp ListMovementsParams) (pagination.Page[repo.InventoryMovement], error) {
+	ctx, span := tracing.Start(ctx, "products.ListInventoryMovements")
+	defer span.End()
+	if _, err := s.repo.FindProductById(ctx, productId); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return pagination.Page[repo.InventoryMovement]{}, ErrProductNotFound
+		}
+		return pagination.Page[repo.InventoryMovement]{}, err
+	}
+	if lp.Limit == 0 {
+		lp.Limit = pagination.DefaultLimit
+	}
+	params := repo.ListInventoryMovementsParams{
+		ProductID: productId,
+		RowLimit:  lp.Limit + 1,
+	}
+	if lp.Cursor != "" {
+		var c movementCursor
+		if err := pagination.DecodeCursor(lp.Cursor, &c); err != nil {
+			return pagination.Page[repo.InventoryMovement]{}, err
+		}
+		params.CursorID = pgtype.Int8{Int64: c.ID, Valid: true}
+	}
+	movements, err := s.repo.ListInventoryMovements(ctx, params)
+	if err != nil {
+		return pagination.Page[repo.InventoryMovement]{}, err
+	}
+	return pagination.NewPage(movements, lp.Limit, func(last repo.InventoryMovement) (string, error) {
+		return pagination.EncodeCursor(movementCursor{ID: last.ID})
+	})
+}
+
+// ReconcileInventory recomputes the quantity of every product at every
+// location from its movements and returns the ones that drifted from the
+// ledger.
+func (s *svc) ReconcileInventory(ctx context.Context) ([]repo.ReconcileInventoryRow, error) {
+	ctx, span := tracing.Start(ctx, "products.ReconcileInventory")
+	defer span.End()
+	return s.repo.ReconcileInventory(ctx)
+}
+
+// recordMovement appends a stock change made with q to the inventory ledger,
+// along with the quantity left at the location.
+func recordMovement(ctx context.Context, q repo.Querier, stock repo.ProductStock, delta int32, m Movement) error {
+	params := repo.CreateInventoryMovementParams{
+		ProductID:  stock.ProductID,
+		LocationID: stock.LocationID,
+		Delta:      delta,
+		Quantity:   stock.Quantity,
+		Reason:     m.Reason,
+	}
+	if m.OrderId != 0 {
+		params.OrderID = pgtype.Int8{Int64: m.OrderId, Valid: true}
+	}
+	_, err := q.CreateInventoryMovement(ctx, params)
+	return err
+}
@@ -1,16 +1,70 @@
 package products
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
+	"slices"
+	"strings"
 
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
 	repo "github.com/mellomaths/ecommerce-ms/internal/adapters/postgresql/sqlc"
+	"github.com/mellomaths/ecommerce-ms/internal/outbox"
+	"github.com/mellomaths/ecommerce-ms/internal/pagination"
+	"github.com/mellomaths/ecommerce-ms/internal/requests"
+	"github.com/mellomaths/ecommerce-ms/internal/tracing"
+	"github.com/mellomaths/ecommerce-ms/internal/utils"
 )
 
 var (
 	ErrProductNotFound = errors.New("product not found")
+	ErrProductNoStock  = errors.New("product has not enough stock")
+	ErrInvalidSort     = errors.New("invalid sort")
+	ErrInvalidProduct  = errors.New("invalid product")
+	ErrProductDeleted  = errors.New("product is no longer available")
+	ErrInvalidStock    = errors.New("invalid stock")
 )
 
+var sortFields = []string{"name", "price_in_cents", "created_at"}
+
+// likeEscaper escapes the LIKE wildcards, so a name prefix is matched
+// literally.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+type UpdateProductParams struct {
+	Name         string `json:"name"`
+	PriceInCents int32  `json:"price_in_cents"`
+	Quantity     int32  `json:"quantity"`
+}
+
+func (up UpdateProductParams) valid() bool {
+	return up.Name != "" && up.PriceInCents >= 0 && up.Quantity >= 0
+}
+
+// ListProductsParams filters and paginates the products listing. Sort is one
+// of name, price_in_cents or created_at, prefixed with "-" for descending.
+type ListProductsParams struct {
+	Cursor     string
+	Limit      int32
+	Sort       string
+	MinPrice   *int32
+	MaxPrice   *int32
+	InStock    bool
+	NamePrefix string
+}
+
+// productCursor is the position of the last product of a page. It carries the
+// sort it was created with, so it cannot be replayed against another one.
+type productCursor struct {
+	Sort         string             `json:"s"`
+	ID           int64              `json:"id"`
+	Name         string             `json:"n,omitempty"`
+	PriceInCents int32              `json:"p,omitempty"`
+	CreatedAt    pgtype.Timestamptz `json:"c"`
+}
+
 type CreateProductParams struct {
 	Name         string `json:"name"`
 	PriceInCents int32  `json:"price_in_cents"`
@@ -18,71 +72,427 @@ type CreateProductParams struct {
 }
 
 type Service interface {
-	ListProducts(ctx context.Context) ([]repo.Product, error)
+	ListProducts(ctx context.Context, lp ListProductsParams) (pagination.Page[repo.Product], error)
 	FindProductById(ctx context.Context, id int64) (repo.Product, error)
 	CreateProduct(ctx context.Context, pp CreateProductParams) (repo.Product, error)
-	AddProductStock(ctx context.Context, id int64, quantity int32) (repo.Product, error)
-	RemoveProductStock(ctx context.Context, id int64, quantity int32) (repo.Product, error)
+	UpdateProduct(ctx context.Context, id int64, up UpdateProductParams) (repo.Product, error)
+	PatchProduct(ctx context.Context, id int64, patch []byte) (repo.Product, error)
+	DeleteProduct(ctx context.Context, id int64) error
+	AddProductStock(ctx context.Context, id int64, quantity int32, m Movement) (repo.Product, error)
+	RemoveProductStock(ctx context.Context, id int64, quantity int32, m Movement) (repo.Product, error)
+	ListProductStock(ctx context.Context, id int64) ([]repo.ListProductStockRow, error)
+	SetLocationStock(ctx context.Context, id int64, locationId int64, sp SetStockParams) ([]repo.ListProductStockRow, error)
+	ListInventoryMovements(ctx context.Context, productId int64, lp ListMovementsParams) (pagination.Page[repo.InventoryMovement], error)
+	ReconcileInventory(ctx context.Context) ([]repo.ReconcileInventoryRow, error)
 }
 
 type svc struct {
-	repo repo.Querier
+	repo *repo.Queries
+	db   utils.DBConn
 }
 
-func NewService(repo repo.Querier) Service {
-	return &svc{repo: repo}
+func NewService(repo *repo.Queries, db utils.DBConn) Service {
+	return &svc{repo: repo, db: db}
 }
 
-func (s *svc) ListProducts(ctx context.Context) ([]repo.Product, error) {
-	products, err := s.repo.ListProducts(ctx)
-	if products == nil {
-		return []repo.Product{}, err
+func (s *svc) ListProducts(ctx context.Context, lp ListProductsParams) (pagination.Page[repo.Product], error) {
+	ctx, span := tracing.Start(ctx, "products.ListProducts")
+	defer span.End()
+	if lp.Sort == "" {
+		lp.Sort = "created_at"
+	}
+	field, descending := strings.CutPrefix(lp.Sort, "-")
+	if !slices.Contains(sortFields, field) {
+		return pagination.Page[repo.Product]{}, ErrInvalidSort
 	}
-	return products, err
+	if lp.Limit == 0 {
+		lp.Limit = pagination.DefaultLimit
+	}
+	params := repo.ListProductsParams{
+		InStock:    lp.InStock,
+		Sort:       field,
+		Descending: descending,
+		RowLimit:   lp.Limit + 1,
+	}
+	if lp.MinPrice != nil {
+		params.MinPrice = pgtype.Int4{Int32: *lp.MinPrice, Valid: true}
+	}
+	if lp.MaxPrice != nil {
+		params.MaxPrice = pgtype.Int4{Int32: *lp.MaxPrice, Valid: true}
+	}
+	if lp.NamePrefix != "" {
+		params.NamePrefix = pgtype.Text{String: likeEscaper.Replace(lp.NamePrefix), Valid: true}
+	}
+	if lp.Cursor != "" {
+		var c productCursor
+		if err := pagination.DecodeCursor(lp.Cursor, &c); err != nil {
+			return pagination.Page[repo.Product]{}, err
+		}
+		if c.Sort != lp.Sort {
+			return pagination.Page[repo.Product]{}, pagination.ErrInvalidCursor
+		}
+		params.CursorID = pgtype.Int8{Int64: c.ID, Valid: true}
+		params.CursorName = pgtype.Text{String: c.Name, Valid: true}
+		params.CursorPrice = pgtype.Int4{Int32: c.PriceInCents, Valid: true}
+		params.CursorCreatedAt = c.CreatedAt
+	}
+	products, err := s.repo.ListProducts(ctx, params)
+	if err != nil {
+		return pagination.Page[repo.Product]{}, err
+	}
+	return pagination.NewPage(products, lp.Limit, func(last repo.Product) (string, error) {
+		return pagination.EncodeCursor(productCursor{
+			Sort:         lp.Sort,
+			ID:           last.ID,
+			Name:         last.Name,
+			PriceInCents: last.PriceInCents,
+			CreatedAt:    last.CreatedAt,
+		})
+	})
 }
 
 func (s *svc) FindProductById(ctx context.Context, id int64) (repo.Product, error) {
+	ctx, span := tracing.Start(ctx, "products.FindProductById")
+	defer span.End()
 	return s.repo.FindProductById(ctx, id)
 }
 
 func (s *svc) CreateProduct(ctx context.Context, pp CreateProductParams) (repo.Product, error) {
-	product, err := s.repo.CreateProduct(ctx, repo.CreateProductParams{
+	ctx, span := tracing.Start(ctx, "products.CreateProduct")
+	defer span.End()
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return repo.Product{}, err
+	}
+	defer tx.Rollback(ctx)
+	qtx := s.repo.WithTx(tx)
+	product, err := qtx.CreateProduct(ctx, repo.CreateProductParams{
 		Name:         pp.Name,
 		PriceInCents: pp.PriceInCents,
-		Quantity:     pp.Quantity,
 	})
 	if err != nil {
 		return repo.Product{}, err
 	}
+	// The initial stock is received at the default location
+	if pp.Quantity != 0 {
+		location, err := qtx.FindDefaultStockLocation(ctx)
+		if err != nil {
+			return repo.Product{}, err
+		}
+		stock, err := qtx.AddLocationStock(ctx, repo.AddLocationStockParams{
+			ProductID:  product.ID,
+			LocationID: location.ID,
+			Quantity:   pp.Quantity,
+		})
+		if err != nil {
+			return repo.Product{}, err
+		}
+		product.Quantity = stock.Quantity
+		if err := recordMovement(ctx, qtx, stock, pp.Quantity, Movement{Reason: ReasonRestock}); err != nil {
+			return repo.Product{}, err
+		}
+	}
+	err = outbox.Record(ctx, qtx, outbox.AggregateProduct, product.ID, outbox.EventProductCreated, outbox.ProductCreated{
+		ProductId:    product.ID,
+		Name:         product.Name,
+		PriceInCents: product.PriceInCents,
+		Quantity:     product.Quantity,
+	})
+	if err != nil {
+		return repo.Product{}, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return repo.Product{}, err
+	}
 	return product, nil
 }
 
-func (s *svc) AddProductStock(ctx context.Context, id int64, quantity int32) (repo.Product, error) {
-	p, err := s.repo.FindProductById(ctx, id)
+func (s *svc) UpdateProduct(ctx context.Context, id int64, up UpdateProductParams) (repo.Product, error) {
+	ctx, span := tracing.Start(ctx, "products.UpdateProduct")
+	defer span.End()
+	if !up.valid() {
+		return repo.Product{}, ErrInvalidProduct
+	}
+	return s.withTx(ctx, func(qtx *repo.Queries) (repo.Product, error) {
+		// The row is locked so the stock change is computed against the
+		// quantity being replaced, not one a concurrent order has already
+		// changed.
+		current, err := lockProduct(ctx, qtx, id)
+		if err == nil && current.DeletedAt.Valid {
+			err = ErrProductNotFound
+		}
+		if err != nil {
+			return repo.Product{}, err
+		}
+		return updateProduct(ctx, qtx, current, up)
+	})
+}
+
+// updateProduct replaces the locked product with up.
+func updateProduct(ctx context.Context, qtx *repo.Queries, current repo.Product, up UpdateProductParams) (repo.Product, error) {
+	// The total is derived from the locations, the difference is adjusted at
+	// the default location
+	if up.Quantity != current.Quantity {
+		location, err := qtx.FindDefaultStockLocation(ctx)
+		if err != nil {
+			return repo.Product{}, err
+		}
+		_, err = changeStock(ctx, qtx, current, location.ID, up.Quantity-current.Quantity, Movement{Reason: ReasonAdjustment})
+		if err != nil {
+			return repo.Product{}, err
+		}
+	}
+	return qtx.UpdateProduct(ctx, repo.UpdateProductParams{
+		ID:           current.ID,
+		Name:         up.Name,
+		PriceInCents: up.PriceInCents,
+	})
+}
+
+// PatchProduct applies a JSON merge patch to the product. Fields left out of
+// the patch keep their current value, and every field is required, so a null
+// that would remove one is rejected.
+func (s *svc) PatchProduct(ctx context.Context, id int64, patch []byte) (repo.Product, error) {
+	ctx, span := tracing.Start(ctx, "products.PatchProduct")
+	defer span.End()
+	// The patch is merged into the locked row, so the fields it leaves out,
+	// the quantity above all, are not overwritten with a stale value.
+	return s.withTx(ctx, func(qtx *repo.Queries) (repo.Product, error) {
+		current, err := lockProduct(ctx, qtx, id)
+		if err == nil && current.DeletedAt.Valid {
+			err = ErrProductNotFound
+		}
+		if err != nil {
+			return repo.Product{}, err
+		}
+		doc, err := json.Marshal(UpdateProductParams{
+			Name:         current.Name,
+			PriceInCents: current.PriceInCents,
+			Quantity:     current.Quantity,
+		})
+		if err != nil {
+			return repo.Product{}, err
+		}
+		patched, err := requests.MergePatch(doc, patch)
+		if err != nil {
+			return repo.Product{}, ErrInvalidProduct
+		}
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(patched, &fields); err != nil || len(fields) != 3 {
+			return repo.Product{}, ErrInvalidProduct
+		}
+		var up UpdateProductParams
+		decoder := json.NewDecoder(bytes.NewReader(patched))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&up); err != nil || !up.valid() {
+			return repo.Product{}, ErrInvalidProduct
+		}
+		return updateProduct(ctx, qtx, current, up)
+	})
+}
+
+// DeleteProduct soft deletes the product, it is hidden from the listing and
+// cannot be ordered anymore but remains available to historical orders.
+// Deleting a product twice is not an error.
+func (s *svc) DeleteProduct(ctx context.Context, id int64) error {
+	ctx, span := tracing.Start(ctx, "products.DeleteProduct")
+	defer span.End()
+	_, err := s.repo.SoftDeleteProduct(ctx, id)
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return err
+	}
+	if _, err := s.repo.FindProductById(ctx, id); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrProductNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+// AddProductStock adds stock to the location of the movement, or to the
+// default location when it has none.
+func (s *svc) AddProductStock(ctx context.Context, id int64, quantity int32, m Movement) (repo.Product, error) {
+	ctx, span := tracing.Start(ctx, "products.AddProductStock")
+	defer span.End()
+	return s.withTx(ctx, func(qtx *repo.Queries) (repo.Product, error) {
+		return AddStock(ctx, qtx, id, quantity, m)
+	})
+}
+
+// AddStock is the stock increment shared by the products service and by
+// callers that need it to run inside their own transaction.
+func AddStock(ctx context.Context, q repo.Querier, id int64, quantity int32, m Movement) (repo.Product, error) {
+	ctx, span := tracing.Start(ctx, "products.AddStock")
+	defer span.End()
+	p, err := lockProduct(ctx, q, id)
 	if err != nil {
-		return repo.Product{}, ErrProductNotFound
+		return repo.Product{}, err
 	}
-	p.Quantity += int32(quantity)
-	s.repo.UpdateProduct(ctx, repo.UpdateProductParams{
-		ID:           p.ID,
-		Name:         p.Name,
-		PriceInCents: p.PriceInCents,
-		Quantity:     p.Quantity,
+	locationId, err := movementLocation(ctx, q, m)
+	if err != nil {
+		return repo.Product{}, err
+	}
+	return changeStock(ctx, q, p, locationId, quantity, m)
+}
+
+// RemoveProductStock takes stock from the location of the movement, or from
+// the default location when it has none.
+func (s *svc) RemoveProductStock(ctx context.Context, id int64, quantity int32, m Movement) (repo.Product, error) {
+	ctx, span := tracing.Start(ctx, "products.RemoveProductStock")
+	defer span.End()
+	return s.withTx(ctx, func(qtx *repo.Queries) (repo.Product, error) {
+		return RemoveStock(ctx, qtx, id, quantity, m)
 	})
-	return p, nil
 }
 
-func (s *svc) RemoveProductStock(ctx context.Context, id int64, quantity int32) (repo.Product, error) {
-	p, err := s.repo.FindProductById(ctx, id)
+// RemoveStock is the stock decrement shared by the products service and by
+// callers that need it to run inside their own transaction.
+func RemoveStock(ctx context.Context, q repo.Querier, id int64, quantity int32, m Movement) (repo.Product, error) {
+	ctx, span := tracing.Start(ctx, "products.RemoveStock")
+	defer span.End()
+	p, err := lockProduct(ctx, q, id)
+	if err != nil {
+		return repo.Product{}, err
+	}
+	if p.DeletedAt.Valid {
+		return repo.Product{}, ErrProductDeleted
+	}
+	locationId, err := movementLocation(ctx, q, m)
+	if err != nil {
+		return repo.Product{}, err
+	}
+	return changeStock(ctx, q, p, locationId, -quantity, m)
+}
+
+// AllocateStock takes the quantity from the locations chosen by the
+// allocator and returns the allocation. The product row stays locked until
+// the transaction of q ends, so concurrent callers can never take the stock
+// below zero.
+func AllocateStock(ctx context.Context, q repo.Querier, allocator Allocator, id int64, quantity int32, m Movement) (repo.Product, []Allocation, error) {
+	ctx, span := tracing.Start(ctx, "products.AllocateStock")
+	defer span.End()
+	p, err := lockProduct(ctx, q, id)
 	if err != nil {
+		return repo.Product{}, nil, err
+	}
+	if p.DeletedAt.Valid {
+		return repo.Product{}, nil, ErrProductDeleted
+	}
+	if p.Quantity < quantity {
+		return repo.Product{}, nil, ErrProductNoStock
+	}
+	rows, err := q.ListProductStock(ctx, id)
+	if err != nil {
+		return repo.Product{}, nil, err
+	}
+	var stock []LocationStock
+	for _, r := range rows {
+		if r.Active && r.Quantity > 0 {
+			stock = append(stock, LocationStock{
+				LocationId:          r.LocationID,
+				Priority:            r.Priority,
+				ShippingCostInCents: r.ShippingCostInCents,
+				Quantity:            r.Quantity,
+			})
+		}
+	}
+	allocations, ok := allocator.Allocate(stock, quantity)
+	if !ok {
+		return repo.Product{}, nil, ErrProductNoStock
+	}
+	for _, a := range allocations {
+		p, err = changeStock(ctx, q, p, a.LocationId, -a.Quantity, m)
+		if err != nil {
+			return repo.Product{}, nil, err
+		}
+	}
+	return p, allocations, nil
+}
+
+// lockProduct locks the product row, which every stock change of the product
+// does first so they are serialized.
+func lockProduct(ctx context.Context, q repo.Querier, id int64) (repo.Product, error) {
+	p, err := q.FindProductByIdForUpdate(ctx, id)
+	if errors.Is(err, pgx.ErrNoRows) {
 		return repo.Product{}, ErrProductNotFound
 	}
-	p.Quantity -= int32(quantity)
-	s.repo.UpdateProduct(ctx, repo.UpdateProductParams{
-		ID:           p.ID,
-		Name:         p.Name,
-		PriceInCents: p.PriceInCents,
-		Quantity:     p.Quantity,
+	return p, err
+}
+
+func movementLocation(ctx context.Context, q repo.Querier, m Movement) (int64, error) {
+	if m.LocationId != 0 {
+		return m.LocationId, nil
+	}
+	location, err := q.FindDefaultStockLocation(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return location.ID, nil
+}
+
+// changeStock adds delta to the stock of the locked product p at a location
+// and returns p with its new total. Taking more than the location holds fails
+// with ErrProductNoStock.
+func changeStock(ctx context.Context, q repo.Querier, p repo.Product, locationId int64, delta int32, m Movement) (repo.Product, error) {
+	var stock repo.ProductStock
+	var err error
+	if delta > 0 {
+		stock, err = q.AddLocationStock(ctx, repo.AddLocationStockParams{
+			ProductID:  p.ID,
+			LocationID: locationId,
+			Quantity:   delta,
+		})
+	} else {
+		stock, err = q.RemoveLocationStock(ctx, repo.RemoveLocationStockParams{
+			ProductID:  p.ID,
+			LocationID: locationId,
+			Quantity:   -delta,
+		})
+		if errors.Is(err, pgx.ErrNoRows) {
+			return repo.Product{}, ErrProductNoStock
+		}
+	}
+	if err != nil {
+		return repo.Product{}, err
+	}
+	// products.quantity is kept as the total of the locations by the database
+	p.Quantity += delta
+	if err := recordStockChanged(ctx, q, p, stock, delta, m); err != nil {
+		return repo.Product{}, err
+	}
+	return p, nil
+}
+
+// recordStockChanged writes the inventory movement and the
+// product.stock_changed event of a stock update made with q.
+func recordStockChanged(ctx context.Context, q repo.Querier, p repo.Product, stock repo.ProductStock, delta int32, m Movement) error {
+	if err := recordMovement(ctx, q, stock, delta, m); err != nil {
+		return err
+	}
+	return outbox.Record(ctx, q, outbox.AggregateProduct, p.ID, outbox.EventProductStockChanged, outbox.ProductStockChanged{
+		ProductId:  p.ID,
+		LocationId: stock.LocationID,
+		Delta:      delta,
+		Quantity:   p.Quantity,
+		Reason:     m.Reason,
+		OrderId:    m.OrderId,
 	})
+}
+
+// withTx runs a change of a product and its events in a single transaction.
+func (s *svc) withTx(ctx context.Context, fn func(qtx *repo.Queries) (repo.Product, error)) (repo.Product, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return repo.Product{}, err
+	}
+	defer tx.Rollback(ctx)
+	p, err := fn(s.repo.WithTx(tx))
+	if err != nil {
+		return repo.Product{}, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return repo.Product{}, err
+	}
 	return p, nil
 }
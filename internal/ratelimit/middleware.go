@@ -0,0 +1,119 @@
+package ratelimit
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/mellomaths/ecommerce-ms/internal/auth"
+	"github.com/mellomaths/ecommerce-ms/internal/logging"
+	"github.com/mellomaths/ecommerce-ms/internal/responses"
+)
+
+const (
+	HeaderLimit      = "RateLimit-Limit"
+	HeaderRemaining  = "RateLimit-Remaining"
+	HeaderReset      = "RateLimit-Reset"
+	HeaderPolicy     = "RateLimit-Policy"
+	HeaderRetryAfter = "Retry-After"
+)
+
+type Limiter struct {
+	store Store
+}
+
+func NewLimiter(store Store) *Limiter {
+	return &Limiter{store: store}
+}
+
+// Limit limits the requests of every client to the policy. The clients are
+// told their quota in the RateLimit-* headers and when to retry in the
+// Retry-After header of the rejected requests. The requests go through when
+// the store fails, so an outage of the store does not take the service down.
+//
+// It must run after the authentication, as the clients are told apart by
+// their API key or user, and by their IP address when they are anonymous.
+func (l *Limiter) Limit(p Policy) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			result, err := l.store.Take(r.Context(), p.Name+":"+clientKey(r), p)
+			if err != nil {
+				logging.FromContext(r.Context()).Error("failed to take a rate limit token, letting the request through", "error", err)
+				next.ServeHTTP(w, r)
+				return
+			}
+			h := w.Header()
+			h.Set(HeaderLimit, strconv.Itoa(p.Limit))
+			h.Set(HeaderRemaining, strconv.Itoa(result.remaining()))
+			h.Set(HeaderReset, ceilSeconds(result.resetAfter(p)))
+			h.Set(HeaderPolicy, strconv.Itoa(p.Limit)+";w="+ceilSeconds(p.Period))
+			if !result.Allowed {
+				h.Set(HeaderRetryAfter, ceilSeconds(result.retryAfter(p)))
+				responses.NewJsonErrorResponse(w, http.StatusTooManyRequests, "rate_limited", "too many requests")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// LimitFailedAuthentication limits the requests of every IP address rejected
+// as unauthenticated to the policy. It must run before the authentication: an
+// address that used up its failures is rejected before its credentials are
+// checked, so guessing API keys and tokens is limited and a right guess is
+// not told apart from a wrong one. The requests go through when the store
+// fails.
+func (l *Limiter) LimitFailedAuthentication(p Policy) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := p.Name + ":" + ipKey(r)
+			result, err := l.store.Peek(r.Context(), key, p)
+			if err != nil {
+				logging.FromContext(r.Context()).Error("failed to check the failed authentications, letting the request through", "error", err)
+				next.ServeHTTP(w, r)
+				return
+			}
+			if !result.Allowed {
+				w.Header().Set(HeaderRetryAfter, ceilSeconds(result.retryAfter(p)))
+				responses.NewJsonErrorResponse(w, http.StatusTooManyRequests, "rate_limited", "too many failed authentications")
+				return
+			}
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			next.ServeHTTP(ww, r)
+			if ww.Status() != http.StatusUnauthorized {
+				return
+			}
+			if _, err := l.store.Take(r.Context(), key, p); err != nil {
+				logging.FromContext(r.Context()).Error("failed to count the failed authentication", "error", err)
+			}
+		})
+	}
+}
+
+// clientKey tells the clients apart.
+func clientKey(r *http.Request) string {
+	if p, ok := auth.PrincipalFrom(r.Context()); ok {
+		if p.Method == auth.MethodAPIKey {
+			return p.Subject
+		}
+		return "user:" + p.Subject
+	}
+	return ipKey(r)
+}
+
+func ipKey(r *http.Request) string {
+	// middleware.RealIP has replaced the address with the one of the client
+	// when the request went through a proxy
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+func ceilSeconds(d time.Duration) string {
+	return strconv.FormatInt(int64(math.Ceil(d.Seconds())), 10)
+}
@@ -0,0 +1,67 @@
+package ratelimit
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Policy is a token bucket holding Limit tokens, refilled at Limit tokens per
+// Period. Every request takes a token, so bursts of up to Limit requests are
+// allowed and the sustained rate is Limit per Period.
+type Policy struct {
+	// Name keeps the buckets of the policies apart, a client has a bucket
+	// per policy.
+	Name   string
+	Limit  int
+	Period time.Duration
+}
+
+// ParsePolicy parses a policy written as "<limit>/<period>", e.g. "100/1m".
+func ParsePolicy(name string, s string) (Policy, error) {
+	limit, period, ok := strings.Cut(s, "/")
+	if !ok {
+		return Policy{}, fmt.Errorf("invalid rate limit %q for %s, expected <limit>/<period>", s, name)
+	}
+	l, err := strconv.Atoi(limit)
+	if err != nil || l <= 0 {
+		return Policy{}, fmt.Errorf("invalid rate limit %q for %s", s, name)
+	}
+	p, err := time.ParseDuration(period)
+	if err != nil || p <= 0 {
+		return Policy{}, fmt.Errorf("invalid rate limit period %q for %s", s, name)
+	}
+	return Policy{Name: name, Limit: l, Period: p}, nil
+}
+
+// rate is the number of tokens added to the bucket every second.
+func (p Policy) rate() float64 {
+	return float64(p.Limit) / p.Period.Seconds()
+}
+
+// Result is the state of a bucket after a request tried to take a token.
+type Result struct {
+	Allowed bool
+	// Tokens left in the bucket, partially refilled tokens included.
+	Tokens float64
+}
+
+// remaining is the number of requests that can be made right away.
+func (r Result) remaining() int {
+	return max(0, int(r.Tokens))
+}
+
+// resetAfter is the time until the bucket is full again.
+func (r Result) resetAfter(p Policy) time.Duration {
+	return seconds((float64(p.Limit) - r.Tokens) / p.rate())
+}
+
+// retryAfter is the time until the bucket holds a token again.
+func (r Result) retryAfter(p Policy) time.Duration {
+	return seconds((1 - r.Tokens) / p.rate())
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(max(0, s) * float64(time.Second))
+}
@@ -0,0 +1,169 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	repo "github.com/mellomaths/ecommerce-ms/internal/adapters/postgresql/sqlc"
+	"github.com/mellomaths/ecommerce-ms/internal/health"
+)
+
+// Names of the stores.
+const (
+	StoreMemory   = "memory"
+	StorePostgres = "postgres"
+)
+
+// Store keeps the token buckets.
+type Store interface {
+	// Take takes a token from the bucket of the key, which starts full.
+	Take(ctx context.Context, key string, p Policy) (Result, error)
+	// Peek tells whether the bucket of the key holds a token, without taking
+	// it.
+	Peek(ctx context.Context, key string, p Policy) (Result, error)
+}
+
+// MemoryStore keeps the buckets in the process, so every instance of the
+// service limits the clients on its own.
+type MemoryStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type bucket struct {
+	tokens    float64
+	updatedAt time.Time
+	policy    Policy
+}
+
+// sweepInterval is how often the full buckets are dropped from memory.
+const sweepInterval = time.Minute
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: make(map[string]*bucket), now: time.Now}
+}
+
+func (s *MemoryStore) Take(ctx context.Context, key string, p Policy) (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	if now.Sub(s.lastSweep) >= sweepInterval {
+		s.sweep(now)
+	}
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(p.Limit), updatedAt: now, policy: p}
+		s.buckets[key] = b
+	}
+	b.refill(now)
+	if b.tokens < 1 {
+		return Result{Allowed: false, Tokens: b.tokens}, nil
+	}
+	b.tokens--
+	return Result{Allowed: true, Tokens: b.tokens}, nil
+}
+
+func (s *MemoryStore) Peek(ctx context.Context, key string, p Policy) (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.buckets[key]
+	if !ok {
+		return Result{Allowed: true, Tokens: float64(p.Limit)}, nil
+	}
+	refilled := *b
+	refilled.refill(s.now())
+	return Result{Allowed: refilled.tokens >= 1, Tokens: refilled.tokens}, nil
+}
+
+// sweep drops the buckets that are full again, which is how a new bucket
+// starts anyway.
+func (s *MemoryStore) sweep(now time.Time) {
+	for key, b := range s.buckets {
+		b.refill(now)
+		if b.tokens >= float64(b.policy.Limit) {
+			delete(s.buckets, key)
+		}
+	}
+	s.lastSweep = now
+}
+
+func (b *bucket) refill(now time.Time) {
+	elapsed := now.Sub(b.updatedAt).Seconds()
+	b.tokens = min(float64(b.policy.Limit), b.tokens+elapsed*b.policy.rate())
+	b.updatedAt = now
+}
+
+// PostgresStore keeps the buckets in the database, so the clients are limited
+// across all the instances of the service. The buckets are updated in a single
+// statement, concurrent requests of a client queue on the bucket row.
+type PostgresStore struct {
+	repo repo.Querier
+}
+
+func NewPostgresStore(repo repo.Querier) *PostgresStore {
+	return &PostgresStore{repo: repo}
+}
+
+func (s *PostgresStore) Take(ctx context.Context, key string, p Policy) (Result, error) {
+	row, err := s.repo.TakeRateLimitToken(ctx, repo.TakeRateLimitTokenParams{
+		Key:   key,
+		Burst: float64(p.Limit),
+		Rate:  p.rate(),
+	})
+	if err != nil {
+		return Result{}, err
+	}
+	return Result{Allowed: row.Allowed, Tokens: row.Tokens}, nil
+}
+
+func (s *PostgresStore) Peek(ctx context.Context, key string, p Policy) (Result, error) {
+	tokens, err := s.repo.PeekRateLimitBucket(ctx, repo.PeekRateLimitBucketParams{
+		Key:   key,
+		Burst: float64(p.Limit),
+		Rate:  p.rate(),
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Result{Allowed: true, Tokens: float64(p.Limit)}, nil
+	}
+	if err != nil {
+		return Result{}, err
+	}
+	return Result{Allowed: tokens >= 1, Tokens: tokens}, nil
+}
+
+// Sweeper deletes the buckets of the PostgresStore left idle for longer than
+// the longest policy period, as they are full again.
+type Sweeper struct {
+	repo     repo.Querier
+	interval time.Duration
+	idle     time.Duration
+}
+
+func NewSweeper(repo repo.Querier, interval time.Duration, idle time.Duration) *Sweeper {
+	return &Sweeper{repo: repo, interval: interval, idle: idle}
+}
+
+// Run deletes the idle buckets every interval until ctx is done.
+func (s *Sweeper) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			health.Beat(ctx)
+			idleSince := pgtype.Timestamptz{Time: time.Now().Add(-s.idle), Valid: true}
+			if _, err := s.repo.DeleteIdleRateLimitBuckets(ctx, idleSince); err != nil {
+				slog.Error("failed to delete the idle rate limit buckets", "error", err)
+			}
+		}
+	}
+}
@@ -10,3 +10,35 @@ func DecodeJsonBody(r *http.Request, data any) error {
 	decoder.DisallowUnknownFields()
 	return decoder.Decode(data)
 }
+
+// MergePatch applies a JSON merge patch (RFC 7386) to the JSON document doc
+// and returns the patched document.
+func MergePatch(doc []byte, patch []byte) ([]byte, error) {
+	var d, p any
+	if err := json.Unmarshal(doc, &d); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(patch, &p); err != nil {
+		return nil, err
+	}
+	return json.Marshal(mergePatch(d, p))
+}
+
+func mergePatch(target any, patch any) any {
+	p, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]any)
+	if !ok {
+		t = map[string]any{}
+	}
+	for k, v := range p {
+		if v == nil {
+			delete(t, k)
+			continue
+		}
+		t[k] = mergePatch(t[k], v)
+	}
+	return t
+}
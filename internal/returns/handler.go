@@ -0,0 +1,170 @@
+package returns
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	repo "github.com/mellomaths/ecommerce-ms/internal/adapters/postgresql/sqlc"
+	"github.com/mellomaths/ecommerce-ms/internal/auth"
+	"github.com/mellomaths/ecommerce-ms/internal/logging"
+	"github.com/mellomaths/ecommerce-ms/internal/orders"
+	"github.com/mellomaths/ecommerce-ms/internal/payments"
+	"github.com/mellomaths/ecommerce-ms/internal/requests"
+	"github.com/mellomaths/ecommerce-ms/internal/responses"
+)
+
+type handler struct {
+	service Service
+}
+
+func NewHandler(service Service) *handler {
+	return &handler{
+		service: service,
+	}
+}
+
+func (h *handler) RequestReturn(w http.ResponseWriter, r *http.Request) {
+	orderId, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		logging.FromContext(r.Context()).Info("invalid request", "error", err)
+		responses.NewJsonErrorResponse(w, http.StatusBadRequest, "validation_error", "invalid order id")
+		return
+	}
+	var returnParams CreateReturnParams
+	if err := requests.DecodeJsonBody(r, &returnParams); err != nil {
+		logging.FromContext(r.Context()).Info("invalid request", "error", err)
+		responses.NewJsonErrorResponse(w, http.StatusBadRequest, "validation_error", "invalid return")
+		return
+	}
+	rc, err := h.service.RequestReturn(r.Context(), orderId, returnParams)
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("request failed", "error", err)
+		writeError(w, err, "unexpected error when requesting the return")
+		return
+	}
+	responses.NewJsonResponse(w, http.StatusCreated, rc)
+}
+
+func (h *handler) ListOrderReturns(w http.ResponseWriter, r *http.Request) {
+	orderId, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		logging.FromContext(r.Context()).Info("invalid request", "error", err)
+		responses.NewJsonErrorResponse(w, http.StatusBadRequest, "validation_error", "invalid order id")
+		return
+	}
+	returns, err := h.service.ListOrderReturns(r.Context(), orderId)
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("request failed", "error", err)
+		writeError(w, err, "unexpected error when listing the order returns")
+		return
+	}
+	responses.NewJsonResponse(w, http.StatusOK, returns)
+}
+
+func (h *handler) FindReturnById(w http.ResponseWriter, r *http.Request) {
+	returnId, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		logging.FromContext(r.Context()).Info("invalid request", "error", err)
+		responses.NewJsonErrorResponse(w, http.StatusBadRequest, "validation_error", "invalid return id")
+		return
+	}
+	rc, err := h.service.FindReturnById(r.Context(), returnId)
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("request failed", "error", err)
+		writeError(w, err, "unexpected error when finding the return")
+		return
+	}
+	responses.NewJsonResponse(w, http.StatusOK, rc)
+}
+
+func (h *handler) ApproveReturn(w http.ResponseWriter, r *http.Request) {
+	h.decide(w, r, h.service.ApproveReturn)
+}
+
+func (h *handler) RejectReturn(w http.ResponseWriter, r *http.Request) {
+	h.decide(w, r, h.service.RejectReturn)
+}
+
+func (h *handler) decide(w http.ResponseWriter, r *http.Request, decide func(context.Context, int64, DecisionParams) (repo.Return, error)) {
+	returnId, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		logging.FromContext(r.Context()).Info("invalid request", "error", err)
+		responses.NewJsonErrorResponse(w, http.StatusBadRequest, "validation_error", "invalid return id")
+		return
+	}
+	var decisionParams DecisionParams
+	if err := requests.DecodeJsonBody(r, &decisionParams); err != nil {
+		logging.FromContext(r.Context()).Info("invalid request", "error", err)
+		responses.NewJsonErrorResponse(w, http.StatusBadRequest, "validation_error", "invalid decision")
+		return
+	}
+	p, _ := auth.PrincipalFrom(r.Context())
+	decisionParams.DecidedBy = p.Subject
+	ret, err := decide(r.Context(), returnId, decisionParams)
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("request failed", "error", err)
+		writeError(w, err, "unexpected error when deciding the return")
+		return
+	}
+	responses.NewJsonResponse(w, http.StatusOK, ret)
+}
+
+func (h *handler) ReceiveReturn(w http.ResponseWriter, r *http.Request) {
+	returnId, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		logging.FromContext(r.Context()).Info("invalid request", "error", err)
+		responses.NewJsonErrorResponse(w, http.StatusBadRequest, "validation_error", "invalid return id")
+		return
+	}
+	rc, err := h.service.ReceiveReturn(r.Context(), returnId)
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("request failed", "error", err)
+		writeError(w, err, "unexpected error when receiving the return")
+		return
+	}
+	if rc.RefundError != "" {
+		// The return is received, the refund is left to RefundReturn
+		responses.NewJsonResponse(w, http.StatusAccepted, rc)
+		return
+	}
+	responses.NewJsonResponse(w, http.StatusOK, rc)
+}
+
+func (h *handler) RefundReturn(w http.ResponseWriter, r *http.Request) {
+	returnId, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		logging.FromContext(r.Context()).Info("invalid request", "error", err)
+		responses.NewJsonErrorResponse(w, http.StatusBadRequest, "validation_error", "invalid return id")
+		return
+	}
+	rc, err := h.service.RefundReturn(r.Context(), returnId)
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("request failed", "error", err)
+		writeError(w, err, "unexpected error when refunding the return")
+		return
+	}
+	responses.NewJsonResponse(w, http.StatusOK, rc)
+}
+
+func writeError(w http.ResponseWriter, err error, serverErrMsg string) {
+	switch err {
+	case ErrReturnNotFound, orders.ErrOrderNotFound:
+		responses.NewJsonErrorResponse(w, http.StatusNotFound, "not_found", err.Error())
+	case ErrOrderItemNotFound:
+		responses.NewJsonErrorResponse(w, http.StatusNotFound, "validation_error", err.Error())
+	case ErrInvalidReturn, ErrQuantityExceeded:
+		responses.NewJsonErrorResponse(w, http.StatusBadRequest, "validation_error", err.Error())
+	case ErrOrderNotReturnable:
+		responses.NewJsonErrorResponse(w, http.StatusConflict, "order_not_returnable", err.Error())
+	case ErrInvalidTransition:
+		responses.NewJsonErrorResponse(w, http.StatusConflict, "invalid_transition", err.Error())
+	case payments.ErrNoCapturedPayment, payments.ErrRefundTooLarge:
+		responses.NewJsonErrorResponse(w, http.StatusConflict, "refund_not_possible", err.Error())
+	case payments.ErrGatewayFailure:
+		responses.NewJsonErrorResponse(w, http.StatusBadGateway, "gateway_error", err.Error())
+	default:
+		responses.NewJsonErrorResponse(w, http.StatusInternalServerError, "server_error", serverErrMsg)
+	}
+}
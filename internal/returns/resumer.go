@@ -0,0 +1,43 @@
+package returns
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/mellomaths/ecommerce-ms/internal/health"
+)
+
+// Resumer periodically completes the refunds left refunding by a request that
+// crashed, so the customers are paid and the returns refunded.
+type Resumer struct {
+	service  Service
+	timeout  time.Duration
+	interval time.Duration
+}
+
+func NewResumer(service Service, timeout time.Duration, interval time.Duration) *Resumer {
+	return &Resumer{service: service, timeout: timeout, interval: interval}
+}
+
+// Run resumes the refunds every interval until ctx is done.
+func (r *Resumer) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			health.Beat(ctx)
+			resumed, err := r.service.ResumeRefunds(ctx, r.timeout)
+			if err != nil {
+				slog.Error("failed to resume the refunds", "error", err)
+				continue
+			}
+			if resumed > 0 {
+				slog.Info("resumed refunds", "returns", resumed)
+			}
+		}
+	}
+}
@@ -0,0 +1,451 @@
+package returns
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	repo "github.com/mellomaths/ecommerce-ms/internal/adapters/postgresql/sqlc"
+	"github.com/mellomaths/ecommerce-ms/internal/auth"
+	"github.com/mellomaths/ecommerce-ms/internal/logging"
+	"github.com/mellomaths/ecommerce-ms/internal/orders"
+	"github.com/mellomaths/ecommerce-ms/internal/payments"
+	"github.com/mellomaths/ecommerce-ms/internal/products"
+	"github.com/mellomaths/ecommerce-ms/internal/tracing"
+	"github.com/mellomaths/ecommerce-ms/internal/utils"
+)
+
+var (
+	ErrReturnNotFound     = errors.New("return not found")
+	ErrInvalidReturn      = errors.New("invalid return")
+	ErrOrderNotReturnable = errors.New("order has not been delivered")
+	ErrOrderItemNotFound  = errors.New("order item not found")
+	// ErrQuantityExceeded is returned when more units are returned than were
+	// ordered, counting the units of the previous returns not rejected.
+	ErrQuantityExceeded = errors.New("return quantity exceeds the quantity left to return")
+	// ErrInvalidTransition is returned when the return is not in the status the
+	// action expects, e.g. receiving a return that was not approved.
+	ErrInvalidTransition = errors.New("invalid return status transition")
+)
+
+// The workflow is requested → approved → received → refunding → refunded, or
+// requested → rejected. A return is refunding from the moment its refund is
+// claimed until it is recorded.
+const (
+	StatusRequested = "requested"
+	StatusApproved  = "approved"
+	StatusRejected  = "rejected"
+	StatusReceived  = "received"
+	StatusRefunding = "refunding"
+	StatusRefunded  = "refunded"
+)
+
+// changedBy is recorded on the order transitions made by the returns.
+const changedBy = "returns"
+
+type CreateReturnParams struct {
+	Reason string              `json:"reason"`
+	Items  []ReturnItemsParams `json:"items"`
+}
+
+type ReturnItemsParams struct {
+	OrderItemId int64 `json:"order_item_id"`
+	Quantity    int32 `json:"quantity"`
+}
+
+// DecisionParams is the decision on a return. DecidedBy is not read from the
+// request, the handler sets it to the principal.
+type DecisionParams struct {
+	DecidedBy string `json:"-"`
+	Reason    string `json:"reason"`
+}
+
+// ReturnCompleted is a return with its items. RefundError is set when the
+// return has been received but could not be refunded.
+type ReturnCompleted struct {
+	Return      repo.Return       `json:"return"`
+	Items       []repo.ReturnItem `json:"items"`
+	RefundError string            `json:"refund_error,omitempty"`
+}
+
+type Service interface {
+	RequestReturn(ctx context.Context, orderId int64, cp CreateReturnParams) (ReturnCompleted, error)
+	FindReturnById(ctx context.Context, id int64) (ReturnCompleted, error)
+	ListOrderReturns(ctx context.Context, orderId int64) ([]repo.Return, error)
+	ApproveReturn(ctx context.Context, id int64, dp DecisionParams) (repo.Return, error)
+	RejectReturn(ctx context.Context, id int64, dp DecisionParams) (repo.Return, error)
+	ReceiveReturn(ctx context.Context, id int64) (ReturnCompleted, error)
+	RefundReturn(ctx context.Context, id int64) (ReturnCompleted, error)
+	ResumeRefunds(ctx context.Context, timeout time.Duration) (int, error)
+}
+
+type svc struct {
+	repo            *repo.Queries
+	db              utils.DBConn
+	paymentsService payments.Service
+	ordersService   orders.Service
+}
+
+func NewService(repo *repo.Queries, db utils.DBConn, pays payments.Service, os orders.Service) Service {
+	return &svc{repo: repo, db: db, paymentsService: pays, ordersService: os}
+}
+
+// RequestReturn opens a return for some lines of a delivered order. The refund
+// is computed from the price snapshot of every order item, so later price
+// changes do not affect it.
+func (s *svc) RequestReturn(ctx context.Context, orderId int64, cp CreateReturnParams) (ReturnCompleted, error) {
+	ctx, span := tracing.Start(ctx, "returns.RequestReturn")
+	defer span.End()
+	if len(cp.Items) == 0 {
+		return ReturnCompleted{}, ErrInvalidReturn
+	}
+	// transactional
+	// 1. lock the order so concurrent returns see each other's quantities
+	// 2. check every line against the quantity ordered minus the quantity
+	//    already returned
+	// 3. create the return and its items
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return ReturnCompleted{}, err
+	}
+	defer tx.Rollback(ctx)
+	qtx := s.repo.WithTx(tx)
+	order, err := qtx.FindOrderByIdForUpdate(ctx, orderId)
+	if errors.Is(err, pgx.ErrNoRows) || (err == nil && !auth.CanAccessCustomer(ctx, order.CustomerID)) {
+		return ReturnCompleted{}, orders.ErrOrderNotFound
+	}
+	if err != nil {
+		return ReturnCompleted{}, err
+	}
+	if orders.Status(order.Status) != orders.StatusDelivered {
+		return ReturnCompleted{}, ErrOrderNotReturnable
+	}
+	orderItems, err := qtx.ListOrderItems(ctx, orderId)
+	if err != nil {
+		return ReturnCompleted{}, err
+	}
+	returned, err := qtx.ListReturnedQuantities(ctx, orderId)
+	if err != nil {
+		return ReturnCompleted{}, err
+	}
+	left := make(map[int64]int32, len(orderItems))
+	for _, item := range orderItems {
+		left[item.ID] = item.Quantity
+	}
+	for _, r := range returned {
+		left[r.OrderItemID] -= r.Quantity
+	}
+
+	items := slices.Clone(cp.Items)
+	slices.SortFunc(items, func(a, b ReturnItemsParams) int {
+		return cmp.Compare(a.OrderItemId, b.OrderItemId)
+	})
+	var refund int64
+	for _, item := range items {
+		if item.Quantity <= 0 {
+			return ReturnCompleted{}, ErrInvalidReturn
+		}
+		remaining, ok := left[item.OrderItemId]
+		if !ok {
+			return ReturnCompleted{}, ErrOrderItemNotFound
+		}
+		if item.Quantity > remaining {
+			return ReturnCompleted{}, ErrQuantityExceeded
+		}
+		left[item.OrderItemId] -= item.Quantity
+	}
+	byId := make(map[int64]repo.OrderItem, len(orderItems))
+	for _, item := range orderItems {
+		byId[item.ID] = item
+	}
+	for _, item := range items {
+		refund += int64(item.Quantity) * int64(byId[item.OrderItemId].PriceCents)
+	}
+
+	ret, err := qtx.CreateReturn(ctx, repo.CreateReturnParams{
+		OrderID:             orderId,
+		Reason:              cp.Reason,
+		RefundAmountInCents: refund,
+	})
+	if err != nil {
+		return ReturnCompleted{}, err
+	}
+	rc := ReturnCompleted{Return: ret, Items: []repo.ReturnItem{}}
+	for _, item := range items {
+		orderItem := byId[item.OrderItemId]
+		ri, err := qtx.CreateReturnItem(ctx, repo.CreateReturnItemParams{
+			ReturnID:    ret.ID,
+			OrderItemID: orderItem.ID,
+			ProductID:   orderItem.ProductID,
+			Quantity:    item.Quantity,
+			PriceCents:  orderItem.PriceCents,
+		})
+		if err != nil {
+			return ReturnCompleted{}, err
+		}
+		rc.Items = append(rc.Items, ri)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return ReturnCompleted{}, err
+	}
+	return rc, nil
+}
+
+func (s *svc) FindReturnById(ctx context.Context, id int64) (ReturnCompleted, error) {
+	ctx, span := tracing.Start(ctx, "returns.FindReturnById")
+	defer span.End()
+	ret, err := s.repo.FindReturnById(ctx, id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ReturnCompleted{}, ErrReturnNotFound
+	}
+	if err != nil {
+		return ReturnCompleted{}, err
+	}
+	items, err := s.repo.ListReturnItems(ctx, id)
+	if err != nil {
+		return ReturnCompleted{}, err
+	}
+	if items == nil {
+		items = []repo.ReturnItem{}
+	}
+	return ReturnCompleted{Return: ret, Items: items}, nil
+}
+
+func (s *svc) ListOrderReturns(ctx context.Context, orderId int64) ([]repo.Return, error) {
+	ctx, span := tracing.Start(ctx, "returns.ListOrderReturns")
+	defer span.End()
+	if _, err := s.ordersService.FindOrderById(ctx, orderId); err != nil {
+		return nil, err
+	}
+	returns, err := s.repo.ListOrderReturns(ctx, orderId)
+	if returns == nil {
+		return []repo.Return{}, err
+	}
+	return returns, err
+}
+
+func (s *svc) ApproveReturn(ctx context.Context, id int64, dp DecisionParams) (repo.Return, error) {
+	ctx, span := tracing.Start(ctx, "returns.ApproveReturn")
+	defer span.End()
+	return s.decide(ctx, id, StatusApproved, dp)
+}
+
+// RejectReturn closes a requested return, its quantities can be returned
+// again.
+func (s *svc) RejectReturn(ctx context.Context, id int64, dp DecisionParams) (repo.Return, error) {
+	ctx, span := tracing.Start(ctx, "returns.RejectReturn")
+	defer span.End()
+	return s.decide(ctx, id, StatusRejected, dp)
+}
+
+func (s *svc) decide(ctx context.Context, id int64, status string, dp DecisionParams) (repo.Return, error) {
+	if dp.DecidedBy == "" {
+		return repo.Return{}, ErrInvalidReturn
+	}
+	ret, err := s.repo.DecideReturn(ctx, repo.DecideReturnParams{
+		ID:             id,
+		Status:         status,
+		DecidedBy:      dp.DecidedBy,
+		DecisionReason: dp.Reason,
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return repo.Return{}, notInStatus(ctx, s.repo, id)
+	}
+	return ret, err
+}
+
+// ReceiveReturn records that the items of an approved return arrived, puts
+// them back in stock and refunds the customer. The return is received and its
+// items restocked in a single transaction holding the return row, so a failed
+// restock leaves it approved to be received again. A failed refund leaves the
+// return received with the RefundError set, so it can be retried with
+// RefundReturn.
+func (s *svc) ReceiveReturn(ctx context.Context, id int64) (ReturnCompleted, error) {
+	ctx, span := tracing.Start(ctx, "returns.ReceiveReturn")
+	defer span.End()
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return ReturnCompleted{}, err
+	}
+	defer tx.Rollback(ctx)
+	qtx := s.repo.WithTx(tx)
+	ret, err := move(ctx, qtx, id, StatusApproved, StatusReceived)
+	if err != nil {
+		return ReturnCompleted{}, err
+	}
+	if err := restock(ctx, qtx, ret); err != nil {
+		return ReturnCompleted{}, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return ReturnCompleted{}, err
+	}
+	_, refundErr := s.refund(ctx, ret)
+	rc, err := s.FindReturnById(ctx, id)
+	if err != nil {
+		return ReturnCompleted{}, err
+	}
+	if refundErr != nil {
+		logging.FromContext(ctx).Warn("failed to refund the received return", "return", ret.ID, "error", refundErr)
+		rc.RefundError = refundErr.Error()
+	}
+	return rc, nil
+}
+
+// restock puts the items of the return back to the first location their order
+// item was shipped from.
+func restock(ctx context.Context, qtx *repo.Queries, ret repo.Return) error {
+	items, err := qtx.ListReturnItems(ctx, ret.ID)
+	if err != nil {
+		return err
+	}
+	allocations, err := qtx.ListOrderItemAllocations(ctx, ret.OrderID)
+	if err != nil {
+		return err
+	}
+	shippedFrom := make(map[int64]int64, len(allocations))
+	for _, a := range allocations {
+		if _, ok := shippedFrom[a.OrderItemID]; !ok {
+			shippedFrom[a.OrderItemID] = a.LocationID
+		}
+	}
+	for _, item := range items {
+		if _, err := products.AddStock(ctx, qtx, item.ProductID, item.Quantity, products.Movement{
+			Reason:     products.ReasonReturn,
+			OrderId:    ret.OrderID,
+			LocationId: shippedFrom[item.OrderItemID],
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RefundReturn retries the refund of a received return.
+func (s *svc) RefundReturn(ctx context.Context, id int64) (ReturnCompleted, error) {
+	ctx, span := tracing.Start(ctx, "returns.RefundReturn")
+	defer span.End()
+	ret, err := s.repo.FindReturnById(ctx, id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ReturnCompleted{}, ErrReturnNotFound
+	}
+	if err != nil {
+		return ReturnCompleted{}, err
+	}
+	if _, err := s.refund(ctx, ret); err != nil {
+		return ReturnCompleted{}, err
+	}
+	return s.FindReturnById(ctx, id)
+}
+
+// ResumeRefunds completes the refunds left refunding for longer than timeout
+// by a request that crashed, and returns how many were completed.
+func (s *svc) ResumeRefunds(ctx context.Context, timeout time.Duration) (int, error) {
+	ctx, span := tracing.Start(ctx, "returns.ResumeRefunds")
+	defer span.End()
+	rets, err := s.repo.ListRefundingReturns(ctx, repo.ListRefundingReturnsParams{
+		RefundingBefore: pgtype.Timestamptz{Time: time.Now().Add(-timeout), Valid: true},
+		RowLimit:        100,
+	})
+	if err != nil {
+		return 0, err
+	}
+	resumed := 0
+	for _, ret := range rets {
+		if _, err := s.completeRefund(ctx, ret); err != nil {
+			logging.FromContext(ctx).Warn("failed to resume the refund of the return", "return", ret.ID, "error", err)
+			continue
+		}
+		resumed++
+	}
+	return resumed, nil
+}
+
+// refund claims the received return before calling the gateway, so two
+// concurrent refunds cannot pay the customer twice, and completes it.
+func (s *svc) refund(ctx context.Context, ret repo.Return) (repo.Return, error) {
+	claimed, err := move(ctx, s.repo, ret.ID, StatusReceived, StatusRefunding)
+	if err != nil {
+		return repo.Return{}, err
+	}
+	return s.completeRefund(ctx, claimed)
+}
+
+// completeRefund refunds a refunding return, then records the refund on the
+// payment and the return as refunded in one transaction. The gateway refund
+// is keyed by the return, so a refund completed again after a crash does not
+// pay the customer twice. A failed refund puts the return back to received.
+func (s *svc) completeRefund(ctx context.Context, ret repo.Return) (repo.Return, error) {
+	var payment repo.Payment
+	if ret.RefundAmountInCents > 0 {
+		var err error
+		payment, err = s.paymentsService.RefundOrder(ctx, ret.OrderID, ret.RefundAmountInCents, fmt.Sprintf("return-%d", ret.ID))
+		if err != nil {
+			if _, moveErr := move(ctx, s.repo, ret.ID, StatusRefunding, StatusReceived); moveErr != nil {
+				logging.FromContext(ctx).Error("failed to put the return back to received", "return", ret.ID, "error", moveErr)
+			}
+			return repo.Return{}, err
+		}
+	}
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return repo.Return{}, err
+	}
+	defer tx.Rollback(ctx)
+	qtx := s.repo.WithTx(tx)
+	refunded, err := move(ctx, qtx, ret.ID, StatusRefunding, StatusRefunded)
+	if err != nil {
+		return repo.Return{}, err
+	}
+	if ret.RefundAmountInCents > 0 {
+		payment, err = payments.RecordRefund(ctx, qtx, payment.ID, ret.RefundAmountInCents)
+		if err != nil {
+			return repo.Return{}, err
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return repo.Return{}, err
+	}
+	if payment.Status == payments.StatusRefunded {
+		// Everything the customer paid has been given back
+		_, err := s.ordersService.TransitionOrder(ctx, ret.OrderID, orders.TransitionParams{
+			Status:    orders.StatusRefunded,
+			ChangedBy: changedBy,
+			Reason:    fmt.Sprintf("return %d refunded", ret.ID),
+		})
+		if err != nil {
+			logging.FromContext(ctx).Error("failed to mark the order as refunded", "order", ret.OrderID, "error", err)
+		}
+	}
+	return refunded, nil
+}
+
+// move changes the status of the return if it is still in from, which holds
+// the return row until the end of the transaction of q.
+func move(ctx context.Context, q repo.Querier, id int64, from, to string) (repo.Return, error) {
+	ret, err := q.UpdateReturnStatus(ctx, repo.UpdateReturnStatusParams{
+		ID:         id,
+		FromStatus: from,
+		ToStatus:   to,
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return repo.Return{}, notInStatus(ctx, q, id)
+	}
+	return ret, err
+}
+
+// notInStatus explains why a conditional update of the return matched no row.
+func notInStatus(ctx context.Context, q repo.Querier, id int64) error {
+	_, err := q.FindReturnById(ctx, id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrReturnNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return ErrInvalidTransition
+}
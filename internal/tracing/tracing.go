@@ -0,0 +1,63 @@
+// Package tracing traces the requests with OpenTelemetry, from the routes down
+// to the services and the queries, and propagates the W3C trace context in and
+// out of the service.
+package tracing
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.43.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// ServiceName names the service in the traces, unless OTEL_SERVICE_NAME is
+	// set.
+	ServiceName = "ecommerce-ms"
+
+	instrumentation = "github.com/mellomaths/ecommerce-ms"
+)
+
+// Names of the exporters.
+const (
+	ExporterNone   = "none"
+	ExporterOTLP   = "otlp"
+	ExporterStdout = "stdout"
+)
+
+// Setup installs the W3C trace context propagator and, when an exporter is
+// given, a tracer provider exporting to it. The provider samples ratio of the
+// new traces, the traces continued from a caller follow its decision. The
+// returned function flushes the spans left and must be called before exiting.
+func Setup(ctx context.Context, exporter sdktrace.SpanExporter, ratio float64) (func(ctx context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	if exporter == nil {
+		return func(ctx context.Context) error { return nil }, nil
+	}
+	res, err := resource.New(ctx,
+		resource.WithAttributes(semconv.ServiceName(ServiceName)),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter, sdktrace.WithBatchTimeout(5*time.Second)),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// Start starts a span named after the package and the method, as in
+// orders.PlaceOrder.
+func Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentation).Start(ctx, name, opts...)
+}
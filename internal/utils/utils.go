@@ -6,6 +6,8 @@ import (
 	"github.com/jackc/pgx/v5"
 )
 
+// DBConn is the part of *pgxpool.Pool the services depend on, so tests can
+// inject a mock connection instead.
 type DBConn interface {
 	Begin(ctx context.Context) (pgx.Tx, error)
 }
@@ -0,0 +1,213 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	repo "github.com/mellomaths/ecommerce-ms/internal/adapters/postgresql/sqlc"
+	"github.com/mellomaths/ecommerce-ms/internal/health"
+	"github.com/mellomaths/ecommerce-ms/internal/tracing"
+	"github.com/mellomaths/ecommerce-ms/internal/utils"
+)
+
+// minBackoff is the wait before the first retry of a failed delivery, it
+// doubles on every attempt up to the dispatcher maximum.
+const minBackoff = 5 * time.Second
+
+// errInactive is recorded on the deliveries of a deactivated subscription.
+var errInactive = errors.New("subscription is inactive")
+
+// Dispatcher periodically posts the pending deliveries to their subscribers.
+// A delivery succeeds on any 2xx response, otherwise it is retried with an
+// exponential backoff until maxAttempts, when it becomes dead.
+type Dispatcher struct {
+	repo        *repo.Queries
+	db          utils.DBConn
+	client      *http.Client
+	interval    time.Duration
+	batchSize   int32
+	maxAttempts int32
+	maxBackoff  time.Duration
+}
+
+func NewDispatcher(repo *repo.Queries, db utils.DBConn, client *http.Client, interval time.Duration, batchSize int32, maxAttempts int32, maxBackoff time.Duration) *Dispatcher {
+	return &Dispatcher{
+		repo:        repo,
+		db:          db,
+		client:      client,
+		interval:    interval,
+		batchSize:   batchSize,
+		maxAttempts: maxAttempts,
+		maxBackoff:  maxBackoff,
+	}
+}
+
+// Run dispatches the pending deliveries every interval until ctx is done.
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for {
+				health.Beat(ctx)
+				dispatched, err := d.DispatchBatch(ctx)
+				if err != nil {
+					slog.Error("failed to dispatch webhook deliveries", "error", err)
+					break
+				}
+				if dispatched < int(d.batchSize) {
+					break
+				}
+			}
+		}
+	}
+}
+
+// DispatchBatch claims a batch of due deliveries, posts them and records the
+// outcome. It returns how many deliveries were attempted.
+func (d *Dispatcher) DispatchBatch(ctx context.Context) (int, error) {
+	deliveries, err := d.repo.ClaimWebhookDeliveries(ctx, repo.ClaimWebhookDeliveriesParams{
+		Lease:    pgtype.Interval{Microseconds: d.lease().Microseconds(), Valid: true},
+		RowLimit: d.batchSize,
+	})
+	if err != nil {
+		return 0, err
+	}
+	subscriptions := map[int64]repo.WebhookSubscription{}
+	for _, delivery := range deliveries {
+		sub, ok := subscriptions[delivery.SubscriptionID]
+		if !ok {
+			sub, err = d.repo.FindWebhookSubscriptionById(ctx, delivery.SubscriptionID)
+			if errors.Is(err, pgx.ErrNoRows) {
+				// Deleted meanwhile, its deliveries are gone too
+				continue
+			}
+			if err != nil {
+				return 0, err
+			}
+			subscriptions[sub.ID] = sub
+		}
+		if err := d.deliver(ctx, sub, delivery); err != nil {
+			return 0, err
+		}
+	}
+	return len(deliveries), nil
+}
+
+func (d *Dispatcher) deliver(ctx context.Context, sub repo.WebhookSubscription, delivery repo.WebhookDelivery) error {
+	ctx, span := tracing.Start(ctx, "webhooks.Deliver")
+	defer span.End()
+	var statusCode pgtype.Int4
+	var sendErr error
+	start := time.Now()
+	if sub.Active {
+		var code int
+		code, sendErr = d.send(ctx, sub, delivery)
+		if code != 0 {
+			statusCode = pgtype.Int4{Int32: int32(code), Valid: true}
+		}
+	} else {
+		sendErr = errInactive
+	}
+	duration := time.Since(start)
+
+	status := StatusDelivered
+	nextAttemptAt := delivery.NextAttemptAt
+	lastError := ""
+	if sendErr != nil {
+		lastError = sendErr.Error()
+		switch {
+		case sendErr == errInactive || delivery.Attempts+1 >= d.maxAttempts:
+			status = StatusDead
+			slog.Warn("webhook delivery is dead", "delivery", delivery.ID, "subscription", sub.ID, "attempts", delivery.Attempts+1, "error", sendErr)
+		default:
+			status = StatusPending
+			nextAttemptAt = pgtype.Timestamptz{Time: time.Now().Add(d.backoff(delivery.Attempts)), Valid: true}
+		}
+	}
+
+	tx, err := d.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	qtx := d.repo.WithTx(tx)
+	_, err = qtx.CreateWebhookDeliveryAttempt(ctx, repo.CreateWebhookDeliveryAttemptParams{
+		DeliveryID: delivery.ID,
+		StatusCode: statusCode,
+		Error:      lastError,
+		DurationMs: int32(duration.Milliseconds()),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = qtx.RecordWebhookDeliveryAttempt(ctx, repo.RecordWebhookDeliveryAttemptParams{
+		ID:             delivery.ID,
+		Status:         status,
+		NextAttemptAt:  nextAttemptAt,
+		LastStatusCode: statusCode,
+		LastError:      lastError,
+	})
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// send posts the delivery and returns the response status, which is 0 when no
+// response was received.
+func (d *Dispatcher) send(ctx context.Context, sub repo.WebhookSubscription, delivery repo.WebhookDelivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.Url, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderSignature, Sign(sub.Secret, time.Now(), delivery.Payload))
+	req.Header.Set(HeaderEvent, delivery.EventType)
+	req.Header.Set(HeaderEventId, strconv.FormatInt(delivery.EventID, 10))
+	req.Header.Set(HeaderDelivery, strconv.FormatInt(delivery.ID, 10))
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	// Drain a bit of the body so the connection can be reused
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected response status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// lease is how long a claimed delivery is hidden from the other dispatchers,
+// long enough for the whole batch to time out.
+func (d *Dispatcher) lease() time.Duration {
+	timeout := d.client.Timeout
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+	return time.Duration(d.batchSize)*timeout + time.Minute
+}
+
+func (d *Dispatcher) backoff(attempts int32) time.Duration {
+	b := minBackoff
+	for range attempts {
+		b *= 2
+		if b >= d.maxBackoff {
+			return d.maxBackoff
+		}
+	}
+	return min(b, d.maxBackoff)
+}
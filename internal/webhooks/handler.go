@@ -0,0 +1,185 @@
+package webhooks
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mellomaths/ecommerce-ms/internal/logging"
+	"github.com/mellomaths/ecommerce-ms/internal/pagination"
+	"github.com/mellomaths/ecommerce-ms/internal/requests"
+	"github.com/mellomaths/ecommerce-ms/internal/responses"
+)
+
+type handler struct {
+	service Service
+}
+
+func NewHandler(service Service) *handler {
+	return &handler{
+		service: service,
+	}
+}
+
+func (h *handler) CreateSubscription(w http.ResponseWriter, r *http.Request) {
+	var subscriptionParams CreateSubscriptionParams
+	if err := requests.DecodeJsonBody(r, &subscriptionParams); err != nil {
+		logging.FromContext(r.Context()).Info("invalid request", "error", err)
+		responses.NewJsonErrorResponse(w, http.StatusBadRequest, "validation_error", "invalid webhook subscription")
+		return
+	}
+	sub, err := h.service.CreateSubscription(r.Context(), subscriptionParams)
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("request failed", "error", err)
+		writeError(w, err, "unexpected error when creating the webhook subscription")
+		return
+	}
+	responses.NewJsonResponse(w, http.StatusCreated, sub)
+}
+
+func (h *handler) ListSubscriptions(w http.ResponseWriter, r *http.Request) {
+	subs, err := h.service.ListSubscriptions(r.Context())
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("request failed", "error", err)
+		writeError(w, err, "unexpected error when listing the webhook subscriptions")
+		return
+	}
+	responses.NewJsonResponse(w, http.StatusOK, subs)
+}
+
+func (h *handler) FindSubscriptionById(w http.ResponseWriter, r *http.Request) {
+	subscriptionId, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		logging.FromContext(r.Context()).Info("invalid request", "error", err)
+		responses.NewJsonErrorResponse(w, http.StatusBadRequest, "validation_error", "invalid webhook subscription id")
+		return
+	}
+	sub, err := h.service.FindSubscriptionById(r.Context(), subscriptionId)
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("request failed", "error", err)
+		writeError(w, err, "unexpected error when finding the webhook subscription")
+		return
+	}
+	responses.NewJsonResponse(w, http.StatusOK, sub)
+}
+
+func (h *handler) UpdateSubscription(w http.ResponseWriter, r *http.Request) {
+	subscriptionId, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		logging.FromContext(r.Context()).Info("invalid request", "error", err)
+		responses.NewJsonErrorResponse(w, http.StatusBadRequest, "validation_error", "invalid webhook subscription id")
+		return
+	}
+	var subscriptionParams UpdateSubscriptionParams
+	if err := requests.DecodeJsonBody(r, &subscriptionParams); err != nil {
+		logging.FromContext(r.Context()).Info("invalid request", "error", err)
+		responses.NewJsonErrorResponse(w, http.StatusBadRequest, "validation_error", "invalid webhook subscription")
+		return
+	}
+	sub, err := h.service.UpdateSubscription(r.Context(), subscriptionId, subscriptionParams)
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("request failed", "error", err)
+		writeError(w, err, "unexpected error when updating the webhook subscription")
+		return
+	}
+	responses.NewJsonResponse(w, http.StatusOK, sub)
+}
+
+func (h *handler) DeleteSubscription(w http.ResponseWriter, r *http.Request) {
+	subscriptionId, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		logging.FromContext(r.Context()).Info("invalid request", "error", err)
+		responses.NewJsonErrorResponse(w, http.StatusBadRequest, "validation_error", "invalid webhook subscription id")
+		return
+	}
+	if err := h.service.DeleteSubscription(r.Context(), subscriptionId); err != nil {
+		logging.FromContext(r.Context()).Warn("request failed", "error", err)
+		writeError(w, err, "unexpected error when deleting the webhook subscription")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *handler) ListDeliveries(w http.ResponseWriter, r *http.Request) {
+	subscriptionId, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		logging.FromContext(r.Context()).Info("invalid request", "error", err)
+		responses.NewJsonErrorResponse(w, http.StatusBadRequest, "validation_error", "invalid webhook subscription id")
+		return
+	}
+	q := r.URL.Query()
+	limit, err := pagination.ParseLimit(q.Get("limit"))
+	if err != nil {
+		logging.FromContext(r.Context()).Info("invalid request", "error", err)
+		responses.NewJsonErrorResponse(w, http.StatusBadRequest, "validation_error", err.Error())
+		return
+	}
+	page, err := h.service.ListDeliveries(r.Context(), subscriptionId, ListDeliveriesParams{
+		Cursor: q.Get("cursor"),
+		Limit:  limit,
+		Status: q.Get("status"),
+	})
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("request failed", "error", err)
+		writeError(w, err, "unexpected error when listing the webhook deliveries")
+		return
+	}
+	responses.NewJsonResponse(w, http.StatusOK, page)
+}
+
+func (h *handler) FindDeliveryById(w http.ResponseWriter, r *http.Request) {
+	subscriptionId, deliveryId, ok := parseDeliveryIds(w, r)
+	if !ok {
+		return
+	}
+	d, err := h.service.FindDeliveryById(r.Context(), subscriptionId, deliveryId)
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("request failed", "error", err)
+		writeError(w, err, "unexpected error when finding the webhook delivery")
+		return
+	}
+	responses.NewJsonResponse(w, http.StatusOK, d)
+}
+
+func (h *handler) RedriveDelivery(w http.ResponseWriter, r *http.Request) {
+	subscriptionId, deliveryId, ok := parseDeliveryIds(w, r)
+	if !ok {
+		return
+	}
+	d, err := h.service.RedriveDelivery(r.Context(), subscriptionId, deliveryId)
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("request failed", "error", err)
+		writeError(w, err, "unexpected error when redriving the webhook delivery")
+		return
+	}
+	responses.NewJsonResponse(w, http.StatusOK, d)
+}
+
+func parseDeliveryIds(w http.ResponseWriter, r *http.Request) (int64, int64, bool) {
+	subscriptionId, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		logging.FromContext(r.Context()).Info("invalid request", "error", err)
+		responses.NewJsonErrorResponse(w, http.StatusBadRequest, "validation_error", "invalid webhook subscription id")
+		return 0, 0, false
+	}
+	deliveryId, err := strconv.ParseInt(chi.URLParam(r, "deliveryId"), 10, 64)
+	if err != nil {
+		logging.FromContext(r.Context()).Info("invalid request", "error", err)
+		responses.NewJsonErrorResponse(w, http.StatusBadRequest, "validation_error", "invalid webhook delivery id")
+		return 0, 0, false
+	}
+	return subscriptionId, deliveryId, true
+}
+
+func writeError(w http.ResponseWriter, err error, serverErrMsg string) {
+	switch err {
+	case ErrSubscriptionNotFound, ErrDeliveryNotFound:
+		responses.NewJsonErrorResponse(w, http.StatusNotFound, "not_found", err.Error())
+	case ErrInvalidSubscription, ErrInvalidStatus, pagination.ErrInvalidCursor:
+		responses.NewJsonErrorResponse(w, http.StatusBadRequest, "validation_error", err.Error())
+	case ErrDeliveryNotDead:
+		responses.NewJsonErrorResponse(w, http.StatusConflict, "delivery_not_dead", err.Error())
+	default:
+		responses.NewJsonErrorResponse(w, http.StatusInternalServerError, "server_error", serverErrMsg)
+	}
+}
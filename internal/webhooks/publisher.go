@@ -0,0 +1,63 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	repo "github.com/mellomaths/ecommerce-ms/internal/adapters/postgresql/sqlc"
+)
+
+// Envelope is the body posted to the subscribers.
+type Envelope struct {
+	Id            int64           `json:"id"`
+	Type          string          `json:"type"`
+	AggregateType string          `json:"aggregate_type"`
+	AggregateId   int64           `json:"aggregate_id"`
+	CreatedAt     time.Time       `json:"created_at"`
+	Data          json.RawMessage `json:"data"`
+}
+
+// Publisher is the outbox publisher of the webhooks: it queues a delivery of
+// the event for every active subscription to its type. Queuing an event twice
+// is a no-op, so the outbox can safely retry it.
+type Publisher struct {
+	repo repo.Querier
+}
+
+func NewPublisher(repo repo.Querier) *Publisher {
+	return &Publisher{repo: repo}
+}
+
+func (p *Publisher) Publish(ctx context.Context, event repo.Outbox) error {
+	subscriptions, err := p.repo.ListWebhookSubscriptionsForEvent(ctx, event.EventType)
+	if err != nil {
+		return err
+	}
+	if len(subscriptions) == 0 {
+		return nil
+	}
+	body, err := json.Marshal(Envelope{
+		Id:            event.ID,
+		Type:          event.EventType,
+		AggregateType: event.AggregateType,
+		AggregateId:   event.AggregateID,
+		CreatedAt:     event.CreatedAt.Time,
+		Data:          event.Payload,
+	})
+	if err != nil {
+		return err
+	}
+	for _, s := range subscriptions {
+		err := p.repo.CreateWebhookDelivery(ctx, repo.CreateWebhookDeliveryParams{
+			SubscriptionID: s.ID,
+			EventID:        event.ID,
+			EventType:      event.EventType,
+			Payload:        body,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,308 @@
+package webhooks
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"slices"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	repo "github.com/mellomaths/ecommerce-ms/internal/adapters/postgresql/sqlc"
+	"github.com/mellomaths/ecommerce-ms/internal/outbox"
+	"github.com/mellomaths/ecommerce-ms/internal/pagination"
+	"github.com/mellomaths/ecommerce-ms/internal/tracing"
+)
+
+var (
+	ErrSubscriptionNotFound = errors.New("webhook subscription not found")
+	ErrDeliveryNotFound     = errors.New("webhook delivery not found")
+	ErrInvalidSubscription  = errors.New("invalid webhook subscription")
+	ErrInvalidStatus        = errors.New("invalid delivery status")
+	// ErrDeliveryNotDead is returned when redriving a delivery that has not
+	// been dead lettered.
+	ErrDeliveryNotDead = errors.New("webhook delivery is not dead")
+)
+
+const (
+	StatusPending   = "pending"
+	StatusDelivered = "delivered"
+	StatusDead      = "dead"
+)
+
+// minSecretLength is the shortest secret accepted from the clients, secrets
+// generated by the service are 32 random bytes.
+const minSecretLength = 16
+
+type CreateSubscriptionParams struct {
+	URL        string   `json:"url"`
+	Secret     string   `json:"secret"`
+	EventTypes []string `json:"event_types"`
+}
+
+type UpdateSubscriptionParams struct {
+	URL        string   `json:"url"`
+	EventTypes []string `json:"event_types"`
+	Active     bool     `json:"active"`
+}
+
+// Subscription is a webhook subscription without its secret, which is only
+// returned when the subscription is created.
+type Subscription struct {
+	ID         int64              `json:"id"`
+	URL        string             `json:"url"`
+	EventTypes []string           `json:"event_types"`
+	Active     bool               `json:"active"`
+	CreatedAt  pgtype.Timestamptz `json:"created_at"`
+	UpdatedAt  pgtype.Timestamptz `json:"updated_at"`
+}
+
+type CreatedSubscription struct {
+	Subscription
+	Secret string `json:"secret"`
+}
+
+type ListDeliveriesParams struct {
+	Cursor string
+	Limit  int32
+	Status string
+}
+
+type deliveryCursor struct {
+	ID int64 `json:"id"`
+}
+
+type DeliveryWithAttempts struct {
+	Delivery repo.WebhookDelivery          `json:"delivery"`
+	Attempts []repo.WebhookDeliveryAttempt `json:"attempts"`
+}
+
+type Service interface {
+	CreateSubscription(ctx context.Context, cp CreateSubscriptionParams) (CreatedSubscription, error)
+	ListSubscriptions(ctx context.Context) ([]Subscription, error)
+	FindSubscriptionById(ctx context.Context, id int64) (Subscription, error)
+	UpdateSubscription(ctx context.Context, id int64, up UpdateSubscriptionParams) (Subscription, error)
+	DeleteSubscription(ctx context.Context, id int64) error
+	ListDeliveries(ctx context.Context, subscriptionId int64, lp ListDeliveriesParams) (pagination.Page[repo.WebhookDelivery], error)
+	FindDeliveryById(ctx context.Context, subscriptionId int64, id int64) (DeliveryWithAttempts, error)
+	RedriveDelivery(ctx context.Context, subscriptionId int64, id int64) (repo.WebhookDelivery, error)
+}
+
+type svc struct {
+	repo repo.Querier
+}
+
+func NewService(repo repo.Querier) Service {
+	return &svc{repo: repo}
+}
+
+// CreateSubscription subscribes a URL to some event types. A secret is
+// generated when none is given.
+func (s *svc) CreateSubscription(ctx context.Context, cp CreateSubscriptionParams) (CreatedSubscription, error) {
+	ctx, span := tracing.Start(ctx, "webhooks.CreateSubscription")
+	defer span.End()
+	if !validURL(cp.URL) || !validEventTypes(cp.EventTypes) {
+		return CreatedSubscription{}, ErrInvalidSubscription
+	}
+	if cp.Secret == "" {
+		secret, err := newSecret()
+		if err != nil {
+			return CreatedSubscription{}, err
+		}
+		cp.Secret = secret
+	}
+	if len(cp.Secret) < minSecretLength {
+		return CreatedSubscription{}, ErrInvalidSubscription
+	}
+	sub, err := s.repo.CreateWebhookSubscription(ctx, repo.CreateWebhookSubscriptionParams{
+		Url:        cp.URL,
+		Secret:     cp.Secret,
+		EventTypes: cp.EventTypes,
+	})
+	if err != nil {
+		return CreatedSubscription{}, err
+	}
+	return CreatedSubscription{Subscription: newSubscription(sub), Secret: sub.Secret}, nil
+}
+
+func (s *svc) ListSubscriptions(ctx context.Context) ([]Subscription, error) {
+	ctx, span := tracing.Start(ctx, "webhooks.ListSubscriptions")
+	defer span.End()
+	subs, err := s.repo.ListWebhookSubscriptions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]Subscription, 0, len(subs))
+	for _, sub := range subs {
+		result = append(result, newSubscription(sub))
+	}
+	return result, nil
+}
+
+func (s *svc) FindSubscriptionById(ctx context.Context, id int64) (Subscription, error) {
+	ctx, span := tracing.Start(ctx, "webhooks.FindSubscriptionById")
+	defer span.End()
+	sub, err := s.repo.FindWebhookSubscriptionById(ctx, id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Subscription{}, ErrSubscriptionNotFound
+	}
+	if err != nil {
+		return Subscription{}, err
+	}
+	return newSubscription(sub), nil
+}
+
+// UpdateSubscription replaces the URL, event types and state of the
+// subscription. Deliveries already queued keep going to the subscription,
+// unless it is deactivated.
+func (s *svc) UpdateSubscription(ctx context.Context, id int64, up UpdateSubscriptionParams) (Subscription, error) {
+	ctx, span := tracing.Start(ctx, "webhooks.UpdateSubscription")
+	defer span.End()
+	if !validURL(up.URL) || !validEventTypes(up.EventTypes) {
+		return Subscription{}, ErrInvalidSubscription
+	}
+	sub, err := s.repo.UpdateWebhookSubscription(ctx, repo.UpdateWebhookSubscriptionParams{
+		ID:         id,
+		Url:        up.URL,
+		EventTypes: up.EventTypes,
+		Active:     up.Active,
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Subscription{}, ErrSubscriptionNotFound
+	}
+	if err != nil {
+		return Subscription{}, err
+	}
+	return newSubscription(sub), nil
+}
+
+// DeleteSubscription deletes the subscription with its deliveries.
+func (s *svc) DeleteSubscription(ctx context.Context, id int64) error {
+	ctx, span := tracing.Start(ctx, "webhooks.DeleteSubscription")
+	defer span.End()
+	deleted, err := s.repo.DeleteWebhookSubscription(ctx, id)
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return ErrSubscriptionNotFound
+	}
+	return nil
+}
+
+// ListDeliveries is the delivery log of the subscription, newest first.
+func (s *svc) ListDeliveries(ctx context.Context, subscriptionId int64, lp ListDeliveriesParams) (pagination.Page[repo.WebhookDelivery], error) {
+	ctx, span := tracing.Start(ctx, "webhooks.ListDeliveries")
+	defer span.End()
+	if lp.Status != "" && !slices.Contains([]string{StatusPending, StatusDelivered, StatusDead}, lp.Status) {
+		return pagination.Page[repo.WebhookDelivery]{}, ErrInvalidStatus
+	}
+	if _, err := s.FindSubscriptionById(ctx, subscriptionId); err != nil {
+		return pagination.Page[repo.WebhookDelivery]{}, err
+	}
+	if lp.Limit == 0 {
+		lp.Limit = pagination.DefaultLimit
+	}
+	params := repo.ListWebhookDeliveriesParams{
+		SubscriptionID: subscriptionId,
+		RowLimit:       lp.Limit + 1,
+	}
+	if lp.Status != "" {
+		params.Status = pgtype.Text{String: lp.Status, Valid: true}
+	}
+	if lp.Cursor != "" {
+		var c deliveryCursor
+		if err := pagination.DecodeCursor(lp.Cursor, &c); err != nil {
+			return pagination.Page[repo.WebhookDelivery]{}, err
+		}
+		params.CursorID = pgtype.Int8{Int64: c.ID, Valid: true}
+	}
+	deliveries, err := s.repo.ListWebhookDeliveries(ctx, params)
+	if err != nil {
+		return pagination.Page[repo.WebhookDelivery]{}, err
+	}
+	return pagination.NewPage(deliveries, lp.Limit, func(last repo.WebhookDelivery) (string, error) {
+		return pagination.EncodeCursor(deliveryCursor{ID: last.ID})
+	})
+}
+
+func (s *svc) FindDeliveryById(ctx context.Context, subscriptionId int64, id int64) (DeliveryWithAttempts, error) {
+	ctx, span := tracing.Start(ctx, "webhooks.FindDeliveryById")
+	defer span.End()
+	delivery, err := s.repo.FindWebhookDeliveryById(ctx, repo.FindWebhookDeliveryByIdParams{
+		ID:             id,
+		SubscriptionID: subscriptionId,
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return DeliveryWithAttempts{}, ErrDeliveryNotFound
+	}
+	if err != nil {
+		return DeliveryWithAttempts{}, err
+	}
+	attempts, err := s.repo.ListWebhookDeliveryAttempts(ctx, id)
+	if err != nil {
+		return DeliveryWithAttempts{}, err
+	}
+	if attempts == nil {
+		attempts = []repo.WebhookDeliveryAttempt{}
+	}
+	return DeliveryWithAttempts{Delivery: delivery, Attempts: attempts}, nil
+}
+
+// RedriveDelivery queues a dead delivery again with a fresh retry budget.
+func (s *svc) RedriveDelivery(ctx context.Context, subscriptionId int64, id int64) (repo.WebhookDelivery, error) {
+	ctx, span := tracing.Start(ctx, "webhooks.RedriveDelivery")
+	defer span.End()
+	delivery, err := s.repo.RedriveWebhookDelivery(ctx, repo.RedriveWebhookDeliveryParams{
+		ID:             id,
+		SubscriptionID: subscriptionId,
+	})
+	if err == nil {
+		return delivery, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return repo.WebhookDelivery{}, err
+	}
+	if _, err := s.FindDeliveryById(ctx, subscriptionId, id); err != nil {
+		return repo.WebhookDelivery{}, err
+	}
+	return repo.WebhookDelivery{}, ErrDeliveryNotDead
+}
+
+func newSubscription(sub repo.WebhookSubscription) Subscription {
+	return Subscription{
+		ID:         sub.ID,
+		URL:        sub.Url,
+		EventTypes: sub.EventTypes,
+		Active:     sub.Active,
+		CreatedAt:  sub.CreatedAt,
+		UpdatedAt:  sub.UpdatedAt,
+	}
+}
+
+func validURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+func validEventTypes(eventTypes []string) bool {
+	if len(eventTypes) == 0 {
+		return false
+	}
+	for _, t := range eventTypes {
+		if !slices.Contains(outbox.EventTypes, t) {
+			return false
+		}
+	}
+	return true
+}
+
+func newSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(b), nil
+}